@@ -0,0 +1,62 @@
+package zstd
+
+import "time"
+
+// adaptInterval is the amount of input between adjustments of an adaptive level
+const adaptInterval = 1 << 20
+
+// adapter tracks how long an adaptive Writer waits on its destination, in the spirit of `zstd --adapt`
+type adapter struct {
+	minLevel  int
+	maxLevel  int
+	start     time.Time     // Start of the current measurement
+	writeTime time.Duration // Time spent writing to the destination since start
+	next      int64         // Input count at which the level is next adjusted
+}
+
+// newAdapter returns an adapter bounded by minLevel and maxLevel
+func newAdapter(minLevel, maxLevel int) *adapter {
+	return &adapter{
+		minLevel: minLevel,
+		maxLevel: max(minLevel, maxLevel),
+		start:    time.Now(),
+		next:     adaptInterval,
+	}
+}
+
+// adaptLevel moves the level one step according to the time measured since the last call.
+// A destination that holds the writer up for over half of the time lowers the level; one
+// that takes under a tenth of it raises the level. The new level applies from the next
+// job, which is as large as the adjustment interval.
+func (w *Writer) adaptLevel() {
+	a := w.adapt
+	elapsed := time.Since(a.start)
+
+	level := w.level
+	switch {
+	case a.writeTime > elapsed/2 && level > a.minLevel:
+		level--
+	case a.writeTime < elapsed/10 && level < a.maxLevel:
+		level++
+	}
+	a.start, a.writeTime = time.Now(), 0
+	a.next = w.bytesIn + adaptInterval
+
+	if level == w.level {
+		return
+	}
+
+	// The level is a parameter zstd accepts in the middle of a frame; keep the old one if not
+	result := w.zstd.cctxSetParameter(w.stream, cParamCompressionLevel, level)
+	if w.zstd.isError(result) == 0 {
+		w.level = level
+	}
+}
+
+// Level returns the compression level in use. With an adaptive level it changes as
+// the Writer runs.
+func (w *Writer) Level() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.level
+}
@@ -0,0 +1,137 @@
+package zstd
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// Allocator provides the native memory of the contexts and streams of an instance, to
+// account for it or take it from a custom allocator. Alloc returns a block of at least
+// size bytes aligned to 8 bytes, or nil if there is no memory; Free releases a block
+// returned by Alloc. Both are called from within libzstd, so they must be safe for
+// concurrent use and must not panic.
+type Allocator interface {
+	Alloc(size int) unsafe.Pointer
+	Free(ptr unsafe.Pointer)
+}
+
+// customMem is ZSTD_customMem, the allocation callbacks given to the advanced constructors
+type customMem struct {
+	customAlloc uintptr
+	customFree  uintptr
+	opaque      uintptr // Key of the Allocator in allocators
+}
+
+// allocators holds the Allocator of every instance by the key passed to the callbacks,
+// which are created once as callbacks are never released
+var allocators struct {
+	once        sync.Once
+	alloc, free uintptr
+	mu          sync.RWMutex
+	byKey       map[uintptr]Allocator
+	next        uintptr
+}
+
+// allocatorFor returns the Allocator of the key passed to a callback
+func allocatorFor(key uintptr) Allocator {
+	allocators.mu.RLock()
+	defer allocators.mu.RUnlock()
+	return allocators.byKey[key]
+}
+
+// registerAllocator makes a available to the callbacks and returns its customMem
+func registerAllocator(a Allocator) customMem {
+	allocators.once.Do(func() {
+		allocators.byKey = make(map[uintptr]Allocator)
+		allocators.alloc = purego.NewCallback(func(key, size uintptr) unsafe.Pointer {
+			return allocatorFor(key).Alloc(int(size))
+		})
+		allocators.free = purego.NewCallback(func(key uintptr, ptr unsafe.Pointer) {
+			if ptr != nil {
+				allocatorFor(key).Free(ptr)
+			}
+		})
+	})
+
+	allocators.mu.Lock()
+	defer allocators.mu.Unlock()
+	allocators.next++
+	allocators.byKey[allocators.next] = a
+	return customMem{customAlloc: allocators.alloc, customFree: allocators.free, opaque: allocators.next}
+}
+
+// unregisterAllocator forgets the Allocator of mem once nothing allocated with it is left
+func unregisterAllocator(mem customMem) {
+	allocators.mu.Lock()
+	defer allocators.mu.Unlock()
+	delete(allocators.byKey, mem.opaque)
+}
+
+// useAllocator makes the instance create its contexts and streams with a
+func (z *Zstd) useAllocator(a Allocator) {
+	registerAdvancedCreate(z, z.handle)
+	z.customMem = registerAllocator(a)
+	mem := z.customMem
+
+	z.createCCtx = countCreate(func() unsafe.Pointer { return z.createCCtxAdvanced(mem) })
+	z.createDCtx = countCreate(func() unsafe.Pointer { return z.createDCtxAdvanced(mem) })
+	z.createCStream = countCreate(func() unsafe.Pointer { return z.createCStreamAdvanced(mem) })
+	z.createDStream = countCreate(func() unsafe.Pointer { return z.createDStreamAdvanced(mem) })
+}
+
+// GoAllocator is an Allocator taking memory from the Go heap, pinned while libzstd holds
+// it, so the native memory of an instance is seen by the Go runtime and its memory limit,
+// and InUse tells how much the library holds
+type GoAllocator struct {
+	mu     sync.Mutex
+	blocks map[uintptr]*goBlock
+	inUse  atomic.Int64
+}
+
+// goBlock is a block of memory handed out by a GoAllocator
+type goBlock struct {
+	buf    []uint64 // Words, for 8-byte alignment
+	pinner runtime.Pinner
+}
+
+// Alloc implements Allocator
+func (g *GoAllocator) Alloc(size int) unsafe.Pointer {
+	if size <= 0 {
+		return nil
+	}
+	block := &goBlock{buf: make([]uint64, (size+7)/8)}
+	block.pinner.Pin(&block.buf[0])
+	ptr := unsafe.Pointer(&block.buf[0])
+
+	g.mu.Lock()
+	if g.blocks == nil {
+		g.blocks = make(map[uintptr]*goBlock)
+	}
+	g.blocks[uintptr(ptr)] = block
+	g.mu.Unlock()
+
+	g.inUse.Add(int64(len(block.buf) * 8))
+	return ptr
+}
+
+// Free implements Allocator
+func (g *GoAllocator) Free(ptr unsafe.Pointer) {
+	g.mu.Lock()
+	block := g.blocks[uintptr(ptr)]
+	delete(g.blocks, uintptr(ptr))
+	g.mu.Unlock()
+
+	if block != nil {
+		block.pinner.Unpin()
+		g.inUse.Add(-int64(len(block.buf) * 8))
+	}
+}
+
+// InUse returns the bytes allocated and not freed yet
+func (g *GoAllocator) InUse() int64 {
+	return g.inUse.Load()
+}
@@ -0,0 +1,12 @@
+package zstd
+
+import "github.com/ebitengine/purego"
+
+// registerAdvancedCreate binds the constructors taking a ZSTD_customMem by value.
+// purego supports struct arguments natively on darwin.
+func registerAdvancedCreate(z *Zstd, handle uintptr) {
+	purego.RegisterLibFunc(&z.createCCtxAdvanced, handle, "ZSTD_createCCtx_advanced")
+	purego.RegisterLibFunc(&z.createDCtxAdvanced, handle, "ZSTD_createDCtx_advanced")
+	purego.RegisterLibFunc(&z.createCStreamAdvanced, handle, "ZSTD_createCStream_advanced")
+	purego.RegisterLibFunc(&z.createDStreamAdvanced, handle, "ZSTD_createDStream_advanced")
+}
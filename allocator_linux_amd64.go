@@ -0,0 +1,26 @@
+package zstd
+
+import (
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// registerAdvancedCreate binds the constructors taking a ZSTD_customMem by value.
+// purego cannot pass structs on linux, but the System V ABI passes structs larger than
+// 16 bytes on the stack, where the integer arguments after the sixth go, so the fields
+// are passed as the seventh to ninth arguments with the registers left unused.
+func registerAdvancedCreate(z *Zstd, handle uintptr) {
+	bind := func(name string) func(mem customMem) unsafe.Pointer {
+		var create func(_, _, _, _, _, _ uintptr, customAlloc, customFree, opaque uintptr) unsafe.Pointer
+		purego.RegisterLibFunc(&create, handle, name)
+		return func(mem customMem) unsafe.Pointer {
+			return create(0, 0, 0, 0, 0, 0, mem.customAlloc, mem.customFree, mem.opaque)
+		}
+	}
+
+	z.createCCtxAdvanced = bind("ZSTD_createCCtx_advanced")
+	z.createDCtxAdvanced = bind("ZSTD_createDCtx_advanced")
+	z.createCStreamAdvanced = bind("ZSTD_createCStream_advanced")
+	z.createDStreamAdvanced = bind("ZSTD_createDStream_advanced")
+}
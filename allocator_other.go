@@ -0,0 +1,16 @@
+//go:build !darwin && !(linux && amd64)
+
+package zstd
+
+import "unsafe"
+
+// registerAdvancedCreate installs stubs on platforms without an embedded library.
+func registerAdvancedCreate(z *Zstd, handle uintptr) {
+	create := func(mem customMem) unsafe.Pointer {
+		return nil
+	}
+	z.createCCtxAdvanced = create
+	z.createDCtxAdvanced = create
+	z.createCStreamAdvanced = create
+	z.createDStreamAdvanced = create
+}
@@ -0,0 +1,159 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"unsafe"
+)
+
+const (
+	blockHeaderSize = 3
+	checksumSize    = 4
+)
+
+// frameInfo locates a frame within a stream
+type frameInfo struct {
+	offset    int64
+	size      int64 // Compressed size, including the header and checksum
+	skippable bool
+	header    zstdFrameHeader // Header of a regular frame
+}
+
+// NewAppendWriter returns a Writer adding frames at the end of the .zst file f, which
+// must be open for reading and writing. The existing content is first checked to end
+// on a frame boundary, reading only frame and block headers; a file cut short within a
+// frame is left untouched, with an error matching io.ErrUnexpectedEOF. Readers decode
+// the appended frames after the existing ones, like the zstd CLI does with concatenated
+// files, so compressed logs can grow without being rewritten.
+// Closing the Writer doesn't close f. Appending to a seekable archive moves data past
+// its seek table, so the result can be read as a stream only.
+func (z *Zstd) NewAppendWriter(f *os.File, level int, opts ...Option) (*Writer, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if err := z.walkFrames(f, info.Size(), func(frameInfo) error { return nil }); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+
+	w := z.NewWriter(f, level, opts...)
+	if w.err != nil {
+		return nil, w.err
+	}
+	return w, nil
+}
+
+// walkFrames calls fn with each frame of the size bytes of r in order, reading only
+// frame and block headers. The frames run to the end of r if it is shorter.
+func (z *Zstd) walkFrames(r io.ReaderAt, size int64, fn func(frameInfo) error) error {
+	buf := make([]byte, frameHeaderSizeMax)
+	for offset := int64(0); offset < size; {
+		header := buf[:min(int64(len(buf)), size-offset)]
+		n, err := r.ReadAt(header, offset)
+		if n < len(header) {
+			if err != io.EOF {
+				return truncatedFrame(offset, err)
+			}
+			if n == 0 {
+				return nil // A stream of unknown size ends here
+			}
+			header = header[:n]
+		}
+
+		info := frameInfo{offset: offset}
+		if len(header) >= 4 && binary.LittleEndian.Uint32(header)&^maxSkippableVariant == skippableMagicStart {
+			if len(header) < skippableHeaderSize {
+				return truncatedFrame(offset, io.ErrUnexpectedEOF)
+			}
+			info.skippable = true
+			info.size = skippableHeaderSize + int64(binary.LittleEndian.Uint32(header[4:8]))
+		} else {
+			result := z.getFrameHeader(&info.header, unsafe.Pointer(&header[0]), uint64(len(header)))
+			if z.isError(result) != 0 {
+				return fmt.Errorf("zstd: frame at offset %d: %w", offset, z.nativeError("read frame header", result))
+			}
+			// A positive result is the size the header needs
+			if result > 0 {
+				return truncatedFrame(offset, io.ErrUnexpectedEOF)
+			}
+
+			end, err := blocksEnd(r, size, offset+int64(info.header.headerSize))
+			if err != nil {
+				return truncatedFrame(offset, err)
+			}
+			if info.header.checksumFlag != 0 {
+				end += checksumSize
+			}
+			info.size = end - offset
+		}
+
+		// Check the frame is complete, as the size of a stream read through isn't known
+		if info.size > size-offset {
+			return truncatedFrame(offset, io.ErrUnexpectedEOF)
+		}
+		if err := readFrameBytes(r, buf[:1], offset+info.size-1); err != nil {
+			return truncatedFrame(offset, err)
+		}
+		if err := fn(info); err != nil {
+			return err
+		}
+		offset += info.size
+	}
+	return nil
+}
+
+// blocksEnd follows the block headers of a frame from pos and returns the offset past
+// its last block
+func blocksEnd(r io.ReaderAt, size, pos int64) (int64, error) {
+	var block [blockHeaderSize]byte
+	for last := false; !last; {
+		if pos+blockHeaderSize > size {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if err := readFrameBytes(r, block[:], pos); err != nil {
+			return 0, err
+		}
+
+		h := uint32(block[0]) | uint32(block[1])<<8 | uint32(block[2])<<16
+		last = h&1 != 0
+		blockSize := int64(h >> 3)
+		switch (h >> 1) & 3 {
+		case 1: // RLE: a single byte, repeated blockSize times
+			blockSize = 1
+		case 3:
+			return 0, fmt.Errorf("%w: reserved block type at offset %d", ErrCorruptedData, pos)
+		}
+		pos += blockHeaderSize + blockSize
+	}
+	return pos, nil
+}
+
+// readFrameBytes fills p from r at off
+func readFrameBytes(r io.ReaderAt, p []byte, off int64) error {
+	n, err := r.ReadAt(p, off)
+	if n == len(p) {
+		return nil
+	}
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// truncatedFrame adds the offset of the frame to io.ErrUnexpectedEOF; other errors
+// are returned as is
+func truncatedFrame(offset int64, err error) error {
+	if err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("zstd: frame at offset %d is truncated: %w", offset, err)
+	}
+	return err
+}
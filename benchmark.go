@@ -0,0 +1,94 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// benchMinTime is the least time Benchmark spends compressing and then decompressing at each level
+const benchMinTime = 100 * time.Millisecond
+
+// BenchResult measures compressing some data at one level
+type BenchResult struct {
+	Level           int           // Compression level measured
+	OriginalSize    int64         // Size of the data
+	CompressedSize  int64         // Size of the data compressed
+	Ratio           float64       // OriginalSize / CompressedSize
+	CompressTime    time.Duration // Time taken by one compression
+	DecompressTime  time.Duration // Time taken by one decompression
+	CompressSpeed   float64       // Megabytes (10^6 bytes) of data compressed per second
+	DecompressSpeed float64       // Megabytes (10^6 bytes) of data decompressed per second
+}
+
+// Benchmark compresses and decompresses data at each level (0 = the instance default),
+// so an application can pick a level for its own data at startup. Each level is warmed
+// up with a round trip first, then timed over repeated passes on the instance's pooled
+// contexts for at least 100ms each way, which keeps small data from timing noise.
+func (z *Zstd) Benchmark(data []byte, levels []int) ([]BenchResult, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	if len(data) == 0 {
+		return nil, ErrEmptyInput
+	}
+
+	results := make([]BenchResult, 0, len(levels))
+	for _, level := range levels {
+		result, err := z.benchLevel(data, z.resolveLevel(level))
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// benchLevel measures compressing data at level
+func (z *Zstd) benchLevel(data []byte, level int) (BenchResult, error) {
+	result := BenchResult{Level: level, OriginalSize: int64(len(data))}
+
+	compressed, err := z.Compress(data, level)
+	if err != nil {
+		return result, err
+	}
+	decompressed, err := z.Decompress(compressed, len(data))
+	if err != nil {
+		return result, err
+	}
+	if !bytes.Equal(decompressed, data) {
+		return result, fmt.Errorf("%w: level %d did not round trip", ErrCorruptedData, level)
+	}
+	result.CompressedSize = int64(len(compressed))
+
+	if result.CompressTime, err = benchRepeat(func() error {
+		_, err := z.Compress(data, level)
+		return err
+	}); err != nil {
+		return result, err
+	}
+	if result.DecompressTime, err = benchRepeat(func() error {
+		_, err := z.Decompress(compressed, len(data))
+		return err
+	}); err != nil {
+		return result, err
+	}
+
+	result.Ratio = float64(result.OriginalSize) / float64(result.CompressedSize)
+	result.CompressSpeed = float64(result.OriginalSize) / 1e6 / result.CompressTime.Seconds()
+	result.DecompressSpeed = float64(result.OriginalSize) / 1e6 / result.DecompressTime.Seconds()
+	return result, nil
+}
+
+// benchRepeat runs pass until benchMinTime has elapsed and returns the time of one pass
+func benchRepeat(pass func() error) (time.Duration, error) {
+	passes := 0
+	start := time.Now()
+	for passes == 0 || time.Since(start) < benchMinTime {
+		if err := pass(); err != nil {
+			return 0, err
+		}
+		passes++
+	}
+	return time.Since(start) / time.Duration(passes), nil
+}
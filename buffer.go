@@ -0,0 +1,54 @@
+package zstd
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Size classes of the pooled buffers, as powers of two
+const (
+	minBufferClass = 10 // 1KB
+	maxBufferClass = 26 // 64MB
+)
+
+// bufferPools keeps scratch buffers for reuse, one pool per size class, so one-shot
+// operations and short-lived Readers and Writers do not allocate them every time
+var bufferPools [maxBufferClass - minBufferClass + 1]sync.Pool
+
+// getBuffer returns a buffer of size bytes, with undefined content, from the pool of
+// its size class. Sizes beyond the largest class are allocated directly.
+func getBuffer(size int) []byte {
+	if size <= 0 || size > 1<<maxBufferClass {
+		return make([]byte, size)
+	}
+
+	class := max(bits.Len(uint(size-1)), minBufferClass) - minBufferClass
+	if b, ok := bufferPools[class].Get().(*[]byte); ok {
+		return (*b)[:size]
+	}
+	return make([]byte, size, 1<<(class+minBufferClass))
+}
+
+// putBuffer hands b back to the pool of its size class for reuse. Buffers of other
+// capacities, which getBuffer did not return, are left to the garbage collector.
+// b must not be used afterwards.
+func putBuffer(b []byte) {
+	c := cap(b)
+	if c < 1<<minBufferClass || c > 1<<maxBufferClass || c&(c-1) != 0 {
+		return
+	}
+	b = b[:0]
+	bufferPools[bits.Len(uint(c))-1-minBufferClass].Put(&b)
+}
+
+// scratchResult returns the first n bytes of the scratch buffer b in a slice of their
+// own and hands b back to the pool, unless the result fills b, which is returned then
+func scratchResult(b []byte, n int) []byte {
+	if n == len(b) {
+		return b
+	}
+	result := make([]byte, n)
+	copy(result, b)
+	putBuffer(b)
+	return result
+}
@@ -0,0 +1,97 @@
+package zstd
+
+import (
+	"bytes"
+	"context"
+	"io"
+)
+
+// maxPreallocSize bounds the output buffer allocated up front from an untrusted frame header
+const maxPreallocSize = 64 << 20
+
+// CompressContext compresses src like Compress, in chunks, checking ctx between them.
+// It returns the context's error as soon as it is done, so a request-scoped server can
+// stop compressing large inputs when the client goes away.
+func (z *Zstd) CompressContext(ctx context.Context, src []byte, level int) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(src) == 0 {
+		return []byte{}, nil
+	}
+
+	// The pledged size is recorded in the frame header, as Compress does
+	var buf bytes.Buffer
+	buf.Grow(z.CompressBound(len(src)))
+	w := z.NewWriter(&buf, level, WithContext(ctx), WithContentSize(int64(len(src))))
+	if _, err := w.Write(src); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// DecompressContext decompresses src, which may hold several frames, checking ctx between
+// chunks of output. The maxSize parameter limits the decompressed size; use 0 for no limit.
+// A larger output fails with a *MaxSizeError.
+func (z *Zstd) DecompressContext(ctx context.Context, src []byte, maxSize int) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(src) == 0 {
+		return []byte{}, nil
+	}
+
+	r := z.NewReader(bytes.NewReader(src), WithContext(ctx), WithMaxDecompressSize(int64(maxSize)))
+	defer r.Close()
+
+	// Start from the recorded size, if any, so the output is not copied as it grows
+	var buf bytes.Buffer
+	if header, err := r.Header(); err == nil && header.HasContentSize {
+		size := header.ContentSize
+		if maxSize > 0 {
+			size = min(size, uint64(maxSize))
+		}
+		buf.Grow(int(min(size, maxPreallocSize)))
+	}
+
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CompressContext compresses src at the default level, stopping once ctx is done.
+func CompressContext(ctx context.Context, src []byte) ([]byte, error) {
+	z, err := New()
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	return z.CompressContext(ctx, src, DefaultCompression)
+}
+
+// DecompressContext decompresses src, stopping once ctx is done.
+// The maxSize parameter limits the decompressed size; use 0 for no limit.
+func DecompressContext(ctx context.Context, src []byte, maxSize int) ([]byte, error) {
+	z, err := New()
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+
+	return z.DecompressContext(ctx, src, maxSize)
+}
@@ -0,0 +1,67 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// legacyMagic is the magic number of zstd v0.7 frames, the last legacy format
+const legacyMagic = 0xFD2FB527
+
+// Capabilities describes the features of the loaded library. Builds of libzstd differ
+// in the optional features they include.
+type Capabilities struct {
+	Version              string // Library version, such as "1.5.5"
+	MinLevel             int    // Lowest (fastest) compression level
+	MaxLevel             int    // Highest compression level
+	Multithreading       bool   // Compression can run in worker threads (WithWorkers)
+	LongDistanceMatching bool   // Long distance matching can be enabled for large windows
+	LegacyFormats        bool   // Frames from zstd versions before 0.8 can be decoded
+	AdvancedAPI          bool   // The advanced parameter and streaming API is available
+	DictionaryBuilder    bool   // Dictionaries can be trained from samples
+}
+
+// Capabilities probes the loaded library for optional features, so applications can
+// enable them only where they are available
+func (z *Zstd) Capabilities() (Capabilities, error) {
+	if z.isClosed() {
+		return Capabilities{}, ErrAlreadyClosed
+	}
+
+	caps := Capabilities{
+		Version:     z.versionString(),
+		MinLevel:    int(z.minCLevel()),
+		MaxLevel:    int(z.maxCLevel()),
+		AdvancedAPI: z.hasSymbol("ZSTD_CCtx_setParameter") && z.hasSymbol("ZSTD_compressStream2"),
+	}
+	caps.DictionaryBuilder = z.hasSymbol("ZDICT_trainFromBuffer")
+
+	// Parameters that the build doesn't support are rejected by the library
+	cctx := z.getCCtx()
+	if cctx == nil {
+		return Capabilities{}, z.allocError("probe capabilities", "compression context")
+	}
+	defer z.putCCtx(cctx)
+	caps.Multithreading = z.isError(z.cctxSetParameter(cctx, cParamNbWorkers, 1)) == 0
+	caps.LongDistanceMatching = z.isError(z.cctxSetParameter(cctx, cParamEnableLongDistanceMatching, 1)) == 0
+
+	// Legacy frames are only recognized by builds that can decode them
+	if z.hasSymbol("ZSTD_isFrame") {
+		var isFrame func(buffer unsafe.Pointer, size uint64) uint32
+		purego.RegisterLibFunc(&isFrame, z.handle, "ZSTD_isFrame")
+
+		var magic [4]byte
+		binary.LittleEndian.PutUint32(magic[:], legacyMagic)
+		caps.LegacyFormats = isFrame(unsafe.Pointer(&magic[0]), uint64(len(magic))) != 0
+	}
+
+	return caps, nil
+}
+
+// hasSymbol reports whether the loaded library exports the named function
+func (z *Zstd) hasSymbol(name string) bool {
+	sym, err := purego.Dlsym(z.handle, name)
+	return err == nil && sym != 0
+}
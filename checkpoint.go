@@ -0,0 +1,176 @@
+package zstd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// defaultCheckpointInterval is the input compressed between checkpoints by default
+const defaultCheckpointInterval = 64 << 20
+
+// Checkpoint records where an interrupted CompressFileResumable job resumes. Both
+// offsets are at the end of a frame.
+type Checkpoint struct {
+	Input  int64 `json:"input"`  // Bytes of the source compressed
+	Output int64 `json:"output"` // Bytes of the destination written
+}
+
+// ResumeOptions configures CompressFileResumable
+type ResumeOptions struct {
+	Interval int64 // Source bytes compressed between checkpoints (0 = 64 MiB)
+
+	// OnCheckpoint is called after each checkpoint is recorded, if set. Returning an
+	// error stops the job, which can be resumed later.
+	OnCheckpoint func(Checkpoint) error
+}
+
+// CompressFileResumable compresses the file at src to the file at dst, recording a
+// checkpoint in dst+".checkpoint" every Interval bytes of input. Each interval is
+// compressed as a frame of its own, synced to disk before its checkpoint is recorded.
+// If a checkpoint is found, the job resumes from it: dst is cut back to the end of the
+// last recorded frame, after checking the frames before it, and compression restarts
+// from the matching offset of src, so an interrupted job loses at most one interval of
+// work. The checkpoint is removed once dst is complete. The Writer of each frame is
+// configured by opts.
+func (z *Zstd) CompressFileResumable(src, dst string, options ResumeOptions, opts ...Option) error {
+	if z.isClosed() {
+		return ErrAlreadyClosed
+	}
+	interval := options.Interval
+	if interval <= 0 {
+		interval = defaultCheckpointInterval
+	}
+
+	checkpointPath := dst + ".checkpoint"
+	checkpoint, err := readCheckpoint(checkpointPath)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if info, err := in.Stat(); err != nil {
+		return err
+	} else if info.Size() < checkpoint.Input {
+		return fmt.Errorf("zstd: resume %s: source is shorter than the checkpoint", dst)
+	}
+
+	out, err := os.OpenFile(dst, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Only the recorded frames are kept, and they must be complete
+	if err := z.walkFrames(out, checkpoint.Output, func(frameInfo) error { return nil }); err != nil {
+		return fmt.Errorf("zstd: resume %s: %w", dst, err)
+	}
+	if err := out.Truncate(checkpoint.Output); err != nil {
+		return err
+	}
+	if _, err := out.Seek(checkpoint.Output, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := in.Seek(checkpoint.Input, io.SeekStart); err != nil {
+		return err
+	}
+
+	for {
+		w := z.NewWriter(out, 0, opts...)
+		if w.err != nil {
+			return w.err
+		}
+		n, err := io.CopyN(w, in, interval)
+		if err != nil && err != io.EOF {
+			w.Close()
+			return err
+		}
+		if closeErr := w.Close(); closeErr != nil {
+			return closeErr
+		}
+		checkpoint.Input += n
+		checkpoint.Output += w.BytesOut()
+		if err == io.EOF {
+			break
+		}
+
+		if err := out.Sync(); err != nil {
+			return err
+		}
+		if err := writeCheckpoint(checkpointPath, checkpoint); err != nil {
+			return err
+		}
+		if options.OnCheckpoint != nil {
+			if err := options.OnCheckpoint(checkpoint); err != nil {
+				return err
+			}
+		}
+	}
+
+	// The last frame is on disk before the checkpoint that could resume it goes away
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(checkpointPath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// readCheckpoint returns the checkpoint recorded at path, or the start of the job if
+// there is none
+func readCheckpoint(path string) (Checkpoint, error) {
+	var checkpoint Checkpoint
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return checkpoint, nil
+	} else if err != nil {
+		return checkpoint, err
+	}
+
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return checkpoint, fmt.Errorf("zstd: checkpoint %s: %w", path, err)
+	}
+	if checkpoint.Input < 0 || checkpoint.Output < 0 {
+		return checkpoint, fmt.Errorf("zstd: checkpoint %s: negative offset", path)
+	}
+	return checkpoint, nil
+}
+
+// writeCheckpoint records the checkpoint at path, replacing the previous one atomically
+func writeCheckpoint(path string, checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,390 @@
+// Command gozstd compresses and decompresses files in the Zstandard format, like the
+// zstd command line tool, without depending on it at build time.
+//
+// Usage:
+//
+//	gozstd [compress] [flags] [file...]
+//	gozstd decompress [flags] [file...]
+//	gozstd test [flags] [file...]
+//	gozstd list [file...]
+//	gozstd index [flags] [file...]
+//
+// Without files, or with -, data is read from standard input and written to standard
+// output. Otherwise compressing file writes file.zst, and decompressing file.zst writes
+// file; the source is kept unless -rm is given. Indexing file.zst writes the seek table
+// of its frames to file.zst.seek, for random access to it. Levels are given as -1 to -22, as with
+// zstd, and -T, -long and -D work as their zstd counterparts.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/develerltd/zstd-purego"
+)
+
+// config holds the flags of a command
+type config struct {
+	level   int
+	workers int
+	long    longFlag
+	dict    string
+	output  string
+	stdout  bool
+	force   bool
+	remove  bool
+	check   bool
+}
+
+// longFlag is -long, enabling long distance matching, or -long=N setting the window log
+type longFlag struct {
+	windowLog int // 0 = disabled
+}
+
+func (l *longFlag) String() string {
+	if l.windowLog == 0 {
+		return "false"
+	}
+	return strconv.Itoa(l.windowLog)
+}
+
+func (l *longFlag) Set(s string) error {
+	switch s {
+	case "true":
+		l.windowLog = 27
+	case "false":
+		l.windowLog = 0
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 10 || n > 31 {
+			return errors.New("window log must be from 10 to 31")
+		}
+		l.windowLog = n
+	}
+	return nil
+}
+
+func (l *longFlag) IsBoolFlag() bool {
+	return true
+}
+
+// Arguments the flag package doesn't parse: a level given as -#, and threads as -T#
+var (
+	levelArg   = regexp.MustCompile(`^-([0-9]+)$`)
+	threadsArg = regexp.MustCompile(`^-T([0-9]+)$`)
+)
+
+func main() {
+	args := os.Args[1:]
+	command := "compress"
+	if len(args) > 0 {
+		switch args[0] {
+		case "compress", "decompress", "test", "list", "index":
+			command, args = args[0], args[1:]
+		}
+	}
+
+	cfg := config{check: true}
+	flags := flag.NewFlagSet(command, flag.ExitOnError)
+	flags.IntVar(&cfg.workers, "T", 0, "compress in this many threads (0 = single-threaded)")
+	flags.Var(&cfg.long, "long", "enable long distance matching, with a window log of 27 or the one given")
+	flags.StringVar(&cfg.dict, "D", "", "use the dictionary in this file")
+	flags.StringVar(&cfg.output, "o", "", "write the output to this file")
+	flags.BoolVar(&cfg.stdout, "c", false, "write the output to standard output")
+	flags.BoolVar(&cfg.force, "f", false, "overwrite existing output files")
+	flags.BoolVar(&cfg.remove, "rm", false, "remove source files once processed")
+	flags.BoolVar(&cfg.check, "check", true, "add a content checksum to compressed frames")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [compress|decompress|test|list|index] [-#] [flags] [file...]\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	// The flag package doesn't parse levels given as -#, nor values attached as in -T4
+	var rest, files []string
+	for i, arg := range args {
+		if arg == "--" {
+			files = args[i+1:]
+			break
+		}
+		if m := levelArg.FindStringSubmatch(arg); m != nil {
+			cfg.level, _ = strconv.Atoi(m[1])
+		} else if m := threadsArg.FindStringSubmatch(arg); m != nil {
+			rest = append(rest, "-T", m[1])
+		} else {
+			rest = append(rest, arg)
+		}
+	}
+
+	// Flags may follow files, as with zstd
+	var named []string
+	for flags.Parse(rest); flags.NArg() > 0; flags.Parse(rest) {
+		named = append(named, flags.Arg(0))
+		rest = flags.Args()[1:]
+	}
+	files = append(named, files...)
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	if cfg.output != "" && len(files) > 1 {
+		fmt.Fprintln(os.Stderr, "gozstd: -o needs a single input file")
+		os.Exit(2)
+	}
+	if cfg.remove && command == "index" {
+		fmt.Fprintln(os.Stderr, "gozstd: -rm would remove the files indexed")
+		os.Exit(2)
+	}
+
+	z, err := zstd.New()
+	if err != nil {
+		fatal(err)
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		fatal(err)
+	}
+
+	status := 0
+	if command == "list" {
+		fmt.Printf("%6s %6s %11s %13s %7s %6s  %s\n", "Frames", "Skips", "Compressed", "Uncompressed", "Ratio", "Check", "Filename")
+	}
+	for _, name := range files {
+		var err error
+		switch command {
+		case "compress":
+			err = process(name, &cfg, compressedName, func(dst io.Writer, src io.Reader) error {
+				return compress(z, dst, src, opts)
+			})
+		case "decompress":
+			err = process(name, &cfg, decompressedName, func(dst io.Writer, src io.Reader) error {
+				return decompress(z, dst, src, opts)
+			})
+		case "test":
+			err = withInput(name, func(src io.Reader) error {
+				return decompress(z, io.Discard, src, opts)
+			})
+		case "list":
+			err = withInput(name, func(src io.Reader) error {
+				return list(z, name, src)
+			})
+		case "index":
+			err = process(name, &cfg, indexName, func(dst io.Writer, src io.Reader) error {
+				return index(z, dst, src)
+			})
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "gozstd: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	z.Close()
+	os.Exit(status)
+}
+
+// options returns the options of the Readers and Writers the flags configure
+func (cfg *config) options() ([]zstd.Option, error) {
+	opts := []zstd.Option{zstd.WithChecksum(cfg.check)}
+	if cfg.level != 0 {
+		opts = append(opts, zstd.WithLevel(cfg.level))
+	}
+	if cfg.workers > 0 {
+		opts = append(opts, zstd.WithWorkers(cfg.workers))
+	}
+	if cfg.long.windowLog > 0 {
+		opts = append(opts, zstd.WithLongDistance(true), zstd.WithWindowLog(cfg.long.windowLog))
+	}
+	if cfg.dict != "" {
+		dict, err := os.ReadFile(cfg.dict)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, zstd.WithDictionary(dict))
+	}
+	return opts, nil
+}
+
+// process streams the file at name, or standard input for -, through convert into its
+// output file, named by outputName unless set by the flags
+func process(name string, cfg *config, outputName func(string) (string, error), convert func(dst io.Writer, src io.Reader) error) error {
+	return withInput(name, func(src io.Reader) error {
+		dstName := "-"
+		if cfg.output != "" {
+			dstName = cfg.output
+		} else if !cfg.stdout && name != "-" {
+			var err error
+			if dstName, err = outputName(name); err != nil {
+				return err
+			}
+		}
+
+		if dstName == "-" {
+			out := bufio.NewWriter(os.Stdout)
+			if err := convert(out, src); err != nil {
+				return err
+			}
+			return out.Flush()
+		}
+
+		if !cfg.force {
+			if _, err := os.Stat(dstName); err == nil {
+				return fmt.Errorf("%s already exists, use -f to overwrite it", dstName)
+			}
+		}
+		out, err := os.Create(dstName)
+		if err != nil {
+			return err
+		}
+		if err := convert(out, src); err != nil {
+			out.Close()
+			os.Remove(dstName)
+			return err
+		}
+		if err := out.Close(); err != nil {
+			return err
+		}
+
+		if cfg.remove && name != "-" {
+			return os.Remove(name)
+		}
+		return nil
+	})
+}
+
+// withInput calls fn with the content of the file at name, or standard input for -
+func withInput(name string, fn func(src io.Reader) error) error {
+	if name == "-" {
+		return fn(bufio.NewReader(os.Stdin))
+	}
+
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return fn(f)
+}
+
+// compressedName returns the name of the compressed file for the file at name
+func compressedName(name string) (string, error) {
+	if strings.HasSuffix(name, ".zst") {
+		return "", errors.New("already has the .zst suffix")
+	}
+	return name + ".zst", nil
+}
+
+// decompressedName returns the name of the decompressed file for the file at name
+func decompressedName(name string) (string, error) {
+	base, ok := strings.CutSuffix(name, ".zst")
+	if !ok || base == "" {
+		return "", errors.New("unknown suffix, expected .zst")
+	}
+	return base, nil
+}
+
+// indexName returns the name of the seek table of the file at name
+func indexName(name string) (string, error) {
+	return name + ".seek", nil
+}
+
+func compress(z *zstd.Zstd, dst io.Writer, src io.Reader, opts []zstd.Option) error {
+	w := z.NewWriter(dst, 0, opts...)
+	if _, err := io.Copy(w, src); err != nil {
+		w.Abort()
+		return err
+	}
+	return w.Close()
+}
+
+func decompress(z *zstd.Zstd, dst io.Writer, src io.Reader, opts []zstd.Option) error {
+	r := z.NewReader(src, opts...)
+	defer r.Close()
+	_, err := io.Copy(dst, r)
+	return err
+}
+
+// list prints the frames of the stream read from src, like zstd -l
+func list(z *zstd.Zstd, name string, src io.Reader) error {
+	frames, err := z.ListFrames(src)
+	if err != nil {
+		return err
+	}
+
+	checked := 0
+	for _, frame := range frames.Frames {
+		if frame.HasChecksum {
+			checked++
+		}
+	}
+	check := "Mixed"
+	switch checked {
+	case 0:
+		check = "None"
+	case len(frames.Frames) - frames.SkippableFrames:
+		check = "XXH64"
+	}
+
+	uncompressed, ratio := "", ""
+	if frames.HasContentSize {
+		uncompressed = formatSize(int64(frames.ContentSize))
+		ratio = strconv.FormatFloat(frames.Ratio(), 'f', 3, 64)
+	}
+	fmt.Printf("%6d %6d %11s %13s %7s %6s  %s\n", len(frames.Frames)-frames.SkippableFrames, frames.SkippableFrames,
+		formatSize(frames.CompressedSize), uncompressed, ratio, check, name)
+	return nil
+}
+
+// index writes the seek table of the frames of the stream read from src
+func index(z *zstd.Zstd, dst io.Writer, src io.Reader) error {
+	var r io.ReaderAt
+	var size int64
+	if f, ok := src.(*os.File); ok {
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			r, size = f, info.Size()
+		}
+	}
+	if r == nil {
+		data, err := io.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		r, size = bytes.NewReader(data), int64(len(data))
+	}
+
+	table, err := z.BuildSeekTable(r, size)
+	if err != nil {
+		return err
+	}
+	_, err = dst.Write(table)
+	return err
+}
+
+// formatSize returns size with a binary unit, as zstd -l shows it
+func formatSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprintf("%d B", size)
+	}
+	value, unit := float64(size)/1024, "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < 1024 {
+			break
+		}
+		value, unit = value/1024, next
+	}
+	return fmt.Sprintf("%.2f %s", value, unit)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "gozstd:", err)
+	os.Exit(1)
+}
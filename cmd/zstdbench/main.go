@@ -0,0 +1,222 @@
+// Command zstdbench measures compression ratio and speed on a corpus across levels,
+// and dictionaries, so parameters can be chosen on actual data.
+//
+// Usage:
+//
+//	zstdbench [flags] path...
+//
+// Each file is compressed on its own, and directories are walked for files. With -B,
+// files are cut into blocks of that size instead, which suits measuring dictionaries
+// for small messages. Every level is measured without a dictionary and with each -D.
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/develerltd/zstd-purego"
+)
+
+// listFlag collects the values of a flag given several times
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
+}
+
+// result measures compressing the corpus with one set of parameters
+type result struct {
+	compressedSize  int64
+	compressSpeed   float64 // Bytes compressed per second
+	decompressSpeed float64 // Bytes decompressed per second
+}
+
+func main() {
+	var (
+		levels    = flag.String("l", "1,3,6,9,12,15,19", "levels to measure, as a list of levels and ranges such as 1-5,19")
+		blockSize = flag.Int("B", 0, "cut files into samples of this many bytes (0 = whole files)")
+		minTime   = flag.Duration("t", time.Second, "minimum time spent on each measurement")
+		dicts     listFlag
+	)
+	flag.Var(&dicts, "D", "dictionary file to measure, may be given several times")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] path...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	levelList, err := parseLevels(*levels)
+	if err != nil {
+		fatal(err)
+	}
+	samples, total, err := zstd.ReadSamples(flag.Args(), *blockSize)
+	if err != nil {
+		fatal(err)
+	}
+	if total == 0 {
+		fatal(errors.New("the corpus is empty"))
+	}
+
+	z, err := zstd.New()
+	if err != nil {
+		fatal(err)
+	}
+	defer z.Close()
+
+	dictionaries := []*zstd.Dictionary{nil}
+	for _, path := range dicts {
+		dict, err := z.LoadDictionaryFile(path)
+		if err != nil {
+			fatal(err)
+		}
+		dictionaries = append(dictionaries, dict)
+	}
+
+	fmt.Printf("Corpus of %d samples, %d bytes in total\n\n", len(samples), total)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "Level\tDictionary\tCompressed\tRatio\tCompress MB/s\tDecompress MB/s\t")
+	for _, level := range levelList {
+		for i, dict := range dictionaries {
+			r, err := bench(z, samples, dict, level, *minTime)
+			if err != nil {
+				fatal(err)
+			}
+			name := "-"
+			if dict != nil {
+				name = filepath.Base(dicts[i-1])
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%d\t%.3f\t%.1f\t%.1f\t\n", level, name, r.compressedSize,
+				float64(total)/float64(r.compressedSize), r.compressSpeed/1e6, r.decompressSpeed/1e6)
+		}
+	}
+	tw.Flush()
+}
+
+// bench compresses and decompresses each sample with the dictionary, or none if nil,
+// repeating passes over the samples for at least minTime each way
+func bench(z *zstd.Zstd, samples [][]byte, dict *zstd.Dictionary, level int, minTime time.Duration) (result, error) {
+	var r result
+	if dict != nil {
+		// Digest the dictionary ahead, as applications compressing many messages do
+		if err := dict.Compile(level); err != nil {
+			return r, err
+		}
+		if err := dict.CompileDecoder(); err != nil {
+			return r, err
+		}
+		defer dict.Release()
+	}
+
+	var total int64
+	compressed := make([][]byte, len(samples))
+	compressTime, err := repeat(minTime, func() error {
+		for i, s := range samples {
+			c, err := z.CompressUsingDict(s, dict, level)
+			if err != nil {
+				return err
+			}
+			compressed[i] = c
+		}
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+	for i, c := range compressed {
+		r.compressedSize += int64(len(c))
+		total += int64(len(samples[i]))
+	}
+
+	// Check the round trip before timing it
+	for i, c := range compressed {
+		d, err := z.DecompressUsingDict(c, dict, len(samples[i]))
+		if err != nil {
+			return r, err
+		}
+		if !bytes.Equal(d, samples[i]) {
+			return r, fmt.Errorf("sample %d did not round trip at level %d", i, level)
+		}
+	}
+	decompressTime, err := repeat(minTime, func() error {
+		for i, c := range compressed {
+			if _, err := z.DecompressUsingDict(c, dict, len(samples[i])); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return r, err
+	}
+
+	r.compressSpeed = float64(total) / compressTime.Seconds()
+	r.decompressSpeed = float64(total) / decompressTime.Seconds()
+	return r, nil
+}
+
+// repeat calls pass once to warm up, then until minTime has elapsed, and returns the
+// average time of a pass
+func repeat(minTime time.Duration, pass func() error) (time.Duration, error) {
+	if err := pass(); err != nil {
+		return 0, err
+	}
+
+	passes := 0
+	start := time.Now()
+	for passes == 0 || time.Since(start) < minTime {
+		if err := pass(); err != nil {
+			return 0, err
+		}
+		passes++
+	}
+	return time.Since(start) / time.Duration(passes), nil
+}
+
+// parseLevels parses a list of levels and ranges of levels, such as 1-5,19
+func parseLevels(s string) ([]int, error) {
+	var levels []int
+	for _, part := range strings.Split(s, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		if first == "" && isRange {
+			// A negative level, or a range starting at one
+			rest, tail, _ := strings.Cut(last, "-")
+			first, last, isRange = "-"+rest, tail, tail != ""
+		}
+		from, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid level %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(last); err != nil || to < from {
+				return nil, fmt.Errorf("invalid level range %q", part)
+			}
+		}
+		for level := from; level <= to; level++ {
+			levels = append(levels, level)
+		}
+	}
+	return levels, nil
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "zstdbench:", err)
+	os.Exit(1)
+}
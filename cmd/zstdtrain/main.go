@@ -0,0 +1,89 @@
+// Command zstdtrain trains a Zstandard dictionary from sample files, like zstd --train.
+//
+// Usage:
+//
+//	zstdtrain [flags] path...
+//
+// Each file is a sample, and directories are walked for files. With -B, files are cut
+// into blocks of that size instead, for training on a few large files.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/develerltd/zstd-purego"
+)
+
+func main() {
+	var (
+		output    = flag.String("o", "dictionary", "file to write the dictionary to")
+		maxDict   = flag.Int("maxdict", 112640, "maximum size of the dictionary in bytes")
+		blockSize = flag.Int("B", 0, "cut files into samples of this many bytes (0 = whole files)")
+		fast      = flag.Bool("fast", false, "train with fastCover instead of COVER")
+		k         = flag.Int("k", 0, "segment size (0 = search)")
+		d         = flag.Int("d", 0, "dmer size (0 = search)")
+		steps     = flag.Int("steps", 0, "parameter combinations tried while searching (0 = 40)")
+		split     = flag.Float64("split", 0, "fraction of samples trained on, the rest scoring candidates")
+		accel     = flag.Int("accel", 0, "fastCover acceleration from 1 to 10")
+		f         = flag.Int("f", 0, "fastCover log2 of the frequency table size")
+		threads   = flag.Int("T", runtime.NumCPU(), "threads searching in parallel")
+		dictID    = flag.Uint("dictID", 0, "ID recorded in the dictionary (0 = random)")
+		level     zstd.Level
+	)
+	flag.Var(&level, "level", "compression level the dictionary is optimized for (number, fast, default, best or ultra)")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] path...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	samples, total, err := zstd.ReadSamples(flag.Args(), *blockSize)
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Training on %d samples of %d bytes in total\n", len(samples), total)
+
+	z, err := zstd.New()
+	if err != nil {
+		fatal(err)
+	}
+	defer z.Close()
+
+	dictData, tuned, err := z.TrainDictionary(samples, *maxDict, zstd.TrainingOptions{
+		FastCover: *fast,
+		K:         *k,
+		D:         *d,
+		Steps:     *steps,
+		Split:     *split,
+		Accel:     *accel,
+		F:         *f,
+		Threads:   *threads,
+		Level:     int(level),
+		DictID:    uint32(*dictID),
+	})
+	if err != nil {
+		fatal(err)
+	}
+
+	dict, err := z.LoadDictionary(dictData)
+	if err != nil {
+		fatal(err)
+	}
+	if err := z.SaveDictionary(*output, dict); err != nil {
+		fatal(err)
+	}
+	fmt.Fprintf(os.Stderr, "Saved dictionary %d of %d bytes to %s (k=%d d=%d)\n",
+		dict.ID(), dict.Size(), *output, tuned.K, tuned.D)
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "zstdtrain:", err)
+	os.Exit(1)
+}
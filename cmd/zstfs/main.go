@@ -0,0 +1,108 @@
+// Command zstfs prepares a directory of static assets to be embedded compressed, and
+// served by the zstfs package or zstd.FS. It is meant to be run by go:generate:
+//
+//	//go:generate go run github.com/develerltd/zstd-purego/cmd/zstfs -o assets static
+//
+// Usage:
+//
+//	zstfs [flags] -o output source
+//
+// Each file of the source directory is written to the same path of the output directory,
+// compressed as name.zst if that saves at least the -min ratio of its size, and copied
+// as it is otherwise, as for images that are compressed already. Files of the output
+// directory left from a previous run under the other name are removed.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/develerltd/zstd-purego"
+)
+
+func main() {
+	var (
+		level    = flag.Int("l", zstd.BestCompression, "compression level")
+		output   = flag.String("o", "", "directory to write the assets to")
+		minSaved = flag.Float64("min", 0.1, "fraction of its size a file must save to be stored compressed")
+		verbose  = flag.Bool("v", false, "print the size of each file")
+	)
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags] -o output source\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 || *output == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	source := flag.Arg(0)
+
+	z, err := zstd.New()
+	if err != nil {
+		fatal(err)
+	}
+	defer z.Close()
+
+	var total, stored int64
+	err = filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		compressed, err := z.Compress(data, *level)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		dst, stale := filepath.Join(*output, rel), filepath.Join(*output, rel+".zst")
+		if float64(len(compressed)) <= float64(len(data))*(1-*minSaved) {
+			dst, stale, data = stale, dst, compressed
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(dst, data, 0o644); err != nil {
+			return err
+		}
+		if err := os.Remove(stale); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		total += info.Size()
+		stored += int64(len(data))
+		if *verbose {
+			fmt.Printf("%10d %10d  %s\n", info.Size(), len(data), dst)
+		}
+		return nil
+	})
+	if err != nil {
+		fatal(err)
+	}
+	if *verbose {
+		fmt.Printf("%10d %10d  total\n", total, stored)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "zstfs:", err)
+	os.Exit(1)
+}
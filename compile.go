@@ -0,0 +1,167 @@
+package zstd
+
+import (
+	"runtime"
+	"unsafe"
+)
+
+// maxCompiledLevels bounds the compression dictionaries kept per Dictionary, one per level
+const maxCompiledLevels = 4
+
+// Compile digests the dictionary for compression at the given level (0 = the instance
+// default) and keeps it, so CompressUsingDict at that level no longer digests the
+// dictionary on every call. CompressUsingDict compiles the levels it uses by itself;
+// Compile moves the cost ahead of the first call.
+// Up to 4 levels are kept, the oldest being replaced first. The native memory is held
+// until Release, or until the dictionary is garbage collected.
+func (d *Dictionary) Compile(level int) error {
+	z := d.zstd
+	if z.isClosed() {
+		return ErrAlreadyClosed
+	}
+	level = z.resolveLevel(level)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.cdicts[level] != nil {
+		return nil
+	}
+
+	cdict := d.digestCDict(z, level)
+	if cdict == nil {
+		return z.allocError("compile dictionary", "compression dictionary")
+	}
+	d.setFinalizer()
+	if len(d.cdictLevels) == maxCompiledLevels {
+		oldest := d.cdictLevels[0]
+		z.freeCDict(d.cdicts[oldest])
+		delete(d.cdicts, oldest)
+		d.cdictLevels = d.cdictLevels[1:]
+	}
+	if d.cdicts == nil {
+		d.cdicts = make(map[int]unsafe.Pointer, maxCompiledLevels)
+	}
+	d.cdicts[level] = cdict
+	d.cdictLevels = append(d.cdictLevels, level)
+	return nil
+}
+
+// CompileDecoder digests the dictionary for decompression and keeps it, so
+// DecompressUsingDict no longer digests the dictionary on every call.
+// The native memory is held until Release, or until the dictionary is garbage collected.
+func (d *Dictionary) CompileDecoder() error {
+	z := d.zstd
+	if z.isClosed() {
+		return ErrAlreadyClosed
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.ddict != nil {
+		return nil
+	}
+
+	ddict := d.digestDDict(z)
+	if ddict == nil {
+		return z.allocError("compile dictionary", "decompression dictionary")
+	}
+	d.setFinalizer()
+	d.ddict = ddict
+	return nil
+}
+
+// setFinalizer releases the compiled dictionaries when the dictionary is collected.
+// It is called with the lock held, before the first one is kept. Dictionaries loaded
+// by reference have their finalizer from the start.
+func (d *Dictionary) setFinalizer() {
+	if len(d.cdicts) == 0 && d.ddict == nil && !d.byReference {
+		runtime.SetFinalizer(d, (*Dictionary).finalize)
+	}
+}
+
+// finalize frees the native resources of a dictionary that is no longer reachable.
+// Streams that referenced its digested dictionaries are unreachable as well.
+func (d *Dictionary) finalize() {
+	d.Release()
+	if d.byReference {
+		d.pinner.Unpin()
+	}
+}
+
+// Release frees the digested dictionaries kept for reuse.
+// It waits for calls using them to finish; the dictionary remains usable afterwards.
+// The data of a dictionary loaded by reference stays pinned until it is collected.
+func (d *Dictionary) Release() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if !d.byReference {
+		runtime.SetFinalizer(d, nil)
+	}
+
+	// The native objects went away with the library
+	if !d.zstd.isClosed() {
+		for _, cdict := range d.cdicts {
+			d.zstd.freeCDict(cdict)
+		}
+		if d.ddict != nil {
+			d.zstd.freeDDict(d.ddict)
+		}
+	}
+	d.cdicts, d.cdictLevels, d.ddict = nil, nil, nil
+}
+
+// compiledCDict returns the compression dictionary for the level, compiling it if needed,
+// or a new one digested for a single use when it can't be kept. The returned function must
+// be called once it is no longer used.
+func (d *Dictionary) compiledCDict(z *Zstd, level int) (unsafe.Pointer, func()) {
+	if d.zstd == z {
+		for compiled := false; ; compiled = true {
+			d.mu.RLock()
+			if cdict := d.cdicts[level]; cdict != nil {
+				return cdict, d.mu.RUnlock
+			}
+			d.mu.RUnlock()
+
+			// Replaced by other levels right after compiling it; don't keep trying
+			if compiled || d.Compile(level) != nil {
+				break
+			}
+		}
+	}
+
+	cdict := d.digestCDict(z, level)
+	return cdict, func() { z.freeCDict(cdict) }
+}
+
+// compiledDDict returns the compiled decompression dictionary, or a new one digested
+// for a single use. The returned function must be called once it is no longer used.
+func (d *Dictionary) compiledDDict(z *Zstd) (unsafe.Pointer, func()) {
+	if d.zstd == z {
+		d.mu.RLock()
+		if d.ddict != nil {
+			return d.ddict, d.mu.RUnlock
+		}
+		d.mu.RUnlock()
+	}
+
+	ddict := d.digestDDict(z)
+	return ddict, func() { z.freeDDict(ddict) }
+}
+
+// digestCDict creates a compression dictionary for the level, referring to the
+// dictionary data when it was loaded by reference
+func (d *Dictionary) digestCDict(z *Zstd, level int) unsafe.Pointer {
+	if d.byReference {
+		return z.createCDictByRef(unsafe.Pointer(&d.dictData[0]), uint64(len(d.dictData)), level)
+	}
+	return z.createCDict(unsafe.Pointer(&d.dictData[0]), uint64(len(d.dictData)), level)
+}
+
+// digestDDict creates a decompression dictionary, referring to the dictionary data
+// when it was loaded by reference
+func (d *Dictionary) digestDDict(z *Zstd) unsafe.Pointer {
+	if d.byReference {
+		return z.createDDictByRef(unsafe.Pointer(&d.dictData[0]), uint64(len(d.dictData)))
+	}
+	return z.createDDict(unsafe.Pointer(&d.dictData[0]), uint64(len(d.dictData)))
+}
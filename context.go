@@ -1,38 +1,133 @@
 package zstd
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
+	"math/bits"
+	"runtime"
+	"sync"
+	"time"
 	"unsafe"
 )
 
-// Reader implements an io.ReadCloser for reading and decompressing data
+// Reader implements an io.ReadCloser for reading and decompressing data.
+// The input may consist of several concatenated frames. Skippable frames, as used by
+// pzstd and the seekable format, are skipped transparently like the zstd CLI does.
 type Reader struct {
 	zstd        *Zstd
 	reader      io.Reader
-	ctx         unsafe.Pointer
 	buffer      []byte
 	inBuffer    ZstdInBuffer
 	outBuffer   ZstdOutBuffer
 	readBuffer  []byte
+	bufferSize  int // Size of buffer and readBuffer, pooled while the reader is closed
 	pos         int
 	end         int
 	streamEnded bool
 	stream      unsafe.Pointer
+	inFrame     bool // A frame has been started but not completely decoded
+	sourceEOF   bool // The underlying reader has returned io.EOF
+	flushing    bool // The last call filled the output buffer, so ZSTD may hold more output
+
+	windowSize        int   // Largest window accepted by the decoder (0 = library default)
+	maxDecompressSize int64 // Limit on total decompressed output (0 = no limit)
+	totalOut          int64 // Decompressed bytes produced so far
+	totalIn           int64 // Compressed bytes read from the source so far
+	err               error // Sticky error returned once buffered data has been drained
+
+	dict     *Dictionary         // Dictionary the stream was compressed with, if any
+	ddict    unsafe.Pointer      // Digested dictionary referenced by the stream
+	registry *DictionaryRegistry // Provides the dictionary by the ID of the first frame, if set
+	dictDone func()              // Releases the dictionary acquired from the registry, if any
+
+	dictLoad    DictLoadMethod  // How the stream loads the dictionary
+	dictContent DictContentType // How the stream interprets the dictionary
+	dictPinner  runtime.Pinner  // Pins the dictionary loaded by reference while the stream exists
+
+	skippableHandler func(magicVariant uint32, payload []byte) error // Receives skippable frames, if set
+
+	ownsZstd bool         // The instance was created for this reader alone and is closed with it
+	header   *FrameHeader // Header of the first frame, once parsed
+	single   bool         // Stop at the end of the first regular frame
+	pool     *ReaderPool  // Pool the reader belongs to, if any
+
+	frameEnds  bool // Stop filling at the end of each frame, setting frameEnded
+	frameEnded bool // The output buffered ends a frame
+
+	cancelCtx context.Context // Stops decompression once done (nil = never)
+
+	progress      func(processed, total int64) // Receives the compressed bytes consumed, if set
+	progressTotal int64                        // Size of the source, or -1 if unknown
+
+	mu        sync.Mutex  // Guards the decoding state while a readahead goroutine runs
+	delivered int64       // Decompressed bytes returned to the caller
+	readAhead int         // Chunks to decompress ahead in the background (0 = disabled)
+	prefetch  *prefetcher // Background decompression, started on the first Read
+	closed    bool        // Close has been called
+
+	op    *operation // Stream reported to the instrumentation, from the first Read
+	opErr error      // First error returned by Read, for the instrumentation
+
+	pinner runtime.Pinner // Pins the buffers referenced by inBuffer and outBuffer during native calls
 }
 
 // Read implements the io.Reader interface
 func (r *Reader) Read(p []byte) (int, error) {
+	if r.closed || r.zstd.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+	r.startOperation()
+
+	var n int
+	var err error
+	if r.readAhead > 0 {
+		if r.prefetch == nil {
+			r.startPrefetch()
+		}
+		n, err = r.prefetch.read(p)
+	} else {
+		n, err = r.read(p)
+	}
+	r.delivered += int64(n)
+	if r.op != nil && err != nil && err != io.EOF && r.opErr == nil {
+		r.opErr = err
+	}
+	return n, err
+}
+
+// read decompresses directly into p, refilling the internal buffer as needed
+func (r *Reader) read(p []byte) (int, error) {
+	if err := r.fill(); err != nil {
+		return 0, err
+	}
+
+	// Copy decompressed data from r.readBuffer to the caller's buffer p.
+	n := copy(p, r.readBuffer[r.pos:r.end])
+	r.pos += n // Advance our position in r.readBuffer
+
+	return n, nil
+}
+
+// fill decompresses more data into r.readBuffer once the previous pass has been consumed.
+// It may leave the buffer empty without an error if the source returned no data.
+func (r *Reader) fill() error {
 	// If we have data in the read buffer from a previous pass, use that first
 	if r.pos < r.end {
-		n := copy(p, r.readBuffer[r.pos:r.end])
-		r.pos += n
-		return n, nil
+		return nil
+	}
+
+	// Report a sticky error, such as an exceeded size limit, once buffered data is drained
+	if r.err != nil {
+		return r.err
 	}
 
 	// If the stream was previously marked as ended and all buffered data is consumed
 	if r.streamEnded {
-		return 0, io.EOF
+		return io.EOF
 	}
 
 	// Reset internal read buffer position; r.end will be set by decompressStream logic
@@ -41,13 +136,34 @@ func (r *Reader) Read(p []byte) (int, error) {
 
 	// Loop to decompress data and fill r.readBuffer.
 	// This loop continues as long as r.readBuffer is empty (r.end == 0) from this pass
-	// and the Zstandard stream has not definitively ended for the current frame.
+	// and the input has not definitively ended. The input may hold several concatenated
+	// frames, so completing a frame only ends the stream once the source is exhausted.
 	for r.end == 0 && !r.streamEnded {
+		// Give up between chunks once the caller no longer wants the data
+		if r.cancelCtx != nil {
+			if err := r.cancelCtx.Err(); err != nil {
+				r.streamEnded = true
+				r.err = err
+				return err
+			}
+		}
+
 		// Ensure ZSTD stream context is initialized
 		if r.stream == nil {
 			r.stream = r.zstd.createDStream()
 			if r.stream == nil {
-				return 0, fmt.Errorf("failed to create decompression stream")
+				return r.zstd.allocError("decompress", "decompression stream")
+			}
+			if r.windowSize > 0 {
+				result := r.zstd.dctxSetParameter(r.stream, dParamWindowLogMax, windowLogFor(r.windowSize))
+				if r.zstd.isError(result) != 0 {
+					return r.zstd.nativeError("decompress", result)
+				}
+			}
+			if r.dict != nil && len(r.dict.dictData) > 0 {
+				if err := r.refDictionary(); err != nil {
+					return err
+				}
 			}
 			// r.readBuffer is initialized in NewReader
 			if r.readBuffer == nil { // Safety check
@@ -56,8 +172,9 @@ func (r *Reader) Read(p []byte) (int, error) {
 		}
 
 		// If ZSTD's input buffer (r.inBuffer) has been fully consumed, read more compressed data from the source.
-		if r.inBuffer.Pos >= r.inBuffer.Size {
-			nBytesFromSource, sourceReadErr := r.reader.Read(r.buffer) // r.buffer is a temporary store for compressed data
+		if r.inBuffer.Pos >= r.inBuffer.Size && !r.sourceEOF {
+			nBytesFromSource, sourceReadErr := r.readSource(r.buffer) // r.buffer is a temporary store for compressed data
+			r.totalIn += int64(nBytesFromSource)
 
 			if nBytesFromSource > 0 {
 				r.inBuffer.Src = unsafe.Pointer(&r.buffer[0])
@@ -72,22 +189,53 @@ func (r *Reader) Read(p []byte) (int, error) {
 
 			if sourceReadErr != nil {
 				if sourceReadErr == io.EOF {
-					// Source reader is at EOF. ZSTD_decompressStream will be called with an
-					// empty input buffer. This is crucial for flushing ZSTD's internal buffers.
+					// Source reader is at EOF. ZSTD_decompressStream may still be called with an
+					// empty input buffer, which is crucial for flushing ZSTD's internal buffers.
+					r.sourceEOF = true
 				} else {
 					// A genuine error occurred while reading from the source.
-					return 0, sourceReadErr // Propagate the error
+					return sourceReadErr // Propagate the error
 				}
 			}
 
 			// If no bytes were read and no error (e.g., non-blocking read with no data),
 			// we should break this inner loop. The outer Read logic will return 0, nil,
-			// signaling the caller to try again.
-			if nBytesFromSource == 0 && sourceReadErr == nil {
+			// signaling the caller to try again. If the last call filled the output buffer,
+			// ZSTD may still hold output decoded from earlier input, so it is called first.
+			if nBytesFromSource == 0 && sourceReadErr == nil && !r.flushing {
 				break
 			}
 		}
 
+		// Between frames, hand skippable frames to the handler instead of letting ZSTD skip
+		// them, and record the header of the first regular frame before decoding it
+		if !r.inFrame && r.inBuffer.Pos < r.inBuffer.Size && (r.skippableHandler != nil || r.header == nil) {
+			handled, err := r.handleSkippableFrame()
+			if err != nil {
+				r.streamEnded = true
+				return err
+			}
+			if handled {
+				continue
+			}
+			if r.header == nil {
+				if _, err := r.parseHeader(); err != nil {
+					r.streamEnded = true
+					return err
+				}
+				if err := r.registryDictionary(); err != nil {
+					r.streamEnded = true
+					return err
+				}
+			}
+		}
+
+		// All input consumed and no frame in progress: this is the true end of the stream.
+		if r.inBuffer.Pos >= r.inBuffer.Size && r.sourceEOF && !r.inFrame {
+			r.streamEnded = true
+			break
+		}
+
 		// Prepare the output buffer for ZSTD.
 		// ZSTD will write decompressed data into r.readBuffer.
 		r.outBuffer.Dst = unsafe.Pointer(&r.readBuffer[0])
@@ -95,40 +243,57 @@ func (r *Reader) Read(p []byte) (int, error) {
 		r.outBuffer.Pos = 0 // ZSTD updates this to indicate how much was written.
 
 		// Call the Zstandard C function to decompress the stream.
+		// The buffer descriptors hold pointers into Go memory, which are pinned for the call.
+		if r.inBuffer.Src != nil {
+			r.pinner.Pin(&r.buffer[0])
+		}
+		r.pinner.Pin(&r.readBuffer[0])
 		zstdReturnHint := r.zstd.decompressStream(r.stream, &r.outBuffer, &r.inBuffer)
+		r.pinner.Unpin()
 
 		if r.zstd.isError(zstdReturnHint) != 0 {
 			r.streamEnded = true // Mark as ended on error to prevent further attempts.
-			return 0, fmt.Errorf("zstd decompression error: %s", r.zstd.getErrorName(zstdReturnHint))
+			return r.zstd.nativeError("decompress", zstdReturnHint)
 		}
 
 		// r.end tracks how much valid decompressed data is in r.readBuffer.
 		r.end = int(r.outBuffer.Pos)
+		r.flushing = r.outBuffer.Pos == r.outBuffer.Size
 
-		if zstdReturnHint == 0 {
-			// A return hint of 0 means the current Zstandard frame is complete and fully flushed.
+		if r.progress != nil {
+			r.progress(r.totalIn-int64(r.inBuffer.Size-r.inBuffer.Pos), r.progressTotal)
+		}
+
+		// A return hint of 0 means the current Zstandard frame is complete and fully flushed.
+		// Any remaining input starts a new frame, which ZSTD decodes without an explicit reset.
+		r.inFrame = zstdReturnHint != 0
+
+		// In single-frame mode the stream ends with its first regular frame
+		if r.single && !r.inFrame && r.header != nil {
 			r.streamEnded = true
-			// Break this inner loop; r.readBuffer might contain the last chunk of data or be empty.
+		}
+		if r.frameEnds && !r.inFrame {
+			r.frameEnded = true
 			break
 		}
 
-		// If zstdReturnHint > 0:
-		// ZSTD indicates more processing is needed. It might have produced output (r.end > 0),
-		// consumed input, or needs to be called again to flush internal state.
+		// The source is exhausted mid-frame and ZSTD could not make progress: the input is truncated.
+		if r.end == 0 && r.inFrame && r.sourceEOF && r.inBuffer.Pos >= r.inBuffer.Size {
+			r.streamEnded = true
+			return io.ErrUnexpectedEOF
+		}
+
 		// If ZSTD actually produced output (r.end > 0), we break this inner loop
 		// to return the available data to the caller.
 		if r.end > 0 {
 			break
 		}
 
-		// If r.end == 0 (no output produced in this call) and zstdReturnHint > 0:
+		// If r.end == 0 (no output produced in this call):
 		// - If r.inBuffer was exhausted (r.inBuffer.Pos >= r.inBuffer.Size), the next iteration
-		//   of this loop will attempt to read more from r.reader (or call ZSTD with empty
-		//   input if source is EOF, for flushing). This is correct.
+		//   of this loop will attempt to read more from r.reader, or detect the end of the stream.
 		// - If r.inBuffer was not exhausted, ZSTD needs to be called again with the remaining
 		//   input in r.inBuffer. The loop continues.
-		// - If source EOF'd, r.inBuffer is empty, ZSTD produces no output but hint > 0,
-		//   this is the flushing scenario. The loop continues until hint becomes 0.
 	} // End of inner loop for filling r.readBuffer
 
 	// After the inner loop, r.readBuffer may have data, or r.streamEnded might be true.
@@ -136,28 +301,293 @@ func (r *Reader) Read(p []byte) (int, error) {
 	// If r.readBuffer is empty (r.end == 0) after trying to decompress:
 	if r.end == 0 {
 		if r.streamEnded {
-			return 0, io.EOF // Stream ended and no data produced.
+			return io.EOF // Stream ended and no data produced.
 		}
 		// No data produced, stream not marked as ended (e.g. underlying reader non-blocking and returned 0 bytes, 0 err)
-		return 0, nil // Caller should try Read again.
+		return nil // Caller should try Read again.
 	}
 
-	// Copy decompressed data from r.readBuffer to the caller's buffer p.
-	n := copy(p, r.readBuffer[r.pos:r.end])
-	r.pos += n // Advance our position in r.readBuffer
+	// Enforce the decompressed size limit: hand out data up to the limit, then fail
+	if r.maxDecompressSize > 0 && r.totalOut+int64(r.end) > r.maxDecompressSize {
+		r.streamEnded = true
+		r.err = &MaxSizeError{Limit: r.maxDecompressSize}
+		r.end = int(r.maxDecompressSize - r.totalOut)
+		if r.end == 0 {
+			return r.err
+		}
+	}
+	r.totalOut += int64(r.end)
+	return nil
+}
 
-	return n, nil
+// handleSkippableFrame consumes a skippable frame at the start of the pending input and
+// passes its payload to the handler, if any. It reports false if the input starts a regular frame.
+func (r *Reader) handleSkippableFrame() (bool, error) {
+	header, err := r.peekInput(skippableHeaderSize)
+	if err != nil {
+		return false, err
+	}
+
+	// Leave short or regular input to ZSTD, which reports truncation and corruption
+	if len(header) < skippableHeaderSize {
+		return false, nil
+	}
+	magic := binary.LittleEndian.Uint32(header[0:4])
+	if magic&^maxSkippableVariant != skippableMagicStart {
+		return false, nil
+	}
+	size := int64(binary.LittleEndian.Uint32(header[4:8]))
+	r.inBuffer.Pos += skippableHeaderSize
+
+	// Take what is already buffered, then read the rest straight from the source.
+	// The payload grows with the data actually read, never trusting the declared size.
+	var payload bytes.Buffer
+	var dst io.Writer = &payload
+	if r.skippableHandler == nil {
+		dst = io.Discard
+	}
+
+	take := min(int64(r.inBuffer.Size-r.inBuffer.Pos), size)
+	dst.Write(r.buffer[r.inBuffer.Pos : r.inBuffer.Pos+uint64(take)])
+	r.inBuffer.Pos += uint64(take)
+
+	if rest := size - take; rest > 0 {
+		if r.sourceEOF {
+			return true, io.ErrUnexpectedEOF
+		}
+		n, err := io.CopyN(dst, r.source(), rest)
+		r.totalIn += n
+		if err == io.EOF {
+			return true, io.ErrUnexpectedEOF
+		} else if err != nil {
+			return true, err
+		}
+	}
+
+	if r.skippableHandler == nil {
+		return true, nil
+	}
+	return true, r.skippableHandler(magic-skippableMagicStart, payload.Bytes())
+}
+
+// peekInput returns up to n bytes of pending input without consuming them, moving the
+// pending input to the front of the buffer and reading more from the source as needed.
+// Fewer than n bytes are returned only at the end of the source.
+func (r *Reader) peekInput(n int) ([]byte, error) {
+	if r.inBuffer.Size-r.inBuffer.Pos >= uint64(n) {
+		return r.buffer[r.inBuffer.Pos : r.inBuffer.Pos+uint64(n)], nil
+	}
+
+	if len(r.buffer) < n {
+		r.buffer = append(r.buffer, make([]byte, n-len(r.buffer))...)
+	}
+	size := copy(r.buffer, r.buffer[r.inBuffer.Pos:r.inBuffer.Size])
+
+	for size < n && !r.sourceEOF {
+		m, err := r.source().Read(r.buffer[size:])
+		r.totalIn += int64(m)
+		size += m
+		if err == io.EOF {
+			r.sourceEOF = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+
+	r.inBuffer.Src = unsafe.Pointer(&r.buffer[0])
+	r.inBuffer.Size = uint64(size)
+	r.inBuffer.Pos = 0
+	return r.buffer[:min(size, n)], nil
+}
+
+// ReadByte implements the io.ByteReader interface, so the Reader can be used directly by
+// byte-oriented decoders such as binary.ReadUvarint without an extra bufio layer.
+func (r *Reader) ReadByte() (byte, error) {
+	// Fast path: serve from already decompressed data
+	if !r.closed && r.prefetch == nil && r.pos < r.end {
+		b := r.readBuffer[r.pos]
+		r.pos++
+		r.delivered++
+		return b, nil
+	}
+
+	var b [1]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// Multistream controls whether the Reader decodes every frame of a concatenated stream,
+// which is the default, or reports io.EOF at the end of the first frame, mirroring
+// gzip.Reader. Input read from the source beyond that frame is not given back to it.
+func (r *Reader) Multistream(ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.single = !ok
+}
+
+// BytesIn returns the number of compressed bytes consumed by the decoder so far.
+// Input read from the source but not yet decoded is not counted.
+func (r *Reader) BytesIn() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.totalIn - int64(r.inBuffer.Size-r.inBuffer.Pos)
+}
+
+// BytesOut returns the number of decompressed bytes returned by Read so far
+func (r *Reader) BytesOut() int64 {
+	return r.delivered
+}
+
+// refDictionary digests the reader's dictionary and references it from the stream,
+// or loads it into the stream when a load method or content type is set
+func (r *Reader) refDictionary() error {
+	if r.dictLoad != DictLoadByCopy || r.dictContent != DictContentAuto {
+		data := r.dict.dictData
+		if r.dictLoad == DictLoadByRef {
+			r.dictPinner.Pin(&data[0])
+		}
+		result := r.zstd.dctxLoadDictionary(r.stream, unsafe.Pointer(&data[0]), uint64(len(data)), r.dictLoad, r.dictContent)
+		if r.zstd.isError(result) != 0 {
+			return r.zstd.nativeError("decompress", result)
+		}
+		return nil
+	}
+
+	r.ddict = r.dict.digestDDict(r.zstd)
+	if r.ddict == nil {
+		return r.zstd.allocError("decompress", "decompression dictionary")
+	}
+
+	result := r.zstd.dctxRefDDict(r.stream, r.ddict)
+	if r.zstd.isError(result) != 0 {
+		return r.zstd.nativeError("decompress", result)
+	}
+	return nil
+}
+
+// registryDictionary references the registered dictionary the first frame was
+// compressed with, once its header is parsed by fill or Header, unless the reader has a
+// dictionary
+func (r *Reader) registryDictionary() error {
+	if r.registry == nil || r.header == nil || r.dict != nil {
+		return nil
+	}
+
+	dict, done, err := r.registry.acquireFrame(r.header.DictionaryID)
+	if err != nil || dict == nil {
+		return err
+	}
+	if err := r.zstd.registerDictionaryFunctions(); err != nil {
+		done()
+		return err
+	}
+	r.dict = dict
+	r.dictDone = done
+
+	// Without a stream yet, it is referenced once the stream is created
+	if r.stream == nil {
+		return nil
+	}
+	return r.refDictionary()
+}
+
+// dropRegistryDictionary detaches the dictionary acquired from the registry for the
+// previous stream, so the next stream looks up the one it was compressed with. As in
+// Reset, the stream is recreated on the next read without the dictionary referenced.
+func (r *Reader) dropRegistryDictionary() {
+	if r.dictDone == nil {
+		return
+	}
+
+	if !r.zstd.isClosed() {
+		if r.stream != nil {
+			r.zstd.freeDStream(r.stream)
+			r.stream = nil
+		}
+		if r.ddict != nil {
+			r.zstd.freeDDict(r.ddict)
+			r.ddict = nil
+		}
+	}
+	r.dictPinner.Unpin()
+	r.releaseDictionary()
+}
+
+// releaseDictionary drops the dictionary the reader acquired from its registry, if any
+func (r *Reader) releaseDictionary() {
+	if r.dictDone != nil {
+		r.dictDone()
+		r.dictDone = nil
+		r.dict = nil
+	}
 }
 
-// Close implements the io.Closer interface
+// windowLogFor returns the smallest window log covering size bytes, within the supported range
+func windowLogFor(size int) int {
+	windowLog := bits.Len(uint(size - 1))
+	return min(max(windowLog, windowLogMin), windowLogMax)
+}
+
+// Close implements the io.Closer interface.
+// Closing an already closed Reader has no effect; Read then returns ErrAlreadyClosed.
 func (r *Reader) Close() error {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+
+	// Stop the readahead goroutine before freeing the state it uses
+	if r.prefetch != nil {
+		r.prefetch.stop()
+	}
+	r.pos, r.end = 0, 0
+	r.endOperation()
+
+	// Pooled readers keep their native state for the next user
+	if r.pool != nil {
+		return nil
+	}
+	return r.free()
+}
+
+// finalize frees the native resources of a reader dropped without Close, or left in a pool
+func (r *Reader) finalize() {
+	if r.prefetch != nil {
+		r.prefetch.stop()
+	}
+	r.closed = true
+	r.free()
+}
+
+// free releases the native resources held by the reader
+func (r *Reader) free() error {
+	runtime.SetFinalizer(r, nil)
+	defer r.dictPinner.Unpin()
+
+	putBuffer(r.buffer)
+	putBuffer(r.readBuffer)
+	r.buffer, r.readBuffer = nil, nil
+
+	// The native objects went away with the library
+	if r.zstd.isClosed() {
+		return nil
+	}
+
 	if r.stream != nil {
 		r.zstd.freeDStream(r.stream)
 		r.stream = nil
 	}
-	if r.ctx != nil {
-		r.zstd.freeDCtx(r.ctx)
-		r.ctx = nil
+	if r.ddict != nil {
+		r.zstd.freeDDict(r.ddict)
+		r.ddict = nil
+	}
+	r.releaseDictionary()
+
+	// Unload the instance created by a package-level constructor
+	if r.ownsZstd {
+		return r.zstd.Close()
 	}
 	return nil
 }
@@ -166,161 +596,575 @@ func (r *Reader) Close() error {
 type Writer struct {
 	zstd      *Zstd
 	writer    io.Writer
-	ctx       unsafe.Pointer
 	level     int
 	buffer    []byte
 	inBuffer  ZstdInBuffer
 	outBuffer ZstdOutBuffer
 	stream    unsafe.Pointer
+	pending   []byte // Input coalesced from small writes, not yet handed to zstd
+
+	bufferSize int // Size of buffer, pooled while the writer is closed
+
+	windowSize int  // Compression window size (0 = level default)
+	checksum   bool // Append a content checksum to each frame
+	workers    int  // Native worker threads (0 = single-threaded)
+
+	longDistance bool // Enable long distance matching
+	rsyncable    bool // Cut the output at content-defined points
+
+	contentSize int64 // Pledged total size of the input (0 = unknown)
+
+	ownsZstd bool        // The instance was created for this writer alone and is closed with it
+	adapt    *adapter    // Adjusts the level to the speed of the destination, if enabled
+	pool     *WriterPool // Pool the writer belongs to, if any
+
+	cancelCtx context.Context // Stops compression once done (nil = never)
+
+	progress func(processed, total int64) // Receives the input bytes compressed, if set
+	consumed int64                        // Input bytes handed to zstd
+
+	dict  *Dictionary    // Dictionary to compress with, if any
+	cdict unsafe.Pointer // Digested dictionary referenced by the stream
+
+	dictLoad    DictLoadMethod  // How the stream loads the dictionary
+	dictContent DictContentType // How the stream interprets the dictionary
+	dictPinner  runtime.Pinner  // Pins the dictionary loaded by reference while the stream exists
+
+	bytesIn  int64 // Uncompressed bytes accepted by Write
+	bytesOut int64 // Compressed bytes written to the underlying writer
+	closed   bool  // Close, CloseWithError or Abort has been called
+	inFrame  bool  // Data has been written since the last frame was ended
+
+	pinner runtime.Pinner // Pins the buffers referenced by inBuffer and outBuffer during native calls
+
+	flushOnWrite bool // Flush after every Write for low-latency streams
+
+	mu            sync.Mutex    // Serializes writes with background flushes
+	flushInterval time.Duration // Idle time before a background flush (0 = disabled)
+	flushTimer    *time.Timer   // Pending background flush
+	dirty         bool          // Data has been written since the last flush
+	err           error         // Sticky error from writing to the underlying writer
+
+	op *operation // Stream reported to the instrumentation, from the first Write
 }
 
-// Write implements the io.Writer interface
+// Write implements the io.Writer interface.
+// Writes smaller than the coalescing threshold are buffered and compressed together
+// once the buffer fills, or when Flush or Close is called. In flush-on-write mode every
+// Write is compressed and flushed to the underlying writer before returning.
 func (w *Writer) Write(p []byte) (int, error) {
 	if len(p) == 0 {
 		return 0, nil
 	}
 
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || w.zstd.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+
+	// Compressed data was lost by the underlying writer: the frame cannot be completed
+	if w.err != nil {
+		return 0, w.err
+	}
+	if err := w.checkContext(); err != nil {
+		return 0, err
+	}
+
 	// Initialize stream if not already done
 	if w.stream == nil {
-		w.stream = w.zstd.createCStream()
-		if w.stream == nil {
-			return 0, fmt.Errorf("failed to create compression stream")
+		if err := w.initStream(); err != nil {
+			return 0, err
 		}
 	}
+	w.startOperation()
 
-	// Set up input buffer
-	w.inBuffer.Src = unsafe.Pointer(&p[0])
-	w.inBuffer.Size = uint64(len(p))
-	w.inBuffer.Pos = 0
-
-	// Compress data in chunks if the output buffer is smaller than needed
-	for w.inBuffer.Pos < w.inBuffer.Size {
-		// Set up output buffer
-		w.outBuffer.Dst = unsafe.Pointer(&w.buffer[0])
-		w.outBuffer.Size = uint64(len(w.buffer))
-		w.outBuffer.Pos = 0
+	// The frame header already records the pledged size, so exceeding it would corrupt the frame
+	if w.contentSize > 0 && w.bytesIn+int64(len(p)) > w.contentSize {
+		return 0, fmt.Errorf("zstd: write of %d bytes exceeds the pledged content size of %d bytes (%d written)",
+			len(p), w.contentSize, w.bytesIn)
+	}
 
-		// Compress
-		result := w.zstd.compressStream2(w.stream, &w.outBuffer, &w.inBuffer, EndContinue)
+	w.bytesIn += int64(len(p))
+	w.inFrame = true
 
-		// Check for errors
-		if w.zstd.isError(result) != 0 {
-			return int(w.inBuffer.Pos), fmt.Errorf("compression error: %s", w.zstd.getErrorName(result))
+	// Restart the idle timer for the background flush
+	if w.flushInterval > 0 {
+		w.dirty = true
+		if w.flushTimer == nil {
+			w.flushTimer = time.AfterFunc(w.flushInterval, w.timedFlush)
+		} else {
+			w.flushTimer.Reset(w.flushInterval)
 		}
+	}
 
-		// Write compressed data
-		if w.outBuffer.Pos > 0 {
-			_, err := w.writer.Write(w.buffer[:w.outBuffer.Pos])
-			if err != nil {
-				return int(w.inBuffer.Pos), err
+	// All pledged content has arrived: end the frame now rather than on Close.
+	// Content written in a single call is compressed in one pass, as by Compress.
+	if w.contentSize > 0 && w.bytesIn == w.contentSize {
+		if len(w.pending) > 0 {
+			if err := w.compressPending(EndContinue); err != nil {
+				return 0, err
 			}
 		}
+		w.inFrame = false
+		return w.compressStream(p, EndEnd)
+	}
+
+	// Low-latency mode: make the data decodable by the receiver right away
+	if w.flushOnWrite {
+		return w.compressStream(p, EndFlush)
+	}
+
+	// Coalesce small writes while they fit in the pending buffer
+	if cap(w.pending) > 0 {
+		if len(w.pending)+len(p) < cap(w.pending) {
+			w.pending = append(w.pending, p...)
+			return len(p), nil
+		}
+
+		// The pending buffer is full: compress it before handling p
+		if err := w.compressPending(EndContinue); err != nil {
+			return 0, err
+		}
+
+		if len(p) < cap(w.pending) {
+			w.pending = append(w.pending, p...)
+			return len(p), nil
+		}
 	}
 
-	return len(p), nil
+	// Large writes are compressed directly without copying
+	return w.compressStream(p, EndContinue)
 }
 
 // Flush flushes any pending data to the underlying writer
 func (w *Writer) Flush() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || w.zstd.isClosed() {
+		return ErrAlreadyClosed
+	}
+
+	if w.err != nil {
+		return w.err
+	}
+
 	if w.stream == nil {
 		return nil
 	}
 
-	// Set up empty input buffer
-	w.inBuffer.Src = nil
-	w.inBuffer.Size = 0
-	w.inBuffer.Pos = 0
+	w.dirty = false
+	return w.compressPending(EndFlush)
+}
 
-	var result uint64
+// BytesIn returns the number of uncompressed bytes accepted by Write so far
+func (w *Writer) BytesIn() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.bytesIn
+}
 
-	// Flush until we're done
-	for {
-		// Set up output buffer
-		w.outBuffer.Dst = unsafe.Pointer(&w.buffer[0])
-		w.outBuffer.Size = uint64(len(w.buffer))
-		w.outBuffer.Pos = 0
+// BytesOut returns the number of compressed bytes written to the underlying writer so far.
+// Data still buffered in the encoder is only counted once it is flushed.
+func (w *Writer) BytesOut() int64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.bytesOut
+}
+
+// Ratio returns the compression ratio achieved so far (BytesIn / BytesOut),
+// or 0 if no compressed output has been written yet.
+func (w *Writer) Ratio() float64 {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.bytesOut == 0 {
+		return 0
+	}
+	return float64(w.bytesIn) / float64(w.bytesOut)
+}
 
-		// Perform flush operation
-		result = w.zstd.compressStream2(w.stream, &w.outBuffer, &w.inBuffer, EndFlush)
+// initStream creates the compression stream and applies the writer's parameters to it
+func (w *Writer) initStream() error {
+	stream := w.zstd.createCStream()
+	if stream == nil {
+		return w.zstd.allocError("compress", "compression stream")
+	}
 
-		// Check for errors
+	if err := w.applyParameters(stream); err != nil {
+		w.zstd.freeCStream(stream)
+		return err
+	}
+
+	// Load the dictionary into the stream when a load method or content type is set
+	if w.dict != nil && len(w.dict.dictData) > 0 && (w.dictLoad != DictLoadByCopy || w.dictContent != DictContentAuto) {
+		data := w.dict.dictData
+		if w.dictLoad == DictLoadByRef {
+			w.dictPinner.Pin(&data[0])
+		}
+		result := w.zstd.cctxLoadDictionary(stream, unsafe.Pointer(&data[0]), uint64(len(data)), w.dictLoad, w.dictContent)
 		if w.zstd.isError(result) != 0 {
-			return fmt.Errorf("flush error: %s", w.zstd.getErrorName(result))
+			w.zstd.freeCStream(stream)
+			w.dictPinner.Unpin()
+			return w.zstd.nativeError("compress", result)
 		}
-
-		// Write compressed data
-		if w.outBuffer.Pos > 0 {
-			_, err := w.writer.Write(w.buffer[:w.outBuffer.Pos])
-			if err != nil {
-				return err
+	} else if w.dict != nil && len(w.dict.dictData) > 0 {
+		// Reference the digested dictionary, created once for the lifetime of the writer
+		if w.cdict == nil {
+			w.cdict = w.dict.digestCDict(w.zstd, w.level)
+			if w.cdict == nil {
+				w.zstd.freeCStream(stream)
+				return w.zstd.allocError("compress", "compression dictionary")
 			}
 		}
 
-		// If flush complete, break
-		if result == 0 {
-			break
+		result := w.zstd.cctxRefCDict(stream, w.cdict)
+		if w.zstd.isError(result) != 0 {
+			w.zstd.freeCStream(stream)
+			return w.zstd.nativeError("compress", result)
+		}
+	}
+
+	w.stream = stream
+	return nil
+}
+
+// applyParameters sets the writer's parameters on stream, which must be between frames
+func (w *Writer) applyParameters(stream unsafe.Pointer) error {
+	params := []parameter{{cParamCompressionLevel, w.level}}
+	windowLog := 0 // Level default
+	if w.windowSize > 0 {
+		windowLog = windowLogFor(w.windowSize)
+	}
+	params = append(params, parameter{cParamWindowLog, windowLog})
+	checksum := 0
+	if w.checksum {
+		checksum = 1
+	}
+	params = append(params, parameter{cParamChecksumFlag, checksum})
+	if w.workers > 0 {
+		params = append(params, parameter{cParamNbWorkers, w.workers})
+	}
+	if w.adapt != nil {
+		params = append(params, parameter{cParamJobSize, adaptInterval})
+	}
+	if w.longDistance {
+		params = append(params, parameter{cParamEnableLongDistanceMatching, 1})
+	}
+	if w.rsyncable {
+		params = append(params, parameter{cParamRsyncable, 1})
+	}
+
+	for _, p := range params {
+		result := w.zstd.cctxSetParameter(stream, p.key, p.value)
+		if w.zstd.isError(result) != 0 {
+			return w.zstd.nativeError("compress", result)
 		}
 	}
 
+	// Pledge the content size so it is recorded in the frame header
+	if w.contentSize > 0 {
+		result := w.zstd.cctxSetPledgedSize(stream, uint64(w.contentSize))
+		if w.zstd.isError(result) != 0 {
+			return w.zstd.nativeError("compress", result)
+		}
+	}
 	return nil
 }
 
-// Close implements the io.Closer interface
-func (w *Writer) Close() error {
+// timedFlush runs on the flush timer after a period without writes.
+// A failure to write is kept and reported by the next Write, Flush or Close.
+func (w *Writer) timedFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || w.err != nil || w.stream == nil || !w.dirty {
+		return
+	}
+
+	w.dirty = false
+	w.compressPending(EndFlush)
+}
+
+// Close implements the io.Closer interface.
+// Closing an already closed Writer has no effect; Write and Flush then return ErrAlreadyClosed.
+func (w *Writer) Close() (err error) {
+	if w.flushTimer != nil {
+		w.flushTimer.Stop()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
 	defer func() {
-		if w.ctx != nil {
-			w.zstd.freeCCtx(w.ctx)
-			w.ctx = nil
+		if releaseErr := w.release(); err == nil {
+			err = releaseErr
 		}
+		w.endOperation(err)
 	}()
 
-	if w.stream == nil {
+	if w.err != nil {
+		return w.err
+	}
+
+	if w.stream == nil || w.zstd.isClosed() || !w.inFrame {
 		return nil
 	}
 
-	// Set up empty input buffer
-	w.inBuffer.Src = nil
-	w.inBuffer.Size = 0
-	w.inBuffer.Pos = 0
+	// End the stream and write pending data
+	return w.endFrame()
+}
+
+// WriteSkippableFrame writes a skippable frame carrying payload, so metadata such as
+// indexes, manifests or signatures can be embedded in standard .zst output. Decoders,
+// including this package's Reader, skip it. The magicVariant (0-15) selects the magic
+// number 0x184D2A50+magicVariant. A frame in progress is ended first, and later writes
+// start a new frame.
+func (w *Writer) WriteSkippableFrame(magicVariant uint32, payload []byte) error {
+	if magicVariant > maxSkippableVariant {
+		return fmt.Errorf("zstd: skippable frame magic variant %d out of range 0-%d", magicVariant, maxSkippableVariant)
+	}
+	if uint64(len(payload)) > math.MaxUint32 {
+		return fmt.Errorf("zstd: skippable frame payload of %d bytes is too large", len(payload))
+	}
 
-	var result uint64
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || w.zstd.isClosed() {
+		return ErrAlreadyClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+
+	// The skippable frame must sit between regular frames
+	if w.inFrame {
+		if err := w.endFrame(); err != nil {
+			return err
+		}
+	}
+
+	header := make([]byte, skippableHeaderSize)
+	binary.LittleEndian.PutUint32(header[0:4], skippableMagicStart+magicVariant)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len(payload)))
+
+	if err := w.writeOutput(header); err != nil {
+		return err
+	}
+	return w.writeOutput(payload)
+}
+
+// endFrame compresses pending input and writes the frame epilogue
+func (w *Writer) endFrame() error {
+	if w.contentSize > 0 && w.bytesIn != w.contentSize {
+		return fmt.Errorf("zstd: frame ended after %d of the %d pledged content bytes", w.bytesIn, w.contentSize)
+	}
+
+	w.inFrame = false
+	return w.compressPending(EndEnd)
+}
+
+// CloseWithError abandons the stream without writing the frame epilogue, so a failed
+// transfer is never mistaken for complete data, and releases the writer's resources.
+// Coalesced input that has not been compressed yet is discarded. If the underlying
+// writer has a CloseWithError method, as *io.PipeWriter does, it is called with err
+// (or ErrAborted if err is nil) so the reading side observes the failure.
+func (w *Writer) CloseWithError(err error) error {
+	if w.flushTimer != nil {
+		w.flushTimer.Stop()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.pending = w.pending[:0]
+	w.release()
+
+	if err == nil {
+		err = ErrAborted
+	}
+	w.endOperation(err)
+	if cw, ok := w.writer.(interface{ CloseWithError(error) error }); ok {
+		return cw.CloseWithError(err)
+	}
+	return nil
+}
+
+// Abort abandons the stream without writing the frame epilogue.
+// It is equivalent to CloseWithError(ErrAborted).
+func (w *Writer) Abort() error {
+	return w.CloseWithError(ErrAborted)
+}
+
+// finalize frees the native resources of a writer dropped without Close, or left in a pool.
+// The frame epilogue is not written, as the destination may no longer be usable.
+func (w *Writer) finalize() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.closed = true
+	w.free()
+}
+
+// release marks the writer closed and frees its native resources, unless it is pooled
+func (w *Writer) release() error {
+	w.closed = true
+
+	// Pooled writers keep their native state for the next user
+	if w.pool != nil {
+		return nil
+	}
+	return w.free()
+}
+
+// free releases the native resources held by the writer
+func (w *Writer) free() error {
+	runtime.SetFinalizer(w, nil)
+	defer w.dictPinner.Unpin()
+
+	putBuffer(w.buffer)
+	w.buffer = nil
+
+	// The native objects went away with the library
+	if w.zstd.isClosed() {
+		return nil
+	}
+
+	if w.stream != nil {
+		w.zstd.freeCStream(w.stream)
+		w.stream = nil
+	}
+	if w.cdict != nil {
+		w.zstd.freeCDict(w.cdict)
+		w.cdict = nil
+	}
+
+	// Unload the instance created by a package-level constructor
+	if w.ownsZstd {
+		return w.zstd.Close()
+	}
+	return nil
+}
+
+// compressPending compresses the coalesced input with the given end directive
+func (w *Writer) compressPending(endOp int) error {
+	_, err := w.compressStream(w.pending, endOp)
+	w.pending = w.pending[:0]
+	return err
+}
+
+// compressStream feeds src to the compression stream and writes all output produced.
+// With EndContinue it returns once src has been consumed; with EndFlush or EndEnd it
+// keeps going until the flush or frame epilogue has been completely written.
+// It returns the number of bytes of src consumed.
+func (w *Writer) compressStream(src []byte, endOp int) (int, error) {
+	// The buffer descriptors hold pointers into Go memory, which stay pinned while zstd uses them
+	defer w.pinner.Unpin()
+	w.pinner.Pin(&w.buffer[0])
+
+	// Set up input buffer
+	if len(src) > 0 {
+		w.pinner.Pin(&src[0])
+		w.inBuffer.Src = unsafe.Pointer(&src[0])
+	} else {
+		w.inBuffer.Src = nil
+	}
+	w.inBuffer.Size = uint64(len(src))
+	w.inBuffer.Pos = 0
 
-	// End the stream and write pending data
 	for {
+		// Large inputs are compressed in many passes; give up between them once cancelled
+		if err := w.checkContext(); err != nil {
+			return int(w.inBuffer.Pos), err
+		}
+
 		// Set up output buffer
 		w.outBuffer.Dst = unsafe.Pointer(&w.buffer[0])
 		w.outBuffer.Size = uint64(len(w.buffer))
 		w.outBuffer.Pos = 0
 
-		// End the stream
-		result = w.zstd.compressStream2(w.stream, &w.outBuffer, &w.inBuffer, EndEnd)
+		// Compress
+		pos := w.inBuffer.Pos
+		result := w.zstd.compressStream2(w.stream, &w.outBuffer, &w.inBuffer, endOp)
 
 		// Check for errors
 		if w.zstd.isError(result) != 0 {
-			w.zstd.freeCStream(w.stream)
-			w.stream = nil
-			return fmt.Errorf("close error: %s", w.zstd.getErrorName(result))
+			return int(w.inBuffer.Pos), w.zstd.nativeError("compress", result)
 		}
 
-		// Write compressed data
-		if w.outBuffer.Pos > 0 {
-			_, err := w.writer.Write(w.buffer[:w.outBuffer.Pos])
-			if err != nil {
-				w.zstd.freeCStream(w.stream)
-				w.stream = nil
-				return err
+		w.consumed += int64(w.inBuffer.Pos - pos)
+		if w.progress != nil && w.inBuffer.Pos > pos {
+			total := w.contentSize
+			if total == 0 {
+				total = -1
 			}
+			w.progress(w.consumed, total)
+		}
+
+		// Write compressed data, timing the destination for the adaptive level
+		start := time.Now()
+		if err := w.writeOutput(w.buffer[:w.outBuffer.Pos]); err != nil {
+			return int(w.inBuffer.Pos), err
+		}
+		if w.adapt != nil {
+			w.adapt.writeTime += time.Since(start)
 		}
 
-		// If end complete, break
-		if result == 0 {
+		// Continue until the input is consumed, or until a flush or end is complete
+		if endOp == EndContinue {
+			if w.inBuffer.Pos >= w.inBuffer.Size {
+				break
+			}
+		} else if result == 0 {
 			break
 		}
 	}
 
-	// Free the stream
-	w.zstd.freeCStream(w.stream)
-	w.stream = nil
+	if w.adapt != nil && w.bytesIn >= w.adapt.next {
+		w.adaptLevel()
+	}
+	return int(w.inBuffer.Pos), nil
+}
+
+// checkContext fails the writer for good once its context is done
+func (w *Writer) checkContext() error {
+	if w.cancelCtx == nil {
+		return nil
+	}
+	if err := w.cancelCtx.Err(); err != nil {
+		w.err = err
+		return err
+	}
+	return nil
+}
+
+// writeOutput writes compressed data to the underlying writer, retrying short writes
+// as long as progress is made. Any failure is sticky: once compressed data is lost
+// the frame can no longer be completed.
+func (w *Writer) writeOutput(out []byte) error {
+	for len(out) > 0 {
+		n, err := w.writer.Write(out)
+		if n < 0 || n > len(out) {
+			n, err = 0, fmt.Errorf("invalid write count %d", n)
+		}
+		w.bytesOut += int64(n)
+		out = out[n:]
 
+		if err == nil && n == 0 {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			w.err = fmt.Errorf("zstd: writing compressed data: %w", err)
+			return w.err
+		}
+	}
 	return nil
 }
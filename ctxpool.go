@@ -0,0 +1,93 @@
+package zstd
+
+import (
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// contextPool keeps native contexts for reuse across calls, so one-shot operations on a
+// shared instance neither allocate a context every time nor contend for a single one
+type contextPool struct {
+	mu   sync.Mutex
+	idle []unsafe.Pointer
+}
+
+// get returns an idle context, or nil if there is none
+func (p *contextPool) get() unsafe.Pointer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.idle) == 0 {
+		return nil
+	}
+	ctx := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return ctx
+}
+
+// put keeps ctx for reuse. It reports false if enough contexts are idle already,
+// leaving the caller to free ctx.
+func (p *contextPool) put(ctx unsafe.Pointer) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// No more can be in use at once than goroutines run in parallel
+	if len(p.idle) >= runtime.GOMAXPROCS(0) {
+		return false
+	}
+	p.idle = append(p.idle, ctx)
+	return true
+}
+
+// drain empties the pool and returns the contexts it held
+func (p *contextPool) drain() []unsafe.Pointer {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	idle := p.idle
+	p.idle = nil
+	return idle
+}
+
+// getCCtx returns a compression context with default parameters, reusing an idle one if possible
+func (z *Zstd) getCCtx() unsafe.Pointer {
+	if cctx := z.cctxPool.get(); cctx != nil {
+		return cctx
+	}
+	return z.createCCtx()
+}
+
+// putCCtx clears the parameters and references of cctx and keeps it for reuse, or frees it
+func (z *Zstd) putCCtx(cctx unsafe.Pointer) {
+	z.cctxReset(cctx, resetSessionAndParameters)
+	if !z.cctxPool.put(cctx) {
+		z.freeCCtx(cctx)
+	}
+}
+
+// getDCtx returns a decompression context with default parameters, reusing an idle one if possible
+func (z *Zstd) getDCtx() unsafe.Pointer {
+	if dctx := z.dctxPool.get(); dctx != nil {
+		return dctx
+	}
+	return z.createDCtx()
+}
+
+// putDCtx clears the parameters and references of dctx and keeps it for reuse, or frees it
+func (z *Zstd) putDCtx(dctx unsafe.Pointer) {
+	z.dctxReset(dctx, resetSessionAndParameters)
+	if !z.dctxPool.put(dctx) {
+		z.freeDCtx(dctx)
+	}
+}
+
+// freeContexts frees the idle contexts before the library is unloaded
+func (z *Zstd) freeContexts() {
+	for _, cctx := range z.cctxPool.drain() {
+		z.freeCCtx(cctx)
+	}
+	for _, dctx := range z.dctxPool.drain() {
+		z.freeDCtx(dctx)
+	}
+}
@@ -0,0 +1,236 @@
+package zstd
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sync"
+	"unsafe"
+)
+
+// DecompressingWriter is an io.WriteCloser that accepts compressed data and writes the
+// decompressed output to an underlying writer. It suits push pipelines, such as proxies,
+// where the compressed source drives the transfer.
+type DecompressingWriter struct {
+	zstd      *Zstd
+	writer    io.Writer
+	stream    unsafe.Pointer
+	buffer    []byte
+	inBuffer  ZstdInBuffer
+	outBuffer ZstdOutBuffer
+
+	windowSize        int   // Largest window accepted by the decoder (0 = library default)
+	maxDecompressSize int64 // Limit on total decompressed output (0 = no limit)
+
+	bytesIn  int64 // Compressed bytes accepted by Write
+	bytesOut int64 // Decompressed bytes written to the underlying writer
+	inFrame  bool  // A frame has been started but not completely decoded
+	closed   bool  // Close has been called
+	ownsZstd bool  // The instance was created for this writer alone and is closed with it
+	err      error // Sticky error that stops further writes
+
+	mu     sync.Mutex
+	pinner runtime.Pinner // Pins the buffers referenced by inBuffer and outBuffer during native calls
+}
+
+// NewDecompressingWriter creates a DecompressingWriter that writes the decompressed form
+// of the data written to it to w. WindowSize and MaxDecompressSize from the options apply
+// as for a Reader, and ReadBufferSize sizes the output buffer.
+// The caller must call Close() when done to detect truncated input.
+func (z *Zstd) NewDecompressingWriter(w io.Writer, opts ...Option) *DecompressingWriter {
+	options := z.options(opts...)
+
+	// A writer from a closed instance fails every operation
+	if z.isClosed() {
+		return &DecompressingWriter{zstd: z, closed: true}
+	}
+
+	bufferSize := options.ReadBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultReadBufferSize
+	}
+
+	dw := &DecompressingWriter{
+		zstd:              z,
+		writer:            w,
+		buffer:            getBuffer(bufferSize),
+		windowSize:        options.WindowSize,
+		maxDecompressSize: options.MaxDecompressSize,
+	}
+
+	// Free the native stream if the writer is dropped without Close
+	runtime.SetFinalizer(dw, (*DecompressingWriter).free)
+	return dw
+}
+
+// NewDecompressingWriter creates a DecompressingWriter that writes the decompressed form
+// of the data written to it to w.
+// The returned writer should be closed with Close() when done.
+func NewDecompressingWriter(w io.Writer, opts ...Option) (*DecompressingWriter, error) {
+	z, err := New()
+	if err != nil {
+		return nil, err
+	}
+
+	dw := z.NewDecompressingWriter(w, opts...)
+	dw.ownsZstd = true
+	return dw, nil
+}
+
+// Write decompresses p and writes the output to the underlying writer.
+// Frames may be split across writes in any way, and several frames may follow each other.
+func (d *DecompressingWriter) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed || d.zstd.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if d.stream == nil {
+		if err := d.initStream(); err != nil {
+			return 0, err
+		}
+	}
+
+	// The buffer descriptors hold pointers into Go memory, which stay pinned while zstd uses them
+	defer d.pinner.Unpin()
+	d.pinner.Pin(&p[0])
+	d.pinner.Pin(&d.buffer[0])
+
+	d.inBuffer.Src = unsafe.Pointer(&p[0])
+	d.inBuffer.Size = uint64(len(p))
+	d.inBuffer.Pos = 0
+
+	// Keep going until the input is consumed and zstd has no more output to hand out
+	for {
+		d.outBuffer.Dst = unsafe.Pointer(&d.buffer[0])
+		d.outBuffer.Size = uint64(len(d.buffer))
+		d.outBuffer.Pos = 0
+
+		result := d.zstd.decompressStream(d.stream, &d.outBuffer, &d.inBuffer)
+		if d.zstd.isError(result) != 0 {
+			d.err = d.zstd.nativeError("decompress", result)
+			return d.consumed(), d.err
+		}
+		d.inFrame = result != 0
+
+		out := d.buffer[:d.outBuffer.Pos]
+		if d.maxDecompressSize > 0 && d.bytesOut+int64(len(out)) > d.maxDecompressSize {
+			d.err = &MaxSizeError{Limit: d.maxDecompressSize}
+			out = out[:d.maxDecompressSize-d.bytesOut]
+		}
+
+		if len(out) > 0 {
+			n, err := d.writer.Write(out)
+			d.bytesOut += int64(n)
+			if err == nil && n < len(out) {
+				err = io.ErrShortWrite
+			}
+			if err != nil {
+				d.err = fmt.Errorf("zstd: writing decompressed data: %w", err)
+				return d.consumed(), d.err
+			}
+		}
+		if d.err != nil {
+			return d.consumed(), d.err
+		}
+
+		if d.inBuffer.Pos >= d.inBuffer.Size && d.outBuffer.Pos < d.outBuffer.Size {
+			break
+		}
+	}
+
+	return d.consumed(), nil
+}
+
+// consumed records and returns the input used by the current Write
+func (d *DecompressingWriter) consumed() int {
+	d.bytesIn += int64(d.inBuffer.Pos)
+	return int(d.inBuffer.Pos)
+}
+
+// initStream creates the decompression stream and applies the window limit
+func (d *DecompressingWriter) initStream() error {
+	stream := d.zstd.createDStream()
+	if stream == nil {
+		return d.zstd.allocError("decompress", "decompression stream")
+	}
+
+	if d.windowSize > 0 {
+		result := d.zstd.dctxSetParameter(stream, dParamWindowLogMax, windowLogFor(d.windowSize))
+		if d.zstd.isError(result) != 0 {
+			d.zstd.freeDStream(stream)
+			return d.zstd.nativeError("decompress", result)
+		}
+	}
+
+	d.stream = stream
+	return nil
+}
+
+// BytesIn returns the number of compressed bytes accepted so far
+func (d *DecompressingWriter) BytesIn() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bytesIn
+}
+
+// BytesOut returns the number of decompressed bytes written to the underlying writer
+func (d *DecompressingWriter) BytesOut() int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.bytesOut
+}
+
+// Close releases the decompression stream. It returns io.ErrUnexpectedEOF if the data
+// written ended in the middle of a frame, and any error that stopped an earlier Write.
+// Closing an already closed DecompressingWriter has no effect.
+func (d *DecompressingWriter) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.closed {
+		return nil
+	}
+	d.closed = true
+
+	err := d.err
+	if err == nil && d.inFrame {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if freeErr := d.free(); err == nil {
+		err = freeErr
+	}
+	return err
+}
+
+// free releases the native resources held by the writer
+func (d *DecompressingWriter) free() error {
+	runtime.SetFinalizer(d, nil)
+	putBuffer(d.buffer)
+	d.buffer = nil
+
+	// The native objects went away with the library
+	if d.zstd.isClosed() {
+		return nil
+	}
+
+	if d.stream != nil {
+		d.zstd.freeDStream(d.stream)
+		d.stream = nil
+	}
+
+	// Unload the instance created by the package-level constructor
+	if d.ownsZstd {
+		return d.zstd.Close()
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package dedup
+
+import (
+	"io"
+	"math/bits"
+)
+
+// Default chunk sizes, suiting backups of files from kilobytes to gigabytes
+const (
+	DefaultAvgSize = 64 << 10
+	minChunkSize   = 64
+)
+
+// ChunkerOptions sets the sizes of the chunks a Chunker cuts. Zero values select
+// sizes derived from AvgSize, itself defaulting to DefaultAvgSize.
+type ChunkerOptions struct {
+	MinSize int // No chunk is cut before this size, except the last one (0 = AvgSize/4)
+	AvgSize int // Typical chunk size, approximated with a power of two
+	MaxSize int // Chunks are cut at this size at the latest (0 = AvgSize*4)
+}
+
+// gear holds the random values of the rolling hash, one per byte value. They are fixed,
+// so every run cuts the same content at the same places.
+var gear = func() (table [256]uint64) {
+	seed := uint64(0x9E3779B97F4A7C15)
+	for i := range table {
+		// splitmix64
+		seed += 0x9E3779B97F4A7C15
+		x := seed
+		x = (x ^ x>>30) * 0xBF58476D1CE4E5B9
+		x = (x ^ x>>27) * 0x94D049BB133111EB
+		table[i] = x ^ x>>31
+	}
+	return table
+}()
+
+// Chunker splits a stream into content-defined chunks: the cut points depend on the
+// content around them, found with a gear rolling hash as in FastCDC, so an insertion or
+// deletion only changes the chunks around it and the others deduplicate across versions.
+type Chunker struct {
+	reader  io.Reader
+	minSize int
+	maxSize int
+	mask    uint64 // A cut is made where the bits of the hash under the mask are all zero
+	buf     []byte // Holds up to maxSize bytes read ahead
+	start   int    // Start of the pending data in buf
+	end     int    // End of the pending data in buf
+	eof     bool
+}
+
+// NewChunker returns a Chunker reading from r
+func NewChunker(r io.Reader, opts ChunkerOptions) *Chunker {
+	avg := opts.AvgSize
+	if avg <= 0 {
+		avg = DefaultAvgSize
+	}
+	avg = max(avg, minChunkSize)
+	minSize, maxSize := opts.MinSize, opts.MaxSize
+	if minSize <= 0 {
+		minSize = avg / 4
+	}
+	if maxSize <= 0 {
+		maxSize = avg * 4
+	}
+	maxSize = max(maxSize, minSize+1)
+
+	return &Chunker{
+		reader:  r,
+		minSize: minSize,
+		maxSize: maxSize,
+		mask:    ^uint64(0) << (64 - (bits.Len(uint(max(avg-minSize, minChunkSize))) - 1)),
+		buf:     make([]byte, maxSize),
+	}
+}
+
+// Next returns the next chunk, or io.EOF once the stream is exhausted. The chunk is
+// only valid until the next call.
+func (c *Chunker) Next() ([]byte, error) {
+	// Keep a whole chunk of the largest size available, as far as the stream goes
+	if c.end-c.start < c.maxSize && !c.eof {
+		c.end = copy(c.buf, c.buf[c.start:c.end])
+		c.start = 0
+		for c.end < len(c.buf) && !c.eof {
+			n, err := c.reader.Read(c.buf[c.end:])
+			c.end += n
+			if err == io.EOF {
+				c.eof = true
+			} else if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+
+	data := c.buf[c.start:c.end]
+	n := c.cut(data)
+	c.start += n
+	return data[:n:n], nil
+}
+
+// cut returns the size of the chunk at the start of data
+func (c *Chunker) cut(data []byte) int {
+	if len(data) <= c.minSize {
+		return len(data)
+	}
+
+	var hash uint64
+	limit := min(len(data), c.maxSize)
+	for i := c.minSize; i < limit; i++ {
+		// The high bits depend on the last 64 bytes, the low ones on fewer
+		hash = hash<<1 + gear[data[i]]
+		if hash&c.mask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
@@ -0,0 +1,308 @@
+// Package dedup stores data as content-defined chunks compressed with Zstandard, each
+// stored once however many times it occurs, which is the storage core of backup and
+// sync tools. Data put in a Store is split by a Chunker, new chunks are compressed,
+// optionally with a dictionary, and written to a Storage, and a Recipe listing the
+// chunks is returned to restore the data later. Successive versions of a file share
+// most of their chunks, so each version only costs the chunks that changed.
+package dedup
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// ErrChunkCorrupted is returned when a restored chunk doesn't match its ID
+var ErrChunkCorrupted = errors.New("dedup: chunk content doesn't match its ID")
+
+// ChunkID identifies a chunk by the SHA-256 of its uncompressed content
+type ChunkID [sha256.Size]byte
+
+// String returns the ID in hexadecimal
+func (id ChunkID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// MarshalText implements the encoding.TextMarshaler interface, so recipes encode as JSON
+func (id ChunkID) MarshalText() ([]byte, error) {
+	return []byte(id.String()), nil
+}
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface
+func (id *ChunkID) UnmarshalText(text []byte) error {
+	if hex.DecodedLen(len(text)) != len(id) {
+		return fmt.Errorf("dedup: invalid chunk ID %q", text)
+	}
+	_, err := hex.Decode(id[:], text)
+	return err
+}
+
+// ChunkRef is a chunk of a Recipe
+type ChunkRef struct {
+	ID   ChunkID
+	Size int // Uncompressed size
+}
+
+// Recipe lists the chunks of data put in a Store, in order
+type Recipe []ChunkRef
+
+// Size returns the size of the data the recipe restores
+func (r Recipe) Size() int64 {
+	var size int64
+	for _, chunk := range r {
+		size += int64(chunk.Size)
+	}
+	return size
+}
+
+// Stats describes the effect of a Put
+type Stats struct {
+	Chunks     int   // Chunks the data was split into
+	NewChunks  int   // Chunks that weren't stored yet
+	Size       int64 // Size of the data
+	NewSize    int64 // Uncompressed size of the new chunks
+	StoredSize int64 // Compressed size of the new chunks
+}
+
+// Storage holds compressed chunks by ID. Implementations must be safe for concurrent use.
+type Storage interface {
+	Put(id ChunkID, data []byte) error
+	Get(id ChunkID) ([]byte, error) // Returns an error matching fs.ErrNotExist for unknown chunks
+}
+
+// Options configures a Store
+type Options struct {
+	Level      int              // Compression level of the chunks (0 = the instance default)
+	Dictionary *zstd.Dictionary // Dictionary to compress the chunks with, if any
+	Chunker    ChunkerOptions
+}
+
+// indexEntry records a stored chunk
+type indexEntry struct {
+	size           uint32
+	compressedSize uint32
+}
+
+// Store puts data in a Storage as deduplicated compressed chunks. It is safe for
+// concurrent use.
+type Store struct {
+	zstd    *zstd.Zstd
+	storage Storage
+	options Options
+
+	mu    sync.Mutex
+	index map[ChunkID]indexEntry // Chunks known to be in the storage
+}
+
+// NewStore returns a Store writing chunks to storage. Its index starts empty: chunks
+// stored before are stored again, unless the index saved by SaveIndex is loaded.
+func NewStore(z *zstd.Zstd, storage Storage, opts Options) *Store {
+	return &Store{
+		zstd:    z,
+		storage: storage,
+		options: opts,
+		index:   make(map[ChunkID]indexEntry),
+	}
+}
+
+// Put splits the data read from r into chunks, stores those not in the index yet, and
+// returns the recipe restoring the data
+func (s *Store) Put(r io.Reader) (Recipe, Stats, error) {
+	var recipe Recipe
+	var stats Stats
+	chunker := NewChunker(r, s.options.Chunker)
+	for {
+		chunk, err := chunker.Next()
+		if err == io.EOF {
+			return recipe, stats, nil
+		} else if err != nil {
+			return recipe, stats, err
+		}
+
+		id := ChunkID(sha256.Sum256(chunk))
+		recipe = append(recipe, ChunkRef{ID: id, Size: len(chunk)})
+		stats.Chunks++
+		stats.Size += int64(len(chunk))
+		if s.Has(id) {
+			continue
+		}
+
+		compressed, err := s.zstd.CompressUsingDict(chunk, s.options.Dictionary, s.options.Level)
+		if err != nil {
+			return recipe, stats, err
+		}
+		if err := s.storage.Put(id, compressed); err != nil {
+			return recipe, stats, err
+		}
+		s.mu.Lock()
+		s.index[id] = indexEntry{size: uint32(len(chunk)), compressedSize: uint32(len(compressed))}
+		s.mu.Unlock()
+
+		stats.NewChunks++
+		stats.NewSize += int64(len(chunk))
+		stats.StoredSize += int64(len(compressed))
+	}
+}
+
+// Get restores the data of the recipe to w, checking every chunk against its ID, and
+// returns the number of bytes written
+func (s *Store) Get(w io.Writer, recipe Recipe) (int64, error) {
+	var written int64
+	for _, ref := range recipe {
+		compressed, err := s.storage.Get(ref.ID)
+		if err != nil {
+			return written, err
+		}
+		chunk, err := s.zstd.DecompressUsingDict(compressed, s.options.Dictionary, ref.Size)
+		if err != nil {
+			return written, fmt.Errorf("dedup: chunk %s: %w", ref.ID, err)
+		}
+		if len(chunk) != ref.Size || sha256.Sum256(chunk) != ref.ID {
+			return written, fmt.Errorf("%w: %s", ErrChunkCorrupted, ref.ID)
+		}
+
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// Has reports whether the chunk is in the index
+func (s *Store) Has(id ChunkID) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.index[id]
+	return ok
+}
+
+// Size returns the number of chunks in the index, and their total compressed size
+func (s *Store) Size() (chunks int, compressedSize int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, entry := range s.index {
+		compressedSize += int64(entry.compressedSize)
+	}
+	return len(s.index), compressedSize
+}
+
+// SaveIndex writes the index to w, to be loaded by LoadIndex when the storage is
+// opened again. Each chunk takes 40 bytes: its ID, then its uncompressed and compressed
+// sizes as little-endian 32-bit integers.
+func (s *Store) SaveIndex(w io.Writer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	bw := bufio.NewWriter(w)
+	var record [sha256.Size + 8]byte
+	for id, entry := range s.index {
+		copy(record[:], id[:])
+		binary.LittleEndian.PutUint32(record[len(id):], entry.size)
+		binary.LittleEndian.PutUint32(record[len(id)+4:], entry.compressedSize)
+		if _, err := bw.Write(record[:]); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// LoadIndex adds the chunks of an index written by SaveIndex to the index
+func (s *Store) LoadIndex(r io.Reader) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	br := bufio.NewReader(r)
+	var record [sha256.Size + 8]byte
+	for {
+		_, err := io.ReadFull(br, record[:])
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("dedup: load index: %w", err)
+		}
+
+		id := ChunkID(record[:sha256.Size])
+		s.index[id] = indexEntry{
+			size:           binary.LittleEndian.Uint32(record[len(id):]),
+			compressedSize: binary.LittleEndian.Uint32(record[len(id)+4:]),
+		}
+	}
+}
+
+// MemoryStorage is a Storage keeping the chunks in memory
+type MemoryStorage struct {
+	mu     sync.RWMutex
+	chunks map[ChunkID][]byte
+}
+
+// Put implements the Storage interface
+func (m *MemoryStorage) Put(id ChunkID, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.chunks == nil {
+		m.chunks = make(map[ChunkID][]byte)
+	}
+	m.chunks[id] = data
+	return nil
+}
+
+// Get implements the Storage interface
+func (m *MemoryStorage) Get(id ChunkID) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, ok := m.chunks[id]
+	if !ok {
+		return nil, fmt.Errorf("dedup: chunk %s: %w", id, fs.ErrNotExist)
+	}
+	return data, nil
+}
+
+// DirStorage is a Storage keeping each chunk in a file of the directory, named after
+// its ID in a subdirectory named after the first byte of the ID, as git does
+type DirStorage string
+
+// Put implements the Storage interface. The file is written atomically.
+func (d DirStorage) Put(id ChunkID, data []byte) error {
+	path := d.path(id)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get implements the Storage interface
+func (d DirStorage) Get(id ChunkID) ([]byte, error) {
+	return os.ReadFile(d.path(id))
+}
+
+// path returns the path of the file of the chunk
+func (d DirStorage) path(id ChunkID) string {
+	name := id.String()
+	return filepath.Join(string(d), name[:2], name[2:]+".zst")
+}
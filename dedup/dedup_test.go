@@ -0,0 +1,132 @@
+package dedup
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"testing"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// testData returns compressible pseudo-random data
+func testData(seed uint64, size int) []byte {
+	rng := rand.New(rand.NewPCG(seed, 0))
+	words := []string{"alpha ", "beta ", "gamma ", "delta ", "epsilon ", "zeta ", "eta ", "theta "}
+	var buf bytes.Buffer
+	for buf.Len() < size {
+		buf.WriteString(words[rng.IntN(len(words))])
+	}
+	return buf.Bytes()[:size]
+}
+
+func TestChunker(t *testing.T) {
+	data := testData(1, 1<<20)
+	opts := ChunkerOptions{AvgSize: 8 << 10}
+
+	chunks := func(data []byte) map[string]bool {
+		seen := make(map[string]bool)
+		c := NewChunker(bytes.NewReader(data), opts)
+		var total int
+		for {
+			chunk, err := c.Next()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("Next failed: %v", err)
+			}
+			if len(chunk) > 32<<10 {
+				t.Errorf("Chunk of %d bytes exceeds the maximum size", len(chunk))
+			}
+			seen[string(chunk)] = true
+			total += len(chunk)
+		}
+		if total != len(data) {
+			t.Errorf("Chunks cover %d of %d bytes", total, len(data))
+		}
+		return seen
+	}
+
+	original := chunks(data)
+	if n := len(original); n < 1<<20/(24<<10) || n > 1<<20/(4<<10) {
+		t.Errorf("Unexpected number of chunks %d", n)
+	}
+
+	// An insertion only changes the chunks around it
+	edited := append(append(append([]byte(nil), data[:500000]...), "inserted"...), data[500000:]...)
+	changed := 0
+	for chunk := range chunks(edited) {
+		if !original[chunk] {
+			changed++
+		}
+	}
+	if changed > 2 {
+		t.Errorf("Insertion changed %d chunks", changed)
+	}
+}
+
+func TestStore(t *testing.T) {
+	z, err := zstd.New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	for name, storage := range map[string]Storage{"memory": &MemoryStorage{}, "dir": DirStorage(t.TempDir())} {
+		store := NewStore(z, storage, Options{Chunker: ChunkerOptions{AvgSize: 8 << 10}})
+		v1 := testData(2, 300000)
+		recipe1, stats1, err := store.Put(bytes.NewReader(v1))
+		if err != nil {
+			t.Fatalf("%s: Put failed: %v", name, err)
+		}
+		if stats1.Size != int64(len(v1)) || recipe1.Size() != int64(len(v1)) || stats1.StoredSize >= stats1.Size {
+			t.Errorf("%s: Unexpected stats %+v", name, stats1)
+		}
+
+		// A second version stores only its new chunks
+		v2 := append(bytes.Clone(v1[:100000]), v1[110000:]...)
+		recipe2, stats2, err := store.Put(bytes.NewReader(v2))
+		if err != nil {
+			t.Fatalf("%s: Put failed: %v", name, err)
+		}
+		if stats2.NewChunks == 0 || stats2.NewChunks > 3 || stats2.NewSize >= stats2.Size/4 {
+			t.Errorf("%s: Second version wasn't deduplicated: %+v", name, stats2)
+		}
+
+		for i, want := range [][]byte{v1, v2} {
+			var buf bytes.Buffer
+			if _, err := store.Get(&buf, []Recipe{recipe1, recipe2}[i]); err != nil || !bytes.Equal(buf.Bytes(), want) {
+				t.Errorf("%s: Restoring version %d failed: %v", name, i+1, err)
+			}
+		}
+
+		// A reopened store knows the chunks from its saved index
+		var index bytes.Buffer
+		store.SaveIndex(&index)
+		reopened := NewStore(z, storage, Options{Chunker: ChunkerOptions{AvgSize: 8 << 10}})
+		if err := reopened.LoadIndex(&index); err != nil {
+			t.Fatalf("%s: LoadIndex failed: %v", name, err)
+		}
+		if _, stats, _ := reopened.Put(bytes.NewReader(v2)); stats.NewChunks != 0 {
+			t.Errorf("%s: Reopened store stored %d known chunks again", name, stats.NewChunks)
+		}
+		if chunks, _ := reopened.Size(); chunks != len(store.index) {
+			t.Errorf("%s: Reopened index has %d chunks instead of %d", name, chunks, len(store.index))
+		}
+
+		// Chunks are checked against their IDs
+		storage.Put(recipe1[0].ID, mustCompress(t, z, []byte("tampered")))
+		if _, err := store.Get(io.Discard, recipe1); !errors.Is(err, ErrChunkCorrupted) {
+			t.Errorf("%s: Expected ErrChunkCorrupted, got %v", name, err)
+		}
+	}
+}
+
+func mustCompress(t *testing.T, z *zstd.Zstd, data []byte) []byte {
+	compressed, err := z.Compress(data, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressed
+}
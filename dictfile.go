@@ -0,0 +1,91 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+// dictionaryMagic starts every dictionary in the zstd format, ZSTD_MAGIC_DICTIONARY
+const dictionaryMagic = 0xEC30A437
+
+// Size returns the size of the dictionary content in bytes
+func (d *Dictionary) Size() int {
+	return len(d.dictData)
+}
+
+// SaveDictionary writes the dictionary to the file at path, replacing it atomically.
+// Only dictionaries in the zstd format, with a header and an ID, can be saved.
+func (z *Zstd) SaveDictionary(path string, dict *Dictionary) error {
+	if err := z.checkDictionary(dict.dictData); err != nil {
+		return fmt.Errorf("zstd: save dictionary %s: %w", path, err)
+	}
+
+	// Write to a temporary file next to the destination, so readers never see a partial file
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(dict.dictData); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0o644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LoadDictionaryFile loads a dictionary saved by SaveDictionary, or by the zstd command
+// line tool with --train. The file must hold a dictionary in the zstd format: its magic
+// number and ID are checked, and the library must be able to digest it. The size and ID
+// of the loaded dictionary are available from Size and ID.
+func (z *Zstd) LoadDictionaryFile(path string) (*Dictionary, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	dictData, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := z.checkDictionary(dictData); err != nil {
+		return nil, fmt.Errorf("zstd: load dictionary %s: %w", path, err)
+	}
+	return z.LoadDictionary(dictData)
+}
+
+// checkDictionary verifies that dictData is a dictionary in the zstd format that the
+// library accepts, matching ErrDictionaryCorrupted otherwise
+func (z *Zstd) checkDictionary(dictData []byte) error {
+	if z.isClosed() {
+		return ErrAlreadyClosed
+	}
+	if len(dictData) < 8 || binary.LittleEndian.Uint32(dictData) != dictionaryMagic {
+		return fmt.Errorf("%w: not a zstd dictionary", ErrDictionaryCorrupted)
+	}
+
+	if err := z.registerDictionaryFunctions(); err != nil {
+		return err
+	}
+	id := z.getDictID(unsafe.Pointer(&dictData[0]), uint64(len(dictData)))
+	if id == 0 || id != binary.LittleEndian.Uint32(dictData[4:]) {
+		return fmt.Errorf("%w: invalid dictionary ID", ErrDictionaryCorrupted)
+	}
+
+	// Digesting the dictionary checks its entropy tables
+	ddict := z.createDDict(unsafe.Pointer(&dictData[0]), uint64(len(dictData)))
+	if ddict == nil {
+		return fmt.Errorf("%w: invalid entropy tables", ErrDictionaryCorrupted)
+	}
+	z.freeDDict(ddict)
+	return nil
+}
@@ -2,6 +2,9 @@ package zstd
 
 import (
 	"fmt"
+	"io"
+	"runtime"
+	"sync"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -12,29 +15,54 @@ type Dictionary struct {
 	zstd     *Zstd
 	dictData []byte
 	dictID   uint32
+
+	// Dictionaries loaded by reference keep dictData pinned, as native memory points into it
+	byReference bool
+	pinner      runtime.Pinner
+
+	// Digested dictionaries kept for reuse; uses hold the read lock
+	mu          sync.RWMutex
+	cdicts      map[int]unsafe.Pointer // Compression dictionaries by level
+	cdictLevels []int                  // Levels in cdicts, oldest first
+	ddict       unsafe.Pointer
 }
 
 // RegisterDictionary registers additional functions for dictionary operations
 func (z *Zstd) registerDictionaryFunctions() error {
-	// Check if already registered
-	if z.createCDict != nil {
-		return nil
-	}
+	// Register dictionary API functions once, even if several goroutines get here together
+	z.dictOnce.Do(func() {
+		purego.RegisterLibFunc(&z.createCDict, z.handle, "ZSTD_createCDict")
+		purego.RegisterLibFunc(&z.freeCDict, z.handle, "ZSTD_freeCDict")
+		purego.RegisterLibFunc(&z.createDDict, z.handle, "ZSTD_createDDict")
+		purego.RegisterLibFunc(&z.createCDictByRef, z.handle, "ZSTD_createCDict_byReference")
+		purego.RegisterLibFunc(&z.createDDictByRef, z.handle, "ZSTD_createDDict_byReference")
+		purego.RegisterLibFunc(&z.freeDDict, z.handle, "ZSTD_freeDDict")
+		purego.RegisterLibFunc(&z.compressUsingCDict, z.handle, "ZSTD_compress_usingCDict")
+		purego.RegisterLibFunc(&z.decompressUsingDDict, z.handle, "ZSTD_decompress_usingDDict")
+		purego.RegisterLibFunc(&z.getDictID, z.handle, "ZSTD_getDictID_fromDict")
+		purego.RegisterLibFunc(&z.cctxRefCDict, z.handle, "ZSTD_CCtx_refCDict")
+		purego.RegisterLibFunc(&z.dctxRefDDict, z.handle, "ZSTD_DCtx_refDDict")
+		purego.RegisterLibFunc(&z.cctxLoadDictionary, z.handle, "ZSTD_CCtx_loadDictionary_advanced")
+		purego.RegisterLibFunc(&z.dctxLoadDictionary, z.handle, "ZSTD_DCtx_loadDictionary_advanced")
+		purego.RegisterLibFunc(&z.optimizeTrainCover, z.handle, "ZDICT_optimizeTrainFromBuffer_cover")
+		purego.RegisterLibFunc(&z.optimizeTrainFastCover, z.handle, "ZDICT_optimizeTrainFromBuffer_fastCover")
+		registerFinalizeDictionary(z, z.handle)
+		z.countDictionaryCalls()
 
-	// Register dictionary API functions
-	purego.RegisterLibFunc(&z.createCDict, z.handle, "ZSTD_createCDict")
-	purego.RegisterLibFunc(&z.freeCDict, z.handle, "ZSTD_freeCDict")
-	purego.RegisterLibFunc(&z.createDDict, z.handle, "ZSTD_createDDict")
-	purego.RegisterLibFunc(&z.freeDDict, z.handle, "ZSTD_freeDDict")
-	purego.RegisterLibFunc(&z.compressUsingCDict, z.handle, "ZSTD_compress_usingCDict")
-	purego.RegisterLibFunc(&z.decompressUsingDDict, z.handle, "ZSTD_decompress_usingDDict")
-	purego.RegisterLibFunc(&z.getDictID, z.handle, "ZSTD_getDictID_fromDict")
+		if z.tracer != nil {
+			z.traceDictionaryCalls()
+		}
+	})
 
 	return nil
 }
 
 // LoadDictionary loads a pre-trained dictionary for compression/decompression
 func (z *Zstd) LoadDictionary(dictData []byte) (*Dictionary, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
 	if len(dictData) == 0 {
 		return nil, fmt.Errorf("empty dictionary data")
 	}
@@ -54,6 +82,22 @@ func (z *Zstd) LoadDictionary(dictData []byte) (*Dictionary, error) {
 	}, nil
 }
 
+// LoadDictionaryByReference loads a dictionary like LoadDictionary, but the digested
+// dictionaries refer to dictData instead of copying it to native memory, which saves
+// memory and time for large dictionaries. dictData is pinned for as long as the
+// Dictionary is reachable, and must not be modified once loaded.
+func (z *Zstd) LoadDictionaryByReference(dictData []byte) (*Dictionary, error) {
+	dict, err := z.LoadDictionary(dictData)
+	if err != nil {
+		return nil, err
+	}
+
+	dict.byReference = true
+	dict.pinner.Pin(&dictData[0])
+	runtime.SetFinalizer(dict, (*Dictionary).finalize)
+	return dict, nil
+}
+
 // ID returns the dictionary ID
 func (d *Dictionary) ID() uint32 {
 	return d.dictID
@@ -61,6 +105,10 @@ func (d *Dictionary) ID() uint32 {
 
 // CompressUsingDict compresses data using the dictionary
 func (z *Zstd) CompressUsingDict(src []byte, dict *Dictionary, level int) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
 	if len(src) == 0 {
 		return []byte{}, nil
 	}
@@ -68,30 +116,33 @@ func (z *Zstd) CompressUsingDict(src []byte, dict *Dictionary, level int) ([]byt
 	if dict == nil || len(dict.dictData) == 0 {
 		return z.Compress(src, level)
 	}
+	level = z.resolveLevel(level)
+
+	// Register dictionary functions if needed, the dictionary may come from another instance
+	if err := z.registerDictionaryFunctions(); err != nil {
+		return nil, err
+	}
 
 	// Create a compression context
-	cctx := z.createCCtx()
+	cctx := z.getCCtx()
 	if cctx == nil {
-		return nil, fmt.Errorf("failed to create compression context")
+		return nil, z.allocError("compress with dictionary", "compression context")
 	}
-	defer z.freeCCtx(cctx)
+	defer z.putCCtx(cctx)
 
-	// Create a compression dictionary
-	cdict := z.createCDict(
-		unsafe.Pointer(&dict.dictData[0]),
-		uint64(len(dict.dictData)),
-		level,
-	)
+	// Use the compiled dictionary, or digest one for this call
+	cdict, done := dict.compiledCDict(z, level)
 	if cdict == nil {
-		return nil, fmt.Errorf("failed to create compression dictionary")
+		return nil, z.allocError("compress with dictionary", "compression dictionary")
 	}
-	defer z.freeCDict(cdict)
+	defer done()
 
-	// Allocate output buffer
+	// Compress into a pooled buffer of the worst case size
 	dstCapacity := z.compressBound(uint64(len(src)))
-	dst := make([]byte, dstCapacity)
+	dst := getBuffer(int(dstCapacity))
 
 	// Compress using dictionary
+	op := z.startOperation(true, Operation{Level: level, SrcSize: int64(len(src))})
 	result := z.compressUsingCDict(
 		cctx,
 		unsafe.Pointer(&dst[0]),
@@ -103,14 +154,22 @@ func (z *Zstd) CompressUsingDict(src []byte, dict *Dictionary, level int) ([]byt
 
 	// Check for errors
 	if z.isError(result) != 0 {
-		return nil, fmt.Errorf("dictionary compression error: %s", z.getErrorName(result))
+		err := z.nativeError("compress with dictionary", result)
+		op.end(0, 0, err)
+		putBuffer(dst)
+		return nil, err
 	}
+	op.end(int64(len(src)), int64(result), nil)
 
-	return dst[:result], nil
+	return scratchResult(dst, int(result)), nil
 }
 
 // DecompressUsingDict decompresses data using the dictionary
 func (z *Zstd) DecompressUsingDict(src []byte, dict *Dictionary, maxSize int) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
 	if len(src) == 0 {
 		return []byte{}, nil
 	}
@@ -119,6 +178,11 @@ func (z *Zstd) DecompressUsingDict(src []byte, dict *Dictionary, maxSize int) ([
 		return z.Decompress(src, maxSize)
 	}
 
+	// Register dictionary functions if needed, the dictionary may come from another instance
+	if err := z.registerDictionaryFunctions(); err != nil {
+		return nil, err
+	}
+
 	// If maxSize is 0, use a reasonable default
 	if maxSize <= 0 {
 		// Use a conservative estimation
@@ -129,26 +193,24 @@ func (z *Zstd) DecompressUsingDict(src []byte, dict *Dictionary, maxSize int) ([
 	}
 
 	// Create a decompression context
-	dctx := z.createDCtx()
+	dctx := z.getDCtx()
 	if dctx == nil {
-		return nil, fmt.Errorf("failed to create decompression context")
+		return nil, z.allocError("decompress with dictionary", "decompression context")
 	}
-	defer z.freeDCtx(dctx)
+	defer z.putDCtx(dctx)
 
-	// Create a decompression dictionary
-	ddict := z.createDDict(
-		unsafe.Pointer(&dict.dictData[0]),
-		uint64(len(dict.dictData)),
-	)
+	// Use the compiled dictionary, or digest one for this call
+	ddict, done := dict.compiledDDict(z)
 	if ddict == nil {
-		return nil, fmt.Errorf("failed to create decompression dictionary")
+		return nil, z.allocError("decompress with dictionary", "decompression dictionary")
 	}
-	defer z.freeDDict(ddict)
+	defer done()
 
-	// Allocate output buffer
-	dst := make([]byte, maxSize)
+	// Decompress into a pooled buffer of maxSize
+	dst := getBuffer(maxSize)
 
 	// Decompress using dictionary
+	op := z.startOperation(false, Operation{SrcSize: int64(len(src))})
 	result := z.decompressUsingDDict(
 		dctx,
 		unsafe.Pointer(&dst[0]),
@@ -160,8 +222,65 @@ func (z *Zstd) DecompressUsingDict(src []byte, dict *Dictionary, maxSize int) ([
 
 	// Check for errors
 	if z.isError(result) != 0 {
-		return nil, fmt.Errorf("dictionary decompression error: %s", z.getErrorName(result))
+		err := z.nativeError("decompress with dictionary", result)
+		op.end(0, 0, err)
+		putBuffer(dst)
+		return nil, err
+	}
+	op.end(int64(len(src)), int64(result), nil)
+
+	return scratchResult(dst, int(result)), nil
+}
+
+// CompressBestDict compresses src with each of the dictionaries, and without one, and
+// returns the smallest result along with the ID of the dictionary used (0 = none). Frames
+// record the ID, so a DictionaryRegistry holding the candidates picks the right one to
+// decompress. This suits workloads whose messages follow several distinct schemas; the
+// dictionaries are compiled on first use, so each attempt costs a compression only.
+func (z *Zstd) CompressBestDict(src []byte, level int, dicts ...*Dictionary) ([]byte, uint32, error) {
+	best, err := z.Compress(src, level)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var bestID uint32
+	for _, dict := range dicts {
+		compressed, err := z.CompressUsingDict(src, dict, level)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(compressed) < len(best) {
+			best, bestID = compressed, dict.ID()
+		}
+	}
+	return best, bestID, nil
+}
+
+// NewReaderDict creates a Reader for decompressing a stream that was compressed
+// with the dictionary. The digested dictionary is created once and referenced by the
+// stream, so long-lived connections pay for it only once.
+func (z *Zstd) NewReaderDict(r io.Reader, dict *Dictionary, opts ...Option) *Reader {
+	// Register dictionary functions if needed
+	if !z.isClosed() {
+		z.registerDictionaryFunctions()
+	}
+
+	reader := z.NewReader(r, opts...)
+	reader.dict = dict
+	return reader
+}
+
+// NewWriterDict creates a Writer for compressing to the provided writer using the
+// dictionary at the specified level. The digested dictionary is created once and referenced
+// by the stream, so long-lived connections pay for it only once.
+// The caller must call Close() when done to ensure all data is flushed.
+func (z *Zstd) NewWriterDict(w io.Writer, dict *Dictionary, level int, opts ...Option) *Writer {
+	// Register dictionary functions if needed
+	if !z.isClosed() {
+		z.registerDictionaryFunctions()
 	}
 
-	return dst[:result], nil
+	writer := z.NewWriter(w, level, opts...)
+	writer.dict = dict
+	return writer
 }
@@ -5,15 +5,44 @@ import (
 	"io"
 )
 
-// Error represents a Zstandard error
+// Error represents a Zstandard error. Code is the library's ZSTD_ErrorCode, and the
+// error matches the sentinel for its kind with errors.Is.
 type Error struct {
+	Op      string // Operation that failed, such as "compress" or "decompress"
 	Code    uint64
 	Message string
 }
 
 // Error implements the error interface
 func (e *Error) Error() string {
-	return fmt.Sprintf("zstd error: %s (code: %d)", e.Message, e.Code)
+	if e.Op == "" {
+		return fmt.Sprintf("zstd error: %s (code: %d)", e.Message, e.Code)
+	}
+	return fmt.Sprintf("zstd: %s: %s (code: %d)", e.Op, e.Message, e.Code)
+}
+
+// Is reports whether target is the sentinel error for the kind of e
+func (e *Error) Is(target error) bool {
+	sentinel, ok := errorCodes[e.Code]
+	return ok && target == sentinel
+}
+
+// nativeError returns the *Error for a function result that ZSTD_isError reports as failed
+func (z *Zstd) nativeError(op string, result uint64) error {
+	return &Error{
+		Op:      op,
+		Code:    uint64(z.getErrorCode(result)),
+		Message: z.getErrorName(result),
+	}
+}
+
+// allocError returns the *Error for a native object the library failed to create
+func (z *Zstd) allocError(op, object string) error {
+	return &Error{
+		Op:      op,
+		Code:    codeMemoryAllocation,
+		Message: "failed to create " + object,
+	}
 }
 
 // IsError returns true if the code represents an error condition
@@ -34,8 +63,97 @@ var (
 	ErrMaxSizeExceeded = fmt.Errorf("zstd: maximum size exceeded")
 	ErrUnsupported     = fmt.Errorf("zstd: unsupported platform")
 	ErrAlreadyClosed   = fmt.Errorf("zstd: already closed")
+	ErrAborted         = fmt.Errorf("zstd: stream aborted")
+	ErrMidFrame        = fmt.Errorf("zstd: parameters can only be changed between frames")
+	ErrInvalidOption   = fmt.Errorf("zstd: invalid option")
+)
+
+// Errors reported by the library, matched by *Error values with errors.Is
+var (
+	ErrGeneric               = fmt.Errorf("zstd: generic error")
+	ErrUnknownFrame          = fmt.Errorf("zstd: unknown frame descriptor")
+	ErrVersionUnsupported    = fmt.Errorf("zstd: unsupported format version")
+	ErrFrameParameter        = fmt.Errorf("zstd: unsupported frame parameter")
+	ErrWindowTooLarge        = fmt.Errorf("zstd: frame requires too much memory for decoding")
+	ErrCorruptedData         = fmt.Errorf("zstd: data corruption detected")
+	ErrChecksumWrong         = fmt.Errorf("zstd: checksum mismatch")
+	ErrDictionaryCorrupted   = fmt.Errorf("zstd: dictionary is corrupted")
+	ErrDictionaryWrong       = fmt.Errorf("zstd: dictionary mismatch")
+	ErrDictionaryCreation    = fmt.Errorf("zstd: cannot create dictionary")
+	ErrParameterUnsupported  = fmt.Errorf("zstd: unsupported parameter")
+	ErrParameterCombination  = fmt.Errorf("zstd: unsupported combination of parameters")
+	ErrParameterOutOfBound   = fmt.Errorf("zstd: parameter out of bounds")
+	ErrStageWrong            = fmt.Errorf("zstd: operation not authorized at current processing stage")
+	ErrMemoryAllocation      = fmt.Errorf("zstd: memory allocation failed")
+	ErrWorkspaceTooSmall     = fmt.Errorf("zstd: workspace too small")
+	ErrDstSizeTooSmall       = fmt.Errorf("zstd: destination buffer too small")
+	ErrSrcSizeWrong          = fmt.Errorf("zstd: source size does not match")
+	ErrNoForwardProgressDest = fmt.Errorf("zstd: no forward progress, destination full")
+	ErrNoForwardProgressSrc  = fmt.Errorf("zstd: no forward progress, input empty")
 )
 
+// ZSTD_error_memory_allocation, also reported when creating a native object fails
+const codeMemoryAllocation = 64
+
+// ZSTD_error_workspace_tooSmall, also reported when a static context cannot fit its workspace
+const codeWorkspaceTooSmall = 66
+
+// errorCodes maps ZSTD_ErrorCode values, from zstd_errors.h, to their sentinel errors
+var errorCodes = map[uint64]error{
+	1:  ErrGeneric,
+	10: ErrUnknownFrame,
+	12: ErrVersionUnsupported,
+	14: ErrFrameParameter,
+	16: ErrWindowTooLarge,
+	20: ErrCorruptedData,
+	22: ErrChecksumWrong,
+	30: ErrDictionaryCorrupted,
+	32: ErrDictionaryWrong,
+	34: ErrDictionaryCreation,
+	40: ErrParameterUnsupported,
+	41: ErrParameterCombination,
+	42: ErrParameterOutOfBound,
+	60: ErrStageWrong,
+	64: ErrMemoryAllocation,
+	66: ErrWorkspaceTooSmall,
+	70: ErrDstSizeTooSmall,
+	72: ErrSrcSizeWrong,
+	80: ErrNoForwardProgressDest,
+	82: ErrNoForwardProgressSrc,
+}
+
+// MaxSizeError is returned by a Reader once the decompressed output would exceed
+// the configured MaxDecompressSize. It matches ErrMaxSizeExceeded with errors.Is.
+type MaxSizeError struct {
+	Limit int64 // The configured limit, in bytes
+}
+
+// Error implements the error interface
+func (e *MaxSizeError) Error() string {
+	return fmt.Sprintf("zstd: decompressed size exceeds limit of %d bytes", e.Limit)
+}
+
+// Is reports whether target is ErrMaxSizeExceeded
+func (e *MaxSizeError) Is(target error) bool {
+	return target == ErrMaxSizeExceeded
+}
+
+// MissingDictionaryError is returned when data needs a dictionary that isn't in the
+// DictionaryRegistry in use. It matches ErrDictionaryWrong with errors.Is.
+type MissingDictionaryError struct {
+	ID uint32 // ID of the dictionary the frame was compressed with
+}
+
+// Error implements the error interface
+func (e *MissingDictionaryError) Error() string {
+	return fmt.Sprintf("zstd: dictionary %d is not registered", e.ID)
+}
+
+// Is reports whether target is ErrDictionaryWrong
+func (e *MissingDictionaryError) Is(target error) bool {
+	return target == ErrDictionaryWrong
+}
+
 // Reader for testing that always returns an error
 type errorReader struct {
 	err error
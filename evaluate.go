@@ -0,0 +1,116 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// DictionaryEvaluation compares compressing samples with a dictionary and without one
+type DictionaryEvaluation struct {
+	Samples      int               // Number of samples compressed
+	OriginalSize int64             // Total size of the samples
+	With         CompressionResult // Results with the dictionary
+	Without      CompressionResult // Results without a dictionary
+}
+
+// CompressionResult measures compressing a set of samples one by one
+type CompressionResult struct {
+	CompressedSize  int64         // Total size of the compressed samples
+	Ratio           float64       // OriginalSize / CompressedSize
+	CompressTime    time.Duration // Time spent compressing
+	DecompressTime  time.Duration // Time spent decompressing
+	CompressSpeed   float64       // Uncompressed bytes compressed per second
+	DecompressSpeed float64       // Uncompressed bytes decompressed per second
+}
+
+// Gain returns how much smaller the samples compress with the dictionary, as a fraction
+// of their size without it: 0.25 means 25% smaller, a negative value larger.
+func (e DictionaryEvaluation) Gain() float64 {
+	if e.Without.CompressedSize == 0 {
+		return 0
+	}
+	return 1 - float64(e.With.CompressedSize)/float64(e.Without.CompressedSize)
+}
+
+// EvaluateDictionary compresses and decompresses each sample on its own at the level
+// (0 = the instance default), with the dictionary and without one, so a dictionary can be
+// checked against a representative set of messages, such as before replacing another.
+// The dictionary is digested before timing. Samples should not be the ones it was trained on.
+func (z *Zstd) EvaluateDictionary(dict []byte, samples [][]byte, level int) (DictionaryEvaluation, error) {
+	var eval DictionaryEvaluation
+	if z.isClosed() {
+		return eval, ErrAlreadyClosed
+	}
+	level = z.resolveLevel(level)
+
+	d, err := z.LoadDictionary(dict)
+	if err != nil {
+		return eval, err
+	}
+	defer d.Release()
+	if err := d.Compile(level); err != nil {
+		return eval, err
+	}
+	if err := d.CompileDecoder(); err != nil {
+		return eval, err
+	}
+
+	for _, s := range samples {
+		if len(s) > 0 {
+			eval.Samples++
+			eval.OriginalSize += int64(len(s))
+		}
+	}
+	if eval.Samples == 0 {
+		return eval, ErrEmptyInput
+	}
+
+	if eval.With, err = z.measure(samples, eval.OriginalSize, d, level); err != nil {
+		return eval, err
+	}
+	if eval.Without, err = z.measure(samples, eval.OriginalSize, nil, level); err != nil {
+		return eval, err
+	}
+	return eval, nil
+}
+
+// measure compresses and decompresses each sample with the dictionary, or none if nil
+func (z *Zstd) measure(samples [][]byte, originalSize int64, dict *Dictionary, level int) (CompressionResult, error) {
+	var result CompressionResult
+	compressed := make([][]byte, len(samples))
+
+	start := time.Now()
+	for i, s := range samples {
+		if len(s) == 0 {
+			continue
+		}
+		c, err := z.CompressUsingDict(s, dict, level)
+		if err != nil {
+			return result, err
+		}
+		compressed[i] = c
+		result.CompressedSize += int64(len(c))
+	}
+	result.CompressTime = time.Since(start)
+
+	start = time.Now()
+	for i, c := range compressed {
+		if c == nil {
+			continue
+		}
+		d, err := z.DecompressUsingDict(c, dict, len(samples[i]))
+		if err != nil {
+			return result, err
+		}
+		if !bytes.Equal(d, samples[i]) {
+			return result, fmt.Errorf("%w: sample %d did not round trip", ErrCorruptedData, i)
+		}
+	}
+	result.DecompressTime = time.Since(start)
+
+	result.Ratio = float64(originalSize) / float64(result.CompressedSize)
+	result.CompressSpeed = float64(originalSize) / result.CompressTime.Seconds()
+	result.DecompressSpeed = float64(originalSize) / result.DecompressTime.Seconds()
+	return result, nil
+}
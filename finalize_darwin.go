@@ -0,0 +1,9 @@
+package zstd
+
+import "github.com/ebitengine/purego"
+
+// registerFinalizeDictionary binds ZDICT_finalizeDictionary, which takes its parameters by value.
+// purego supports struct arguments natively on darwin.
+func registerFinalizeDictionary(z *Zstd, handle uintptr) {
+	purego.RegisterLibFunc(&z.finalizeDictionary, handle, "ZDICT_finalizeDictionary")
+}
@@ -0,0 +1,22 @@
+package zstd
+
+import (
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// registerFinalizeDictionary binds ZDICT_finalizeDictionary, which takes its parameters by value.
+// purego cannot pass structs on linux, but the System V ABI passes the 12-byte ZDICT_params_t
+// on the stack after the seventh argument, in two eightbytes, so it is passed as two integers.
+func registerFinalizeDictionary(z *Zstd, handle uintptr) {
+	var finalizeDictionary func(dst unsafe.Pointer, maxSize uint64, content unsafe.Pointer, contentSize uint64,
+		samples unsafe.Pointer, sampleSizes unsafe.Pointer, nbSamples uint32, paramsLow, paramsHigh uint64) uint64
+	purego.RegisterLibFunc(&finalizeDictionary, handle, "ZDICT_finalizeDictionary")
+
+	z.finalizeDictionary = func(dst unsafe.Pointer, maxSize uint64, content unsafe.Pointer, contentSize uint64,
+		samples unsafe.Pointer, sampleSizes unsafe.Pointer, nbSamples uint32, params zdictParams) uint64 {
+		low := uint64(uint32(params.compressionLevel)) | uint64(params.notificationLevel)<<32
+		return finalizeDictionary(dst, maxSize, content, contentSize, samples, sampleSizes, nbSamples, low, uint64(params.dictID))
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !darwin && !(linux && amd64)
+
+package zstd
+
+import "unsafe"
+
+// registerFinalizeDictionary installs a stub on platforms without an embedded library.
+func registerFinalizeDictionary(z *Zstd, handle uintptr) {
+	z.finalizeDictionary = func(dst unsafe.Pointer, maxSize uint64, content unsafe.Pointer, contentSize uint64,
+		samples unsafe.Pointer, sampleSizes unsafe.Pointer, nbSamples uint32, params zdictParams) uint64 {
+		return ^uint64(0) // ZSTD_error_GENERIC
+	}
+}
@@ -0,0 +1,277 @@
+package zstd
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// The library instance of the package-level FS, loaded on first use and shared by all
+// its files
+var (
+	fsOnce     sync.Once
+	fsInstance *Zstd
+	fsErr      error
+)
+
+// fsZstd returns the instance of the package-level FS
+func fsZstd() (*Zstd, error) {
+	fsOnce.Do(func() {
+		fsInstance, fsErr = New()
+	})
+	return fsInstance, fsErr
+}
+
+// zstFS is the file system returned by FS
+type zstFS struct {
+	zstd  *Zstd // Instance decompressing the files (nil = the shared one of the package)
+	inner fs.FS
+}
+
+// FS returns a file system serving the files of inner, where a file stored compressed
+// as name.zst appears as name and is decompressed as it is read. Directories list it
+// under that name too, unless name exists as well. Other files are served as they are.
+// This lets embedded assets, or trees of compressed files on disk, be used through any
+// fs.FS consumer, such as http.FileServerFS or template.ParseFS.
+// The library is loaded once, on the first open of a compressed file, and shared.
+func FS(inner fs.FS) fs.FS {
+	return &zstFS{inner: inner}
+}
+
+// FS is like the package-level FS, using the instance to decompress the files
+func (z *Zstd) FS(inner fs.FS) fs.FS {
+	return &zstFS{zstd: z, inner: inner}
+}
+
+// Open implements the fs.FS interface. Decompressed files implement io.Seeker; seeking
+// backward requires the file of inner to implement it too.
+func (fsys *zstFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	f, err := fsys.inner.Open(name)
+	if err == nil {
+		if info, err := f.Stat(); err == nil && info.IsDir() {
+			return &zstDir{File: f, fsys: fsys, name: name}, nil
+		}
+		return f, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) || name == "." {
+		return nil, err
+	}
+
+	raw, zerr := fsys.inner.Open(name + ".zst")
+	if zerr != nil {
+		return nil, err
+	}
+	z := fsys.zstd
+	if z == nil {
+		if z, err = fsZstd(); err != nil {
+			raw.Close()
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+	}
+	return &zstFile{fsys: fsys, name: name, raw: raw, reader: z.NewReader(raw), size: -1}, nil
+}
+
+// decompressedSize returns the size of the content of the compressed file at name,
+// from its frame headers, or by decompressing it if they don't record it
+func (fsys *zstFS) decompressedSize(z *Zstd, name string) (int64, error) {
+	raw, err := fsys.inner.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer raw.Close()
+
+	// Read the frame headers only, if the file allows it
+	var src io.Reader = raw
+	if ra, ok := raw.(io.ReaderAt); ok {
+		if info, err := raw.Stat(); err == nil {
+			src = io.NewSectionReader(ra, 0, info.Size())
+		}
+	}
+	list, err := z.ListFrames(src)
+	if err != nil {
+		return 0, err
+	}
+	if list.HasContentSize {
+		return int64(list.ContentSize), nil
+	}
+
+	// Frames written as a stream don't record their size
+	raw, err = fsys.inner.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer raw.Close()
+	r := z.NewReader(raw)
+	defer r.Close()
+	return io.Copy(io.Discard, r)
+}
+
+// zstFile is a compressed file of a zstFS, decompressed as it is read
+type zstFile struct {
+	fsys   *zstFS
+	name   string // Name in the file system, without the .zst extension
+	raw    fs.File
+	reader *Reader
+	size   int64 // Decompressed size, once known (-1 = not yet)
+}
+
+// Read implements the io.Reader interface
+func (f *zstFile) Read(p []byte) (int, error) {
+	return f.reader.Read(p)
+}
+
+// Seek implements the io.Seeker interface. Seeking relative to the end needs the
+// decompressed size, which is determined as by Stat.
+func (f *zstFile) Seek(offset int64, whence int) (int64, error) {
+	if whence == io.SeekEnd {
+		size, err := f.contentSize()
+		if err != nil {
+			return 0, err
+		}
+		offset, whence = size+offset, io.SeekStart
+	}
+	return f.reader.Seek(offset, whence)
+}
+
+// Stat implements the fs.File interface. The size is the decompressed size, which is
+// recorded in the frame headers unless the file was compressed as a stream; then, it
+// is determined by decompressing the file once.
+func (f *zstFile) Stat() (fs.FileInfo, error) {
+	info, err := f.raw.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size, err := f.contentSize()
+	if err != nil {
+		return nil, &fs.PathError{Op: "stat", Path: f.name, Err: err}
+	}
+	return &zstFileInfo{FileInfo: info, name: path.Base(f.name), size: size}, nil
+}
+
+// Close implements the io.Closer interface
+func (f *zstFile) Close() error {
+	err := f.reader.Close()
+	if rawErr := f.raw.Close(); err == nil {
+		err = rawErr
+	}
+	return err
+}
+
+// contentSize returns the decompressed size of the file, determining it the first time
+func (f *zstFile) contentSize() (int64, error) {
+	if f.size < 0 {
+		size, err := f.fsys.decompressedSize(f.reader.zstd, f.name+".zst")
+		if err != nil {
+			return 0, err
+		}
+		f.size = size
+	}
+	return f.size, nil
+}
+
+// zstFileInfo describes a compressed file under its name in a zstFS
+type zstFileInfo struct {
+	fs.FileInfo
+	name string
+	size int64
+}
+
+// Name implements the fs.FileInfo interface
+func (i *zstFileInfo) Name() string {
+	return i.name
+}
+
+// Size implements the fs.FileInfo interface
+func (i *zstFileInfo) Size() int64 {
+	return i.size
+}
+
+// zstDir is a directory of a zstFS, listing compressed files under their names
+type zstDir struct {
+	fs.File
+	fsys    *zstFS
+	name    string
+	entries []fs.DirEntry // Entries not returned yet, once listed
+	listed  bool
+}
+
+// ReadDir implements the fs.ReadDirFile interface
+func (d *zstDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		dir, ok := d.File.(fs.ReadDirFile)
+		if !ok {
+			return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: errors.New("not implemented")}
+		}
+		entries, err := dir.ReadDir(-1)
+		if err != nil {
+			return nil, err
+		}
+		d.entries = d.fsys.listEntries(d.name, entries)
+		d.listed = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// listEntries renames the compressed files among the entries of dir, sorted by name
+func (fsys *zstFS) listEntries(dir string, entries []fs.DirEntry) []fs.DirEntry {
+	names := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		names[entry.Name()] = true
+	}
+
+	listed := make([]fs.DirEntry, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".zst")
+		if ok && name != "" && !entry.IsDir() && !names[name] {
+			entry = &zstDirEntry{DirEntry: entry, fsys: fsys, name: name, dir: dir}
+		}
+		listed = append(listed, entry)
+	}
+	slices.SortFunc(listed, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+	return listed
+}
+
+// zstDirEntry is a compressed file listed under its name in a zstFS
+type zstDirEntry struct {
+	fs.DirEntry
+	fsys *zstFS
+	name string
+	dir  string
+}
+
+// Name implements the fs.DirEntry interface
+func (e *zstDirEntry) Name() string {
+	return e.name
+}
+
+// Info implements the fs.DirEntry interface, with the decompressed size as by Stat
+func (e *zstDirEntry) Info() (fs.FileInfo, error) {
+	f, err := e.fsys.Open(path.Join(e.dir, e.name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Stat()
+}
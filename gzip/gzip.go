@@ -0,0 +1,167 @@
+// Package gzip mirrors the API of compress/gzip on top of Zstandard, so code written
+// against compress/gzip can switch formats by changing its import path. The data
+// written and read is a Zstandard stream, not gzip.
+//
+// The gzip Header fields (name, comment, modification time) have no equivalent and
+// are not supported.
+package gzip
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// Compression levels, as accepted by NewWriterLevel. Besides these, any Zstandard
+// level up to zstd.UltraCompression may be given.
+const (
+	NoCompression      = 0 // Mapped to the fastest regular Zstandard level
+	BestSpeed          = zstd.BestSpeed
+	BestCompression    = zstd.BestCompression
+	DefaultCompression = -1 // Mapped to zstd.DefaultCompression
+	HuffmanOnly        = -2 // Mapped to the fastest regular Zstandard level
+)
+
+// Errors matching those of compress/gzip, for use with errors.Is
+var (
+	ErrChecksum = zstd.ErrChecksumWrong
+	ErrHeader   = zstd.ErrUnknownFrame
+)
+
+// The library is loaded once and shared by all Readers and Writers of the package
+var (
+	sharedOnce sync.Once
+	shared     *zstd.Zstd
+	sharedErr  error
+)
+
+// instance returns the shared library instance, loading it on first use
+func instance() (*zstd.Zstd, error) {
+	sharedOnce.Do(func() {
+		shared, sharedErr = zstd.New()
+	})
+	return shared, sharedErr
+}
+
+// Writer is an io.WriteCloser that compresses the data written to it
+type Writer struct {
+	zw  *zstd.Writer
+	err error // Failure to load the library, reported by every operation
+}
+
+// NewWriter returns a new Writer compressing at DefaultCompression.
+// Writes may be buffered; it is the caller's responsibility to call Close when done.
+func NewWriter(w io.Writer) *Writer {
+	zw, _ := NewWriterLevel(w, DefaultCompression)
+	return zw
+}
+
+// NewWriterLevel is like NewWriter but specifies the compression level instead of
+// assuming DefaultCompression. The error returned is non-nil only if level is invalid.
+func NewWriterLevel(w io.Writer, level int) (*Writer, error) {
+	if level < HuffmanOnly || level > zstd.UltraCompression {
+		return nil, fmt.Errorf("%w: %d", zstd.ErrInvalidLevel, level)
+	}
+
+	switch level {
+	case DefaultCompression:
+		level = zstd.DefaultCompression
+	case NoCompression, HuffmanOnly:
+		level = zstd.BestSpeed
+	}
+
+	z, err := instance()
+	if err != nil {
+		return &Writer{err: err}, nil
+	}
+	return &Writer{zw: z.NewWriter(w, level)}, nil
+}
+
+// Write writes a compressed form of p to the underlying io.Writer
+func (w *Writer) Write(p []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	return w.zw.Write(p)
+}
+
+// Flush writes any pending compressed data to the underlying writer, so a reader can
+// decode everything written so far
+func (w *Writer) Flush() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.zw.Flush()
+}
+
+// Reset discards the Writer's state and makes it equivalent to the result of its
+// original state from NewWriter or NewWriterLevel, but writing to dst instead.
+// This permits reusing a Writer rather than allocating a new one.
+func (w *Writer) Reset(dst io.Writer) {
+	if w.err == nil {
+		w.zw.Reset(dst)
+	}
+}
+
+// Close flushes unwritten data and ends the stream. It does not close the underlying writer.
+func (w *Writer) Close() error {
+	if w.err != nil {
+		return w.err
+	}
+	return w.zw.Close()
+}
+
+// Reader is an io.Reader that decompresses data read from an underlying reader.
+// Like gzip.Reader, it decodes concatenated streams as one unless Multistream(false)
+// is called.
+type Reader struct {
+	zr *zstd.Reader
+}
+
+// NewReader creates a new Reader reading the given reader. The header of the first
+// frame is read before returning, so an invalid stream is reported immediately.
+// It is the caller's responsibility to call Close on the Reader when done.
+func NewReader(r io.Reader) (*Reader, error) {
+	z, err := instance()
+	if err != nil {
+		return nil, err
+	}
+
+	zr := z.NewReader(r)
+	if _, err := zr.Header(); err != nil {
+		zr.Close()
+		return nil, err
+	}
+	return &Reader{zr: zr}, nil
+}
+
+// Read implements io.Reader, reading uncompressed bytes from its underlying reader
+func (z *Reader) Read(p []byte) (int, error) {
+	return z.zr.Read(p)
+}
+
+// Multistream controls whether the reader supports multistream files. When disabled,
+// Read returns io.EOF at the end of the first frame. Input read beyond that frame is
+// not given back to the underlying reader.
+func (z *Reader) Multistream(ok bool) {
+	z.zr.Multistream(ok)
+}
+
+// Reset discards the Reader's state and makes it equivalent to the result of its
+// original state from NewReader, but reading from r instead. Multistream is enabled again.
+func (z *Reader) Reset(r io.Reader) error {
+	if err := z.zr.Reset(r, nil); err != nil {
+		return err
+	}
+	z.zr.Multistream(true)
+
+	_, err := z.zr.Header()
+	return err
+}
+
+// Close closes the Reader. It does not close the underlying io.Reader.
+func (z *Reader) Close() error {
+	return z.zr.Close()
+}
@@ -0,0 +1,77 @@
+package gzip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+func TestRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("drop-in replacement for compress/gzip ", 500))
+
+	var buf bytes.Buffer
+	w, err := NewWriterLevel(&buf, BestCompression)
+	if err != nil {
+		t.Fatalf("NewWriterLevel failed: %v", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	first := append([]byte(nil), buf.Bytes()...)
+
+	// A reset writer produces a second, independent stream
+	w.Reset(&buf)
+	w.Write([]byte("second stream"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close after Reset failed: %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader failed: %v", err)
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if want := append(append([]byte(nil), data...), "second stream"...); !bytes.Equal(got, want) {
+		t.Error("Concatenated streams were not decoded as one")
+	}
+
+	// Without multistream, reading stops after the first stream
+	if err := r.Reset(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	r.Multistream(false)
+	got, err = io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("Expected only the first stream, got %d bytes", len(got))
+	}
+
+	if len(first) >= len(data) {
+		t.Error("Data was not compressed")
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	if _, err := NewWriterLevel(io.Discard, 100); !errors.Is(err, zstd.ErrInvalidLevel) {
+		t.Errorf("Expected ErrInvalidLevel, got %v", err)
+	}
+	if _, err := NewReader(strings.NewReader("not compressed at all")); !errors.Is(err, ErrHeader) {
+		t.Errorf("Expected ErrHeader, got %v", err)
+	}
+	if _, err := NewReader(strings.NewReader("")); err != io.EOF {
+		t.Errorf("Expected io.EOF for empty input, got %v", err)
+	}
+}
@@ -0,0 +1,73 @@
+package zstd
+
+import (
+	"hash"
+	"io"
+)
+
+// HashWriter compresses to an underlying writer like a Writer, while hashing the
+// uncompressed content, so integrity manifests need no second pass over the data.
+// Any hash.Hash works, such as sha256.New() or NewXXH64().
+type HashWriter struct {
+	writer *Writer
+	hash   hash.Hash
+}
+
+// NewHashWriter returns a HashWriter compressing to w at the level, configured by
+// opts, and hashing the content with h.
+// The caller must call Close() when done to ensure all data is flushed.
+func (z *Zstd) NewHashWriter(w io.Writer, h hash.Hash, level int, opts ...Option) *HashWriter {
+	return &HashWriter{writer: z.NewWriter(w, level, opts...), hash: h}
+}
+
+// Write implements the io.Writer interface, hashing the content accepted
+func (hw *HashWriter) Write(p []byte) (int, error) {
+	n, err := hw.writer.Write(p)
+	hw.hash.Write(p[:n])
+	return n, err
+}
+
+// Flush flushes pending data to the underlying writer
+func (hw *HashWriter) Flush() error {
+	return hw.writer.Flush()
+}
+
+// Close ends the stream like Writer.Close. The hash remains available.
+func (hw *HashWriter) Close() error {
+	return hw.writer.Close()
+}
+
+// Sum appends the hash of the content written so far to b
+func (hw *HashWriter) Sum(b []byte) []byte {
+	return hw.hash.Sum(b)
+}
+
+// HashReader decompresses from an underlying reader like a Reader, while hashing the
+// decompressed content, so it can be checked against a manifest in the same pass.
+type HashReader struct {
+	reader *Reader
+	hash   hash.Hash
+}
+
+// NewHashReader returns a HashReader decompressing from r, configured by opts, and
+// hashing the content with h
+func (z *Zstd) NewHashReader(r io.Reader, h hash.Hash, opts ...Option) *HashReader {
+	return &HashReader{reader: z.NewReader(r, opts...), hash: h}
+}
+
+// Read implements the io.Reader interface, hashing the content returned
+func (hr *HashReader) Read(p []byte) (int, error) {
+	n, err := hr.reader.Read(p)
+	hr.hash.Write(p[:n])
+	return n, err
+}
+
+// Close releases the Reader like Reader.Close. The hash remains available.
+func (hr *HashReader) Close() error {
+	return hr.reader.Close()
+}
+
+// Sum appends the hash of the content read so far to b
+func (hr *HashReader) Sum(b []byte) []byte {
+	return hr.hash.Sum(b)
+}
@@ -0,0 +1,118 @@
+package zstd
+
+import (
+	"fmt"
+	"io"
+	"unsafe"
+)
+
+// FrameHeader describes a frame as recorded in its header
+type FrameHeader struct {
+	ContentSize    uint64 // Decompressed size of the frame, if HasContentSize is set
+	HasContentSize bool   // The header records the decompressed size
+	WindowSize     uint64 // Memory the decoder needs to reference back
+	DictionaryID   uint32 // ID of the dictionary the frame was compressed with (0 = none or unrecorded)
+	HasChecksum    bool   // The frame ends with a checksum of its content
+}
+
+// zstdFrameHeader matches the layout of ZSTD_frameHeader
+type zstdFrameHeader struct {
+	frameContentSize uint64
+	windowSize       uint64
+	blockSizeMax     uint32
+	frameType        uint32 // 0 = ZSTD_frame, 1 = ZSTD_skippableFrame
+	headerSize       uint32
+	dictID           uint32
+	checksumFlag     uint32
+	_                [2]uint32
+}
+
+// Header returns the header of the first frame in the stream, so consumers can
+// preallocate or enforce policy before reading. If nothing has been read yet, the
+// header is read from the source without decompressing anything; leading skippable
+// frames are passed over. It returns io.EOF if the stream holds no frame and
+// io.ErrUnexpectedEOF if it ends within the header.
+func (r *Reader) Header() (FrameHeader, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed || r.zstd.isClosed() {
+		return FrameHeader{}, ErrAlreadyClosed
+	}
+
+	for r.header == nil {
+		// Decoding already went past the first frame header without recognizing it
+		if r.inFrame || r.streamEnded {
+			if r.err != nil {
+				return FrameHeader{}, r.err
+			}
+			return FrameHeader{}, fmt.Errorf("zstd: frame header is not available")
+		}
+
+		handled, err := r.handleSkippableFrame()
+		if err != nil {
+			return FrameHeader{}, err
+		}
+		if handled {
+			continue
+		}
+
+		n, err := r.parseHeader()
+		if err != nil {
+			return FrameHeader{}, err
+		}
+		if r.header == nil {
+			if n == 0 {
+				return FrameHeader{}, io.EOF
+			}
+			return FrameHeader{}, io.ErrUnexpectedEOF
+		}
+
+		// fill only looks the dictionary up when it parses the header itself
+		if err := r.registryDictionary(); err != nil {
+			return FrameHeader{}, err
+		}
+	}
+
+	return *r.header, nil
+}
+
+// parseHeader records the header of the regular frame at the start of the pending input,
+// reading from the source as needed. It returns the number of bytes examined; the header
+// is left unset if the input ends within it.
+func (r *Reader) parseHeader() (int, error) {
+	input, err := r.peekInput(frameHeaderSizeMax)
+	if err != nil || len(input) == 0 {
+		return 0, err
+	}
+
+	var zfh zstdFrameHeader
+	result := r.zstd.getFrameHeader(&zfh, unsafe.Pointer(&input[0]), uint64(len(input)))
+	if r.zstd.isError(result) != 0 {
+		return len(input), r.zstd.nativeError("decompress", result)
+	}
+
+	// A positive result is the size the header needs: the input is truncated
+	if result > 0 || zfh.frameType != 0 {
+		return len(input), nil
+	}
+
+	header := zfh.frameHeader()
+	r.header = &header
+	return len(input), nil
+}
+
+// frameHeader converts the header reported by the library
+func (zfh *zstdFrameHeader) frameHeader() FrameHeader {
+	header := FrameHeader{
+		ContentSize:    zfh.frameContentSize,
+		HasContentSize: zfh.frameContentSize != contentSizeUnknown,
+		WindowSize:     zfh.windowSize,
+		DictionaryID:   zfh.dictID,
+		HasChecksum:    zfh.checksumFlag != 0,
+	}
+	if !header.HasContentSize {
+		header.ContentSize = 0
+	}
+	return header
+}
@@ -0,0 +1,122 @@
+package zstd
+
+import (
+	"context"
+	"time"
+)
+
+// Operation describes a compression or decompression starting, as passed to an
+// Instrumentation. One-shot calls such as Compress are an operation each, and so is the
+// stream of a Reader or Writer, from its first Read or Write to Close or Reset.
+type Operation struct {
+	Context   context.Context // Given by WithContext or CompressContext, or context.Background()
+	Streaming bool            // The operation is the stream of a Reader or Writer
+	Level     int             // Compression level (0 for decompression)
+	SrcSize   int64           // Input size, or -1 if unknown
+}
+
+// OperationResult describes how an operation ended
+type OperationResult struct {
+	Consumed int64         // Input bytes processed
+	Produced int64         // Output bytes produced
+	Duration time.Duration // Time from the start of the operation to its end
+	Err      error         // Why the operation failed, if it did
+}
+
+// Instrumentation is notified around every compression and decompression an instance
+// makes, to wire tracing spans or custom metrics without forking the package. The
+// context returned by a Start method is passed to the matching End method, so it can
+// carry a span; returning the operation's context is fine. The methods are called from
+// the goroutine running the operation and must be safe for concurrent use.
+type Instrumentation interface {
+	OnCompressStart(op Operation) context.Context
+	OnCompressEnd(ctx context.Context, result OperationResult)
+	OnDecompressStart(op Operation) context.Context
+	OnDecompressEnd(ctx context.Context, result OperationResult)
+}
+
+// operation is an operation in progress reported to the instrumentation, nil if there is none
+type operation struct {
+	instrumentation Instrumentation
+	compress        bool
+	ctx             context.Context
+	start           time.Time
+}
+
+// startOperation reports the start of an operation to the instrumentation, if set
+func (z *Zstd) startOperation(compress bool, op Operation) *operation {
+	if z.instrumentation == nil {
+		return nil
+	}
+	if op.Context == nil {
+		op.Context = context.Background()
+	}
+
+	var ctx context.Context
+	if compress {
+		ctx = z.instrumentation.OnCompressStart(op)
+	} else {
+		ctx = z.instrumentation.OnDecompressStart(op)
+	}
+	if ctx == nil {
+		ctx = op.Context
+	}
+	return &operation{
+		instrumentation: z.instrumentation,
+		compress:        compress,
+		ctx:             ctx,
+		start:           time.Now(),
+	}
+}
+
+// end reports the end of the operation; it does nothing on a nil operation
+func (o *operation) end(consumed, produced int64, err error) {
+	if o == nil {
+		return
+	}
+
+	result := OperationResult{
+		Consumed: consumed,
+		Produced: produced,
+		Duration: time.Since(o.start),
+		Err:      err,
+	}
+	if o.compress {
+		o.instrumentation.OnCompressEnd(o.ctx, result)
+	} else {
+		o.instrumentation.OnDecompressEnd(o.ctx, result)
+	}
+}
+
+// startOperation starts reporting the reader's stream, unless it is already
+func (r *Reader) startOperation() {
+	if r.op == nil {
+		r.op = r.zstd.startOperation(false, Operation{Context: r.cancelCtx, Streaming: true, SrcSize: r.progressTotal})
+	}
+}
+
+// endOperation reports the end of the reader's stream, if started
+func (r *Reader) endOperation() {
+	if r.op == nil {
+		return
+	}
+	r.op.end(r.BytesIn(), r.delivered, r.opErr)
+	r.op, r.opErr = nil, nil
+}
+
+// startOperation starts reporting the writer's stream, unless it is already
+func (w *Writer) startOperation() {
+	if w.op == nil {
+		srcSize := w.contentSize
+		if srcSize == 0 {
+			srcSize = -1
+		}
+		w.op = w.zstd.startOperation(true, Operation{Context: w.cancelCtx, Streaming: true, Level: w.level, SrcSize: srcSize})
+	}
+}
+
+// endOperation reports the end of the writer's stream, if started
+func (w *Writer) endOperation(err error) {
+	w.op.end(w.bytesIn, w.bytesOut, err)
+	w.op = nil
+}
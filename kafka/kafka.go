@@ -0,0 +1,132 @@
+// Package kafka compresses Kafka record batches with Zstandard the way Kafka clients and
+// brokers do, so Go clients can use this module as their zstd codec. The records of a
+// batch are compressed as plain Zstandard frames, without a dictionary or framing of
+// their own: producers write a single frame, while consumers must accept any number of
+// frames, with or without a recorded content size, as written by other clients.
+package kafka
+
+import (
+	"bytes"
+	"io"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// CompressionCodec is the value of the compression bits, the lowest three, of the
+// attributes of a record batch compressed with zstd
+const CompressionCodec = 4
+
+// DefaultLevel is the default compression.zstd.level of Kafka
+const DefaultLevel = 3
+
+// Options configures a Codec
+type Options struct {
+	Level        int   // Compression level, negative levels included (0 = DefaultLevel)
+	MaxBatchSize int64 // Largest decompressed batch accepted (0 = no limit)
+}
+
+// Codec compresses and decompresses record batches, reusing native contexts and
+// streams across batches. It is safe for concurrent use.
+type Codec struct {
+	zstd    *zstd.Zstd
+	level   int
+	readers *zstd.ReaderPool
+	writers *zstd.WriterPool
+}
+
+// NewCodec returns a Codec using the library instance z
+func NewCodec(z *zstd.Zstd, opts Options) *Codec {
+	level := opts.Level
+	if level == 0 {
+		level = DefaultLevel
+	}
+	return &Codec{
+		zstd:    z,
+		level:   level,
+		readers: z.NewReaderPool(zstd.WithMaxDecompressSize(opts.MaxBatchSize)),
+		writers: z.NewWriterPool(level, zstd.WithChecksum(false)),
+	}
+}
+
+// Encode appends the compressed records to dst, as a single frame recording their size
+func (c *Codec) Encode(dst, records []byte) ([]byte, error) {
+	compressed, err := c.zstd.Compress(records, c.level)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, compressed...), nil
+}
+
+// Decode appends the records decompressed from the frames of src to dst
+func (c *Codec) Decode(dst, src []byte) ([]byte, error) {
+	r := c.readers.Get(bytes.NewReader(src))
+	defer c.readers.Put(r)
+	defer r.Close()
+
+	buf := bytes.NewBuffer(dst)
+	_, err := buf.ReadFrom(r)
+	return buf.Bytes(), err
+}
+
+// NewWriter returns a Writer compressing records to w as a single frame, for batches
+// built incrementally. Close completes the frame.
+func (c *Codec) NewWriter(w io.Writer) io.WriteCloser {
+	return &pooledWriter{writer: c.writers.Get(w), pool: c.writers}
+}
+
+// NewReader returns a Reader decompressing the records read from r
+func (c *Codec) NewReader(r io.Reader) io.ReadCloser {
+	return &pooledReader{reader: c.readers.Get(r), pool: c.readers}
+}
+
+// pooledWriter returns its Writer to the pool once closed
+type pooledWriter struct {
+	writer *zstd.Writer // nil once closed
+	pool   *zstd.WriterPool
+}
+
+// Write implements the io.Writer interface
+func (w *pooledWriter) Write(p []byte) (int, error) {
+	if w.writer == nil {
+		return 0, zstd.ErrAlreadyClosed
+	}
+	return w.writer.Write(p)
+}
+
+// Close implements the io.Closer interface
+func (w *pooledWriter) Close() error {
+	if w.writer == nil {
+		return nil
+	}
+	err := w.writer.Close()
+	if err == nil {
+		w.pool.Put(w.writer)
+	}
+	w.writer = nil
+	return err
+}
+
+// pooledReader returns its Reader to the pool once closed
+type pooledReader struct {
+	reader *zstd.Reader // nil once closed
+	pool   *zstd.ReaderPool
+}
+
+// Read implements the io.Reader interface
+func (r *pooledReader) Read(p []byte) (int, error) {
+	if r.reader == nil {
+		return 0, zstd.ErrAlreadyClosed
+	}
+	return r.reader.Read(p)
+}
+
+// Close implements the io.Closer interface
+func (r *pooledReader) Close() error {
+	if r.reader == nil {
+		return nil
+	}
+	err := r.reader.Close()
+	r.pool.Put(r.reader)
+	r.reader = nil
+	return err
+}
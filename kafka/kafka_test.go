@@ -0,0 +1,54 @@
+package kafka
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+func TestCodec(t *testing.T) {
+	z, err := zstd.New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	records := bytes.Repeat([]byte("key=user-42 value={\"clicks\":7}\n"), 1000)
+	codec := NewCodec(z, Options{MaxBatchSize: 1 << 20})
+
+	prefix := []byte("batch header")
+	compressed, err := codec.Encode(bytes.Clone(prefix), records)
+	if err != nil || !bytes.HasPrefix(compressed, prefix) {
+		t.Fatalf("Encode failed: %v", err)
+	}
+	decoded, err := codec.Decode(nil, compressed[len(prefix):])
+	if err != nil || !bytes.Equal(decoded, records) {
+		t.Fatalf("Decode failed: %v", err)
+	}
+
+	// Other clients may write several frames without a recorded size
+	var buf bytes.Buffer
+	w := codec.NewWriter(&buf)
+	w.Write(records[:500])
+	w.Write(records[500:])
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	buf.Write(compressed[len(prefix):])
+	r := codec.NewReader(bytes.NewReader(buf.Bytes()))
+	decoded, err = io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(decoded, append(bytes.Clone(records), records...)) {
+		t.Fatalf("Reading two frames failed: %v", err)
+	}
+
+	// Batches above the limit are refused
+	small := NewCodec(z, Options{MaxBatchSize: 1000})
+	var sizeErr *zstd.MaxSizeError
+	if _, err := small.Decode(nil, buf.Bytes()); !errors.As(err, &sizeErr) {
+		t.Errorf("Expected a MaxSizeError, got %v", err)
+	}
+}
@@ -0,0 +1,74 @@
+package zstd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Level is a compression level that can be parsed from and formatted as text, for use
+// in command-line flags and configuration files. It implements flag.Value and
+// encoding.TextMarshaler and TextUnmarshaler; convert it with int(level) where a
+// level is expected.
+type Level int
+
+// Named levels, as accepted by ParseLevel
+var levelNames = []struct {
+	name  string
+	level Level
+}{
+	{"fast", BestSpeed},
+	{"default", DefaultCompression},
+	{"best", BestCompression},
+	{"ultra", UltraCompression},
+}
+
+// ParseLevel parses a level name ("fast", "default", "best" or "ultra") or a number
+// within the range of the bundled library, such as "-3" or "22"
+func ParseLevel(s string) (Level, error) {
+	s = strings.TrimSpace(s)
+	for _, n := range levelNames {
+		if strings.EqualFold(s, n.name) {
+			return n.level, nil
+		}
+	}
+
+	level, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q is neither a number nor one of fast, default, best and ultra", ErrInvalidLevel, s)
+	}
+	if level < minLevel || level > maxLevel {
+		return 0, fmt.Errorf("%w: %d is outside %d to %d", ErrInvalidLevel, level, minLevel, maxLevel)
+	}
+	return Level(level), nil
+}
+
+// String returns the name of the level if it has one, or its number
+func (l Level) String() string {
+	for _, n := range levelNames {
+		if l == n.level {
+			return n.name
+		}
+	}
+	return strconv.Itoa(int(l))
+}
+
+// Set parses s into the level, implementing flag.Value
+func (l *Level) Set(s string) error {
+	level, err := ParseLevel(s)
+	if err != nil {
+		return err
+	}
+	*l = level
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler
+func (l *Level) UnmarshalText(text []byte) error {
+	return l.Set(string(text))
+}
@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"unsafe"
 
 	"github.com/ebitengine/purego"
@@ -19,9 +21,27 @@ import (
 var embeddedLibs embed.FS
 
 // Zstd represents an instance of the Zstandard library.
+// It is safe for concurrent use by multiple goroutines, so a service can share one
+// long-lived instance; the Readers and Writers it creates are not. An instance must not
+// be closed while it is in use.
 type Zstd struct {
 	handle      uintptr
 	tempLibPath string
+	defaults    []Option // Applied before the options of each Reader and Writer
+
+	cctxPool contextPool // Compression contexts reused by one-shot operations
+	dctxPool contextPool // Decompression contexts reused by one-shot operations
+	dictOnce sync.Once   // Registers the dictionary functions on first use
+
+	staticOnce sync.Once // Registers the static allocation functions on first use
+
+	progress        func(processed, total int64) // Reports on one-shot operations, if set
+	tracer          Tracer                       // Receives the native calls, if set
+	registry        *DictionaryRegistry          // Picks the dictionary for Decompress, if set
+	instrumentation Instrumentation              // Notified around every operation, if set
+	customMem       customMem                    // Allocation callbacks of the contexts, if an Allocator is set
+
+	defaultLevel atomic.Int64 // Level set by SetDefaultLevel (0 = unset)
 
 	// Basic functions
 	versionNumber func() uint32
@@ -29,6 +49,9 @@ type Zstd struct {
 	compressBound func(srcSize uint64) uint64
 	isError       func(code uint64) int
 	getErrorName  func(code uint64) string
+	getErrorCode  func(code uint64) int
+	minCLevel     func() int32
+	maxCLevel     func() int32
 
 	// Simple API functions
 	compress   func(dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, compressionLevel int) uint64
@@ -50,14 +73,52 @@ type Zstd struct {
 	freeDStream      func(zds unsafe.Pointer) uint64
 	decompressStream func(zds unsafe.Pointer, output *ZstdOutBuffer, input *ZstdInBuffer) uint64
 
+	// Advanced API functions
+	cctxSetParameter        func(cctx unsafe.Pointer, param int, value int) uint64
+	cctxSetPledgedSize      func(cctx unsafe.Pointer, pledgedSrcSize uint64) uint64
+	cctxReset               func(cctx unsafe.Pointer, directive int) uint64
+	dctxReset               func(dctx unsafe.Pointer, directive int) uint64
+	dctxSetParameter        func(dctx unsafe.Pointer, param int, value int) uint64
+	compress2               func(cctx unsafe.Pointer, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64) uint64
+	cctxRefPrefix           func(cctx unsafe.Pointer, prefix unsafe.Pointer, prefixSize uint64) uint64
+	dctxRefPrefix           func(dctx unsafe.Pointer, prefix unsafe.Pointer, prefixSize uint64) uint64
+	getFrameContentSize     func(src unsafe.Pointer, srcSize uint64) uint64
+	getFrameHeader          func(zfh *zstdFrameHeader, src unsafe.Pointer, srcSize uint64) uint64
+	getDictIDFromFrame      func(src unsafe.Pointer, srcSize uint64) uint32
+	findFrameCompressedSize func(src unsafe.Pointer, srcSize uint64) uint64
+	getFrameProgression     func(cctx unsafe.Pointer) FrameProgression
+
 	// dictionary functions
 	createCDict          func(dictBuffer unsafe.Pointer, dictSize uint64, compressionLevel int) unsafe.Pointer
+	createCDictByRef     func(dictBuffer unsafe.Pointer, dictSize uint64, compressionLevel int) unsafe.Pointer
 	freeCDict            func(cdict unsafe.Pointer) uint64
 	createDDict          func(dictBuffer unsafe.Pointer, dictSize uint64) unsafe.Pointer
+	createDDictByRef     func(dictBuffer unsafe.Pointer, dictSize uint64) unsafe.Pointer
 	freeDDict            func(ddict unsafe.Pointer) uint64
 	compressUsingCDict   func(ctx unsafe.Pointer, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, cdict unsafe.Pointer) uint64
 	decompressUsingDDict func(ctx unsafe.Pointer, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, ddict unsafe.Pointer) uint64
 	getDictID            func(dict unsafe.Pointer, dictSize uint64) uint32
+	cctxRefCDict         func(cctx unsafe.Pointer, cdict unsafe.Pointer) uint64
+	dctxRefDDict         func(dctx unsafe.Pointer, ddict unsafe.Pointer) uint64
+	cctxLoadDictionary   func(cctx unsafe.Pointer, dict unsafe.Pointer, dictSize uint64, loadMethod DictLoadMethod, contentType DictContentType) uint64
+	dctxLoadDictionary   func(dctx unsafe.Pointer, dict unsafe.Pointer, dictSize uint64, loadMethod DictLoadMethod, contentType DictContentType) uint64
+
+	// Constructors with custom allocation callbacks
+	createCCtxAdvanced    func(mem customMem) unsafe.Pointer
+	createDCtxAdvanced    func(mem customMem) unsafe.Pointer
+	createCStreamAdvanced func(mem customMem) unsafe.Pointer
+	createDStreamAdvanced func(mem customMem) unsafe.Pointer
+
+	// Static allocation functions
+	estimateCCtxSize func(maxCompressionLevel int) uint64
+	estimateDCtxSize func() uint64
+	initStaticCCtx   func(workspace unsafe.Pointer, workspaceSize uint64) unsafe.Pointer
+	initStaticDCtx   func(workspace unsafe.Pointer, workspaceSize uint64) unsafe.Pointer
+
+	// Dictionary training functions
+	optimizeTrainCover     func(dict unsafe.Pointer, dictCapacity uint64, samples unsafe.Pointer, sampleSizes unsafe.Pointer, nbSamples uint32, params *zdictCoverParams) uint64
+	optimizeTrainFastCover func(dict unsafe.Pointer, dictCapacity uint64, samples unsafe.Pointer, sampleSizes unsafe.Pointer, nbSamples uint32, params *zdictFastCoverParams) uint64
+	finalizeDictionary     func(dst unsafe.Pointer, maxSize uint64, content unsafe.Pointer, contentSize uint64, samples unsafe.Pointer, sampleSizes unsafe.Pointer, nbSamples uint32, params zdictParams) uint64
 }
 
 // ZstdOutBuffer represents a buffer for zstd output operations
@@ -86,12 +147,19 @@ func loadLibrary() (*Zstd, error) {
 		tempLibPath: tempDir,
 	}
 
+	// Unload the library and remove the extracted file if the instance is dropped without Close.
+	// Readers and Writers reference the instance, so they are always finalized first.
+	runtime.SetFinalizer(z, (*Zstd).Close)
+
 	// Register basic functions
 	purego.RegisterLibFunc(&z.versionNumber, handle, "ZSTD_versionNumber")
 	purego.RegisterLibFunc(&z.versionString, handle, "ZSTD_versionString")
 	purego.RegisterLibFunc(&z.compressBound, handle, "ZSTD_compressBound")
 	purego.RegisterLibFunc(&z.isError, handle, "ZSTD_isError")
 	purego.RegisterLibFunc(&z.getErrorName, handle, "ZSTD_getErrorName")
+	purego.RegisterLibFunc(&z.getErrorCode, handle, "ZSTD_getErrorCode")
+	purego.RegisterLibFunc(&z.minCLevel, handle, "ZSTD_minCLevel")
+	purego.RegisterLibFunc(&z.maxCLevel, handle, "ZSTD_maxCLevel")
 
 	// Register Simple API functions
 	purego.RegisterLibFunc(&z.compress, handle, "ZSTD_compress")
@@ -113,6 +181,22 @@ func loadLibrary() (*Zstd, error) {
 	purego.RegisterLibFunc(&z.freeDStream, handle, "ZSTD_freeDStream")
 	purego.RegisterLibFunc(&z.decompressStream, handle, "ZSTD_decompressStream")
 
+	// Register Advanced API functions
+	purego.RegisterLibFunc(&z.cctxSetParameter, handle, "ZSTD_CCtx_setParameter")
+	purego.RegisterLibFunc(&z.cctxSetPledgedSize, handle, "ZSTD_CCtx_setPledgedSrcSize")
+	purego.RegisterLibFunc(&z.cctxReset, handle, "ZSTD_CCtx_reset")
+	purego.RegisterLibFunc(&z.dctxReset, handle, "ZSTD_DCtx_reset")
+	purego.RegisterLibFunc(&z.dctxSetParameter, handle, "ZSTD_DCtx_setParameter")
+	purego.RegisterLibFunc(&z.compress2, handle, "ZSTD_compress2")
+	purego.RegisterLibFunc(&z.cctxRefPrefix, handle, "ZSTD_CCtx_refPrefix")
+	purego.RegisterLibFunc(&z.dctxRefPrefix, handle, "ZSTD_DCtx_refPrefix")
+	purego.RegisterLibFunc(&z.getFrameContentSize, handle, "ZSTD_getFrameContentSize")
+	purego.RegisterLibFunc(&z.getFrameHeader, handle, "ZSTD_getFrameHeader")
+	purego.RegisterLibFunc(&z.getDictIDFromFrame, handle, "ZSTD_getDictID_fromFrame")
+	purego.RegisterLibFunc(&z.findFrameCompressedSize, handle, "ZSTD_findFrameCompressedSize")
+	registerFrameProgression(z, handle)
+	z.countCalls()
+
 	return z, nil
 }
 
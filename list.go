@@ -0,0 +1,110 @@
+package zstd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+)
+
+// FrameRecord describes a frame found by ListFrames
+type FrameRecord struct {
+	FrameHeader          // Header of a regular frame
+	Offset         int64 // Position of the frame in the stream
+	CompressedSize int64 // Size of the frame, including its header and checksum
+	Skippable      bool  // A skippable frame, whose payload is CompressedSize-8 bytes
+}
+
+// FrameList describes the frames of a stream, like zstd -l
+type FrameList struct {
+	Frames          []FrameRecord
+	SkippableFrames int    // Number of skippable frames among Frames
+	CompressedSize  int64  // Size of the stream
+	ContentSize     uint64 // Decompressed size of the stream, if HasContentSize is set
+	HasContentSize  bool   // Every regular frame records its decompressed size
+}
+
+// Ratio returns the compression ratio of the stream, or 0 if its decompressed size is unknown
+func (l *FrameList) Ratio() float64 {
+	if !l.HasContentSize || l.CompressedSize == 0 {
+		return 0
+	}
+	return float64(l.ContentSize) / float64(l.CompressedSize)
+}
+
+// ListFrames describes the frames of the stream read from r, like zstd -l, reading
+// only frame and block headers, so archives can be inspected without decompressing
+// them. Sources implementing io.ReaderAt, with a Size method or being an *os.File,
+// are read at the headers only; others are read through.
+// If the stream is truncated or corrupted, the frames found before are returned along
+// with the error.
+func (z *Zstd) ListFrames(r io.Reader) (*FrameList, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	var src io.ReaderAt = &streamReaderAt{reader: bufio.NewReader(r)}
+	size := int64(-1)
+	if ra, ok := r.(io.ReaderAt); ok {
+		switch s := r.(type) {
+		case interface{ Size() int64 }:
+			src, size = ra, s.Size()
+		case *os.File:
+			if info, err := s.Stat(); err == nil && info.Mode().IsRegular() {
+				src, size = ra, info.Size()
+			}
+		}
+	}
+	if size < 0 {
+		size = 1<<63 - 1 // Up to the end of the stream
+	}
+
+	list := &FrameList{HasContentSize: true}
+	err := z.walkFrames(src, size, func(info frameInfo) error {
+		record := FrameRecord{
+			Offset:         info.offset,
+			CompressedSize: info.size,
+			Skippable:      info.skippable,
+		}
+		if info.skippable {
+			list.SkippableFrames++
+		} else {
+			record.FrameHeader = info.header.frameHeader()
+			list.ContentSize += record.ContentSize
+			list.HasContentSize = list.HasContentSize && record.HasContentSize
+		}
+		list.Frames = append(list.Frames, record)
+		list.CompressedSize += info.size
+		return nil
+	})
+	if !list.HasContentSize {
+		list.ContentSize = 0
+	}
+	return list, err
+}
+
+// streamReaderAt serves the increasing offsets read by walkFrames from a stream,
+// discarding the data in between
+type streamReaderAt struct {
+	reader *bufio.Reader
+	pos    int64
+}
+
+// ReadAt implements the io.ReaderAt interface for reads up to the buffer size, at
+// offsets that never go back
+func (s *streamReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < s.pos {
+		return 0, fmt.Errorf("zstd: cannot read back to offset %d of a stream", off)
+	}
+
+	n, err := s.reader.Discard(int(off - s.pos))
+	s.pos += int64(n)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	} else if err != nil {
+		return 0, err
+	}
+
+	buf, err := s.reader.Peek(len(p))
+	return copy(p, buf), err
+}
@@ -0,0 +1,225 @@
+package zstd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// defaultMaxMessageSize is the largest message a MessageReader accepts by default
+const defaultMaxMessageSize = 64 << 20
+
+// MessageWriter writes messages to a stream, such as a socket, each compressed and
+// preceded by its decompressed and compressed sizes as uvarints, so a MessageReader
+// reads them back one by one. By default each message is a frame of its own, decoded
+// independently. With WithMessageStream, messages are compressed as a single stream
+// flushed after each one, which compresses small similar messages much better, but
+// requires them to be read in order by a single MessageReader.
+type MessageWriter struct {
+	zstd   *Zstd
+	w      io.Writer
+	dict   *Dictionary
+	level  int
+	stream *Writer      // Compressing all messages, in stream mode
+	buf    bytes.Buffer // Output of stream for the current message
+	err    error        // Sticky error
+}
+
+// NewMessageWriter creates a MessageWriter writing to w, compressing with dict if not
+// nil. Frames are compressed at the level of opts; in stream mode, opts configure the
+// Writer compressing the stream. Close releases the stream.
+func (z *Zstd) NewMessageWriter(w io.Writer, dict *Dictionary, opts ...Option) *MessageWriter {
+	options := z.options(opts...)
+	mw := &MessageWriter{zstd: z, w: w, dict: dict, level: options.CompressionLevel}
+	if z.isClosed() {
+		mw.err = ErrAlreadyClosed
+	} else if options.MessageStream {
+		mw.stream = z.NewWriterDict(&mw.buf, dict, 0, opts...)
+		mw.err = mw.stream.err
+	}
+	return mw
+}
+
+// WriteMessage compresses p and writes it as a message, with a single write to the
+// underlying writer. After an error, the MessageWriter is unusable, as the reading side
+// could not tell where the next message starts.
+func (mw *MessageWriter) WriteMessage(p []byte) error {
+	if mw.err != nil {
+		return mw.err
+	}
+
+	var compressed []byte
+	if mw.stream != nil {
+		mw.buf.Reset()
+		if _, err := mw.stream.Write(p); err != nil {
+			mw.err = err
+			return err
+		}
+		if err := mw.stream.Flush(); err != nil {
+			mw.err = err
+			return err
+		}
+		compressed = mw.buf.Bytes()
+	} else {
+		var err error
+		if compressed, err = mw.zstd.CompressUsingDict(p, mw.dict, mw.level); err != nil {
+			mw.err = err
+			return err
+		}
+	}
+
+	message := make([]byte, 0, 2*binary.MaxVarintLen64+len(compressed))
+	message = binary.AppendUvarint(message, uint64(len(p)))
+	message = binary.AppendUvarint(message, uint64(len(compressed)))
+	message = append(message, compressed...)
+	if _, err := mw.w.Write(message); err != nil {
+		mw.err = err
+		return err
+	}
+	return nil
+}
+
+// Close releases the compression stream. It does not close the underlying writer.
+func (mw *MessageWriter) Close() error {
+	if mw.err == ErrAlreadyClosed {
+		return nil
+	}
+	if mw.stream != nil {
+		mw.stream.Abort()
+	}
+	mw.err = ErrAlreadyClosed
+	return nil
+}
+
+// MessageReader reads the messages written by a MessageWriter
+type MessageReader struct {
+	zstd    *Zstd
+	r       byteReader
+	dict    *Dictionary
+	maxSize int64
+	source  messageSource // Compressed content of the messages, read by stream
+	stream  *Reader       // Decompressing all messages, in stream mode
+	err     error         // Sticky error
+}
+
+// byteReader reads the sizes of messages a byte at a time, then their content
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// NewMessageReader creates a MessageReader reading from r, decompressing with dict if
+// not nil. Messages larger than the MaxDecompressSize of opts, or 64 MiB if unset, are
+// refused. In stream mode, opts configure the Reader decompressing the stream. Close
+// releases the stream.
+func (z *Zstd) NewMessageReader(r io.Reader, dict *Dictionary, opts ...Option) *MessageReader {
+	options := z.options(opts...)
+	br, ok := r.(byteReader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	mr := &MessageReader{zstd: z, r: br, dict: dict, maxSize: options.MaxDecompressSize}
+	if mr.maxSize <= 0 {
+		mr.maxSize = defaultMaxMessageSize
+	}
+	if z.isClosed() {
+		mr.err = ErrAlreadyClosed
+	} else if options.MessageStream {
+		// The limit applies to each message, not to the whole stream
+		mr.stream = z.NewReaderDict(&mr.source, dict, append(opts, WithMaxDecompressSize(0))...)
+	}
+	return mr
+}
+
+// ReadMessage reads and decompresses the next message. It returns io.EOF once the
+// stream ends between messages, and io.ErrUnexpectedEOF if it ends within one. After
+// other errors, the MessageReader is unusable.
+func (mr *MessageReader) ReadMessage() ([]byte, error) {
+	if mr.err != nil {
+		return nil, mr.err
+	}
+	content, err := mr.readMessage()
+	if err != nil && err != io.EOF {
+		mr.err = err
+	}
+	return content, err
+}
+
+func (mr *MessageReader) readMessage() ([]byte, error) {
+	size, err := binary.ReadUvarint(mr.r)
+	if err != nil {
+		return nil, err
+	}
+	compressedSize, err := binary.ReadUvarint(mr.r)
+	if err != nil {
+		return nil, noEOF(err)
+	}
+	if size > uint64(mr.maxSize) || compressedSize > uint64(mr.zstd.CompressBound(int(mr.maxSize))+frameHeaderSizeMax) {
+		return nil, &MaxSizeError{Limit: mr.maxSize}
+	}
+	compressed := make([]byte, compressedSize)
+	if _, err := io.ReadFull(mr.r, compressed); err != nil {
+		return nil, noEOF(err)
+	}
+
+	if mr.stream == nil {
+		content, err := mr.zstd.DecompressUsingDict(compressed, mr.dict, int(size))
+		if err != nil {
+			return nil, err
+		}
+		if len(content) != int(size) {
+			return nil, fmt.Errorf("%w: message has %d bytes, expected %d", ErrCorruptedData, len(content), size)
+		}
+		return content, nil
+	}
+
+	// The stream was flushed after the message, so its content is decoded from the
+	// input received so far
+	mr.source.data = append(mr.source.data, compressed...)
+	content := make([]byte, size)
+	for n := 0; n < len(content); {
+		m, err := mr.stream.Read(content[n:])
+		if err != nil {
+			return nil, noEOF(err)
+		}
+		if m == 0 && len(mr.source.data) == 0 {
+			return nil, fmt.Errorf("%w: message has %d bytes, expected %d", ErrCorruptedData, n, size)
+		}
+		n += m
+	}
+	return content, nil
+}
+
+// Close releases the decompression stream. It does not close the underlying reader.
+func (mr *MessageReader) Close() error {
+	if mr.stream != nil {
+		mr.stream.Close()
+		mr.stream = nil
+	}
+	mr.err = ErrAlreadyClosed
+	return nil
+}
+
+// messageSource hands the compressed content of the current message to the Reader of
+// a message stream. It returns no data without an error once drained, as more
+// messages may follow.
+type messageSource struct {
+	data []byte
+}
+
+// Read implements the io.Reader interface
+func (s *messageSource) Read(p []byte) (int, error) {
+	n := copy(p, s.data)
+	s.data = s.data[n:]
+	return n, nil
+}
+
+// noEOF reports the end of the stream within a message as unexpected
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
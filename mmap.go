@@ -0,0 +1,119 @@
+package zstd
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"unsafe"
+)
+
+// maxMappedFrameSize is the largest frame content DecompressFile decompresses whole from
+// a mapping; files with larger frames are streamed
+const maxMappedFrameSize = 64 << 20
+
+// DecompressFile decompresses the file at path to w, configured by opts as a Reader is,
+// and returns the number of bytes written. With WithMmap, the file is mapped into memory
+// where supported, avoiding read calls and the copy into the read buffer: when its frames
+// record content sizes of up to 64 MiB, as those of ParallelWriter and SeekableWriter do,
+// each one is decompressed straight from the mapping, concurrently as by
+// DecompressParallel, and otherwise the mapping is streamed through a Reader.
+func (z *Zstd) DecompressFile(w io.Writer, path string, opts ...Option) (int64, error) {
+	if z.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+	options := z.options(opts...)
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var src io.Reader = f
+	if options.Mmap {
+		info, err := f.Stat()
+		if err != nil {
+			return 0, err
+		}
+		data, err := mapFile(f, info.Size())
+		switch {
+		case err == nil:
+			defer unmapFile(data)
+			if frames := z.mappedFrames(data, options); frames != nil {
+				return orderedParallel(w, len(frames), func(i int) ([]byte, error) {
+					return z.decompressFrame(frames[i])
+				})
+			}
+			src = bytes.NewReader(data)
+		case !errors.Is(err, errors.ErrUnsupported):
+			return 0, err
+		}
+	}
+
+	r := z.NewReaderOptions(src, options)
+	defer r.Close()
+	return io.Copy(w, r)
+}
+
+// mappedFrames returns the frames of the mapped data if each can be decompressed whole,
+// or nil if the data must be streamed instead: a frame doesn't record its content size or
+// is too large, the data is invalid, or options only a Reader applies are set.
+func (z *Zstd) mappedFrames(data []byte, options Options) [][]byte {
+	if len(data) == 0 || options.Dictionary != nil || options.Dictionaries != z.registry ||
+		options.MaxDecompressSize > 0 || options.WindowSize > 0 || options.SkippableFrameHandler != nil ||
+		options.Progress != nil || options.Context != nil {
+		return nil
+	}
+
+	frames, err := z.SplitFrames(data)
+	if err != nil {
+		// Let the Reader report the error where it occurs
+		return nil
+	}
+	for _, frame := range frames {
+		// Skippable frames have a content size of 0
+		size := z.getFrameContentSize(unsafe.Pointer(&frame[0]), uint64(len(frame)))
+		if size == contentSizeUnknown || size == contentSizeError || size > maxMappedFrameSize {
+			return nil
+		}
+	}
+	return frames
+}
+
+// OpenSeekableFile opens the file at path in the seekable format, as NewSeekableReader
+// reads it. With WithMmap, the file is mapped into memory where supported, and frames
+// are decompressed straight from the mapping instead of being read into buffers first.
+// Close releases the file.
+func (z *Zstd) OpenSeekableFile(path string, opts ...Option) (*SeekableReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	var src io.ReaderAt = f
+	var data []byte
+	if z.options(opts...).Mmap {
+		data, err = mapFile(f, info.Size())
+		if err == nil {
+			src = bytes.NewReader(data)
+		} else if !errors.Is(err, errors.ErrUnsupported) {
+			f.Close()
+			return nil, err
+		}
+	}
+
+	sr, err := z.NewSeekableReader(src, info.Size())
+	if err != nil {
+		unmapFile(data)
+		f.Close()
+		return nil, err
+	}
+	sr.file, sr.data = f, data
+	return sr, nil
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package zstd
+
+import (
+	"errors"
+	"os"
+)
+
+// mapFile reports that mapping files is unsupported, so callers read them instead
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+// unmapFile releases a mapping made by mapFile
+func unmapFile(data []byte) error {
+	return nil
+}
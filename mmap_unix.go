@@ -0,0 +1,31 @@
+//go:build unix
+
+package zstd
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapFile maps the first size bytes of f into memory, read-only
+func mapFile(f *os.File, size int64) ([]byte, error) {
+	if size == 0 {
+		return nil, nil
+	}
+	if int64(int(size)) != size {
+		return nil, &os.PathError{Op: "mmap", Path: f.Name(), Err: syscall.EFBIG}
+	}
+	data, err := syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+	if err != nil {
+		return nil, &os.PathError{Op: "mmap", Path: f.Name(), Err: err}
+	}
+	return data, nil
+}
+
+// unmapFile releases a mapping made by mapFile
+func unmapFile(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return syscall.Munmap(data)
+}
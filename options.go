@@ -1,5 +1,13 @@
 package zstd
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/bits"
+	"time"
+)
+
 // Constants defining Zstandard compression levels
 const (
 	// Fast compression levels (negative values)
@@ -21,18 +29,135 @@ const (
 	EndFlush    = 1 // Flush pending data
 	EndEnd      = 2 // End the frame
 
+	// Compression parameters for ZSTD_CCtx_setParameter
+	cParamCompressionLevel           = 100
+	cParamWindowLog                  = 101
+	cParamEnableLongDistanceMatching = 160
+	cParamChecksumFlag               = 201
+	cParamNbWorkers                  = 400
+	cParamJobSize                    = 401
+	cParamRsyncable                  = 500 // ZSTD_c_rsyncable, experimental
+
+	// Decompression parameters for ZSTD_DCtx_setParameter
+	dParamWindowLogMax = 100
+
+	// ZSTD_ResetDirective values
+	resetSessionOnly          = 1
+	resetSessionAndParameters = 3
+
+	// Compression level range of the bundled library, ZSTD_minCLevel and ZSTD_maxCLevel
+	minLevel = -(1 << 17)
+	maxLevel = 22
+
+	// Largest number of compression workers, ZSTDMT_NBWORKERS_MAX
+	maxWorkers = 256
+
+	// Window size limits, as log2 of the window size
+	windowLogMin          = 10 // ZSTD_WINDOWLOG_MIN
+	windowLogMax          = 31 // ZSTD_WINDOWLOG_MAX_64
+	windowLogLimitDefault = 27 // ZSTD_WINDOWLOG_LIMIT_DEFAULT, largest window decoded without opt-in
+
+	// Regular frames start with ZSTD_MAGICNUMBER
+	frameMagic = 0xFD2FB528
+
+	// Skippable frames use magic numbers 0x184D2A50 to 0x184D2A5F
+	skippableMagicStart = 0x184D2A50
+	skippableHeaderSize = 8 // Magic number and payload size
+	maxSkippableVariant = 15
+
+	// Largest regular frame header, ZSTD_FRAMEHEADERSIZE_MAX
+	frameHeaderSizeMax = 18
+
+	// Special values returned by ZSTD_getFrameContentSize
+	contentSizeUnknown = ^uint64(0)     // ZSTD_CONTENTSIZE_UNKNOWN
+	contentSizeError   = ^uint64(0) - 1 // ZSTD_CONTENTSIZE_ERROR
+
 	// Default buffer sizes
 	defaultReadBufferSize  = 16 * 1024 // 16KB
 	defaultWriteBufferSize = 32 * 1024 // 32KB
+	defaultCoalesceSize    = 8 * 1024  // 8KB
+)
+
+// DictLoadMethod selects how a stream loads its dictionary, ZSTD_dictLoadMethod_e
+type DictLoadMethod int
+
+const (
+	DictLoadByCopy DictLoadMethod = 0 // Copy the dictionary into native memory
+	DictLoadByRef  DictLoadMethod = 1 // Refer to the dictionary, which is pinned for the lifetime of the stream
+)
+
+// DictContentType selects how dictionary content is interpreted, ZSTD_dictContentType_e
+type DictContentType int
+
+const (
+	DictContentAuto DictContentType = 0 // A full dictionary if it starts with the dictionary magic number, raw content otherwise
+	DictContentRaw  DictContentType = 1 // Raw content, even if it looks like a full dictionary
+	DictContentFull DictContentType = 2 // A full dictionary, failing otherwise
 )
 
 // Options contains configuration options for the Zstd compressor/decompressor
 type Options struct {
-	CompressionLevel  int   // Compression level (1-22, default 3)
-	WindowSize        int   // Window size limit (0 = default)
-	ReadBufferSize    int   // Read buffer size for streaming operations
-	WriteBufferSize   int   // Write buffer size for streaming operations
-	MaxDecompressSize int64 // Maximum size limit for decompression (0 = no limit)
+	CompressionLevel  int             // Compression level (1-22, default 3)
+	WindowSize        int             // Window size limit (0 = default)
+	Checksum          bool            // Append a content checksum to each frame written
+	Workers           int             // Native compression worker threads (0 = single-threaded)
+	ReadBufferSize    int             // Read buffer size for streaming operations
+	ReadAhead         int             // Chunks a Reader decompresses ahead in the background (0 = disabled)
+	WriteBufferSize   int             // Write buffer size for streaming operations
+	CoalesceSize      int             // Writes smaller than this are buffered before compressing (0 = default, negative = disabled)
+	FlushOnWrite      bool            // Flush after every write so each one is immediately decodable
+	FlushInterval     time.Duration   // Flush automatically when no writes arrive for this long (0 = disabled)
+	MaxDecompressSize int64           // Maximum size limit for decompression (0 = no limit)
+	ContentSize       int64           // Total uncompressed size a Writer will receive (0 = unknown)
+	AdaptMinLevel     int             // Lowest level an adaptive Writer may drop to
+	AdaptMaxLevel     int             // Highest level an adaptive Writer may rise to (0 with AdaptMinLevel = fixed level)
+	Dictionary        []byte          // Dictionary content for Readers and Writers (nil = none)
+	DictLoadMethod    DictLoadMethod  // How Readers and Writers load the dictionary (default = digested once, by copy)
+	DictContentType   DictContentType // How Readers and Writers interpret the dictionary (default = auto)
+	LongDistance      bool            // Find matches far back in large windows (long distance matching)
+	Rsyncable         bool            // Cut the output at content-defined points so rsync can match it
+	Mmap              bool            // Map source files into memory instead of reading them, where supported
+	MessageStream     bool            // Compress messages as one stream flushed after each, instead of a frame each
+
+	// SkippableFrameHandler receives the payload of every skippable frame a Reader
+	// encounters instead of it being discarded. Returning an error stops the Reader.
+	SkippableFrameHandler func(magicVariant uint32, payload []byte) error
+
+	// Context stops Readers and Writers between chunks of data once it is done (nil = never)
+	Context context.Context
+
+	// Progress is called as data is processed with the number of input bytes handled so
+	// far and the total, or -1 if unknown: uncompressed bytes for a Writer and compressed
+	// bytes for a Reader. Given to New, it also reports on Compress and Decompress.
+	Progress func(processed, total int64)
+
+	// Dictionaries provides the dictionary each frame was compressed with, by the ID it
+	// records, to Readers and, given to New, to Decompress.
+	Dictionaries *DictionaryRegistry
+
+	// Tracer receives every compression, decompression and parameter call an instance
+	// makes into libzstd. It is only used when given to New.
+	Tracer Tracer
+
+	// Instrumentation is notified around every compression and decompression of an
+	// instance. It is only used when given to New.
+	Instrumentation Instrumentation
+
+	// Allocator provides the native memory of the contexts and streams of an instance.
+	// It is only used when given to New.
+	Allocator Allocator
+}
+
+// Option configures a single setting of Options
+type Option func(*Options)
+
+// WithFlushInterval makes a Writer flush pending compressed data in the background
+// whenever no writes have arrived for d, so output doesn't sit in the encoder during
+// quiet periods.
+func WithFlushInterval(d time.Duration) Option {
+	return func(o *Options) {
+		o.FlushInterval = d
+	}
 }
 
 // DefaultOptions returns the default compression options
@@ -42,10 +167,77 @@ func DefaultOptions() Options {
 		WindowSize:        0, // Use library default
 		ReadBufferSize:    defaultReadBufferSize,
 		WriteBufferSize:   defaultWriteBufferSize,
+		CoalesceSize:      defaultCoalesceSize,
 		MaxDecompressSize: 0, // No limit
 	}
 }
 
+// Validate checks the options against the limits of the bundled library, returning an
+// error that describes every problem found, or nil. Level problems match ErrInvalidLevel
+// and the others ErrInvalidOption. Readers and Writers validate their options against
+// the loaded library, and fail their first operation with this error.
+func (o Options) Validate() error {
+	return o.validate(minLevel, maxLevel)
+}
+
+// validate checks the options, accepting compression levels from minLevel to maxLevel
+func (o Options) validate(minLevel, maxLevel int) error {
+	var errs []error
+	invalid := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: "+format, append([]any{ErrInvalidOption}, args...)...))
+	}
+	checkLevel := func(name string, level int) {
+		if level < minLevel || level > maxLevel {
+			errs = append(errs, fmt.Errorf("%w: %s %d is outside %d to %d", ErrInvalidLevel, name, level, minLevel, maxLevel))
+		}
+	}
+
+	checkLevel("CompressionLevel", o.CompressionLevel)
+	if o.AdaptMinLevel != 0 || o.AdaptMaxLevel != 0 {
+		checkLevel("AdaptMinLevel", o.AdaptMinLevel)
+		checkLevel("AdaptMaxLevel", o.AdaptMaxLevel)
+		if o.AdaptMinLevel > o.AdaptMaxLevel {
+			invalid("AdaptMinLevel %d is above AdaptMaxLevel %d", o.AdaptMinLevel, o.AdaptMaxLevel)
+		}
+	}
+
+	if o.WindowSize < 0 || o.WindowSize > 0 && windowLogFor(o.WindowSize) != bits.Len(uint(o.WindowSize-1)) {
+		invalid("WindowSize %d is outside %d to %d", o.WindowSize, 1<<windowLogMin, 1<<windowLogMax)
+	}
+	if o.Workers < 0 || o.Workers > maxWorkers {
+		invalid("Workers %d is outside 0 to %d", o.Workers, maxWorkers)
+	}
+
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{
+		{"ReadBufferSize", int64(o.ReadBufferSize)},
+		{"ReadAhead", int64(o.ReadAhead)},
+		{"WriteBufferSize", int64(o.WriteBufferSize)},
+		{"FlushInterval", int64(o.FlushInterval)},
+		{"MaxDecompressSize", o.MaxDecompressSize},
+		{"ContentSize", o.ContentSize},
+	} {
+		if f.value < 0 {
+			invalid("%s must not be negative", f.name)
+		}
+	}
+
+	if o.DictLoadMethod < DictLoadByCopy || o.DictLoadMethod > DictLoadByRef {
+		invalid("DictLoadMethod %d is unknown", o.DictLoadMethod)
+	}
+	if o.DictContentType < DictContentAuto || o.DictContentType > DictContentFull {
+		invalid("DictContentType %d is unknown", o.DictContentType)
+	}
+
+	if o.FlushOnWrite && o.FlushInterval > 0 {
+		invalid("FlushOnWrite and FlushInterval are mutually exclusive")
+	}
+
+	return errors.Join(errs...)
+}
+
 // FastOptions returns options optimized for speed
 func FastOptions() Options {
 	opts := DefaultOptions()
@@ -59,3 +251,218 @@ func BestOptions() Options {
 	opts.CompressionLevel = BestCompression
 	return opts
 }
+
+// LogsProfile returns options for log streams: fast, rsyncable compression with a
+// small window, so many streams can be open at once and archived files sync cheaply
+func LogsProfile() Options {
+	opts := DefaultOptions()
+	opts.CompressionLevel = BestSpeed
+	opts.WindowSize = 1 << 20
+	opts.Rsyncable = true
+	return opts
+}
+
+// JSONProfile returns options for small JSON documents and API payloads: a middle level
+// that makes good use of a dictionary trained on sample documents, to be set as Dictionary
+func JSONProfile() Options {
+	opts := DefaultOptions()
+	opts.CompressionLevel = 6
+	opts.WindowSize = 1 << 20
+	return opts
+}
+
+// ArchiveProfile returns options for long-term archives: a high level with long distance
+// matching over a large window, and a checksum to detect damage when restoring
+func ArchiveProfile() Options {
+	opts := DefaultOptions()
+	opts.CompressionLevel = BestCompression
+	opts.WindowSize = 1 << windowLogLimitDefault // The largest window readers accept by default
+	opts.LongDistance = true
+	opts.Checksum = true
+	return opts
+}
+
+// WithLongDistance makes a Writer search for matches far back in a large window, which
+// pays off on inputs with long-range repetition such as backups and archives
+func WithLongDistance(enable bool) Option {
+	return func(o *Options) {
+		o.LongDistance = enable
+	}
+}
+
+// WithRsyncable makes a Writer cut its output at points that depend on the content, so
+// rsync can transfer only the changed parts of a file compressed again after an edit.
+// The Writer compresses in at least one worker thread, which this mode requires.
+func WithRsyncable(enable bool) Option {
+	return func(o *Options) {
+		o.Rsyncable = enable
+	}
+}
+
+// WithMaxDecompressSize makes a Reader fail with a *MaxSizeError once more than n bytes
+// would be decompressed, guarding services that handle untrusted input.
+func WithMaxDecompressSize(n int64) Option {
+	return func(o *Options) {
+		o.MaxDecompressSize = n
+	}
+}
+
+// WithReadAhead makes a Reader decompress up to chunks buffers ahead of the consumer in a
+// background goroutine, overlapping I/O and decompression with processing of the data.
+func WithReadAhead(chunks int) Option {
+	return func(o *Options) {
+		o.ReadAhead = chunks
+	}
+}
+
+// WithSkippableFrameHandler makes a Reader pass the contents of skippable frames to fn,
+// so embedded indexes or metadata can be recovered while streaming.
+func WithSkippableFrameHandler(fn func(magicVariant uint32, payload []byte) error) Option {
+	return func(o *Options) {
+		o.SkippableFrameHandler = fn
+	}
+}
+
+// WithContentSize pledges the total number of bytes that will be written to a Writer.
+// The size is recorded in the frame header and lets zstd tune its parameters for it.
+func WithContentSize(size int64) Option {
+	return func(o *Options) {
+		o.ContentSize = size
+	}
+}
+
+// WithAdaptiveLevel makes a Writer adjust its compression level between minLevel and
+// maxLevel as it runs: the level drops while the destination is slow to accept output,
+// and rises while it keeps up easily. The Writer compresses in at least one worker
+// thread, as the level can only change between jobs.
+func WithAdaptiveLevel(minLevel, maxLevel int) Option {
+	return func(o *Options) {
+		o.AdaptMinLevel = minLevel
+		o.AdaptMaxLevel = maxLevel
+	}
+}
+
+// WithMmap makes DecompressFile and OpenSeekableFile map the source file into memory,
+// where supported, and decompress straight from the mapping instead of reading the file
+// into buffers. On other platforms the file is read as usual.
+func WithMmap(enable bool) Option {
+	return func(o *Options) {
+		o.Mmap = enable
+	}
+}
+
+// WithMessageStream makes a MessageWriter compress all messages as one stream, flushed
+// after each message, so each benefits from the content of the previous ones. The
+// MessageReader must be given it too.
+func WithMessageStream(enable bool) Option {
+	return func(o *Options) {
+		o.MessageStream = enable
+	}
+}
+
+// WithLevel sets the compression level of a Writer
+func WithLevel(level int) Option {
+	return func(o *Options) {
+		o.CompressionLevel = level
+	}
+}
+
+// WithChecksum makes a Writer append a checksum of the content to each frame
+func WithChecksum(checksum bool) Option {
+	return func(o *Options) {
+		o.Checksum = checksum
+	}
+}
+
+// WithDictionary makes Readers and Writers use the given dictionary content.
+// The data must not be modified while they are in use.
+func WithDictionary(dict []byte) Option {
+	return func(o *Options) {
+		o.Dictionary = dict
+	}
+}
+
+// WithWindowLog sets the window size as a power of two: the window a Writer compresses
+// with, or the largest window a Reader accepts.
+func WithWindowLog(windowLog int) Option {
+	return func(o *Options) {
+		o.WindowSize = 1 << windowLog
+	}
+}
+
+// WithWorkers makes a Writer compress in the given number of native worker threads
+func WithWorkers(workers int) Option {
+	return func(o *Options) {
+		o.Workers = workers
+	}
+}
+
+// WithBufferSizes sets the size of the internal buffers of Readers and Writers
+func WithBufferSizes(readSize, writeSize int) Option {
+	return func(o *Options) {
+		o.ReadBufferSize = readSize
+		o.WriteBufferSize = writeSize
+	}
+}
+
+// WithProgress makes Readers and Writers report how much of their input has been
+// processed to fn, so CLIs and UIs can render progress bars. Given to New, it also
+// makes Compress and Decompress process large buffers in chunks and report on them.
+func WithProgress(fn func(processed, total int64)) Option {
+	return func(o *Options) {
+		o.Progress = fn
+	}
+}
+
+// WithDictionaryRegistry makes Readers, and Decompress when given to New, decompress
+// each stream with the dictionary from reg whose ID the data records. Data that needs a
+// dictionary missing from reg fails with a *MissingDictionaryError.
+func WithDictionaryRegistry(reg *DictionaryRegistry) Option {
+	return func(o *Options) {
+		o.Dictionaries = reg
+	}
+}
+
+// WithDictionaryLoad makes Readers and Writers load their dictionary into the stream with
+// the method and content type, instead of digesting it once by copy. With DictLoadByRef
+// the dictionary content is not copied, and must not be modified while streams use it.
+func WithDictionaryLoad(method DictLoadMethod, contentType DictContentType) Option {
+	return func(o *Options) {
+		o.DictLoadMethod = method
+		o.DictContentType = contentType
+	}
+}
+
+// WithTracer makes an instance report each native call it makes to t, for diagnosing
+// stalled streams without rebuilding the package. It only applies when given to New.
+func WithTracer(t Tracer) Option {
+	return func(o *Options) {
+		o.Tracer = t
+	}
+}
+
+// WithInstrumentation makes an instance notify inst around every compression and
+// decompression, so spans and metrics can be recorded. It only applies when given to New.
+func WithInstrumentation(inst Instrumentation) Option {
+	return func(o *Options) {
+		o.Instrumentation = inst
+	}
+}
+
+// WithAllocator makes an instance allocate the native memory of its contexts and streams
+// with a, so it can be accounted for or taken from a custom allocator. Dictionaries still
+// use the default allocator. It only applies when given to New.
+func WithAllocator(a Allocator) Option {
+	return func(o *Options) {
+		o.Allocator = a
+	}
+}
+
+// WithContext makes Readers and Writers check ctx between chunks of data and fail with
+// its error once it is done, so long jobs stop promptly when their request goes away.
+// A cancelled Writer does not complete its frame.
+func WithContext(ctx context.Context) Option {
+	return func(o *Options) {
+		o.Context = ctx
+	}
+}
@@ -0,0 +1,241 @@
+package zstd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"unsafe"
+)
+
+// defaultParallelChunkSize is the content of each frame a ParallelWriter compresses
+const defaultParallelChunkSize = 4 << 20
+
+// ParallelWriter compresses like pzstd: it splits its input into chunks and compresses
+// them as independent frames on several goroutines, each with its own context, writing
+// the frames in order. This scales with cores on large inputs without the native
+// multithreaded compressor, at a small cost in ratio at chunk boundaries.
+// It is not safe for concurrent use.
+type ParallelWriter struct {
+	zstd      *Zstd
+	level     int
+	chunkSize int
+	buffer    []byte                   // Content of the chunk being filled
+	queue     chan chan parallelResult // Chunks in order, bounding those in flight
+	done      chan struct{}            // Closed once the output goroutine returns
+
+	mu     sync.Mutex
+	err    error // First error, from compressing or writing
+	closed bool
+}
+
+// parallelResult is a compressed chunk, or the error compressing it
+type parallelResult struct {
+	frame []byte
+	err   error
+}
+
+// NewParallelWriter creates a ParallelWriter compressing chunks of chunkSize bytes
+// (0 = 4MB) at the level (0 = the instance default) on GOMAXPROCS goroutines.
+// The caller must call Close() to compress the last chunk and wait for the output.
+func (z *Zstd) NewParallelWriter(w io.Writer, chunkSize, level int) *ParallelWriter {
+	if chunkSize <= 0 {
+		chunkSize = defaultParallelChunkSize
+	}
+	pw := &ParallelWriter{
+		zstd:      z,
+		level:     z.resolveLevel(level),
+		chunkSize: chunkSize,
+		buffer:    make([]byte, 0, chunkSize),
+		queue:     make(chan chan parallelResult, runtime.GOMAXPROCS(0)),
+		done:      make(chan struct{}),
+	}
+	go pw.output(w)
+	return pw
+}
+
+// Write implements the io.Writer interface
+func (pw *ParallelWriter) Write(p []byte) (int, error) {
+	if pw.closed || pw.zstd.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+
+	written := 0
+	for len(p) > 0 {
+		if err := pw.error(); err != nil {
+			return written, err
+		}
+		n := min(len(p), pw.chunkSize-len(pw.buffer))
+		pw.buffer = append(pw.buffer, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(pw.buffer) == pw.chunkSize {
+			pw.submit()
+		}
+	}
+	return written, nil
+}
+
+// Close compresses the last chunk and waits for all frames to be written.
+// It does not close the underlying writer.
+func (pw *ParallelWriter) Close() error {
+	if pw.closed {
+		return pw.error()
+	}
+	pw.closed = true
+
+	if len(pw.buffer) > 0 {
+		pw.submit()
+	}
+	close(pw.queue)
+	<-pw.done
+	return pw.error()
+}
+
+// submit starts compressing the buffered chunk, blocking while too many are in flight
+func (pw *ParallelWriter) submit() {
+	chunk := pw.buffer
+	pw.buffer = make([]byte, 0, pw.chunkSize)
+
+	result := make(chan parallelResult, 1)
+	pw.queue <- result
+	go func() {
+		frame, err := pw.zstd.Compress(chunk, pw.level)
+		result <- parallelResult{frame, err}
+	}()
+}
+
+// output writes the compressed chunks in order, until the queue is closed
+func (pw *ParallelWriter) output(w io.Writer) {
+	defer close(pw.done)
+	for result := range pw.queue {
+		r := <-result
+		if pw.error() != nil {
+			continue // Drain the remaining chunks
+		}
+		if r.err == nil {
+			_, r.err = w.Write(r.frame)
+		}
+		if r.err != nil {
+			pw.mu.Lock()
+			pw.err = r.err
+			pw.mu.Unlock()
+		}
+	}
+}
+
+// error returns the first error met, if any
+func (pw *ParallelWriter) error() error {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	return pw.err
+}
+
+// DecompressParallel decompresses the frames of src concurrently, on GOMAXPROCS
+// goroutines, and writes their content to w in order. It speeds up inputs made of
+// many frames, such as those of ParallelWriter, SeekableWriter or pzstd; a single
+// frame is decompressed on one goroutine. It returns the number of bytes written.
+func (z *Zstd) DecompressParallel(w io.Writer, src []byte) (int64, error) {
+	if z.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+
+	frames, err := z.SplitFrames(src)
+	if err != nil {
+		return 0, err
+	}
+	return orderedParallel(w, len(frames), func(i int) ([]byte, error) {
+		return z.decompressFrame(frames[i])
+	})
+}
+
+// WriteTo implements the io.WriterTo interface, decompressing the frames from the
+// current position to the end concurrently, on GOMAXPROCS goroutines
+func (sr *SeekableReader) WriteTo(w io.Writer) (int64, error) {
+	if sr.pos >= sr.size {
+		return 0, nil
+	}
+	first := sort.Search(len(sr.frames), func(i int) bool {
+		f := sr.frames[i]
+		return f.decompressedOffset+int64(f.decompressedSize) > sr.pos
+	})
+	skip := sr.pos - sr.frames[first].decompressedOffset
+
+	n, err := orderedParallel(w, len(sr.frames)-first, func(i int) ([]byte, error) {
+		content, err := sr.decompressFrame(first + i)
+		if err == nil && i == 0 {
+			content = content[skip:]
+		}
+		return content, err
+	})
+	sr.pos += n
+	return n, err
+}
+
+// decompressFrame returns the content of a single frame, nothing for skippable frames
+func (z *Zstd) decompressFrame(frame []byte) ([]byte, error) {
+	if len(frame) >= 4 && binary.LittleEndian.Uint32(frame)&^maxSkippableVariant == skippableMagicStart {
+		return nil, nil
+	}
+
+	size := z.getFrameContentSize(unsafe.Pointer(&frame[0]), uint64(len(frame)))
+	switch {
+	case size == contentSizeError:
+		return nil, fmt.Errorf("%w: invalid frame header", ErrCorruptedData)
+	case size == 0:
+		return nil, nil
+	case size != contentSizeUnknown && size <= maxPreallocSize:
+		return z.Decompress(frame, int(size))
+	}
+
+	// Without a recorded size, or one too large to allocate up front from an untrusted
+	// header, stream the frame
+	r := z.NewReader(bytes.NewReader(frame))
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// orderedParallel runs work for 0 to n-1 on GOMAXPROCS goroutines, writing the results
+// to w in order, and returns the number of bytes written
+func orderedParallel(w io.Writer, n int, work func(i int) ([]byte, error)) (int64, error) {
+	queue := make(chan chan parallelResult, runtime.GOMAXPROCS(0))
+	stop := make(chan struct{})
+	go func() {
+		defer close(queue)
+		for i := 0; i < n; i++ {
+			result := make(chan parallelResult, 1)
+			select {
+			case queue <- result:
+			case <-stop:
+				return
+			}
+			go func() {
+				content, err := work(i)
+				result <- parallelResult{content, err}
+			}()
+		}
+	}()
+
+	var written int64
+	var err error
+	for result := range queue {
+		r := <-result
+		if err != nil {
+			continue // Let the started work finish
+		}
+		if r.err == nil {
+			var m int
+			m, r.err = w.Write(r.frame)
+			written += int64(m)
+		}
+		if r.err != nil {
+			err = r.err
+			close(stop)
+		}
+	}
+	return written, err
+}
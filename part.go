@@ -0,0 +1,117 @@
+package zstd
+
+import "bytes"
+
+const (
+	// defaultPartSize is the compressed size at which a PartWriter cuts a part by default
+	defaultPartSize = 64 << 20
+
+	// partChunkSize bounds the input compressed between checks of the part size
+	partChunkSize = 128 << 10
+)
+
+// PartWriter is an io.WriteCloser compressing its input into parts of a bounded size,
+// as multipart uploads to object storage expect. Once the compressed output reaches the
+// part size, the current frame is ended and the part handed to the emit function. Every
+// part is a complete stream of its own, and together they form a single stream. All parts
+// but the last reach the part size, and exceed it by the output of the input the encoder
+// holds: up to about 256KB, more with native worker threads.
+// It is not safe for concurrent use.
+type PartWriter struct {
+	writer   *Writer
+	buffer   bytes.Buffer // Compressed output of the current part
+	partSize int
+	emit     func(part int, data []byte) error
+	parts    int   // Parts emitted so far
+	err      error // Sticky error from compressing or emitting
+	closed   bool
+}
+
+// NewPartWriter returns a PartWriter cutting parts of partSize compressed bytes
+// (0 = 64MB), configured by opts. emit receives each part with its number, from 1;
+// data is only valid during the call. An error from emit stops the PartWriter.
+func (z *Zstd) NewPartWriter(partSize int, emit func(part int, data []byte) error, opts ...Option) *PartWriter {
+	if partSize <= 0 {
+		partSize = defaultPartSize
+	}
+
+	pw := &PartWriter{partSize: partSize, emit: emit}
+	pw.writer = z.NewWriter(&pw.buffer, 0, opts...)
+	return pw
+}
+
+// Write implements the io.Writer interface
+func (pw *PartWriter) Write(p []byte) (int, error) {
+	if pw.closed {
+		return 0, ErrAlreadyClosed
+	}
+	if pw.err != nil {
+		return 0, pw.err
+	}
+
+	// Check the output size between chunks, so large writes do not overshoot it
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), partChunkSize)
+		if _, err := pw.writer.Write(p[:n]); err != nil {
+			pw.err = err
+			return written, err
+		}
+		written += n
+		p = p[n:]
+
+		if pw.buffer.Len() >= pw.partSize {
+			if err := pw.endPart(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Close ends the last frame and emits the last part, if any data was written since the
+// previous one. It does not emit anything if no data was written at all.
+func (pw *PartWriter) Close() error {
+	if pw.closed {
+		return pw.err
+	}
+	pw.closed = true
+
+	if pw.err != nil {
+		pw.writer.Abort()
+		return pw.err
+	}
+	if err := pw.writer.Close(); err != nil {
+		pw.err = err
+		return err
+	}
+	if pw.buffer.Len() > 0 {
+		pw.emitPart()
+	}
+	return pw.err
+}
+
+// Parts returns the number of parts emitted so far
+func (pw *PartWriter) Parts() int {
+	return pw.parts
+}
+
+// endPart ends the current frame and emits the part
+func (pw *PartWriter) endPart() error {
+	if err := pw.writer.EndFrame(); err != nil {
+		pw.err = err
+		return err
+	}
+	return pw.emitPart()
+}
+
+// emitPart hands the buffered output to the emit function and starts a new part
+func (pw *PartWriter) emitPart() error {
+	pw.parts++
+	if err := pw.emit(pw.parts, pw.buffer.Bytes()); err != nil {
+		pw.err = err
+		return err
+	}
+	pw.buffer.Reset()
+	return nil
+}
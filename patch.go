@@ -0,0 +1,67 @@
+package zstd
+
+import (
+	"math/bits"
+	"unsafe"
+)
+
+// CreatePatch produces a binary patch that transforms oldData into newData,
+// mirroring `zstd --patch-from`. The patch is a regular zstd frame compressed
+// with oldData as a reference prefix, so it is typically tiny when the two
+// versions share most of their content. Apply it with ApplyPatch.
+func (z *Zstd) CreatePatch(oldData, newData []byte) ([]byte, error) {
+	return z.CreatePatchLevel(oldData, newData, 0)
+}
+
+// CreatePatchLevel is like CreatePatch but uses the specified compression level,
+// or the default level of the instance if it is 0.
+func (z *Zstd) CreatePatchLevel(oldData, newData []byte, level int) ([]byte, error) {
+	windowLog := patchWindowLog(len(oldData), len(newData))
+
+	params := []parameter{
+		{cParamCompressionLevel, z.resolveLevel(level)},
+		{cParamWindowLog, windowLog},
+	}
+
+	// Large inputs need long distance matching to find matches across the whole reference
+	if windowLog > windowLogLimitDefault {
+		params = append(params, parameter{cParamEnableLongDistanceMatching, 1})
+	}
+
+	return z.compressWithPrefix(newData, oldData, params...)
+}
+
+// ApplyPatch reconstructs the new version of the data from oldData and a patch
+// created by CreatePatch. The oldData must be identical to the one used when
+// creating the patch. The new version is limited to twice the size of the old one
+// plus 64MB, as patches may come from untrusted sources; ApplyPatchLimit sets another limit.
+func (z *Zstd) ApplyPatch(oldData, patch []byte) ([]byte, error) {
+	return z.ApplyPatchLimit(oldData, patch, 2*len(oldData)+maxPreallocSize)
+}
+
+// ApplyPatchLimit is like ApplyPatch but fails with a *MaxSizeError if the new version
+// exceeds maxSize bytes. The window accepted is only as large as CreatePatch uses for
+// data of these sizes.
+func (z *Zstd) ApplyPatchLimit(oldData, patch []byte, maxSize int) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	if len(patch) == 0 {
+		return []byte{}, nil
+	}
+
+	// The new size recorded by the patch is trusted for the window only within the limit
+	newSize := maxSize
+	if size := z.getFrameContentSize(unsafe.Pointer(&patch[0]), uint64(len(patch))); size < uint64(maxSize) {
+		newSize = int(size)
+	}
+	windowLog := patchWindowLog(len(oldData), newSize)
+	return z.decompressWithPrefix(patch, oldData, maxSize, parameter{dParamWindowLogMax, max(windowLog, windowLogLimitDefault)})
+}
+
+// patchWindowLog returns a window large enough to reference all of the old data
+// from anywhere in the new data, as the zstd command line tool does.
+func patchWindowLog(oldSize, newSize int) int {
+	windowLog := bits.Len(uint(max(oldSize, newSize))) + 1
+	return min(max(windowLog, windowLogMin), windowLogMax)
+}
@@ -0,0 +1,54 @@
+package zstd
+
+import "io"
+
+// pipeReader is the reading side of a pipe fed by a compressing goroutine
+type pipeReader struct {
+	*io.PipeReader
+	done chan struct{} // Closed once the goroutine returns
+}
+
+// Close implements the io.Closer interface, stopping the goroutine and waiting for it
+func (r *pipeReader) Close() error {
+	r.PipeReader.Close()
+	<-r.done
+	return nil
+}
+
+// CompressPipe returns a reader producing the compressed stream of src, compressed at
+// the level (0 = the instance default) by a goroutine as the reader is consumed. This is
+// the shape http.Request bodies and object storage uploads expect. Errors reading src or
+// compressing are returned by Read. Close stops the goroutine and waits for it, which
+// lasts until its pending read from src returns; src itself is not closed.
+func (z *Zstd) CompressPipe(src io.Reader, level int, opts ...Option) io.ReadCloser {
+	pr, pw := io.Pipe()
+	return startPipe(pr, pw, z.NewWriter(pw, level, opts...), src)
+}
+
+// CompressPipe returns a reader producing the compressed stream of src, like the
+// method of the same name, using a Zstandard instance of its own that is unloaded
+// once the stream ends.
+func CompressPipe(src io.Reader, level int) io.ReadCloser {
+	pr, pw := io.Pipe()
+	z, err := New()
+	if err != nil {
+		pw.CloseWithError(err)
+		return pr
+	}
+	return startPipe(pr, pw, ownedWriter(z.NewWriter(pw, level)), src)
+}
+
+// startPipe starts copying src to w, which writes to pw, and returns the reading side
+func startPipe(pr *io.PipeReader, pw *io.PipeWriter, w *Writer, src io.Reader) io.ReadCloser {
+	r := &pipeReader{PipeReader: pr, done: make(chan struct{})}
+	go func() {
+		defer close(r.done)
+		if _, err := io.Copy(w, src); err != nil {
+			// Abandon the frame, failing the reading side with err
+			w.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return r
+}
@@ -0,0 +1,95 @@
+package zstd
+
+import (
+	"io"
+	"sync"
+)
+
+// ReaderPool hands out Readers bound to a shared Zstd instance, reusing their buffers
+// and native decompression streams across uses. It is safe for concurrent use.
+type ReaderPool struct {
+	zstd *Zstd
+	opts Options
+	pool sync.Pool
+}
+
+// NewReaderPool creates a pool of Readers configured by opts.
+func (z *Zstd) NewReaderPool(opts ...Option) *ReaderPool {
+	return &ReaderPool{zstd: z, opts: z.options(opts...)}
+}
+
+// Get returns a Reader decompressing from src. Close it when done, then hand it back
+// with Put; the native state survives Close so the next user does not recreate it.
+func (p *ReaderPool) Get(src io.Reader) *Reader {
+	if r, ok := p.pool.Get().(*Reader); ok {
+		r.reset(src)
+		return r
+	}
+
+	r := p.zstd.NewReaderOptions(src, p.opts)
+	r.pool = p
+	return r
+}
+
+// Put returns a Reader obtained from Get to the pool. The Reader must not be used afterwards.
+// Readers from other sources are ignored.
+func (p *ReaderPool) Put(r *Reader) {
+	if r == nil || r.pool != p {
+		return
+	}
+
+	// Drop the reference to the source while the reader waits in the pool
+	r.reset(nil)
+	r.closed = true
+	p.pool.Put(r)
+}
+
+// WriterPool hands out Writers bound to a shared Zstd instance, reusing their buffers
+// and native compression streams across uses. It is safe for concurrent use.
+type WriterPool struct {
+	zstd *Zstd
+	opts Options
+	pool sync.Pool
+}
+
+// NewWriterPool creates a pool of Writers compressing at the given level, configured by opts.
+// A level of 0 uses the level the instance was created with, as for NewWriter.
+func (z *Zstd) NewWriterPool(level int, opts ...Option) *WriterPool {
+	if level != 0 {
+		opts = append([]Option{WithLevel(level)}, opts...)
+	}
+	return &WriterPool{zstd: z, opts: z.options(opts...)}
+}
+
+// Get returns a Writer compressing to dst. Close it to complete the stream, then hand it
+// back with Put; the native state survives Close so the next user does not recreate it.
+func (p *WriterPool) Get(dst io.Writer) *Writer {
+	if w, ok := p.pool.Get().(*Writer); ok {
+		w.Reset(dst)
+		return w
+	}
+
+	w := p.zstd.NewWriterOptions(dst, p.opts)
+	w.pool = p
+	return w
+}
+
+// Put returns a Writer obtained from Get to the pool. A stream that was not closed is
+// discarded. The Writer must not be used afterwards. Writers from other sources are ignored.
+func (p *WriterPool) Put(w *Writer) {
+	if w == nil || w.pool != p {
+		return
+	}
+
+	// Parameters changed for a single stream do not carry over to the next user
+	w.Reset(nil)
+	w.level, w.checksum, w.windowSize = w.zstd.resolveLevel(p.opts.CompressionLevel), p.opts.Checksum, p.opts.WindowSize
+	if w.adapt != nil {
+		w.level = min(max(w.level, w.adapt.minLevel), w.adapt.maxLevel)
+	}
+	if w.stream != nil && !w.zstd.isClosed() {
+		w.applyParameters(w.stream)
+	}
+	w.closed = true
+	p.pool.Put(w)
+}
@@ -0,0 +1,250 @@
+package zstd
+
+import (
+	"errors"
+	"io"
+	"math"
+	"runtime"
+	"slices"
+	"unsafe"
+)
+
+// CompressWithPrefix compresses src relative to the reference buffer prefix.
+// The prefix acts as a lightweight, single-use dictionary: content shared with
+// prefix (such as a previous version of the same document) is encoded as
+// back-references instead of being stored again. No dictionary training is
+// required, but the same prefix must be supplied to DecompressWithPrefix.
+func (z *Zstd) CompressWithPrefix(src, prefix []byte, level int) ([]byte, error) {
+	return z.compressWithPrefix(src, prefix, parameter{cParamCompressionLevel, z.resolveLevel(level)})
+}
+
+// DecompressWithPrefix decompresses data produced by CompressWithPrefix.
+// The prefix must be byte-for-byte identical to the one used for compression.
+// The maxSize parameter limits the maximum size of the decompressed data, failing with a
+// *MaxSizeError beyond it; use 0 to size the output from the frame header.
+func (z *Zstd) DecompressWithPrefix(src, prefix []byte, maxSize int) ([]byte, error) {
+	return z.decompressWithPrefix(src, prefix, maxSize)
+}
+
+// parameter is a single advanced parameter applied to a context before use
+type parameter struct {
+	key   int
+	value int
+}
+
+// compressWithPrefix compresses src referencing prefix, applying params to the context first.
+// An empty prefix compresses src on its own.
+func (z *Zstd) compressWithPrefix(src, prefix []byte, params ...parameter) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	if len(src) == 0 {
+		return []byte{}, nil
+	}
+
+	// Create a compression context
+	cctx := z.getCCtx()
+	if cctx == nil {
+		return nil, z.allocError("compress with prefix", "compression context")
+	}
+	defer z.putCCtx(cctx)
+
+	// Apply compression parameters
+	level := 0
+	for _, p := range params {
+		if p.key == cParamCompressionLevel {
+			level = p.value
+		}
+		result := z.cctxSetParameter(cctx, p.key, p.value)
+		if z.isError(result) != 0 {
+			return nil, z.nativeError("compress with prefix", result)
+		}
+	}
+
+	// Reference the prefix for the next frame only.
+	// The context keeps a pointer to it across calls, so it stays pinned until we are done.
+	if len(prefix) > 0 {
+		var pinner runtime.Pinner
+		defer pinner.Unpin()
+		pinner.Pin(&prefix[0])
+
+		result := z.cctxRefPrefix(cctx, unsafe.Pointer(&prefix[0]), uint64(len(prefix)))
+		if z.isError(result) != 0 {
+			return nil, z.nativeError("compress with prefix", result)
+		}
+	}
+
+	// Allocate output buffer
+	dstCapacity := z.compressBound(uint64(len(src)))
+	dst := make([]byte, dstCapacity)
+
+	// Compress referencing the prefix
+	op := z.startOperation(true, Operation{Level: level, SrcSize: int64(len(src))})
+	result := z.compress2(
+		cctx,
+		unsafe.Pointer(&dst[0]),
+		dstCapacity,
+		unsafe.Pointer(&src[0]),
+		uint64(len(src)),
+	)
+
+	// Check for errors
+	if z.isError(result) != 0 {
+		err := z.nativeError("compress with prefix", result)
+		op.end(0, 0, err)
+		return nil, err
+	}
+	op.end(int64(len(src)), int64(result), nil)
+
+	return dst[:result], nil
+}
+
+// decompressWithPrefix decompresses src referencing prefix, applying params to the context first.
+// An empty prefix decompresses src on its own.
+func (z *Zstd) decompressWithPrefix(src, prefix []byte, maxSize int, params ...parameter) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	if len(src) == 0 {
+		return []byte{}, nil
+	}
+
+	limit := maxSize
+	if maxSize <= 0 {
+		maxSize = z.decompressedCapacity(src)
+	}
+
+	// Create a decompression context
+	dctx := z.getDCtx()
+	if dctx == nil {
+		return nil, z.allocError("decompress with prefix", "decompression context")
+	}
+	defer z.putDCtx(dctx)
+
+	// Apply decompression parameters
+	for _, p := range params {
+		result := z.dctxSetParameter(dctx, p.key, p.value)
+		if z.isError(result) != 0 {
+			return nil, z.nativeError("decompress with prefix", result)
+		}
+	}
+
+	// Reference the prefix for the next frame only.
+	// The context keeps a pointer to it across calls, so it stays pinned until we are done.
+	if len(prefix) > 0 {
+		var pinner runtime.Pinner
+		defer pinner.Unpin()
+		pinner.Pin(&prefix[0])
+
+		result := z.dctxRefPrefix(dctx, unsafe.Pointer(&prefix[0]), uint64(len(prefix)))
+		if z.isError(result) != 0 {
+			return nil, z.nativeError("decompress with prefix", result)
+		}
+	}
+
+	// A size beyond maxPreallocSize, as a forged frame header can claim, is not allocated
+	// up front: the output grows as it is produced instead, up to the limit
+	if maxSize > maxPreallocSize {
+		return z.decompressGrowing(dctx, src, limit, "decompress with prefix")
+	}
+
+	// Allocate output buffer
+	dst := make([]byte, maxSize)
+
+	// Decompress referencing the prefix
+	op := z.startOperation(false, Operation{SrcSize: int64(len(src))})
+	result := z.decompressDCtx(
+		dctx,
+		unsafe.Pointer(&dst[0]),
+		uint64(maxSize),
+		unsafe.Pointer(&src[0]),
+		uint64(len(src)),
+	)
+
+	// Check for errors
+	if z.isError(result) != 0 {
+		err := z.nativeError("decompress with prefix", result)
+		if limit > 0 && errors.Is(err, ErrDstSizeTooSmall) {
+			err = &MaxSizeError{Limit: int64(limit)}
+		}
+		op.end(0, 0, err)
+		return nil, err
+	}
+	op.end(int64(len(src)), int64(result), nil)
+
+	return dst[:result], nil
+}
+
+// decompressGrowing decompresses the frames of src with dctx, prepared by the caller, into
+// an output growing as data is produced. The limit caps the output, failing with a
+// *MaxSizeError beyond it; use 0 for no limit.
+func (z *Zstd) decompressGrowing(dctx unsafe.Pointer, src []byte, limit int, opName string) ([]byte, error) {
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	pinner.Pin(&src[0])
+
+	op := z.startOperation(false, Operation{SrcSize: int64(len(src))})
+	dst := make([]byte, 0, min(len(src)*5, maxPreallocSize))
+	input := ZstdInBuffer{Src: unsafe.Pointer(&src[0]), Size: uint64(len(src))}
+	for {
+		if len(dst) == cap(dst) {
+			if limit > 0 && len(dst) >= limit {
+				err := &MaxSizeError{Limit: int64(limit)}
+				op.end(int64(input.Pos), int64(len(dst)), err)
+				return nil, err
+			}
+			dst = slices.Grow(dst, max(len(dst), defaultReadBufferSize))
+			if limit > 0 && cap(dst) > limit {
+				dst = dst[:len(dst):limit]
+			}
+		}
+
+		// The output may move as it grows, so it is only pinned during the call
+		var outPinner runtime.Pinner
+		spare := dst[len(dst):cap(dst)]
+		outPinner.Pin(&spare[0])
+		output := ZstdOutBuffer{Dst: unsafe.Pointer(&spare[0]), Size: uint64(len(spare))}
+		result := z.decompressStream(dctx, &output, &input)
+		outPinner.Unpin()
+
+		if z.isError(result) != 0 {
+			err := z.nativeError(opName, result)
+			op.end(int64(input.Pos), int64(len(dst)), err)
+			return nil, err
+		}
+		dst = dst[:len(dst)+int(output.Pos)]
+
+		if input.Pos == input.Size {
+			if result == 0 {
+				break
+			}
+			if output.Pos < output.Size {
+				// The input ends mid-frame
+				op.end(int64(input.Pos), int64(len(dst)), io.ErrUnexpectedEOF)
+				return nil, io.ErrUnexpectedEOF
+			}
+		}
+	}
+	op.end(int64(input.Pos), int64(len(dst)), nil)
+
+	return dst, nil
+}
+
+// decompressedCapacity returns an output buffer size suitable for decompressing src.
+// The frame content size is used when the header records it; otherwise a
+// conservative estimate based on the compressed size is returned.
+func (z *Zstd) decompressedCapacity(src []byte) int {
+	size := z.getFrameContentSize(unsafe.Pointer(&src[0]), uint64(len(src)))
+	if size != contentSizeUnknown && size != contentSizeError && size > 0 {
+		return int(min(size, math.MaxInt))
+	}
+
+	// Use a conservative estimation
+	capacity := len(src) * 5
+	if capacity < 1024 {
+		capacity = 1024 // Minimum reasonable size
+	}
+	return capacity
+}
@@ -0,0 +1,58 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+)
+
+// sourceSize returns the size of sources that know it, such as bytes.Reader,
+// strings.Reader and io.SectionReader, or -1
+func sourceSize(src io.Reader) int64 {
+	if s, ok := src.(interface{ Size() int64 }); ok {
+		return s.Size()
+	}
+	return -1
+}
+
+// compressProgress compresses src like Compress, in chunks, reporting progress as it goes
+func (z *Zstd) compressProgress(src []byte, level int) ([]byte, error) {
+	opts := DefaultOptions()
+	opts.CompressionLevel = level
+	opts.ContentSize = int64(len(src))
+	opts.Progress = z.progress
+
+	var buf bytes.Buffer
+	buf.Grow(z.CompressBound(len(src)))
+	w := z.NewWriterOptions(&buf, opts)
+	if _, err := w.Write(src); err != nil {
+		w.Abort()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressProgress decompresses src like Decompress, in chunks, reporting progress as
+// it goes. Output beyond maxSize fails with a *MaxSizeError.
+func (z *Zstd) decompressProgress(src []byte, maxSize int) ([]byte, error) {
+	opts := DefaultOptions()
+	opts.MaxDecompressSize = int64(maxSize)
+	opts.Progress = z.progress
+
+	r := z.NewReaderOptions(bytes.NewReader(src), opts)
+	defer r.Close()
+
+	// Start from the recorded size, if any, so the output is not copied as it grows
+	var buf bytes.Buffer
+	if header, err := r.Header(); err == nil && header.HasContentSize {
+		buf.Grow(int(min(header.ContentSize, uint64(maxSize), maxPreallocSize)))
+	}
+
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,34 @@
+package zstd
+
+// FrameProgression reports how far the compression of the current frame has progressed.
+// Its layout matches ZSTD_frameProgression.
+type FrameProgression struct {
+	Ingested      uint64 // Input bytes read and buffered
+	Consumed      uint64 // Input bytes actually compressed
+	Produced      uint64 // Compressed bytes generated
+	Flushed       uint64 // Compressed bytes flushed out of the library
+	CurrentJobID  uint32 // Latest started job number (multithreaded mode only)
+	ActiveWorkers uint32 // Workers actively compressing at probe time (multithreaded mode only)
+}
+
+// Ratio returns the compression ratio achieved so far (consumed / produced),
+// or 0 if no compressed output has been produced yet.
+func (p FrameProgression) Ratio() float64 {
+	if p.Produced == 0 {
+		return 0
+	}
+	return float64(p.Consumed) / float64(p.Produced)
+}
+
+// Progress returns live counters for the frame currently being written.
+// The counters restart from zero when a new frame begins.
+// It returns a zero FrameProgression if nothing has been written yet.
+func (w *Writer) Progress() FrameProgression {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.stream == nil || w.zstd.isClosed() {
+		return FrameProgression{}
+	}
+	return w.zstd.getFrameProgression(w.stream)
+}
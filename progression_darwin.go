@@ -0,0 +1,9 @@
+package zstd
+
+import "github.com/ebitengine/purego"
+
+// registerFrameProgression binds ZSTD_getFrameProgression, which returns its result by value.
+// purego supports struct return values natively on darwin.
+func registerFrameProgression(z *Zstd, handle uintptr) {
+	purego.RegisterLibFunc(&z.getFrameProgression, handle, "ZSTD_getFrameProgression")
+}
@@ -0,0 +1,22 @@
+package zstd
+
+import (
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// registerFrameProgression binds ZSTD_getFrameProgression, which returns its result by value.
+// purego cannot return structs on linux, but the System V ABI returns structs larger than
+// 16 bytes through a hidden pointer passed as the first argument, so the function is called
+// with an explicit result pointer instead.
+func registerFrameProgression(z *Zstd, handle uintptr) {
+	var getFrameProgression func(result *FrameProgression, cctx unsafe.Pointer) uintptr
+	purego.RegisterLibFunc(&getFrameProgression, handle, "ZSTD_getFrameProgression")
+
+	z.getFrameProgression = func(cctx unsafe.Pointer) FrameProgression {
+		var progression FrameProgression
+		getFrameProgression(&progression, cctx)
+		return progression
+	}
+}
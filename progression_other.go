@@ -0,0 +1,12 @@
+//go:build !darwin && !(linux && amd64)
+
+package zstd
+
+import "unsafe"
+
+// registerFrameProgression installs a stub on platforms without an embedded library.
+func registerFrameProgression(z *Zstd, handle uintptr) {
+	z.getFrameProgression = func(cctx unsafe.Pointer) FrameProgression {
+		return FrameProgression{}
+	}
+}
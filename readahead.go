@@ -0,0 +1,310 @@
+package zstd
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"weak"
+)
+
+// errInputPending reports that the readahead pump has not delivered more input yet
+var errInputPending = errors.New("zstd: readahead input pending")
+
+// errPrefetchStopped is returned to a read waiting for input when the readahead stops
+var errPrefetchStopped = errors.New("zstd: readahead stopped")
+
+// prefetcher decompresses chunks ahead of the consumer in a background goroutine.
+// The goroutine owns the Reader's decoding state while it decodes; the consumer only
+// touches the chunks handed over through the channel. Between chunks, and while it waits
+// for input, the goroutine holds the Reader only weakly, so a Reader dropped without
+// Close can still be finalized.
+type prefetcher struct {
+	chunks chan prefetchChunk // Decompressed chunks, in stream order
+	free   chan []byte        // Buffers returned by the consumer for reuse
+	done   chan struct{}      // Closed to stop the goroutines
+	exited chan struct{}      // Closed when the decoding goroutine has returned
+	input  *prefetchInput     // Compressed input read from the source by the pump
+
+	current prefetchChunk // Chunk being consumed
+	off     int           // Read position within current
+}
+
+// prefetchChunk is a decompressed or compressed chunk, or the error that ended the stream
+type prefetchChunk struct {
+	data []byte
+	err  error
+}
+
+// prefetchInput reads the source in a pump goroutine of its own, so that no lock is held
+// and no Reader is kept reachable while a source read blocks
+type prefetchInput struct {
+	mu     sync.Mutex
+	chunks []prefetchChunk // Read from the source, not yet taken by the decoder
+	off    int             // Read position within chunks[0]
+	read   int64           // Bytes read from the source
+	taken  int64           // Bytes taken by the decoder
+
+	ready  chan struct{}   // Signalled when a chunk arrives
+	spare  chan []byte     // Buffers free for the pump
+	done   <-chan struct{} // Closed to stop the pump
+	exited chan struct{}   // Closed when the pump has returned
+}
+
+// startPrefetch starts decompressing ahead of the consumer
+func (r *Reader) startPrefetch() {
+	p := &prefetcher{
+		chunks: make(chan prefetchChunk, r.readAhead),
+		free:   make(chan []byte, r.readAhead+1),
+		done:   make(chan struct{}),
+		exited: make(chan struct{}),
+	}
+	p.input = &prefetchInput{
+		ready:  make(chan struct{}, 1),
+		spare:  make(chan []byte, 2),
+		done:   p.done,
+		exited: make(chan struct{}),
+	}
+
+	// One buffer per queued chunk, plus the one being consumed
+	for i := 0; i <= r.readAhead; i++ {
+		p.free <- make([]byte, len(r.readBuffer))
+	}
+	// Two input buffers, so the source is read while the previous input is decoded
+	for range 2 {
+		p.input.spare <- make([]byte, len(r.buffer))
+	}
+
+	r.prefetch = p
+	go p.input.pump(r.reader)
+	go p.run(weak.Make(r))
+}
+
+// run decompresses chunks into free buffers until the stream ends, it is stopped, or
+// the Reader is dropped
+func (p *prefetcher) run(wr weak.Pointer[Reader]) {
+	defer close(p.exited)
+
+	for {
+		var buf []byte
+		select {
+		case buf = <-p.free:
+		case <-p.done:
+			return
+		}
+
+		chunk, ok := p.decode(wr, buf)
+		if !ok {
+			return
+		}
+
+		select {
+		case p.chunks <- chunk:
+		case <-p.done:
+			return
+		}
+
+		if chunk.err != nil {
+			return
+		}
+	}
+}
+
+// decode decompresses the next chunk into buf, waiting for input without holding the
+// Reader. It reports false if the readahead was stopped or the Reader dropped.
+func (p *prefetcher) decode(wr weak.Pointer[Reader], buf []byte) (prefetchChunk, bool) {
+	for {
+		n, err := decodeChunk(wr, buf)
+		if err == errPrefetchStopped {
+			return prefetchChunk{}, false
+		}
+		if err != errInputPending {
+			return prefetchChunk{data: buf[:n], err: err}, true
+		}
+
+		select {
+		case <-p.input.ready:
+		case <-p.done:
+			return prefetchChunk{}, false
+		}
+	}
+}
+
+// decodeChunk decompresses into buf under the Reader's lock, or returns errPrefetchStopped
+// if the Reader was dropped
+func decodeChunk(wr weak.Pointer[Reader], buf []byte) (int, error) {
+	r := wr.Value()
+	if r == nil {
+		return 0, errPrefetchStopped
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.read(buf)
+}
+
+// pump reads the source into spare buffers until it fails or ends, or the readahead stops.
+// A source read in progress is not interrupted: the pump returns once it completes.
+func (in *prefetchInput) pump(src io.Reader) {
+	defer close(in.exited)
+
+	for {
+		var buf []byte
+		select {
+		case buf = <-in.spare:
+		case <-in.done:
+			return
+		}
+
+		n, err := src.Read(buf)
+		in.mu.Lock()
+		in.chunks = append(in.chunks, prefetchChunk{data: buf[:n], err: err})
+		in.read += int64(n)
+		in.mu.Unlock()
+
+		select {
+		case in.ready <- struct{}{}:
+		default:
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// tryRead copies pumped input into dst without waiting, returning errInputPending if
+// none has arrived yet. The error that ended the source is returned once its data is
+// taken, and stays.
+func (in *prefetchInput) tryRead(dst []byte) (int, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	if len(in.chunks) == 0 {
+		return 0, errInputPending
+	}
+	chunk := in.chunks[0]
+	n := copy(dst, chunk.data[in.off:])
+	in.off += n
+	in.taken += int64(n)
+	if in.off < len(chunk.data) {
+		return n, nil
+	}
+	if chunk.err != nil {
+		return n, chunk.err
+	}
+
+	// Hand the drained buffer back to the pump
+	in.chunks = in.chunks[1:]
+	in.off = 0
+	in.spare <- chunk.data[:cap(chunk.data)]
+	return n, nil
+}
+
+// Read implements the io.Reader interface, waiting for the pump until input arrives or
+// the readahead stops
+func (in *prefetchInput) Read(dst []byte) (int, error) {
+	for {
+		if n, err := in.tryRead(dst); err != errInputPending {
+			return n, err
+		}
+
+		select {
+		case <-in.ready:
+		case <-in.done:
+			return 0, errPrefetchStopped
+		}
+	}
+}
+
+// unread returns the bytes read from the source but not taken by the decoder.
+// It is only meaningful once the pump has exited.
+func (in *prefetchInput) unread() int64 {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	return in.read - in.taken
+}
+
+// read copies prefetched data into dst, waiting for the next chunk when needed
+func (p *prefetcher) read(dst []byte) (int, error) {
+	if err := p.next(); err != nil {
+		return 0, err
+	}
+
+	n := copy(dst, p.current.data[p.off:])
+	p.off += n
+	return n, nil
+}
+
+// discard skips n prefetched bytes without copying them
+func (p *prefetcher) discard(n int64) (int64, error) {
+	var skipped int64
+	for skipped < n {
+		if err := p.next(); err != nil {
+			return skipped, err
+		}
+
+		m := min(int64(len(p.current.data)-p.off), n-skipped)
+		p.off += int(m)
+		skipped += m
+	}
+	return skipped, nil
+}
+
+// next waits for the next chunk once the current one has been consumed
+func (p *prefetcher) next() error {
+	for p.off >= len(p.current.data) {
+		// Data is delivered before the error that ended the stream
+		if p.current.err != nil {
+			return p.current.err
+		}
+
+		// Hand the consumed buffer back to the goroutine
+		if p.current.data != nil {
+			p.free <- p.current.data[:cap(p.current.data)]
+		}
+
+		chunk, ok := <-p.chunks
+		if !ok {
+			return io.EOF
+		}
+		p.current = chunk
+		p.off = 0
+	}
+	return nil
+}
+
+// stop terminates the decoding goroutine and waits for it to release the decoding state,
+// which it does promptly as it never blocks on the source. A pump blocked in a source
+// read is not waited for; it exits once the read returns.
+func (p *prefetcher) stop() {
+	select {
+	case <-p.done:
+	default:
+		close(p.done)
+	}
+	<-p.exited
+}
+
+// source returns where the decoder reads compressed input from: the source itself, or
+// the readahead pump while it runs
+func (r *Reader) source() io.Reader {
+	if r.prefetch != nil {
+		return r.prefetch.input
+	}
+	return r.reader
+}
+
+// readSource reads compressed input for fill. With readahead, it returns errInputPending
+// instead of blocking, so the goroutine can wait without holding the Reader, unless
+// ZSTD still holds output of the current frame decoded from earlier input, which is
+// flushed first.
+func (r *Reader) readSource(dst []byte) (int, error) {
+	if r.prefetch == nil {
+		return r.reader.Read(dst)
+	}
+	n, err := r.prefetch.input.tryRead(dst)
+	if err == errInputPending && r.flushing && r.inFrame {
+		return 0, nil
+	}
+	return n, err
+}
@@ -0,0 +1,80 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Recompress decompresses the stream from src and compresses its content again to dst at
+// the level (0 = the instance default), configured by opts, in a single pass. It suits
+// cold storage jobs upgrading archives to a higher level. The content becomes a single
+// frame; skippable frames are kept in place, except a seek table, whose offsets would no
+// longer match. src is decompressed with the defaults of the instance, such as its
+// dictionary registry. It returns the size of the content.
+func (z *Zstd) Recompress(dst io.Writer, src io.Reader, level int, opts ...Option) (int64, error) {
+	return z.recompress(dst, src, level, false, opts)
+}
+
+// RecompressFrames is like Recompress, but keeps the frame boundaries of src: each of
+// its frames becomes a frame of dst with the same content, so independently decodable
+// chunks stay independent.
+func (z *Zstd) RecompressFrames(dst io.Writer, src io.Reader, level int, opts ...Option) (int64, error) {
+	return z.recompress(dst, src, level, true, opts)
+}
+
+// recompress copies the content of src to a Writer on dst, ending a frame wherever src
+// does if frames is set
+func (z *Zstd) recompress(dst io.Writer, src io.Reader, level int, frames bool, opts []Option) (int64, error) {
+	if z.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+
+	w := z.NewWriter(dst, level, opts...)
+	r := z.NewReader(src, WithSkippableFrameHandler(func(magicVariant uint32, payload []byte) error {
+		if isSeekTable(magicVariant, payload) {
+			return nil
+		}
+		return w.WriteSkippableFrame(magicVariant, payload)
+	}))
+	defer r.Close()
+	r.frameEnds = frames
+	r.startOperation()
+
+	// Hand the decompressed chunks to the writer without copying them out of the reader
+	var written int64
+	for {
+		err := r.fill()
+		if r.pos < r.end {
+			n, writeErr := w.Write(r.readBuffer[r.pos:r.end])
+			written += int64(n)
+			r.delivered += int64(n)
+			r.pos = r.end
+			if writeErr != nil {
+				w.Abort()
+				return written, writeErr
+			}
+		}
+		if r.frameEnded {
+			r.frameEnded = false
+			if err := w.EndFrame(); err != nil {
+				w.Abort()
+				return written, err
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			w.Abort()
+			return written, err
+		}
+	}
+	return written, w.Close()
+}
+
+// isSeekTable reports whether a skippable frame holds the seek table of the seekable format
+func isSeekTable(magicVariant uint32, payload []byte) bool {
+	return magicVariant == seekTableMagicVariant && len(payload) >= seekTableFooterSize &&
+		binary.LittleEndian.Uint32(payload[len(payload)-4:]) == seekableMagic
+}
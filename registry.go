@@ -0,0 +1,114 @@
+package zstd
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// DictionaryRegistry holds dictionaries by ID, so data compressed with any of them can be
+// decompressed without knowing which one was used: the ID recorded in each frame picks it.
+// Given to New or a Reader with WithDictionaryRegistry, it is used by Decompress and Readers.
+//
+// Dictionaries can be replaced while in use, such as when a newly trained one is rolled out:
+// operations that acquired a dictionary keep using it, and its digested dictionaries are
+// released once the last of them is done. It is safe for concurrent use.
+type DictionaryRegistry struct {
+	mu    sync.RWMutex
+	dicts map[uint32]*registryEntry
+}
+
+// registryEntry counts the references to a registered dictionary
+type registryEntry struct {
+	dict *Dictionary
+	refs atomic.Int64 // One for the registry while registered, plus one per acquisition
+}
+
+// release drops a reference, releasing the digested dictionaries with the last one
+func (e *registryEntry) release() {
+	if e.refs.Add(-1) == 0 {
+		e.dict.Release()
+	}
+}
+
+// NewDictionaryRegistry creates an empty registry
+func NewDictionaryRegistry() *DictionaryRegistry {
+	return &DictionaryRegistry{dicts: make(map[uint32]*registryEntry)}
+}
+
+// Register adds the dictionary under its ID. A dictionary already registered with the ID
+// is replaced atomically, and released once the operations using it are done.
+// Raw content dictionaries have no ID, and can't be registered.
+func (r *DictionaryRegistry) Register(dict *Dictionary) error {
+	if dict == nil || dict.ID() == 0 {
+		return fmt.Errorf("%w: dictionary has no ID", ErrInvalidOption)
+	}
+
+	entry := &registryEntry{dict: dict}
+	entry.refs.Store(1)
+
+	r.mu.Lock()
+	old := r.dicts[dict.ID()]
+	r.dicts[dict.ID()] = entry
+	r.mu.Unlock()
+
+	if old != nil {
+		old.release()
+	}
+	return nil
+}
+
+// Unregister removes the dictionary with the ID, if any, releasing it once the
+// operations using it are done
+func (r *DictionaryRegistry) Unregister(id uint32) {
+	r.mu.Lock()
+	old := r.dicts[id]
+	delete(r.dicts, id)
+	r.mu.Unlock()
+
+	if old != nil {
+		old.release()
+	}
+}
+
+// Lookup returns the dictionary registered with the ID. The dictionary may be replaced
+// and released at any time; use Acquire to keep using it.
+func (r *DictionaryRegistry) Lookup(id uint32) (*Dictionary, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.dicts[id]
+	if !ok {
+		return nil, false
+	}
+	return entry.dict, true
+}
+
+// Acquire returns the dictionary registered with the ID, or a *MissingDictionaryError.
+// The dictionary stays usable, even if replaced in the meantime, until done is called.
+func (r *DictionaryRegistry) Acquire(id uint32) (dict *Dictionary, done func(), err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.dicts[id]
+	if !ok {
+		return nil, nil, &MissingDictionaryError{ID: id}
+	}
+
+	// Registered entries hold the registry's reference, so the count is positive here
+	entry.refs.Add(1)
+	return entry.dict, sync.OnceFunc(entry.release), nil
+}
+
+// acquireFrame acquires the dictionary for the ID a frame records. A frame that records
+// none gets a nil dictionary and a no-op done function.
+func (r *DictionaryRegistry) acquireFrame(id uint32) (*Dictionary, func(), error) {
+	if id == 0 {
+		return nil, func() {}, nil
+	}
+	return r.Acquire(id)
+}
+
+// frameDictionary acquires the registered dictionary the first frame of src needs, if any
+func (z *Zstd) frameDictionary(src []byte) (*Dictionary, func(), error) {
+	return z.registry.acquireFrame(z.getDictIDFromFrame(unsafe.Pointer(&src[0]), uint64(len(src))))
+}
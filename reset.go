@@ -0,0 +1,161 @@
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"runtime"
+)
+
+// Reset discards the reader's state and makes it decompress a new stream from src with
+// the dictionary dict (nil = none), keeping its options and native decompression state.
+// It implements flate.Resetter. A dictionary given as an option is replaced as well.
+func (r *Reader) Reset(src io.Reader, dict []byte) error {
+	if r.zstd.isClosed() {
+		return ErrAlreadyClosed
+	}
+
+	r.endOperation()
+	r.reset(src)
+
+	// Keep the digested dictionary when it is the one in use already
+	if r.dict != nil && bytes.Equal(r.dict.dictData, dict) {
+		return nil
+	}
+	if r.dict == nil && len(dict) == 0 {
+		return nil
+	}
+
+	// The stream is recreated, with the new dictionary referenced, on the next read
+	if r.stream != nil {
+		r.zstd.freeDStream(r.stream)
+		r.stream = nil
+	}
+	r.dictPinner.Unpin()
+	if r.ddict != nil {
+		r.zstd.freeDDict(r.ddict)
+		r.ddict = nil
+	}
+	r.releaseDictionary()
+	r.dict = nil
+
+	if len(dict) > 0 {
+		d, err := r.zstd.LoadDictionary(dict)
+		if err != nil {
+			r.err = err
+			return err
+		}
+		r.dict = d
+	}
+	return nil
+}
+
+// Reset discards the writer's state and makes it write a new stream to dst, keeping its
+// parameters and native compression state. Data written but not yet flushed is dropped
+// without ending the frame, so Close or EndFrame the previous stream first to keep it.
+// A closed writer can be reset, unless its instance was closed as well.
+func (w *Writer) Reset(dst io.Writer) {
+	if w.flushTimer != nil {
+		w.flushTimer.Stop()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// A frame in progress is abandoned
+	if w.inFrame {
+		w.endOperation(ErrAborted)
+	} else {
+		w.endOperation(nil)
+	}
+
+	// Restart the current frame, if any, keeping the parameters set on the stream
+	if w.stream != nil && !w.zstd.isClosed() {
+		w.zstd.cctxReset(w.stream, resetSessionOnly)
+		if w.contentSize > 0 {
+			w.zstd.cctxSetPledgedSize(w.stream, uint64(w.contentSize))
+		}
+	}
+
+	// Closing freed the native state of a writer outside a pool, along with its finalizer
+	if w.closed && w.pool == nil {
+		runtime.SetFinalizer(w, (*Writer).finalize)
+	}
+	if w.buffer == nil {
+		w.buffer = getBuffer(w.bufferSize)
+	}
+	w.closed = false
+
+	w.writer = dst
+	w.pending = w.pending[:0]
+	w.bytesIn, w.bytesOut, w.consumed = 0, 0, 0
+	w.inFrame, w.dirty = false, false
+	w.err = nil
+	if w.adapt != nil {
+		*w.adapt = *newAdapter(w.adapt.minLevel, w.adapt.maxLevel)
+	}
+}
+
+// EndFrame compresses pending data and writes the epilogue of the current frame, so the
+// output written so far is a complete stream. Later writes start a new frame, and the
+// level, checksum and window size can be changed before them. It does nothing if no
+// data has been written since the last frame ended.
+func (w *Writer) EndFrame() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || w.zstd.isClosed() {
+		return ErrAlreadyClosed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if !w.inFrame || w.stream == nil {
+		return nil
+	}
+	return w.endFrame()
+}
+
+// SetLevel changes the compression level from the next frame on. It must be called
+// between frames: before the first Write, or after Reset or EndFrame. Otherwise it
+// returns ErrMidFrame.
+func (w *Writer) SetLevel(level int) error {
+	return w.setParameter(func() { w.level = level })
+}
+
+// SetChecksum enables or disables content checksums from the next frame on.
+// It must be called between frames, like SetLevel.
+func (w *Writer) SetChecksum(checksum bool) error {
+	return w.setParameter(func() { w.checksum = checksum })
+}
+
+// SetWindowSize changes the compression window size from the next frame on (0 = level
+// default). It must be called between frames, like SetLevel.
+func (w *Writer) SetWindowSize(size int) error {
+	return w.setParameter(func() { w.windowSize = size })
+}
+
+// setParameter applies a change to the writer's parameters between frames
+func (w *Writer) setParameter(change func()) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed || w.zstd.isClosed() {
+		return ErrAlreadyClosed
+	}
+	if w.inFrame {
+		return ErrMidFrame
+	}
+
+	// Apply the change to the existing stream, restoring the previous state if rejected
+	level, checksum, windowSize := w.level, w.checksum, w.windowSize
+	change()
+	if w.stream == nil {
+		return nil
+	}
+	if err := w.applyParameters(w.stream); err != nil {
+		w.level, w.checksum, w.windowSize = level, checksum, windowSize
+		w.applyParameters(w.stream)
+		return err
+	}
+	return nil
+}
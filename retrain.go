@@ -0,0 +1,206 @@
+package zstd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// RetrainOptions configures a DictionaryTrainer
+type RetrainOptions struct {
+	Window        int             // Most recent messages kept for training (0 = 1000)
+	MaxSampleSize int             // Messages are cut to this many bytes (0 = no limit)
+	Interval      time.Duration   // Time between retrainings in Run (0 = 1 hour)
+	DictSize      int             // Size of the trained dictionaries (0 = 16KB)
+	Training      TrainingOptions // Options for TrainDictionary
+	Level         int             // Compression level dictionaries are evaluated at (0 = the instance default)
+	MinGain       float64         // Fraction by which a new dictionary must beat the current one, such as 0.02
+	Keep          int             // Dictionary versions kept registered, so older data stays decodable (0 = all)
+
+	// OnRetrain, if set, is called by Run with the outcome of each retraining
+	OnRetrain func(RetrainResult, error)
+}
+
+// RetrainResult describes the outcome of a retraining
+type RetrainResult struct {
+	Replaced   bool                 // The new dictionary won and is now current
+	ID         uint32               // ID of the current dictionary (0 = none yet)
+	Candidate  DictionaryEvaluation // Evaluation of the new dictionary on the held-out messages
+	Compressed int64                // Size of the held-out messages with the dictionary current before, or none
+}
+
+// DictionaryTrainer keeps a dictionary trained on the most recent messages of a service.
+// It holds a sliding window of the messages fed to Add; each retraining trains a dictionary
+// on the older part of the window, evaluates it against the current one on the most recent
+// fifth, and registers it in the registry if it compresses them better. Data compressed
+// with any version kept registered decompresses through the registry.
+// It is safe for concurrent use.
+type DictionaryTrainer struct {
+	zstd     *Zstd
+	registry *DictionaryRegistry
+	opts     RetrainOptions
+
+	mu       sync.Mutex
+	window   [][]byte // Ring of the most recent messages
+	next     int      // Position of the next message in window
+	current  *Dictionary
+	versions []uint32 // IDs registered by the trainer, oldest first
+
+	retrainMu sync.Mutex // Serializes retrainings
+}
+
+// NewDictionaryTrainer creates a trainer registering its dictionaries in registry
+func (z *Zstd) NewDictionaryTrainer(registry *DictionaryRegistry, opts RetrainOptions) *DictionaryTrainer {
+	if opts.Window <= 0 {
+		opts.Window = 1000
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = time.Hour
+	}
+	if opts.DictSize <= 0 {
+		opts.DictSize = 16 << 10
+	}
+	return &DictionaryTrainer{
+		zstd:     z,
+		registry: registry,
+		opts:     opts,
+		window:   make([][]byte, 0, opts.Window),
+	}
+}
+
+// Add feeds a message to the trainer, which keeps a copy of it in its window.
+// Empty messages are ignored.
+func (t *DictionaryTrainer) Add(msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+	if t.opts.MaxSampleSize > 0 && len(msg) > t.opts.MaxSampleSize {
+		msg = msg[:t.opts.MaxSampleSize]
+	}
+	msg = append([]byte(nil), msg...)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.window) < t.opts.Window {
+		t.window = append(t.window, msg)
+	} else {
+		t.window[t.next] = msg
+	}
+	t.next = (t.next + 1) % t.opts.Window
+}
+
+// Current acquires the current dictionary from the registry, to compress with it.
+// It returns a nil dictionary until a first one is trained. done must be called once
+// the dictionary is no longer used.
+func (t *DictionaryTrainer) Current() (dict *Dictionary, done func(), err error) {
+	t.mu.Lock()
+	current := t.current
+	t.mu.Unlock()
+
+	if current == nil {
+		return nil, func() {}, nil
+	}
+	return t.registry.Acquire(current.ID())
+}
+
+// Retrain trains a dictionary on the window and makes it current if it wins
+func (t *DictionaryTrainer) Retrain() (RetrainResult, error) {
+	t.retrainMu.Lock()
+	defer t.retrainMu.Unlock()
+
+	// Train on the older messages and hold out the most recent fifth for evaluation
+	train, holdOut, current := t.snapshot()
+	result := RetrainResult{}
+	if current != nil {
+		result.ID = current.ID()
+	}
+	if len(holdOut) == 0 {
+		return result, fmt.Errorf("%w: %d messages are too few to retrain", ErrEmptyInput, len(train))
+	}
+
+	dictData, _, err := t.zstd.TrainDictionary(train, t.opts.DictSize, t.opts.Training)
+	if err != nil {
+		return result, err
+	}
+	result.Candidate, err = t.zstd.EvaluateDictionary(dictData, holdOut, t.opts.Level)
+	if err != nil {
+		return result, err
+	}
+
+	result.Compressed = result.Candidate.Without.CompressedSize
+	if current != nil {
+		eval, err := t.zstd.EvaluateDictionary(current.dictData, holdOut, t.opts.Level)
+		if err != nil {
+			return result, err
+		}
+		result.Compressed = eval.With.CompressedSize
+	}
+	gain := 1 - float64(result.Candidate.With.CompressedSize)/float64(result.Compressed)
+	if gain <= t.opts.MinGain {
+		return result, nil
+	}
+
+	dict, err := t.zstd.LoadDictionary(dictData)
+	if err != nil {
+		return result, err
+	}
+	if err := t.registry.Register(dict); err != nil {
+		return result, err
+	}
+	t.promote(dict)
+	result.Replaced = true
+	result.ID = dict.ID()
+	return result, nil
+}
+
+// Run retrains at every interval until ctx is done, reporting each outcome to OnRetrain
+func (t *DictionaryTrainer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(t.opts.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+			result, err := t.Retrain()
+			if t.opts.OnRetrain != nil {
+				t.opts.OnRetrain(result, err)
+			}
+		}
+	}
+}
+
+// snapshot returns the training and held-out messages, in the order they were added,
+// and the current dictionary
+func (t *DictionaryTrainer) snapshot() (train, holdOut [][]byte, current *Dictionary) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	messages := make([][]byte, 0, len(t.window))
+	if len(t.window) == t.opts.Window {
+		messages = append(messages, t.window[t.next:]...)
+		messages = append(messages, t.window[:t.next]...)
+	} else {
+		messages = append(messages, t.window...)
+	}
+	split := len(messages) - len(messages)/5
+	return messages[:split], messages[split:], t.current
+}
+
+// promote makes the registered dictionary current, unregistering the oldest versions
+// beyond Keep
+func (t *DictionaryTrainer) promote(dict *Dictionary) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.current = dict
+	t.versions = append(t.versions, dict.ID())
+	for t.opts.Keep > 0 && len(t.versions) > t.opts.Keep {
+		// The ID may have been registered again by a later version
+		if t.versions[0] != dict.ID() {
+			t.registry.Unregister(t.versions[0])
+		}
+		t.versions = t.versions[1:]
+	}
+}
@@ -0,0 +1,195 @@
+package zstd
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotateOptions sets when a RotatingWriter starts a new file
+type RotateOptions struct {
+	MaxSize    int64         // Log output, before compression, written to a file before rotating (0 = no limit)
+	MaxAge     time.Duration // Time a file is written to before rotating (0 = no limit)
+	MaxBackups int           // Number of rotated files kept (0 = all)
+}
+
+// RotatingWriter is an io.WriteCloser writing compressed log output to a file, which it
+// rotates by size or age: foo.log.zst is renamed foo.log.1.zst, the previous foo.log.1.zst
+// becomes foo.log.2.zst and so on, and output continues in a new foo.log.zst. Every file
+// holds a single complete frame, ended when the file is rotated or the writer closed.
+// It can be used from several goroutines.
+type RotatingWriter struct {
+	zstd     *Zstd
+	path     string
+	options  RotateOptions
+	opts     []Option // Options of the Writer of each file
+	mu       sync.Mutex
+	file     *os.File
+	writer   *Writer
+	written  int64     // Log output written to the current file
+	openedAt time.Time // When the current file was created
+	closed   bool
+}
+
+// NewRotatingWriter returns a RotatingWriter writing to the file at path, whose name
+// should end in .zst. The Writer of each file is configured by opts, for example
+// WithChecksum to protect each file with a content checksum, or WithFlushInterval to
+// bound the output lost if the process dies. An existing file at path is rotated first,
+// so output is never appended to a frame that may be incomplete.
+func (z *Zstd) NewRotatingWriter(path string, options RotateOptions, opts ...Option) (*RotatingWriter, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	rw := &RotatingWriter{zstd: z, path: path, options: options, opts: opts}
+	if err := rw.open(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// Write implements the io.Writer interface. The file is rotated first if the output
+// would exceed MaxSize, or if it holds output older than MaxAge; a single write is never
+// split across files. If a previous rotation failed, the new file is created first.
+func (rw *RotatingWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return 0, ErrAlreadyClosed
+	}
+	if rw.writer == nil {
+		if err := rw.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	sizeDue := rw.options.MaxSize > 0 && rw.written > 0 && rw.written+int64(len(p)) > rw.options.MaxSize
+	ageDue := rw.options.MaxAge > 0 && rw.written > 0 && time.Since(rw.openedAt) >= rw.options.MaxAge
+	if sizeDue || ageDue {
+		if err := rw.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rw.writer.Write(p)
+	rw.written += int64(n)
+	return n, err
+}
+
+// Flush writes the output compressed so far to the current file, so it can be read
+// while the file is still being written
+func (rw *RotatingWriter) Flush() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return ErrAlreadyClosed
+	}
+	if rw.writer == nil {
+		return nil
+	}
+	return rw.writer.Flush()
+}
+
+// Rotate ends the current file and starts a new one, for example when a log shipper
+// asks for it
+func (rw *RotatingWriter) Rotate() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return ErrAlreadyClosed
+	}
+	return rw.rotate()
+}
+
+// Close ends the frame of the current file and closes it
+func (rw *RotatingWriter) Close() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+
+	if rw.closed {
+		return nil
+	}
+	rw.closed = true
+	return rw.closeFile()
+}
+
+// rotate closes the current file, renames it and creates a new one. If it fails, the
+// next Write tries again to rename the closed file and create a new one.
+func (rw *RotatingWriter) rotate() error {
+	if err := rw.closeFile(); err != nil {
+		return err
+	}
+	return rw.open()
+}
+
+// open rotates a file left at the path, if it holds anything, and creates a new one
+func (rw *RotatingWriter) open() error {
+	if info, err := os.Stat(rw.path); err == nil && info.Size() > 0 {
+		if err := rw.shift(); err != nil {
+			return err
+		}
+	}
+	return rw.create()
+}
+
+// create starts a new file at the path
+func (rw *RotatingWriter) create() error {
+	f, err := os.OpenFile(rw.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := rw.zstd.NewWriter(f, 0, rw.opts...)
+	if w.err != nil {
+		f.Close()
+		return w.err
+	}
+	rw.file, rw.writer = f, w
+	rw.written, rw.openedAt = 0, time.Now()
+	return nil
+}
+
+// closeFile ends the frame of the current file, if any, and closes it. The file is
+// dropped even if this fails, as it can't be written to anymore.
+func (rw *RotatingWriter) closeFile() error {
+	if rw.writer == nil {
+		return nil
+	}
+	err := rw.writer.Close()
+	if closeErr := rw.file.Close(); err == nil {
+		err = closeErr
+	}
+	rw.file, rw.writer = nil, nil
+	return err
+}
+
+// shift renames the file at the path to the first backup, moving the existing backups
+// one place up and replacing the oldest one once MaxBackups are kept
+func (rw *RotatingWriter) shift() error {
+	last := 1
+	for rw.options.MaxBackups == 0 || last < rw.options.MaxBackups {
+		if _, err := os.Stat(rw.backup(last)); errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		last++
+	}
+
+	for i := last; i > 1; i-- {
+		if err := os.Rename(rw.backup(i-1), rw.backup(i)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return os.Rename(rw.path, rw.backup(1))
+}
+
+// backup returns the path of the nth rotated file
+func (rw *RotatingWriter) backup(n int) string {
+	return strings.TrimSuffix(rw.path, ".zst") + "." + strconv.Itoa(n) + ".zst"
+}
@@ -0,0 +1,79 @@
+package zstd
+
+import (
+	"math/rand/v2"
+	"sync"
+)
+
+// SampleCollector keeps a uniform random sample of the messages it is fed, using reservoir
+// sampling, so a service can gather training data for TrainDictionary from live traffic
+// in bounded memory. It is safe for concurrent use.
+type SampleCollector struct {
+	mu            sync.Mutex
+	maxSamples    int
+	maxSampleSize int
+	samples       [][]byte
+	seen          int64
+}
+
+// NewSampleCollector creates a collector that keeps up to maxSamples messages, each cut
+// to at most maxSampleSize bytes (0 = no limit). The leading bytes of a message are
+// usually the most representative of its structure.
+func NewSampleCollector(maxSamples, maxSampleSize int) *SampleCollector {
+	return &SampleCollector{
+		maxSamples:    max(maxSamples, 1),
+		maxSampleSize: maxSampleSize,
+	}
+}
+
+// Add offers a message to the collector, which keeps a copy of it if it is sampled.
+// Empty messages are ignored.
+func (c *SampleCollector) Add(msg []byte) {
+	if len(msg) == 0 {
+		return
+	}
+	if c.maxSampleSize > 0 && len(msg) > c.maxSampleSize {
+		msg = msg[:c.maxSampleSize]
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.seen++
+
+	// Fill the reservoir, then replace a random sample with probability maxSamples/seen
+	if len(c.samples) < c.maxSamples {
+		c.samples = append(c.samples, append([]byte(nil), msg...))
+		return
+	}
+	if i := rand.Int64N(c.seen); i < int64(c.maxSamples) {
+		c.samples[i] = append(c.samples[i][:0], msg...)
+	}
+}
+
+// Samples returns the messages sampled so far, ready for TrainDictionary.
+// The returned slices are copies, unaffected by later calls to Add.
+func (c *SampleCollector) Samples() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	samples := make([][]byte, len(c.samples))
+	for i, s := range c.samples {
+		samples[i] = append([]byte(nil), s...)
+	}
+	return samples
+}
+
+// Seen returns the number of messages offered to the collector
+func (c *SampleCollector) Seen() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seen
+}
+
+// Reset discards the samples, so the collector starts over
+func (c *SampleCollector) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.samples = nil
+	c.seen = 0
+}
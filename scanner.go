@@ -0,0 +1,49 @@
+package zstd
+
+import (
+	"bufio"
+	"io"
+)
+
+const (
+	// scanBufferSize is the initial line buffer of a Scanner
+	scanBufferSize = 64 << 10
+
+	// maxScanLineSize is the longest line a Scanner accepts by default
+	maxScanLineSize = 16 << 20
+)
+
+// Scanner reads the lines of a compressed stream, such as a .zst log file. It is a
+// bufio.Scanner over a Reader, accepting lines up to 16MB instead of 64KB; Buffer and
+// Split change that before the first Scan. Errors decompressing are reported by Err.
+type Scanner struct {
+	*bufio.Scanner
+	reader *Reader
+}
+
+// NewScanner returns a Scanner reading the lines of the compressed stream from r
+func (z *Zstd) NewScanner(r io.Reader, opts ...Option) *Scanner {
+	return newScanner(z.NewReader(r, opts...))
+}
+
+// NewScanner returns a Scanner reading the lines of the compressed stream from r.
+// The Scanner uses a Zstandard instance of its own, which is unloaded by Close.
+func NewScanner(r io.Reader, opts ...Option) (*Scanner, error) {
+	reader, err := NewReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return newScanner(reader), nil
+}
+
+// newScanner returns a Scanner over the lines read from reader
+func newScanner(reader *Reader) *Scanner {
+	s := bufio.NewScanner(reader)
+	s.Buffer(make([]byte, 0, scanBufferSize), maxScanLineSize)
+	return &Scanner{Scanner: s, reader: reader}
+}
+
+// Close releases the Reader of the scanner. It does not close the underlying reader.
+func (s *Scanner) Close() error {
+	return s.reader.Close()
+}
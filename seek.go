@@ -0,0 +1,148 @@
+package zstd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+)
+
+// Seek implements the io.Seeker interface over the decompressed stream, so the Reader
+// can back formats such as tar that skip entries by seeking. Seeking forward decompresses
+// the data in between and discards it without copying. Seeking backward restarts
+// decompression from the beginning of the stream, which requires the source to implement
+// io.Seeker. Seeking relative to the end is not supported, as the decompressed size is
+// generally unknown. Seeking past the end of the stream stops there and returns the
+// offset of the end.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	if r.closed || r.zstd.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+
+	var target int64
+	switch whence {
+	case io.SeekStart:
+		target = offset
+	case io.SeekCurrent:
+		target = r.delivered + offset
+	case io.SeekEnd:
+		return r.delivered, errors.New("zstd: seeking relative to the end of the stream is not supported")
+	default:
+		return r.delivered, fmt.Errorf("zstd: invalid whence %d", whence)
+	}
+	if target < 0 {
+		return r.delivered, fmt.Errorf("zstd: negative position %d", target)
+	}
+
+	if target < r.delivered {
+		if err := r.rewind(); err != nil {
+			return r.delivered, err
+		}
+	}
+
+	_, err := r.discard(target - r.delivered)
+	if err == io.EOF {
+		err = nil
+	}
+	return r.delivered, err
+}
+
+// Discard skips the next n decompressed bytes without copying them out, which is cheaper
+// than reading them into a scratch buffer when skipping records. It returns the number
+// of bytes discarded; if that is less than n, it also returns the error that stopped it,
+// io.EOF if the stream ended.
+func (r *Reader) Discard(n int64) (int64, error) {
+	if r.closed || r.zstd.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("zstd: negative discard count %d", n)
+	}
+	return r.discard(n)
+}
+
+// discard skips the next n decompressed bytes without copying them out
+func (r *Reader) discard(n int64) (int64, error) {
+	if r.readAhead > 0 {
+		if r.prefetch == nil {
+			r.startPrefetch()
+		}
+		skipped, err := r.prefetch.discard(n)
+		r.delivered += skipped
+		return skipped, err
+	}
+
+	var skipped int64
+	for skipped < n {
+		if err := r.fill(); err != nil {
+			return skipped, err
+		}
+
+		m := min(int64(r.end-r.pos), n-skipped)
+		r.pos += int(m)
+		r.delivered += m
+		skipped += m
+	}
+	return skipped, nil
+}
+
+// rewind moves the source back to where the stream started and restarts decompression
+func (r *Reader) rewind() error {
+	seeker, ok := r.reader.(io.Seeker)
+	if !ok {
+		return errors.New("zstd: cannot seek backward: source is not an io.Seeker")
+	}
+
+	// Stop the readahead goroutines before touching the decoding state; they restart on the
+	// next Read. The source moved past what the decoder took by the input pumped ahead.
+	if r.prefetch != nil {
+		r.prefetch.stop()
+		<-r.prefetch.input.exited
+		r.totalIn += r.prefetch.input.unread()
+		r.prefetch = nil
+	}
+
+	// Everything read from the source so far belongs to the stream
+	if _, err := seeker.Seek(-r.totalIn, io.SeekCurrent); err != nil {
+		return fmt.Errorf("zstd: rewinding source: %w", err)
+	}
+
+	r.restart()
+	return nil
+}
+
+// reset makes the reader decompress a new stream from src, reusing its buffers and
+// native decompression state
+func (r *Reader) reset(src io.Reader) {
+	if r.prefetch != nil {
+		r.prefetch.stop()
+		r.prefetch = nil
+	}
+
+	// Closing freed the native state of a reader outside a pool, along with its finalizer
+	if r.closed && r.pool == nil {
+		runtime.SetFinalizer(r, (*Reader).finalize)
+	}
+	if r.readBuffer == nil {
+		r.buffer, r.readBuffer = getBuffer(r.bufferSize), getBuffer(r.bufferSize)
+	}
+	r.closed = false
+
+	r.reader = src
+	r.progressTotal = sourceSize(src)
+	r.dropRegistryDictionary()
+	r.restart()
+}
+
+// restart clears the decoding state so decompression starts over from the source
+func (r *Reader) restart() {
+	if r.stream != nil && !r.zstd.isClosed() {
+		r.zstd.dctxReset(r.stream, resetSessionOnly)
+	}
+	r.inBuffer = ZstdInBuffer{}
+	r.pos, r.end = 0, 0
+	r.streamEnded, r.inFrame, r.sourceEOF, r.flushing = false, false, false, false
+	r.totalIn, r.totalOut, r.delivered = 0, 0, 0
+	r.err = nil
+	r.header = nil
+}
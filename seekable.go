@@ -0,0 +1,437 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Seekable format constants, from the reference implementation in contrib/seekable_format
+const (
+	seekTableMagicVariant = 0xE        // Skippable frame variant holding the seek table, magic 0x184D2A5E
+	seekableMagic         = 0x8F92EAB1 // ZSTD_SEEKABLE_MAGICNUMBER, ending the seek table
+	seekTableFooterSize   = 9          // Number of frames, descriptor and magic number
+	seekableMaxFrames     = 0x8000000  // ZSTD_SEEKABLE_MAXFRAMES
+	seekableMaxFrameSize  = 1 << 30    // ZSTD_SEEKABLE_MAX_FRAME_DECOMPRESSED_SIZE
+	seekableChecksumFlag  = 1 << 7     // Seek table descriptor bit: entries carry checksums
+	defaultSeekFrameSize  = 1 << 20
+)
+
+// seekEntry describes a frame of a seekable stream
+type seekEntry struct {
+	compressedSize   uint32
+	decompressedSize uint32
+	checksum         uint32 // Low 32 bits of the XXH64 of the decompressed content
+}
+
+// SeekableWriter compresses to the zstd seekable format: independent frames of a fixed
+// decompressed size followed by a seek table in a skippable frame, so the content can
+// later be read from any offset by decompressing only the frames covering it. Any zstd
+// decoder reads the output as a regular stream. It is not safe for concurrent use.
+type SeekableWriter struct {
+	zstd      *Zstd
+	writer    io.Writer
+	frameSize int
+	level     int
+	buffer    []byte      // Content of the frame being filled
+	entries   []seekEntry // Frames written so far
+	err       error       // Sticky error
+	closed    bool
+}
+
+// NewSeekableWriter creates a SeekableWriter compressing frames of frameSize bytes of
+// content (0 = 1MB, at most 1GB) at the level (0 = the instance default). Smaller frames
+// make random access cheaper at some cost in compression ratio.
+// The caller must call Close() to write the last frame and the seek table.
+func (z *Zstd) NewSeekableWriter(w io.Writer, frameSize, level int) *SeekableWriter {
+	sw := &SeekableWriter{
+		zstd:      z,
+		writer:    w,
+		frameSize: frameSize,
+		level:     z.resolveLevel(level),
+	}
+	if sw.frameSize == 0 {
+		sw.frameSize = defaultSeekFrameSize
+	}
+	if sw.frameSize < 0 || sw.frameSize > seekableMaxFrameSize {
+		sw.err = fmt.Errorf("%w: frame size %d is outside 1 to %d", ErrInvalidOption, frameSize, seekableMaxFrameSize)
+	}
+	return sw
+}
+
+// Write implements the io.Writer interface
+func (sw *SeekableWriter) Write(p []byte) (int, error) {
+	if sw.closed || sw.zstd.isClosed() {
+		return 0, ErrAlreadyClosed
+	}
+	if sw.err != nil {
+		return 0, sw.err
+	}
+
+	written := 0
+	for len(p) > 0 {
+		n := min(len(p), sw.frameSize-len(sw.buffer))
+		sw.buffer = append(sw.buffer, p[:n]...)
+		p = p[n:]
+		written += n
+
+		if len(sw.buffer) == sw.frameSize {
+			if err := sw.writeFrame(); err != nil {
+				return written, err
+			}
+		}
+	}
+	return written, nil
+}
+
+// Flush ends the current frame early, so the content written so far can be read back
+// without the next one. The seek table is only written by Close.
+func (sw *SeekableWriter) Flush() error {
+	if sw.closed || sw.zstd.isClosed() {
+		return ErrAlreadyClosed
+	}
+	if sw.err != nil {
+		return sw.err
+	}
+	if len(sw.buffer) == 0 {
+		return nil
+	}
+	return sw.writeFrame()
+}
+
+// Close writes the last frame and the seek table. It does not close the underlying writer.
+func (sw *SeekableWriter) Close() error {
+	if sw.closed {
+		return nil
+	}
+	if err := sw.Flush(); err != nil {
+		sw.closed = true
+		return err
+	}
+	sw.closed = true
+
+	_, err := sw.writer.Write(seekTable(sw.entries))
+	return err
+}
+
+// seekTable encodes the seek table of the frames, with their checksums: a skippable
+// frame header, one entry per frame, then the footer
+func seekTable(entries []seekEntry) []byte {
+	entrySize := 12
+	size := len(entries)*entrySize + seekTableFooterSize
+	table := make([]byte, 0, skippableHeaderSize+size)
+	table = binary.LittleEndian.AppendUint32(table, skippableMagicStart+seekTableMagicVariant)
+	table = binary.LittleEndian.AppendUint32(table, uint32(size))
+	for _, e := range entries {
+		table = binary.LittleEndian.AppendUint32(table, e.compressedSize)
+		table = binary.LittleEndian.AppendUint32(table, e.decompressedSize)
+		table = binary.LittleEndian.AppendUint32(table, e.checksum)
+	}
+	table = binary.LittleEndian.AppendUint32(table, uint32(len(entries)))
+	table = append(table, seekableChecksumFlag)
+	table = binary.LittleEndian.AppendUint32(table, seekableMagic)
+	return table
+}
+
+// writeFrame compresses the buffered content into an independent frame
+func (sw *SeekableWriter) writeFrame() error {
+	if len(sw.entries) == seekableMaxFrames {
+		sw.err = fmt.Errorf("%w: more than %d frames", ErrInputTooLarge, seekableMaxFrames)
+		return sw.err
+	}
+
+	frame, err := sw.zstd.Compress(sw.buffer, sw.level)
+	if err != nil {
+		sw.err = err
+		return err
+	}
+	if _, err := sw.writer.Write(frame); err != nil {
+		sw.err = err
+		return err
+	}
+
+	sw.entries = append(sw.entries, seekEntry{
+		compressedSize:   uint32(len(frame)),
+		decompressedSize: uint32(len(sw.buffer)),
+		checksum:         uint32(xxhash64(sw.buffer)),
+	})
+	sw.buffer = sw.buffer[:0]
+	return nil
+}
+
+// SeekableReader reads the content of data in the zstd seekable format, as written by
+// SeekableWriter or the reference implementation, decompressing only the frames covering
+// the requested range. It implements io.ReaderAt, which is safe for concurrent use, and
+// io.ReadSeeker, which is not.
+type SeekableReader struct {
+	zstd      *Zstd
+	src       io.ReaderAt
+	frames    []seekFrame
+	size      int64    // Size of the content
+	checksums bool     // Entries carry checksums of the frame contents
+	file      *os.File // File opened by OpenSeekableFile, released by Close
+	data      []byte   // Source mapped into memory, read instead of src if set
+
+	mu     sync.Mutex
+	cached int    // Index of the frame in cache, or -1
+	cache  []byte // Content of the most recently read frame
+
+	pos int64 // Offset of the next Read
+}
+
+// seekFrame locates a frame of a seekable stream
+type seekFrame struct {
+	seekEntry
+	compressedOffset   int64
+	decompressedOffset int64
+}
+
+// NewSeekableReader parses the seek table at the end of the size bytes of r
+func (z *Zstd) NewSeekableReader(r io.ReaderAt, size int64) (*SeekableReader, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	sr, tableSize, err := z.readSeekTable(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if sr.compressedSize() > size-tableSize {
+		return nil, fmt.Errorf("%w: seek table lists more data than present", ErrCorruptedData)
+	}
+	sr.src = r
+	return sr, nil
+}
+
+// readSeekTable parses the seek table at the end of the size bytes of r, returning a
+// SeekableReader without a source and the size of the table
+func (z *Zstd) readSeekTable(r io.ReaderAt, size int64) (*SeekableReader, int64, error) {
+	var footer [seekTableFooterSize]byte
+	if size < skippableHeaderSize+seekTableFooterSize {
+		return nil, 0, fmt.Errorf("%w: no seek table", ErrCorruptedData)
+	}
+	if _, err := r.ReadAt(footer[:], size-seekTableFooterSize); err != nil {
+		return nil, 0, err
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != seekableMagic {
+		return nil, 0, fmt.Errorf("%w: no seek table", ErrCorruptedData)
+	}
+	nbFrames := int64(binary.LittleEndian.Uint32(footer[:]))
+	descriptor := footer[4]
+	if descriptor&0x7C != 0 || nbFrames > seekableMaxFrames {
+		return nil, 0, fmt.Errorf("%w: invalid seek table", ErrCorruptedData)
+	}
+
+	sr := &SeekableReader{zstd: z, checksums: descriptor&seekableChecksumFlag != 0, cached: -1}
+	entrySize := int64(8)
+	if sr.checksums {
+		entrySize = 12
+	}
+	tableSize := skippableHeaderSize + nbFrames*entrySize + seekTableFooterSize
+	if tableSize > size {
+		return nil, 0, fmt.Errorf("%w: truncated seek table", ErrCorruptedData)
+	}
+	table := make([]byte, tableSize)
+	if _, err := r.ReadAt(table, size-tableSize); err != nil {
+		return nil, 0, err
+	}
+	if binary.LittleEndian.Uint32(table) != skippableMagicStart+seekTableMagicVariant ||
+		int64(binary.LittleEndian.Uint32(table[4:])) != tableSize-skippableHeaderSize {
+		return nil, 0, fmt.Errorf("%w: invalid seek table", ErrCorruptedData)
+	}
+
+	sr.frames = make([]seekFrame, nbFrames)
+	var compressed int64
+	for i := range sr.frames {
+		e := table[skippableHeaderSize+int64(i)*entrySize:]
+		f := &sr.frames[i]
+		f.compressedSize = binary.LittleEndian.Uint32(e)
+		f.decompressedSize = binary.LittleEndian.Uint32(e[4:])
+		if f.decompressedSize > seekableMaxFrameSize {
+			return nil, 0, fmt.Errorf("%w: seek table frame %d of %d bytes exceeds %d", ErrCorruptedData, i, f.decompressedSize, seekableMaxFrameSize)
+		}
+		if sr.checksums {
+			f.checksum = binary.LittleEndian.Uint32(e[8:])
+		}
+		f.compressedOffset = compressed
+		f.decompressedOffset = sr.size
+		compressed += int64(f.compressedSize)
+		sr.size += int64(f.decompressedSize)
+	}
+	return sr, tableSize, nil
+}
+
+// compressedSize returns the size of the frames the seek table lists
+func (sr *SeekableReader) compressedSize() int64 {
+	if len(sr.frames) == 0 {
+		return 0
+	}
+	last := sr.frames[len(sr.frames)-1]
+	return last.compressedOffset + int64(last.compressedSize)
+}
+
+// Size returns the size of the content
+func (sr *SeekableReader) Size() int64 {
+	return sr.size
+}
+
+// NumFrames returns the number of frames the content is split in
+func (sr *SeekableReader) NumFrames() int {
+	return len(sr.frames)
+}
+
+// ReadAt implements the io.ReaderAt interface
+func (sr *SeekableReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("zstd: negative offset %d", off)
+	}
+
+	n := 0
+	for n < len(p) && off < sr.size {
+		// First frame ending after off
+		i := sort.Search(len(sr.frames), func(i int) bool {
+			f := sr.frames[i]
+			return f.decompressedOffset+int64(f.decompressedSize) > off
+		})
+		copied, err := sr.copyFrame(i, p[n:], off-sr.frames[i].decompressedOffset)
+		if err != nil {
+			return n, err
+		}
+		n += copied
+		off += int64(copied)
+	}
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// DecompressAt returns the n bytes of content at offset off, decompressing only the
+// frames covering them. Unlike ReadAt, it bypasses the cache of the last frame read, so
+// concurrent point reads don't wait for each other. If the content ends before off+n,
+// the bytes up to the end are returned with io.EOF.
+func (sr *SeekableReader) DecompressAt(off, n int64) ([]byte, error) {
+	if off < 0 || n < 0 {
+		return nil, fmt.Errorf("zstd: invalid range of %d bytes at %d", n, off)
+	}
+	end := sr.size
+	if off < end && n < end-off {
+		end = off + n
+	}
+
+	out := make([]byte, 0, max(end-off, 0))
+	i := sort.Search(len(sr.frames), func(i int) bool {
+		f := sr.frames[i]
+		return f.decompressedOffset+int64(f.decompressedSize) > off
+	})
+	for ; off < end; i++ {
+		content, err := sr.decompressFrame(i)
+		if err != nil {
+			return out, err
+		}
+		start := off - sr.frames[i].decompressedOffset
+		take := min(int64(len(content))-start, end-off)
+		out = append(out, content[start:start+take]...)
+		off += take
+	}
+	if int64(len(out)) < n {
+		return out, io.EOF
+	}
+	return out, nil
+}
+
+// Read implements the io.Reader interface
+func (sr *SeekableReader) Read(p []byte) (int, error) {
+	if sr.pos >= sr.size {
+		return 0, io.EOF
+	}
+	n, err := sr.ReadAt(p, sr.pos)
+	sr.pos += int64(n)
+	if err == io.EOF && n > 0 {
+		err = nil
+	}
+	return n, err
+}
+
+// Seek implements the io.Seeker interface, over the content
+func (sr *SeekableReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += sr.pos
+	case io.SeekEnd:
+		offset += sr.size
+	default:
+		return 0, fmt.Errorf("zstd: invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("zstd: negative position %d", offset)
+	}
+	sr.pos = offset
+	return offset, nil
+}
+
+// Close releases the file opened by OpenSeekableFile, unmapping it if mapped. It does
+// nothing for readers created by NewSeekableReader. Reads must not run concurrently with
+// Close, and fail afterwards.
+func (sr *SeekableReader) Close() error {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.file == nil {
+		return nil
+	}
+
+	err := unmapFile(sr.data)
+	if closeErr := sr.file.Close(); err == nil {
+		err = closeErr
+	}
+	// Reads from the closed file fail, instead of from the released mapping
+	sr.src, sr.data, sr.cached, sr.cache = sr.file, nil, -1, nil
+	sr.file = nil
+	return err
+}
+
+// copyFrame copies the content of frame i from offset off into p
+func (sr *SeekableReader) copyFrame(i int, p []byte, off int64) (int, error) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+
+	if sr.cached != i {
+		content, err := sr.decompressFrame(i)
+		if err != nil {
+			return 0, err
+		}
+		sr.cache, sr.cached = content, i
+	}
+	return copy(p, sr.cache[off:]), nil
+}
+
+// decompressFrame reads and decompresses frame i, checking it against the seek table
+func (sr *SeekableReader) decompressFrame(i int) ([]byte, error) {
+	f := sr.frames[i]
+	var frame []byte
+	if sr.data != nil {
+		// Decompress straight from the mapping
+		frame = sr.data[f.compressedOffset : f.compressedOffset+int64(f.compressedSize)]
+	} else {
+		frame = make([]byte, f.compressedSize)
+		if _, err := sr.src.ReadAt(frame, f.compressedOffset); err != nil {
+			return nil, err
+		}
+	}
+
+	content, err := sr.zstd.Decompress(frame, int(f.decompressedSize))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) != int(f.decompressedSize) {
+		return nil, fmt.Errorf("%w: frame %d has %d bytes, seek table lists %d", ErrCorruptedData, i, len(content), f.decompressedSize)
+	}
+	if sr.checksums && uint32(xxhash64(content)) != f.checksum {
+		return nil, fmt.Errorf("%w: frame %d", ErrChecksumWrong, i)
+	}
+	return content, nil
+}
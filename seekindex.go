@@ -0,0 +1,73 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+)
+
+// BuildSeekTable scans the size bytes of r, an ordinary stream of one or more frames,
+// and returns a seek table indexing its frames, so streams not written by a
+// SeekableWriter can be read at random through NewIndexedReader. Each frame is
+// decompressed once to record its size and checksum. The table is a skippable frame, so
+// it can also be appended to the stream, which NewSeekableReader then reads directly.
+// Random access is at the granularity of frames, which must be decompressed from their
+// start: a stream of a single frame is indexed, but gains nothing. Frames must hold at
+// most 1GB of content.
+func (z *Zstd) BuildSeekTable(r io.ReaderAt, size int64) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	var entries []seekEntry
+	err := z.walkFrames(r, size, func(info frameInfo) error {
+		if len(entries) == seekableMaxFrames {
+			return fmt.Errorf("%w: more than %d frames", ErrInputTooLarge, seekableMaxFrames)
+		}
+		if info.size > math.MaxUint32 ||
+			!info.skippable && info.header.frameContentSize != contentSizeUnknown && info.header.frameContentSize > seekableMaxFrameSize {
+			return fmt.Errorf("%w: frame at offset %d is too large for a seek table", ErrInputTooLarge, info.offset)
+		}
+
+		frame := make([]byte, info.size)
+		if _, err := r.ReadAt(frame, info.offset); err != nil {
+			return err
+		}
+		content, err := z.decompressFrame(frame)
+		if err != nil {
+			return fmt.Errorf("frame at offset %d: %w", info.offset, err)
+		}
+		if len(content) > seekableMaxFrameSize {
+			return fmt.Errorf("%w: frame at offset %d is too large for a seek table", ErrInputTooLarge, info.offset)
+		}
+		entries = append(entries, seekEntry{
+			compressedSize:   uint32(info.size),
+			decompressedSize: uint32(len(content)),
+			checksum:         uint32(xxhash64(content)),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return seekTable(entries), nil
+}
+
+// NewIndexedReader returns a SeekableReader over the size bytes of r, an ordinary
+// stream, using the seek table BuildSeekTable returned for it
+func (z *Zstd) NewIndexedReader(r io.ReaderAt, size int64, table []byte) (*SeekableReader, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	sr, tableSize, err := z.readSeekTable(bytes.NewReader(table), int64(len(table)))
+	if err != nil {
+		return nil, err
+	}
+	if tableSize != int64(len(table)) || sr.compressedSize() != size {
+		return nil, fmt.Errorf("%w: seek table doesn't match the stream", ErrCorruptedData)
+	}
+	sr.src = r
+	return sr, nil
+}
@@ -0,0 +1,99 @@
+package zstd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// selfTestSize spans several compression blocks, so block handling is exercised
+const selfTestSize = 256 * 1024
+
+// SelfTest checks that the loaded library works by compressing and decompressing a
+// sample, in one shot and as a stream, and with the instance's dictionary if it was
+// given one. Deployments can call it at startup to fail fast when the extracted library
+// is broken, such as one built for another architecture or libc.
+func (z *Zstd) SelfTest() error {
+	if z.isClosed() {
+		return ErrAlreadyClosed
+	}
+	if z.versionNumber() == 0 {
+		return fmt.Errorf("zstd: self-test: library reports no version")
+	}
+
+	sample := selfTestSample()
+
+	// One-shot round trip
+	compressed, err := z.Compress(sample, DefaultCompression)
+	if err != nil {
+		return fmt.Errorf("zstd: self-test: %w", err)
+	}
+	if len(compressed) >= len(sample) {
+		return fmt.Errorf("zstd: self-test: sample did not compress (%d to %d bytes)", len(sample), len(compressed))
+	}
+	decompressed, err := z.Decompress(compressed, len(sample))
+	if err != nil {
+		return fmt.Errorf("zstd: self-test: %w", err)
+	}
+	if !bytes.Equal(decompressed, sample) {
+		return fmt.Errorf("zstd: self-test: one-shot round trip returned different data")
+	}
+
+	// Streaming round trip, with a checksum verified by the decoder
+	var buf bytes.Buffer
+	w := z.NewWriterOptions(&buf, Options{CompressionLevel: DefaultCompression, Checksum: true})
+	if _, err := w.Write(sample); err != nil {
+		w.Abort()
+		return fmt.Errorf("zstd: self-test: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("zstd: self-test: %w", err)
+	}
+	r := z.NewReaderOptions(&buf, Options{})
+	decompressed, err = io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return fmt.Errorf("zstd: self-test: %w", err)
+	}
+	if !bytes.Equal(decompressed, sample) {
+		return fmt.Errorf("zstd: self-test: streaming round trip returned different data")
+	}
+
+	// Dictionary round trip, if the instance was given a dictionary
+	if dictData := z.options().Dictionary; len(dictData) > 0 {
+		dict, err := z.LoadDictionary(dictData)
+		if err != nil {
+			return fmt.Errorf("zstd: self-test: %w", err)
+		}
+		compressed, err := z.CompressUsingDict(sample, dict, DefaultCompression)
+		if err != nil {
+			return fmt.Errorf("zstd: self-test: %w", err)
+		}
+		decompressed, err := z.DecompressUsingDict(compressed, dict, len(sample))
+		if err != nil {
+			return fmt.Errorf("zstd: self-test: %w", err)
+		}
+		if !bytes.Equal(decompressed, sample) {
+			return fmt.Errorf("zstd: self-test: dictionary round trip returned different data")
+		}
+	}
+
+	return nil
+}
+
+// selfTestSample returns deterministic data mixing compressible text with noise
+func selfTestSample() []byte {
+	sample := make([]byte, 0, selfTestSize)
+	state := uint32(1)
+	for i := 0; len(sample) < selfTestSize; i++ {
+		sample = fmt.Appendf(sample, "record %d: the quick brown fox jumps over the lazy dog\n", i)
+		for j := 0; j < 16; j++ {
+			// xorshift keeps the noise reproducible without math/rand
+			state ^= state << 13
+			state ^= state >> 17
+			state ^= state << 5
+			sample = append(sample, byte(state))
+		}
+	}
+	return sample[:selfTestSize]
+}
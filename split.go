@@ -0,0 +1,157 @@
+package zstd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+// SplitFrames returns the frames of src, including skippable ones, so they can be
+// stored or transferred one by one. The slices share the memory of src.
+func (z *Zstd) SplitFrames(src []byte) ([][]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	var frames [][]byte
+	for len(src) > 0 {
+		size := z.findFrameCompressedSize(unsafe.Pointer(&src[0]), uint64(len(src)))
+		if z.isError(size) != 0 {
+			return nil, z.nativeError("split frames", size)
+		}
+		frames = append(frames, src[:size:size])
+		src = src[size:]
+	}
+	return frames, nil
+}
+
+// MergeFrames concatenates parts holding one or more frames each into a single stream.
+// Every part is checked to consist of complete frames first, so a truncated or corrupted
+// part doesn't damage the frames after it.
+func (z *Zstd) MergeFrames(parts ...[]byte) ([]byte, error) {
+	size := 0
+	for i, part := range parts {
+		if _, err := z.SplitFrames(part); err != nil {
+			return nil, fmt.Errorf("zstd: part %d: %w", i, err)
+		}
+		size += len(part)
+	}
+
+	merged := make([]byte, 0, size)
+	for _, part := range parts {
+		merged = append(merged, part...)
+	}
+	return merged, nil
+}
+
+// SplitFile writes each frame of the .zst file at path to a file of its own in dir,
+// named after the file and the position of the frame, zero-padded so the names sort in
+// order: data.0.zst to data.9.zst for a data.zst of ten frames. It returns their paths
+// in order. Skippable frames get a file too, so MergeFiles restores the original. The
+// whole file is checked before any frame is written, reading only frame and block headers.
+func (z *Zstd) SplitFile(path, dir string) ([]string, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	frames, err := z.fileFrames(f)
+	if err != nil {
+		return nil, fmt.Errorf("zstd: split %s: %w", path, err)
+	}
+
+	// Pad the positions so the names sort in order
+	base := strings.TrimSuffix(filepath.Base(path), ".zst")
+	width := len(strconv.Itoa(len(frames) - 1))
+	paths := make([]string, 0, len(frames))
+	for i, frame := range frames {
+		name := filepath.Join(dir, fmt.Sprintf("%s.%0*d.zst", base, width, i))
+		if err := writeFrameFile(name, io.NewSectionReader(f, frame.offset, frame.size)); err != nil {
+			return paths, err
+		}
+		paths = append(paths, name)
+	}
+	return paths, nil
+}
+
+// MergeFiles concatenates the .zst files at parts into the file at dst, replacing it
+// atomically. Every part is checked to consist of complete frames first, reading only
+// frame and block headers, so a truncated or corrupted part leaves dst untouched.
+func (z *Zstd) MergeFiles(dst string, parts ...string) error {
+	if z.isClosed() {
+		return ErrAlreadyClosed
+	}
+
+	files := make([]*os.File, len(parts))
+	for i, part := range parts {
+		f, err := os.Open(part)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := z.fileFrames(f); err != nil {
+			return fmt.Errorf("zstd: merge %s: %w", part, err)
+		}
+		files[i] = f
+	}
+
+	// Write to a temporary file next to the destination, so readers never see a partial file
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	for _, f := range files {
+		if _, err := io.Copy(out, f); err != nil {
+			out.Close()
+			return err
+		}
+	}
+	if err := out.Chmod(0o644); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(out.Name(), dst)
+}
+
+// fileFrames returns the frames of the file
+func (z *Zstd) fileFrames(f *os.File) ([]frameInfo, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var frames []frameInfo
+	err = z.walkFrames(f, info.Size(), func(frame frameInfo) error {
+		frames = append(frames, frame)
+		return nil
+	})
+	return frames, err
+}
+
+// writeFrameFile creates the file at path with the content of r
+func writeFrameFile(path string, r io.Reader) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,192 @@
+package zstd
+
+import (
+	"database/sql/driver"
+	"encoding/binary"
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// compressedBytesMagic starts the header of values stored by CompressedBytes. It can't
+// start UTF-8 text, so values stored before a column switched to CompressedBytes are
+// told apart and read as they are.
+const compressedBytesMagic = 0xB5
+
+// compressedBytesVersion is the version of the header of CompressedBytes values
+const compressedBytesVersion = 1
+
+// compressedBytesDictionary flags values compressed with a dictionary, which has no ID
+// if it is raw content
+const compressedBytesDictionary = 1 << 0
+
+// SQLOptions configures how every CompressedBytes value is stored
+type SQLOptions struct {
+	Level      int         // Compression level (0 = DefaultCompression)
+	Dictionary *Dictionary // Dictionary new values are compressed with (nil = none)
+
+	// Dictionaries provides the dictionaries values stored earlier were compressed with,
+	// by ID, once Dictionary changed
+	Dictionaries *DictionaryRegistry
+
+	// MaxSize is the largest content a stored value may decompress to, as the size its
+	// header records is not trusted with an allocation (0 = 64MB)
+	MaxSize int64
+}
+
+// The library instance and options of CompressedBytes, loaded and set on first use
+var (
+	sqlOnce     sync.Once
+	sqlInstance *Zstd
+	sqlErr      error
+	sqlOptions  atomic.Pointer[SQLOptions]
+)
+
+// SetSQLOptions sets how CompressedBytes values are compressed from now on
+func SetSQLOptions(opts SQLOptions) {
+	sqlOptions.Store(&opts)
+}
+
+// sqlZstd returns the instance and options of CompressedBytes
+func sqlZstd() (*Zstd, SQLOptions, error) {
+	sqlOnce.Do(func() {
+		sqlInstance, sqlErr = New()
+	})
+	var opts SQLOptions
+	if stored := sqlOptions.Load(); stored != nil {
+		opts = *stored
+	}
+	return sqlInstance, opts, sqlErr
+}
+
+// CompressedBytes is a byte slice stored compressed in a database column of a binary
+// type, so large JSON documents or blobs are compressed by changing the type of a field.
+// Stored values start with a header recording the level, the dictionary used if any and
+// the size of the content, followed by a Zstandard frame. Values without a valid header
+// and frame, written before the column was compressed, are read as they are. A nil slice
+// is NULL.
+// The level and dictionary are set for all values by SetSQLOptions.
+type CompressedBytes []byte
+
+// Value implements the driver.Valuer interface, compressing the bytes
+func (c CompressedBytes) Value() (driver.Value, error) {
+	if c == nil {
+		return nil, nil
+	}
+	z, opts, err := sqlZstd()
+	if err != nil {
+		return nil, err
+	}
+
+	level := opts.Level
+	if level == 0 {
+		level = DefaultCompression
+	}
+	var flags byte
+	var dictID uint32
+	if opts.Dictionary != nil {
+		flags |= compressedBytesDictionary
+		dictID = opts.Dictionary.ID()
+	}
+	frame, err := z.CompressUsingDict(c, opts.Dictionary, level)
+	if err != nil {
+		return nil, err
+	}
+
+	value := make([]byte, 0, 3+3*binary.MaxVarintLen64+len(frame))
+	value = append(value, compressedBytesMagic, compressedBytesVersion, flags)
+	value = binary.AppendVarint(value, int64(level))
+	value = binary.AppendUvarint(value, uint64(dictID))
+	value = binary.AppendUvarint(value, uint64(len(c)))
+	return append(value, frame...), nil
+}
+
+// Scan implements the sql.Scanner interface, decompressing the value
+func (c *CompressedBytes) Scan(src any) error {
+	var value []byte
+	switch src := src.(type) {
+	case nil:
+		*c = nil
+		return nil
+	case []byte:
+		value = src
+	case string:
+		value = []byte(src)
+	default:
+		return fmt.Errorf("zstd: cannot scan %T into CompressedBytes", src)
+	}
+
+	flags, dictID, size, frame, ok := parseCompressedBytes(value)
+	if !ok {
+		// Stored before the column was compressed; the driver may reuse its buffer
+		*c = append(CompressedBytes{}, value...)
+		return nil
+	}
+
+	z, opts, err := sqlZstd()
+	if err != nil {
+		return err
+	}
+	var dict *Dictionary
+	if flags&compressedBytesDictionary != 0 {
+		if opts.Dictionary != nil && opts.Dictionary.ID() == uint32(dictID) {
+			dict = opts.Dictionary
+		} else if opts.Dictionaries != nil && dictID != 0 {
+			dict, _ = opts.Dictionaries.Lookup(uint32(dictID))
+		}
+		if dict == nil {
+			return fmt.Errorf("%w: dictionary %d of CompressedBytes value is not known", ErrDictionaryWrong, dictID)
+		}
+	}
+
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = maxPreallocSize
+	}
+	if size > uint64(maxSize) {
+		return &MaxSizeError{Limit: maxSize}
+	}
+
+	content, err := z.DecompressUsingDict(frame, dict, int(size))
+	if err != nil {
+		return err
+	}
+	if uint64(len(content)) != size {
+		return fmt.Errorf("%w: CompressedBytes value has %d bytes, header records %d", ErrCorruptedData, len(content), size)
+	}
+	*c = content
+	return nil
+}
+
+// parseCompressedBytes splits a stored value into its header fields and frame. It
+// reports false if the value has no valid header followed by a frame.
+func parseCompressedBytes(value []byte) (flags byte, dictID, size uint64, frame []byte, ok bool) {
+	if len(value) < 3 || value[0] != compressedBytesMagic || value[1] != compressedBytesVersion {
+		return 0, 0, 0, nil, false
+	}
+	flags, header := value[2], value[3:]
+	_, n := binary.Varint(header) // Level, informational
+	if n <= 0 {
+		return 0, 0, 0, nil, false
+	}
+	header = header[n:]
+	dictID, n = binary.Uvarint(header)
+	if n <= 0 || dictID > 1<<32-1 {
+		return 0, 0, 0, nil, false
+	}
+	header = header[n:]
+	size, n = binary.Uvarint(header)
+	if n <= 0 {
+		return 0, 0, 0, nil, false
+	}
+	frame = header[n:]
+
+	// Empty content is stored without a frame
+	if len(frame) == 0 && size == 0 {
+		return flags, dictID, size, frame, true
+	}
+	if len(frame) < 4 || binary.LittleEndian.Uint32(frame) != frameMagic {
+		return 0, 0, 0, nil, false
+	}
+	return flags, dictID, size, frame, true
+}
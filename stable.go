@@ -0,0 +1,297 @@
+package zstd
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// Stable buffer parameters, experimental
+const (
+	cParamStableInBuffer  = 1006 // ZSTD_c_stableInBuffer
+	cParamStableOutBuffer = 1007 // ZSTD_c_stableOutBuffer
+	dParamStableOutBuffer = 1001 // ZSTD_d_stableOutBuffer
+)
+
+// StableCompressor compresses one frame from a source buffer the caller fills
+// progressively into a destination buffer of fixed capacity. Both stay in place for the
+// whole frame, so libzstd references the source instead of copying it into its window
+// and compresses straight into the destination (ZSTD_c_stableInBuffer and
+// ZSTD_c_stableOutBuffer). Source bytes handed over by Compress must not change until
+// the frame is finished, and the destination must hold the whole frame; CompressBound
+// of the source length is always enough.
+type StableCompressor struct {
+	zstd     *Zstd
+	stream   unsafe.Pointer
+	src      []byte
+	dst      []byte
+	in       ZstdInBuffer
+	out      ZstdOutBuffer
+	pinner   runtime.Pinner
+	finished bool
+	op       *operation
+	err      error
+}
+
+// NewStableCompressor creates a StableCompressor of a frame read from src and written to
+// dst at the given level (0 = the instance default). WindowSize, Checksum, ContentSize and LongDistance from opts
+// tune the frame. The compressor must be closed to free its native stream.
+func (z *Zstd) NewStableCompressor(dst, src []byte, level int, opts ...Option) (*StableCompressor, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	if len(dst) == 0 || len(src) == 0 {
+		return nil, fmt.Errorf("%w: stable buffers must not be empty", ErrInvalidOption)
+	}
+	options := z.options(opts...)
+	level = z.resolveLevel(level)
+
+	stream := z.createCStream()
+	if stream == nil {
+		return nil, z.allocError("compress", "compression stream")
+	}
+
+	params := []parameter{
+		{cParamCompressionLevel, level},
+		{cParamStableInBuffer, 1},
+		{cParamStableOutBuffer, 1},
+	}
+	if options.WindowSize > 0 {
+		params = append(params, parameter{cParamWindowLog, windowLogFor(options.WindowSize)})
+	}
+	if options.Checksum {
+		params = append(params, parameter{cParamChecksumFlag, 1})
+	}
+	if options.LongDistance {
+		params = append(params, parameter{cParamEnableLongDistanceMatching, 1})
+	}
+	for _, p := range params {
+		result := z.cctxSetParameter(stream, p.key, p.value)
+		if z.isError(result) != 0 {
+			z.freeCStream(stream)
+			return nil, z.nativeError("compress", result)
+		}
+	}
+	if options.ContentSize > 0 {
+		result := z.cctxSetPledgedSize(stream, uint64(options.ContentSize))
+		if z.isError(result) != 0 {
+			z.freeCStream(stream)
+			return nil, z.nativeError("compress", result)
+		}
+	}
+
+	// The buffers are referenced by the stream between calls, so they stay pinned until Close
+	c := &StableCompressor{zstd: z, stream: stream, src: src, dst: dst}
+	c.pinner.Pin(&src[0])
+	c.pinner.Pin(&dst[0])
+	c.in = ZstdInBuffer{Src: unsafe.Pointer(&src[0])}
+	c.out = ZstdOutBuffer{Dst: unsafe.Pointer(&dst[0]), Size: uint64(len(dst))}
+
+	srcSize := options.ContentSize
+	if srcSize == 0 {
+		srcSize = -1
+	}
+	c.op = z.startOperation(true, Operation{Context: options.Context, Streaming: true, Level: level, SrcSize: srcSize})
+
+	// Free the native stream and unpin the buffers if the compressor is dropped without Close
+	runtime.SetFinalizer(c, (*StableCompressor).Close)
+	return c, nil
+}
+
+// Compress hands over the first n bytes of the source, compressing what it can.
+// n never decreases from one call to the next.
+func (c *StableCompressor) Compress(n int) error {
+	return c.compress(n, EndContinue)
+}
+
+// Finish hands over the first n bytes of the source as the whole content, ends the frame
+// and returns it, the start of the destination. The compressor then only has to be closed.
+func (c *StableCompressor) Finish(n int) ([]byte, error) {
+	if err := c.compress(n, EndEnd); err != nil {
+		return nil, err
+	}
+	c.finished = true
+	return c.dst[:c.out.Pos], nil
+}
+
+// compress runs the stream over the source up to n with the given end directive
+func (c *StableCompressor) compress(n int, endOp int) error {
+	if c.err != nil {
+		return c.err
+	}
+	if c.stream == nil || c.finished {
+		return ErrAlreadyClosed
+	}
+	if n < int(c.in.Size) || n > len(c.src) {
+		c.err = fmt.Errorf("%w: source length %d outside %d to %d", ErrInvalidOption, n, c.in.Size, len(c.src))
+		return c.err
+	}
+	c.in.Size = uint64(n)
+
+	for {
+		inPos, outPos := c.in.Pos, c.out.Pos
+		result := c.zstd.compressStream2(c.stream, &c.out, &c.in, endOp)
+		if c.zstd.isError(result) != 0 {
+			c.err = c.zstd.nativeError("compress", result)
+			return c.err
+		}
+
+		if endOp == EndContinue && c.in.Pos >= c.in.Size || endOp == EndEnd && result == 0 {
+			return nil
+		}
+		if c.in.Pos == inPos && c.out.Pos == outPos {
+			c.err = ErrOutputTooSmall
+			return c.err
+		}
+	}
+}
+
+// Close frees the native stream and unpins the buffers, returning the error that failed
+// the compressor, if any. A frame not finished is lost.
+func (c *StableCompressor) Close() error {
+	runtime.SetFinalizer(c, nil)
+	if c.stream == nil {
+		return c.err
+	}
+	// The native stream went away with the library
+	if !c.zstd.isClosed() {
+		c.zstd.freeCStream(c.stream)
+	}
+	c.stream = nil
+	c.pinner.Unpin()
+
+	opErr := c.err
+	if opErr == nil && !c.finished {
+		opErr = ErrAborted
+	}
+	c.op.end(int64(c.in.Pos), int64(c.out.Pos), opErr)
+	return c.err
+}
+
+// StableDecompressor decompresses one frame into a destination buffer of fixed
+// capacity that stays in place until the frame ends, so libzstd decompresses straight
+// into it instead of into an internal window (ZSTD_d_stableOutBuffer). The destination
+// must hold the whole content, and the bytes already produced must not change until the
+// frame is complete.
+type StableDecompressor struct {
+	zstd     *Zstd
+	stream   unsafe.Pointer
+	dst      []byte
+	in       ZstdInBuffer
+	out      ZstdOutBuffer
+	pinner   runtime.Pinner
+	consumed int64
+	done     bool
+	op       *operation
+	err      error
+}
+
+// NewStableDecompressor creates a StableDecompressor of a frame into dst. WindowSize from
+// opts raises the window accepted. The decompressor must be closed to free its native stream.
+func (z *Zstd) NewStableDecompressor(dst []byte, opts ...Option) (*StableDecompressor, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	if len(dst) == 0 {
+		return nil, fmt.Errorf("%w: stable buffers must not be empty", ErrInvalidOption)
+	}
+	options := z.options(opts...)
+
+	stream := z.createDStream()
+	if stream == nil {
+		return nil, z.allocError("decompress", "decompression stream")
+	}
+
+	params := []parameter{{dParamStableOutBuffer, 1}}
+	if options.WindowSize > 0 {
+		params = append(params, parameter{dParamWindowLogMax, windowLogFor(options.WindowSize)})
+	}
+	for _, p := range params {
+		result := z.dctxSetParameter(stream, p.key, p.value)
+		if z.isError(result) != 0 {
+			z.freeDStream(stream)
+			return nil, z.nativeError("decompress", result)
+		}
+	}
+
+	d := &StableDecompressor{zstd: z, stream: stream, dst: dst}
+	d.pinner.Pin(&dst[0])
+	d.out = ZstdOutBuffer{Dst: unsafe.Pointer(&dst[0]), Size: uint64(len(dst))}
+	d.op = z.startOperation(false, Operation{Context: options.Context, Streaming: true, SrcSize: -1})
+
+	// Free the native stream and unpin the destination if the decompressor is dropped without Close
+	runtime.SetFinalizer(d, (*StableDecompressor).Close)
+	return d, nil
+}
+
+// Decompress feeds compressed data to the frame and returns how much of it was consumed,
+// less than len(src) only once the frame is complete. src only has to stay in place
+// during the call.
+func (d *StableDecompressor) Decompress(src []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if d.stream == nil {
+		return 0, ErrAlreadyClosed
+	}
+	if d.done || len(src) == 0 {
+		return 0, nil
+	}
+
+	var pinner runtime.Pinner
+	defer pinner.Unpin()
+	pinner.Pin(&src[0])
+	d.in = ZstdInBuffer{Src: unsafe.Pointer(&src[0]), Size: uint64(len(src))}
+	defer func() { d.in.Src = nil }()
+
+	for d.in.Pos < d.in.Size {
+		inPos, outPos := d.in.Pos, d.out.Pos
+		result := d.zstd.decompressStream(d.stream, &d.out, &d.in)
+		d.consumed += int64(d.in.Pos - inPos)
+		if d.zstd.isError(result) != 0 {
+			d.err = d.zstd.nativeError("decompress", result)
+			return int(d.in.Pos), d.err
+		}
+		if result == 0 {
+			d.done = true
+			break
+		}
+		if d.in.Pos == inPos && d.out.Pos == outPos {
+			d.err = ErrOutputTooSmall
+			return int(d.in.Pos), d.err
+		}
+	}
+	return int(d.in.Pos), nil
+}
+
+// Bytes returns the content decompressed so far, the start of the destination
+func (d *StableDecompressor) Bytes() []byte {
+	return d.dst[:d.out.Pos]
+}
+
+// Done reports whether the frame is complete
+func (d *StableDecompressor) Done() bool {
+	return d.done
+}
+
+// Close frees the native stream and unpins the destination, returning the error that
+// failed the decompressor, if any
+func (d *StableDecompressor) Close() error {
+	runtime.SetFinalizer(d, nil)
+	if d.stream == nil {
+		return d.err
+	}
+	// The native stream went away with the library
+	if !d.zstd.isClosed() {
+		d.zstd.freeDStream(d.stream)
+	}
+	d.stream = nil
+	d.pinner.Unpin()
+
+	opErr := d.err
+	if opErr == nil && !d.done {
+		opErr = ErrAborted
+	}
+	d.op.end(d.consumed, int64(d.out.Pos), opErr)
+	return d.err
+}
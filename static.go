@@ -0,0 +1,193 @@
+package zstd
+
+import (
+	"runtime"
+	"unsafe"
+
+	"github.com/ebitengine/purego"
+)
+
+// registerStaticFunctions binds the static allocation functions on first use
+func (z *Zstd) registerStaticFunctions() {
+	z.staticOnce.Do(func() {
+		purego.RegisterLibFunc(&z.estimateCCtxSize, z.handle, "ZSTD_estimateCCtxSize")
+		purego.RegisterLibFunc(&z.estimateDCtxSize, z.handle, "ZSTD_estimateDCtxSize")
+		purego.RegisterLibFunc(&z.initStaticCCtx, z.handle, "ZSTD_initStaticCCtx")
+		purego.RegisterLibFunc(&z.initStaticDCtx, z.handle, "ZSTD_initStaticDCtx")
+	})
+}
+
+// StaticCompressorSize returns the workspace a StaticCompressor needs to compress
+// inputs of any size at levels up to level (0 = the instance default)
+func (z *Zstd) StaticCompressorSize(level int) int {
+	z.registerStaticFunctions()
+	return int(z.estimateCCtxSize(z.resolveLevel(level)))
+}
+
+// StaticDecompressorSize returns the workspace a StaticDecompressor needs
+func (z *Zstd) StaticDecompressorSize() int {
+	z.registerStaticFunctions()
+	return int(z.estimateDCtxSize())
+}
+
+// StaticCompressor compresses with a context living in a workspace the caller
+// allocates once (ZSTD_initStaticCCtx), so compression makes no native allocation at
+// all, for embedded or latency-critical uses. It is not safe for concurrent use.
+type StaticCompressor struct {
+	zstd      *Zstd
+	cctx      unsafe.Pointer
+	workspace []byte
+	pinner    runtime.Pinner
+	level     int
+}
+
+// NewStaticCompressor creates a StaticCompressor at the given level (0 = the instance
+// default) in workspace, which must be 8-byte aligned, as allocated by make, and at least
+// StaticCompressorSize(level) long. The workspace belongs to the compressor until Close.
+func (z *Zstd) NewStaticCompressor(workspace []byte, level int) (*StaticCompressor, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	z.registerStaticFunctions()
+	level = z.resolveLevel(level)
+
+	cctx, err := initStatic(z.initStaticCCtx, workspace)
+	if err != nil {
+		return nil, err
+	}
+	c := &StaticCompressor{zstd: z, cctx: cctx, workspace: workspace, level: level}
+	c.pinner.Pin(&workspace[0])
+
+	// Unpin the workspace if the compressor is dropped without Close
+	runtime.SetFinalizer(c, (*StaticCompressor).Close)
+	return c, nil
+}
+
+// Compress compresses src into dst, which must have room for the whole frame;
+// CompressBound(len(src)) is always enough. It returns the start of dst holding the frame.
+func (c *StaticCompressor) Compress(dst, src []byte) ([]byte, error) {
+	if c.cctx == nil {
+		return nil, ErrAlreadyClosed
+	}
+	if len(src) == 0 {
+		return dst[:0], nil
+	}
+	if len(dst) == 0 {
+		return nil, ErrOutputTooSmall
+	}
+
+	op := c.zstd.startOperation(true, Operation{Level: c.level, SrcSize: int64(len(src))})
+	result := c.zstd.compressCCtx(
+		c.cctx,
+		unsafe.Pointer(&dst[0]),
+		uint64(len(dst)),
+		unsafe.Pointer(&src[0]),
+		uint64(len(src)),
+		c.level,
+	)
+	if c.zstd.isError(result) != 0 {
+		err := c.zstd.nativeError("compress", result)
+		op.end(0, 0, err)
+		return nil, err
+	}
+	op.end(int64(len(src)), int64(result), nil)
+
+	return dst[:result], nil
+}
+
+// Close releases the workspace, which the context lives in, so nothing is freed natively
+func (c *StaticCompressor) Close() error {
+	runtime.SetFinalizer(c, nil)
+	if c.cctx != nil {
+		c.cctx = nil
+		c.workspace = nil
+		c.pinner.Unpin()
+	}
+	return nil
+}
+
+// StaticDecompressor decompresses with a context living in a workspace the caller
+// allocates once (ZSTD_initStaticDCtx), so decompression makes no native allocation at
+// all. It is not safe for concurrent use.
+type StaticDecompressor struct {
+	zstd      *Zstd
+	dctx      unsafe.Pointer
+	workspace []byte
+	pinner    runtime.Pinner
+}
+
+// NewStaticDecompressor creates a StaticDecompressor in workspace, which must be 8-byte
+// aligned, as allocated by make, and at least StaticDecompressorSize long. The workspace
+// belongs to the decompressor until Close.
+func (z *Zstd) NewStaticDecompressor(workspace []byte) (*StaticDecompressor, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	z.registerStaticFunctions()
+
+	dctx, err := initStatic(z.initStaticDCtx, workspace)
+	if err != nil {
+		return nil, err
+	}
+	d := &StaticDecompressor{zstd: z, dctx: dctx, workspace: workspace}
+	d.pinner.Pin(&workspace[0])
+
+	// Unpin the workspace if the decompressor is dropped without Close
+	runtime.SetFinalizer(d, (*StaticDecompressor).Close)
+	return d, nil
+}
+
+// Decompress decompresses the frames of src into dst, which must have room for the
+// whole content. It returns the start of dst holding it.
+func (d *StaticDecompressor) Decompress(dst, src []byte) ([]byte, error) {
+	if d.dctx == nil {
+		return nil, ErrAlreadyClosed
+	}
+	if len(src) == 0 {
+		return dst[:0], nil
+	}
+	if len(dst) == 0 {
+		return nil, ErrOutputTooSmall
+	}
+
+	op := d.zstd.startOperation(false, Operation{SrcSize: int64(len(src))})
+	result := d.zstd.decompressDCtx(
+		d.dctx,
+		unsafe.Pointer(&dst[0]),
+		uint64(len(dst)),
+		unsafe.Pointer(&src[0]),
+		uint64(len(src)),
+	)
+	if d.zstd.isError(result) != 0 {
+		err := d.zstd.nativeError("decompress", result)
+		op.end(0, 0, err)
+		return nil, err
+	}
+	op.end(int64(len(src)), int64(result), nil)
+
+	return dst[:result], nil
+}
+
+// Close releases the workspace, which the context lives in, so nothing is freed natively
+func (d *StaticDecompressor) Close() error {
+	runtime.SetFinalizer(d, nil)
+	if d.dctx != nil {
+		d.dctx = nil
+		d.workspace = nil
+		d.pinner.Unpin()
+	}
+	return nil
+}
+
+// initStatic lays out a context in workspace with init, failing if the workspace is
+// misaligned or too small
+func initStatic(init func(workspace unsafe.Pointer, size uint64) unsafe.Pointer, workspace []byte) (unsafe.Pointer, error) {
+	if len(workspace) == 0 || uintptr(unsafe.Pointer(&workspace[0]))%8 != 0 {
+		return nil, &Error{Op: "init static context", Code: codeWorkspaceTooSmall, Message: "workspace is empty or not 8-byte aligned"}
+	}
+	ctx := init(unsafe.Pointer(&workspace[0]), uint64(len(workspace)))
+	if ctx == nil {
+		return nil, &Error{Op: "init static context", Code: codeWorkspaceTooSmall, Message: "workspace too small"}
+	}
+	return ctx, nil
+}
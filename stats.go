@@ -0,0 +1,156 @@
+package zstd
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// Statistics are cumulative counters over every instance of the process, to surface
+// compression health on debug endpoints. The zstdexpvar package publishes them under expvar.
+type Statistics struct {
+	Compressions       int64  `json:"compressions"`         // One-shot compressions and frames completed by streams
+	Decompressions     int64  `json:"decompressions"`       // One-shot decompressions and frames completed by streams
+	CompressBytesIn    int64  `json:"compress_bytes_in"`    // Content consumed by compression
+	CompressBytesOut   int64  `json:"compress_bytes_out"`   // Compressed data produced
+	DecompressBytesIn  int64  `json:"decompress_bytes_in"`  // Compressed data consumed by decompression
+	DecompressBytesOut int64  `json:"decompress_bytes_out"` // Content produced
+	Errors             int64  `json:"errors"`               // Compression and decompression calls that failed
+	ContextsAlive      int64  `json:"contexts_alive"`       // Native contexts and streams not freed yet
+	LibraryVersion     string `json:"library_version"`      // Version of the last libzstd loaded
+}
+
+// stats holds the counters behind Stats
+var stats struct {
+	compressions, decompressions atomic.Int64
+	compressIn, compressOut      atomic.Int64
+	decompressIn, decompressOut  atomic.Int64
+	errors, contexts             atomic.Int64
+	libraryVersion               atomic.Value // string
+}
+
+// Stats returns a snapshot of the package statistics
+func Stats() Statistics {
+	version, _ := stats.libraryVersion.Load().(string)
+	return Statistics{
+		Compressions:       stats.compressions.Load(),
+		Decompressions:     stats.decompressions.Load(),
+		CompressBytesIn:    stats.compressIn.Load(),
+		CompressBytesOut:   stats.compressOut.Load(),
+		DecompressBytesIn:  stats.decompressIn.Load(),
+		DecompressBytesOut: stats.decompressOut.Load(),
+		Errors:             stats.errors.Load(),
+		ContextsAlive:      stats.contexts.Load(),
+		LibraryVersion:     version,
+	}
+}
+
+// countOneShot records a call that compresses or decompresses a whole buffer
+func (z *Zstd) countOneShot(ops, in, out *atomic.Int64, srcSize, result uint64) {
+	if z.isError(result) != 0 {
+		stats.errors.Add(1)
+		return
+	}
+	ops.Add(1)
+	in.Add(int64(srcSize))
+	out.Add(int64(result))
+}
+
+// countStream records a streaming call from the buffer positions before and after it;
+// frameDone tells whether the call completed a frame
+func (z *Zstd) countStream(ops, in, out *atomic.Int64, output *ZstdOutBuffer, input *ZstdInBuffer, outPos, inPos, result uint64, frameDone bool) {
+	if z.isError(result) != 0 {
+		stats.errors.Add(1)
+		return
+	}
+	in.Add(int64(input.Pos - inPos))
+	out.Add(int64(output.Pos - outPos))
+	if frameDone {
+		ops.Add(1)
+	}
+}
+
+// countCreate wraps a context constructor to count the contexts alive
+func countCreate(create func() unsafe.Pointer) func() unsafe.Pointer {
+	return func() unsafe.Pointer {
+		ctx := create()
+		if ctx != nil {
+			stats.contexts.Add(1)
+		}
+		return ctx
+	}
+}
+
+// countFree wraps a context destructor to count the contexts alive
+func countFree(free func(ctx unsafe.Pointer) uint64) func(ctx unsafe.Pointer) uint64 {
+	return func(ctx unsafe.Pointer) uint64 {
+		if ctx != nil {
+			stats.contexts.Add(-1)
+		}
+		return free(ctx)
+	}
+}
+
+// countCalls wraps the context, compression and decompression functions so every
+// instance feeds the package statistics
+func (z *Zstd) countCalls() {
+	stats.libraryVersion.Store(z.versionString())
+
+	z.createCCtx, z.freeCCtx = countCreate(z.createCCtx), countFree(z.freeCCtx)
+	z.createDCtx, z.freeDCtx = countCreate(z.createDCtx), countFree(z.freeDCtx)
+	z.createCStream, z.freeCStream = countCreate(z.createCStream), countFree(z.freeCStream)
+	z.createDStream, z.freeDStream = countCreate(z.createDStream), countFree(z.freeDStream)
+
+	compressCCtx := z.compressCCtx
+	z.compressCCtx = func(ctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, level int) uint64 {
+		result := compressCCtx(ctx, dst, dstCapacity, src, srcSize, level)
+		z.countOneShot(&stats.compressions, &stats.compressIn, &stats.compressOut, srcSize, result)
+		return result
+	}
+
+	decompressDCtx := z.decompressDCtx
+	z.decompressDCtx = func(ctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64) uint64 {
+		result := decompressDCtx(ctx, dst, dstCapacity, src, srcSize)
+		z.countOneShot(&stats.decompressions, &stats.decompressIn, &stats.decompressOut, srcSize, result)
+		return result
+	}
+
+	compress2 := z.compress2
+	z.compress2 = func(cctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64) uint64 {
+		result := compress2(cctx, dst, dstCapacity, src, srcSize)
+		z.countOneShot(&stats.compressions, &stats.compressIn, &stats.compressOut, srcSize, result)
+		return result
+	}
+
+	compressStream2 := z.compressStream2
+	z.compressStream2 = func(zcs unsafe.Pointer, output *ZstdOutBuffer, input *ZstdInBuffer, endOp int) uint64 {
+		inPos, outPos := input.Pos, output.Pos
+		result := compressStream2(zcs, output, input, endOp)
+		z.countStream(&stats.compressions, &stats.compressIn, &stats.compressOut, output, input, outPos, inPos, result, endOp == EndEnd && result == 0)
+		return result
+	}
+
+	decompressStream := z.decompressStream
+	z.decompressStream = func(zds unsafe.Pointer, output *ZstdOutBuffer, input *ZstdInBuffer) uint64 {
+		inPos, outPos := input.Pos, output.Pos
+		result := decompressStream(zds, output, input)
+		z.countStream(&stats.decompressions, &stats.decompressIn, &stats.decompressOut, output, input, outPos, inPos, result, result == 0)
+		return result
+	}
+}
+
+// countDictionaryCalls wraps the dictionary functions once they are registered
+func (z *Zstd) countDictionaryCalls() {
+	compressUsingCDict := z.compressUsingCDict
+	z.compressUsingCDict = func(ctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, cdict unsafe.Pointer) uint64 {
+		result := compressUsingCDict(ctx, dst, dstCapacity, src, srcSize, cdict)
+		z.countOneShot(&stats.compressions, &stats.compressIn, &stats.compressOut, srcSize, result)
+		return result
+	}
+
+	decompressUsingDDict := z.decompressUsingDDict
+	z.decompressUsingDDict = func(ctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, ddict unsafe.Pointer) uint64 {
+		result := decompressUsingDDict(ctx, dst, dstCapacity, src, srcSize, ddict)
+		z.countOneShot(&stats.decompressions, &stats.decompressIn, &stats.decompressOut, srcSize, result)
+		return result
+	}
+}
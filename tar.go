@@ -0,0 +1,171 @@
+package zstd
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+)
+
+// ErrInsecurePath is returned when extracting an archive entry whose name would escape
+// the destination directory, such as an absolute path or one with ".." elements
+var ErrInsecurePath = errors.New("zstd: insecure path in archive")
+
+// TarOptions configures CreateTarZst and ExtractTarZst
+type TarOptions struct {
+	Level   int   // Compression level for CreateTarZst (0 = the instance default)
+	MaxSize int64 // Largest total content ExtractTarZst extracts (0 = no limit)
+
+	// Progress, if set, is called after each entry with its name and the total size of
+	// the file contents archived or extracted so far
+	Progress func(name string, processed int64)
+}
+
+// CreateTarZst writes the files and directories of fsys to dst as a .tar.zst archive.
+// Paths on disk can be archived with os.DirFS. Only regular files and directories are
+// archived; symbolic links and special files are skipped. If archiving fails, the
+// compressed stream is left unterminated.
+func (z *Zstd) CreateTarZst(dst io.Writer, fsys fs.FS, opts TarOptions) error {
+	zw := z.NewWriter(dst, opts.Level)
+	tw := tar.NewWriter(zw)
+
+	var processed int64
+	err := fs.WalkDir(fsys, ".", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || name == "." {
+			return err
+		}
+		if !entry.IsDir() && !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if entry.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+
+		if !entry.IsDir() {
+			f, err := fsys.Open(name)
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(tw, f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			processed += n
+		}
+		if opts.Progress != nil {
+			opts.Progress(name, processed)
+		}
+		return nil
+	})
+
+	// A failed archive is abandoned without ending the frame, so it fails to decompress
+	// rather than reading as a complete archive cut at an entry boundary
+	if err == nil {
+		err = tw.Close()
+	}
+	if err != nil {
+		zw.Abort()
+		return err
+	}
+	return zw.Close()
+}
+
+// ExtractTarZst extracts the .tar.zst archive read from src into dir, which must exist.
+// Entries whose names would escape dir fail with ErrInsecurePath, and files are created
+// through an os.Root, so symbolic links already in dir can't redirect them either.
+// Regular files and directories are extracted with their permissions; other entries,
+// such as links, are skipped.
+func (z *Zstd) ExtractTarZst(src io.Reader, dir string, opts TarOptions) error {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+
+	zr := z.NewReader(src, WithMaxDecompressSize(opts.MaxSize))
+	defer zr.Close()
+	tr := tar.NewReader(zr)
+
+	var processed int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		name := strings.TrimSuffix(hdr.Name, "/")
+		if !fs.ValidPath(name) || name == "." {
+			return fmt.Errorf("%w: %q", ErrInsecurePath, hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := mkdirAll(root, name, hdr.FileInfo().Mode().Perm()); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := mkdirAll(root, path.Dir(name), 0o755); err != nil {
+				return err
+			}
+			f, err := root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			n, err := io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+			processed += n
+		default:
+			continue
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(name, processed)
+		}
+	}
+}
+
+// mkdirAll creates the directory name within root along with its missing parents
+func mkdirAll(root *os.Root, name string, perm fs.FileMode) error {
+	if name == "." {
+		return nil
+	}
+	if info, err := root.Stat(name); err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("zstd: %s exists and is not a directory", name)
+		}
+		return nil
+	}
+	if err := mkdirAll(root, path.Dir(name), 0o755); err != nil {
+		return err
+	}
+	if err := root.Mkdir(name, perm|0o700); err != nil && !errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package zstd
+
+import (
+	"time"
+	"unsafe"
+)
+
+// NativeCall describes a call into libzstd, as passed to a Tracer
+type NativeCall struct {
+	Function string        // Name of the libzstd function, such as "ZSTD_decompressStream"
+	SrcSize  int           // Input bytes offered to the call
+	DstSize  int           // Output space offered to the call
+	Consumed int           // Input bytes consumed by the call
+	Produced int           // Output bytes written by the call
+	Result   uint64        // Return value: a size, the remaining work hint of a streaming call, or an error code
+	Err      error         // The *Error for Result, if the call failed
+	Duration time.Duration // Time spent in the call
+}
+
+// Tracer receives the native calls made by an instance, to diagnose stalled streams and
+// unexpected errors. It is called from the goroutine making the call, so a Tracer used
+// by concurrent operations must be safe for concurrent use.
+type Tracer interface {
+	TraceNativeCall(call NativeCall)
+}
+
+// TracerFunc adapts an ordinary function to the Tracer interface
+type TracerFunc func(call NativeCall)
+
+// TraceNativeCall calls f(call)
+func (f TracerFunc) TraceNativeCall(call NativeCall) {
+	f(call)
+}
+
+// trace completes call with its result and duration and hands it to the tracer
+func (z *Zstd) trace(call NativeCall, start time.Time, result uint64) {
+	call.Duration = time.Since(start)
+	call.Result = result
+	if z.isError(result) != 0 {
+		call.Err = z.nativeError("", result)
+	}
+	z.tracer.TraceNativeCall(call)
+}
+
+// traceOneShot reports a call that compresses or decompresses a whole buffer
+func (z *Zstd) traceOneShot(function string, dstCapacity, srcSize uint64, start time.Time, result uint64) {
+	call := NativeCall{Function: function, SrcSize: int(srcSize), DstSize: int(dstCapacity)}
+	if z.isError(result) == 0 {
+		call.Consumed, call.Produced = int(srcSize), int(result)
+	}
+	z.trace(call, start, result)
+}
+
+// traceStream reports a streaming call from the buffer positions before and after it
+func (z *Zstd) traceStream(function string, output *ZstdOutBuffer, input *ZstdInBuffer, outPos, inPos uint64, start time.Time, result uint64) {
+	z.trace(NativeCall{
+		Function: function,
+		SrcSize:  int(input.Size - inPos),
+		DstSize:  int(output.Size - outPos),
+		Consumed: int(input.Pos - inPos),
+		Produced: int(output.Pos - outPos),
+	}, start, result)
+}
+
+// traceCalls wraps the compression, decompression and parameter functions so each call
+// is reported to the tracer. Instances without a tracer call libzstd directly.
+func (z *Zstd) traceCalls() {
+	compressCCtx := z.compressCCtx
+	z.compressCCtx = func(ctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, level int) uint64 {
+		start := time.Now()
+		result := compressCCtx(ctx, dst, dstCapacity, src, srcSize, level)
+		z.traceOneShot("ZSTD_compressCCtx", dstCapacity, srcSize, start, result)
+		return result
+	}
+
+	decompressDCtx := z.decompressDCtx
+	z.decompressDCtx = func(ctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64) uint64 {
+		start := time.Now()
+		result := decompressDCtx(ctx, dst, dstCapacity, src, srcSize)
+		z.traceOneShot("ZSTD_decompressDCtx", dstCapacity, srcSize, start, result)
+		return result
+	}
+
+	compress2 := z.compress2
+	z.compress2 = func(cctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64) uint64 {
+		start := time.Now()
+		result := compress2(cctx, dst, dstCapacity, src, srcSize)
+		z.traceOneShot("ZSTD_compress2", dstCapacity, srcSize, start, result)
+		return result
+	}
+
+	compressStream2 := z.compressStream2
+	z.compressStream2 = func(zcs unsafe.Pointer, output *ZstdOutBuffer, input *ZstdInBuffer, endOp int) uint64 {
+		inPos, outPos := input.Pos, output.Pos
+		start := time.Now()
+		result := compressStream2(zcs, output, input, endOp)
+		z.traceStream("ZSTD_compressStream2", output, input, outPos, inPos, start, result)
+		return result
+	}
+
+	decompressStream := z.decompressStream
+	z.decompressStream = func(zds unsafe.Pointer, output *ZstdOutBuffer, input *ZstdInBuffer) uint64 {
+		inPos, outPos := input.Pos, output.Pos
+		start := time.Now()
+		result := decompressStream(zds, output, input)
+		z.traceStream("ZSTD_decompressStream", output, input, outPos, inPos, start, result)
+		return result
+	}
+
+	z.cctxSetParameter = z.traceSetParameter("ZSTD_CCtx_setParameter", z.cctxSetParameter)
+	z.dctxSetParameter = z.traceSetParameter("ZSTD_DCtx_setParameter", z.dctxSetParameter)
+
+	cctxReset := z.cctxReset
+	z.cctxReset = func(cctx unsafe.Pointer, directive int) uint64 {
+		start := time.Now()
+		result := cctxReset(cctx, directive)
+		z.trace(NativeCall{Function: "ZSTD_CCtx_reset"}, start, result)
+		return result
+	}
+
+	dctxReset := z.dctxReset
+	z.dctxReset = func(dctx unsafe.Pointer, directive int) uint64 {
+		start := time.Now()
+		result := dctxReset(dctx, directive)
+		z.trace(NativeCall{Function: "ZSTD_DCtx_reset"}, start, result)
+		return result
+	}
+}
+
+// traceSetParameter wraps a parameter setter
+func (z *Zstd) traceSetParameter(function string, fn func(ctx unsafe.Pointer, param int, value int) uint64) func(ctx unsafe.Pointer, param int, value int) uint64 {
+	return func(ctx unsafe.Pointer, param int, value int) uint64 {
+		start := time.Now()
+		result := fn(ctx, param, value)
+		z.trace(NativeCall{Function: function}, start, result)
+		return result
+	}
+}
+
+// traceDictionaryCalls wraps the dictionary functions once they are registered
+func (z *Zstd) traceDictionaryCalls() {
+	compressUsingCDict := z.compressUsingCDict
+	z.compressUsingCDict = func(ctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, cdict unsafe.Pointer) uint64 {
+		start := time.Now()
+		result := compressUsingCDict(ctx, dst, dstCapacity, src, srcSize, cdict)
+		z.traceOneShot("ZSTD_compress_usingCDict", dstCapacity, srcSize, start, result)
+		return result
+	}
+
+	decompressUsingDDict := z.decompressUsingDDict
+	z.decompressUsingDDict = func(ctx, dst unsafe.Pointer, dstCapacity uint64, src unsafe.Pointer, srcSize uint64, ddict unsafe.Pointer) uint64 {
+		start := time.Now()
+		result := decompressUsingDDict(ctx, dst, dstCapacity, src, srcSize, ddict)
+		z.traceOneShot("ZSTD_decompress_usingDDict", dstCapacity, srcSize, start, result)
+		return result
+	}
+}
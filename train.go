@@ -0,0 +1,242 @@
+package zstd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+// maxFileSampleSize caps the bytes TrainDictionaryFromFS reads from each file. The start
+// of a file is representative of its structure, and training favours many small samples.
+const maxFileSampleSize = 128 << 10
+
+// TrainingOptions tunes dictionary training. Zero values select the library defaults;
+// K and D are searched when left at 0, trying Steps combinations.
+type TrainingOptions struct {
+	FastCover bool    // Train with the fastCover algorithm, which is quicker than COVER on large sample sets
+	K         int     // Segment size, typically 16 to 2048 (0 = search)
+	D         int     // Dmer size, typically 6 to 16 and at most K (0 = search 6 and 8)
+	Steps     int     // Parameter combinations tried while searching (0 = 40)
+	Split     float64 // Fraction of samples trained on, the rest scoring candidates (0 = 1.0 for COVER, 0.75 for fastCover)
+	Accel     int     // fastCover acceleration from 1 to 10, higher being faster and less accurate (0 = 1)
+	F         int     // fastCover log2 of the frequency table size, up to 31 (0 = 20)
+	Threads   int     // Threads searching in parallel (0 = 1)
+	Level     int     // Compression level the dictionary is optimized for (0 = default)
+	DictID    uint32  // ID recorded in the dictionary (0 = random)
+}
+
+// zdictParams mirrors ZDICT_params_t
+type zdictParams struct {
+	compressionLevel  int32
+	notificationLevel uint32
+	dictID            uint32
+}
+
+// zdictCoverParams mirrors ZDICT_cover_params_t
+type zdictCoverParams struct {
+	k, d, steps, nbThreads  uint32
+	splitPoint              float64
+	shrinkDict              uint32
+	shrinkDictMaxRegression uint32
+	zParams                 zdictParams
+}
+
+// zdictFastCoverParams mirrors ZDICT_fastCover_params_t
+type zdictFastCoverParams struct {
+	k, d, f, steps, nbThreads uint32
+	splitPoint                float64
+	accel                     uint32
+	shrinkDict                uint32
+	shrinkDictMaxRegression   uint32
+	zParams                   zdictParams
+}
+
+// TrainDictionary trains a dictionary of at most dictSize bytes on samples of the data
+// it will compress, typically a few hundred small messages. It returns the dictionary
+// content, ready for LoadDictionary, and the options with the K and D found by the
+// search, so later training on similar data can skip it.
+func (z *Zstd) TrainDictionary(samples [][]byte, dictSize int, opts TrainingOptions) ([]byte, TrainingOptions, error) {
+	if z.isClosed() {
+		return nil, opts, ErrAlreadyClosed
+	}
+	if dictSize <= 0 {
+		return nil, opts, fmt.Errorf("%w: dictionary size %d", ErrInvalidOption, dictSize)
+	}
+
+	buffer, sizes := concatSamples(samples)
+	if len(buffer) == 0 {
+		return nil, opts, ErrEmptyInput
+	}
+
+	if err := z.registerDictionaryFunctions(); err != nil {
+		return nil, opts, err
+	}
+
+	dict := make([]byte, dictSize)
+	zParams := zdictParams{compressionLevel: int32(opts.Level), dictID: opts.DictID}
+	threads := uint32(max(opts.Threads, 1))
+
+	var result uint64
+	if opts.FastCover {
+		params := zdictFastCoverParams{
+			k: uint32(opts.K), d: uint32(opts.D), f: uint32(opts.F), steps: uint32(opts.Steps),
+			nbThreads: threads, splitPoint: opts.Split, accel: uint32(opts.Accel), zParams: zParams,
+		}
+		result = z.optimizeTrainFastCover(
+			unsafe.Pointer(&dict[0]), uint64(len(dict)),
+			unsafe.Pointer(&buffer[0]), unsafe.Pointer(&sizes[0]), uint32(len(sizes)),
+			&params,
+		)
+		opts.K, opts.D, opts.F, opts.Accel = int(params.k), int(params.d), int(params.f), int(params.accel)
+		opts.Split = params.splitPoint
+	} else {
+		params := zdictCoverParams{
+			k: uint32(opts.K), d: uint32(opts.D), steps: uint32(opts.Steps),
+			nbThreads: threads, splitPoint: opts.Split, zParams: zParams,
+		}
+		result = z.optimizeTrainCover(
+			unsafe.Pointer(&dict[0]), uint64(len(dict)),
+			unsafe.Pointer(&buffer[0]), unsafe.Pointer(&sizes[0]), uint32(len(sizes)),
+			&params,
+		)
+		opts.K, opts.D = int(params.k), int(params.d)
+		opts.Split = params.splitPoint
+	}
+
+	if z.isError(result) != 0 {
+		return nil, opts, z.nativeError("train dictionary", result)
+	}
+	return dict[:result], opts, nil
+}
+
+// TrainDictionaryFromFS trains a dictionary of at most targetSize bytes on the files of
+// fsys matching glob, with the syntax of fs.Glob, each file being a sample of which the
+// first 128KB are used. K and D are searched, as with TrainDictionary and zero options.
+func (z *Zstd) TrainDictionaryFromFS(fsys fs.FS, glob string, targetSize int) ([]byte, error) {
+	names, err := fs.Glob(fsys, glob)
+	if err != nil {
+		return nil, err
+	}
+
+	var samples [][]byte
+	for _, name := range names {
+		sample, err := readSample(fsys, name)
+		if err != nil {
+			return nil, err
+		}
+		if len(sample) > 0 {
+			samples = append(samples, sample)
+		}
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("%w: no samples match %s", ErrEmptyInput, glob)
+	}
+
+	dict, _, err := z.TrainDictionary(samples, targetSize, TrainingOptions{})
+	return dict, err
+}
+
+// ReadSamples reads the files at paths, walking directories, as samples of at most
+// blockSize bytes each (0 = whole files), to train dictionaries or measure compression
+// on. It returns the samples and their total size.
+func ReadSamples(paths []string, blockSize int) ([][]byte, int, error) {
+	var samples [][]byte
+	var total int
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || !entry.Type().IsRegular() {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			total += len(data)
+			for len(data) > 0 {
+				n := len(data)
+				if blockSize > 0 && n > blockSize {
+					n = blockSize
+				}
+				samples = append(samples, data[:n:n])
+				data = data[n:]
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	return samples, total, nil
+}
+
+// readSample reads up to maxFileSampleSize bytes of the named file, nothing for directories
+func readSample(fsys fs.FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, err
+	}
+	return io.ReadAll(io.LimitReader(f, maxFileSampleSize))
+}
+
+// FinalizeDictionary turns raw dictionary content, such as prefixes common to the data,
+// into a complete dictionary of at most dictSize bytes: entropy tables computed from the
+// samples are added, along with a header carrying the ID. Only the Level and DictID of
+// opts are used. Content that doesn't fit is cut from its start, keeping its end, which
+// zstd matches against most cheaply.
+func (z *Zstd) FinalizeDictionary(content []byte, samples [][]byte, dictSize int, opts TrainingOptions) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	if len(content) == 0 {
+		return nil, fmt.Errorf("empty dictionary data")
+	}
+	if dictSize <= 0 {
+		return nil, fmt.Errorf("%w: dictionary size %d", ErrInvalidOption, dictSize)
+	}
+
+	buffer, sizes := concatSamples(samples)
+	if len(buffer) == 0 {
+		return nil, ErrEmptyInput
+	}
+
+	if err := z.registerDictionaryFunctions(); err != nil {
+		return nil, err
+	}
+
+	dict := make([]byte, dictSize)
+	result := z.finalizeDictionary(
+		unsafe.Pointer(&dict[0]), uint64(len(dict)),
+		unsafe.Pointer(&content[0]), uint64(len(content)),
+		unsafe.Pointer(&buffer[0]), unsafe.Pointer(&sizes[0]), uint32(len(sizes)),
+		zdictParams{compressionLevel: int32(opts.Level), dictID: opts.DictID},
+	)
+	if z.isError(result) != 0 {
+		return nil, z.nativeError("finalize dictionary", result)
+	}
+	return dict[:result], nil
+}
+
+// concatSamples returns the samples concatenated, along with their sizes, as the
+// training functions take them
+func concatSamples(samples [][]byte) ([]byte, []uint64) {
+	var total int
+	for _, s := range samples {
+		total += len(s)
+	}
+	buffer := make([]byte, 0, total)
+	sizes := make([]uint64, len(samples))
+	for i, s := range samples {
+		buffer = append(buffer, s...)
+		sizes[i] = uint64(len(s))
+	}
+	return buffer, sizes
+}
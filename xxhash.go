@@ -0,0 +1,148 @@
+package zstd
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+// XXH64 primes
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+// xxhash64 returns the XXH64 hash of b with seed 0, the checksum zstd uses for content
+func xxhash64(b []byte) uint64 {
+	var d xxh64
+	d.Reset()
+	d.Write(b)
+	return d.Sum64()
+}
+
+// xxh64 computes XXH64 with seed 0 incrementally, as a hash.Hash64
+type xxh64 struct {
+	v1, v2, v3, v4 uint64
+	total          uint64   // Bytes written
+	mem            [32]byte // Input not yet processed as a stripe
+	n              int      // Bytes in mem
+}
+
+// NewXXH64 returns a hash.Hash64 computing XXH64 with seed 0, the checksum zstd frames
+// record, whose lower 32 bits they store. Sum appends the hash in big-endian order.
+func NewXXH64() hash.Hash64 {
+	d := &xxh64{}
+	d.Reset()
+	return d
+}
+
+// Reset implements the hash.Hash interface
+func (d *xxh64) Reset() {
+	// Seed 0 plus the primes, wrapping around
+	d.v1 = xxhPrime1
+	d.v1 += xxhPrime2
+	d.v2 = xxhPrime2
+	d.v3 = 0
+	d.v4 = 0
+	d.v4 -= xxhPrime1
+	d.total = 0
+	d.n = 0
+}
+
+// Size implements the hash.Hash interface
+func (d *xxh64) Size() int {
+	return 8
+}
+
+// BlockSize implements the hash.Hash interface
+func (d *xxh64) BlockSize() int {
+	return 32
+}
+
+// Write implements the io.Writer interface; it never fails
+func (d *xxh64) Write(b []byte) (int, error) {
+	n := len(b)
+	d.total += uint64(n)
+
+	// Complete the pending stripe first
+	if d.n > 0 {
+		c := copy(d.mem[d.n:], b)
+		d.n += c
+		b = b[c:]
+		if d.n < len(d.mem) {
+			return n, nil
+		}
+		d.stripe(d.mem[:])
+		d.n = 0
+	}
+
+	for ; len(b) >= 32; b = b[32:] {
+		d.stripe(b)
+	}
+	d.n = copy(d.mem[:], b)
+	return n, nil
+}
+
+// stripe processes 32 bytes of input
+func (d *xxh64) stripe(b []byte) {
+	d.v1 = xxhRound(d.v1, binary.LittleEndian.Uint64(b))
+	d.v2 = xxhRound(d.v2, binary.LittleEndian.Uint64(b[8:]))
+	d.v3 = xxhRound(d.v3, binary.LittleEndian.Uint64(b[16:]))
+	d.v4 = xxhRound(d.v4, binary.LittleEndian.Uint64(b[24:]))
+}
+
+// Sum implements the hash.Hash interface
+func (d *xxh64) Sum(b []byte) []byte {
+	return binary.BigEndian.AppendUint64(b, d.Sum64())
+}
+
+// Sum64 implements the hash.Hash64 interface
+func (d *xxh64) Sum64() uint64 {
+	var h uint64
+	if d.total >= 32 {
+		h = bits.RotateLeft64(d.v1, 1) + bits.RotateLeft64(d.v2, 7) + bits.RotateLeft64(d.v3, 12) + bits.RotateLeft64(d.v4, 18)
+		h = xxhMergeRound(h, d.v1)
+		h = xxhMergeRound(h, d.v2)
+		h = xxhMergeRound(h, d.v3)
+		h = xxhMergeRound(h, d.v4)
+	} else {
+		h = xxhPrime5
+	}
+	h += d.total
+
+	b := d.mem[:d.n]
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	return h
+}
+
+func xxhRound(acc, input uint64) uint64 {
+	acc += input * xxhPrime2
+	acc = bits.RotateLeft64(acc, 31)
+	return acc * xxhPrime1
+}
+
+func xxhMergeRound(acc, val uint64) uint64 {
+	acc ^= xxhRound(0, val)
+	return acc*xxhPrime1 + xxhPrime4
+}
@@ -0,0 +1,59 @@
+package zstd
+
+import (
+	"archive/zip"
+	"io"
+	"sync"
+)
+
+// ZipMethod is the ZIP compression method ID for Zstandard, as assigned by APPNOTE.TXT
+const ZipMethod uint16 = 93
+
+// ZipCompressor returns a zip.Compressor writing Zstandard entries at the level
+// (0 = the instance default), for zip.Writer.RegisterCompressor with ZipMethod.
+// Entries are then added with zip.Writer.CreateHeader and Method set to ZipMethod.
+func (z *Zstd) ZipCompressor(level int) zip.Compressor {
+	return func(w io.Writer) (io.WriteCloser, error) {
+		zw := z.NewWriter(w, level)
+		if zw.err != nil {
+			return nil, zw.err
+		}
+		return zw, nil
+	}
+}
+
+// ZipDecompressor returns a zip.Decompressor reading Zstandard entries, for
+// zip.Reader.RegisterDecompressor with ZipMethod
+func (z *Zstd) ZipDecompressor() zip.Decompressor {
+	return func(r io.Reader) io.ReadCloser {
+		return z.NewReader(r)
+	}
+}
+
+var registerZipCompressor, registerZipDecompressor sync.Once
+
+// RegisterZipCompressor registers a Zstandard compressor at the level for ZipMethod with
+// archive/zip, for all zip.Writers. Each entry loads its own instance of the library.
+// Only the first call has an effect, as archive/zip accepts a single registration.
+func RegisterZipCompressor(level int) {
+	registerZipCompressor.Do(func() {
+		zip.RegisterCompressor(ZipMethod, func(w io.Writer) (io.WriteCloser, error) {
+			return NewWriterLevel(w, level)
+		})
+	})
+}
+
+// RegisterZipDecompressor registers a Zstandard decompressor for ZipMethod with
+// archive/zip, for all zip.Readers. Each entry loads its own instance of the library.
+// Only the first call has an effect, as archive/zip accepts a single registration.
+func RegisterZipDecompressor() {
+	registerZipDecompressor.Do(func() {
+		zip.RegisterDecompressor(ZipMethod, func(r io.Reader) io.ReadCloser {
+			zr, err := NewReader(r)
+			if err != nil {
+				return io.NopCloser(NewErrorReader(err))
+			}
+			return zr
+		})
+	})
+}
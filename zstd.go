@@ -12,35 +12,74 @@ package zstd
 import (
 	"fmt"
 	"io"
+	"runtime"
 	"unsafe"
 )
 
-// Version returns the library version as an integer
+// Version returns the library version as an integer, or 0 once the instance is closed
 func (z *Zstd) Version() uint32 {
+	if z.isClosed() {
+		return 0
+	}
 	return z.versionNumber()
 }
 
-// VersionString returns the library version as a string (e.g., "1.5.5")
+// VersionString returns the library version as a string (e.g., "1.5.5"),
+// or an empty string once the instance is closed
 func (z *Zstd) VersionString() string {
+	if z.isClosed() {
+		return ""
+	}
 	return z.versionString()
 }
 
 // CompressBound returns the maximum compressed size in the worst case scenario.
 func (z *Zstd) CompressBound(srcSize int) int {
+	if z.isClosed() {
+		return compressBound(srcSize)
+	}
 	return int(z.compressBound(uint64(srcSize)))
 }
 
+// compressBound mirrors the ZSTD_COMPRESSBOUND macro, for use without the library
+func compressBound(srcSize int) int {
+	const blockSizeMax = 128 * 1024
+	margin := 0
+	if srcSize < blockSizeMax {
+		margin = (blockSizeMax - srcSize) >> 11
+	}
+	return srcSize + (srcSize >> 8) + margin
+}
+
 // Compress compresses the data from src and returns the compressed data.
-// Level can be between 1 (fastest) and 22 (highest compression ratio).
+// Level can be between 1 (fastest) and 22 (highest compression ratio); 0 uses the
+// default level of the instance.
 func (z *Zstd) Compress(src []byte, level int) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+	level = z.resolveLevel(level)
+
 	if len(src) == 0 {
 		return []byte{}, nil
 	}
+	if z.progress != nil {
+		return z.compressProgress(src, level)
+	}
+
+	cctx := z.getCCtx()
+	if cctx == nil {
+		return nil, z.allocError("compress", "compression context")
+	}
+	defer z.putCCtx(cctx)
 
+	// Compress into a pooled buffer of the worst case size, returning a copy of the result
 	dstCapacity := z.CompressBound(len(src))
-	dst := make([]byte, dstCapacity)
+	dst := getBuffer(dstCapacity)
 
-	result := z.compress(
+	op := z.startOperation(true, Operation{Level: level, SrcSize: int64(len(src))})
+	result := z.compressCCtx(
+		cctx,
 		unsafe.Pointer(&dst[0]),
 		uint64(dstCapacity),
 		unsafe.Pointer(&src[0]),
@@ -49,16 +88,24 @@ func (z *Zstd) Compress(src []byte, level int) ([]byte, error) {
 	)
 
 	if z.isError(result) != 0 {
-		return nil, fmt.Errorf("zstd compression error: %s", z.getErrorName(result))
+		err := z.nativeError("compress", result)
+		op.end(0, 0, err)
+		putBuffer(dst)
+		return nil, err
 	}
+	op.end(int64(len(src)), int64(result), nil)
 
-	return dst[:result], nil
+	return scratchResult(dst, int(result)), nil
 }
 
 // Decompress decompresses the data from src and returns the decompressed data.
 // The maxSize parameter limits the maximum size of the decompressed data to prevent
 // decompression bombs. Use 0 for the library default max size.
 func (z *Zstd) Decompress(src []byte, maxSize int) ([]byte, error) {
+	if z.isClosed() {
+		return nil, ErrAlreadyClosed
+	}
+
 	if len(src) == 0 {
 		return []byte{}, nil
 	}
@@ -72,10 +119,32 @@ func (z *Zstd) Decompress(src []byte, maxSize int) ([]byte, error) {
 			maxSize = 1024 // Minimum reasonable size
 		}
 	}
+	if z.registry != nil {
+		dict, done, err := z.frameDictionary(src)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		if dict != nil {
+			return z.DecompressUsingDict(src, dict, maxSize)
+		}
+	}
+	if z.progress != nil {
+		return z.decompressProgress(src, maxSize)
+	}
+
+	dctx := z.getDCtx()
+	if dctx == nil {
+		return nil, z.allocError("decompress", "decompression context")
+	}
+	defer z.putDCtx(dctx)
 
-	dst := make([]byte, maxSize)
+	// Decompress into a pooled buffer of maxSize, returning a copy of the result
+	dst := getBuffer(maxSize)
 
-	result := z.decompress(
+	op := z.startOperation(false, Operation{SrcSize: int64(len(src))})
+	result := z.decompressDCtx(
+		dctx,
 		unsafe.Pointer(&dst[0]),
 		uint64(maxSize),
 		unsafe.Pointer(&src[0]),
@@ -83,44 +152,215 @@ func (z *Zstd) Decompress(src []byte, maxSize int) ([]byte, error) {
 	)
 
 	if z.isError(result) != 0 {
-		return nil, fmt.Errorf("zstd decompression error: %s", z.getErrorName(result))
+		err := z.nativeError("decompress", result)
+		op.end(0, 0, err)
+		putBuffer(dst)
+		return nil, err
 	}
+	op.end(int64(len(src)), int64(result), nil)
 
-	return dst[:result], nil
+	return scratchResult(dst, int(result)), nil
 }
 
-// NewReader creates an io.ReadCloser for decompressing data from the provided reader.
+// NewReader creates a Reader for decompressing data from the provided reader.
 // It will read and decompress data on demand.
-func (z *Zstd) NewReader(r io.Reader) io.ReadCloser {
-	return &Reader{
-		zstd:   z,
-		reader: r,
-		ctx:    z.createDCtx(),
-		buffer: make([]byte, defaultReadBufferSize),
+func (z *Zstd) NewReader(r io.Reader, opts ...Option) *Reader {
+	return z.NewReaderOptions(r, z.options(opts...))
+}
+
+// NewReaderOptions creates a Reader for decompressing data from the provided reader,
+// configured by opts. ReadBufferSize sizes the internal buffers, WindowSize limits the
+// window the decoder accepts and MaxDecompressSize caps the total decompressed output:
+// data up to the limit is returned, after which Read fails with a *MaxSizeError.
+// ReadAhead enables decompression in a background goroutine, and SkippableFrameHandler
+// receives the contents of skippable frames. Dictionary is the dictionary the data was
+// compressed with; if it cannot be loaded, the first Read reports why.
+// Zero values fall back to the defaults.
+func (z *Zstd) NewReaderOptions(r io.Reader, opts Options) *Reader {
+	// A reader from a closed instance fails every operation
+	if z.isClosed() {
+		return &Reader{zstd: z, closed: true}
+	}
+
+	bufferSize := opts.ReadBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultReadBufferSize
+	}
+
+	reader := &Reader{
+		zstd:              z,
+		reader:            r,
+		buffer:            getBuffer(bufferSize),
+		readBuffer:        getBuffer(bufferSize),
+		bufferSize:        bufferSize,
+		windowSize:        opts.WindowSize,
+		maxDecompressSize: opts.MaxDecompressSize,
+		readAhead:         opts.ReadAhead,
+		skippableHandler:  opts.SkippableFrameHandler,
+		cancelCtx:         opts.Context,
+		progress:          opts.Progress,
+		progressTotal:     sourceSize(r),
+		registry:          opts.Dictionaries,
+		dictLoad:          opts.DictLoadMethod,
+		dictContent:       opts.DictContentType,
+	}
+	if err := opts.validate(int(z.minCLevel()), int(z.maxCLevel())); err != nil {
+		reader.err = err
+	} else if len(opts.Dictionary) > 0 {
+		reader.dict, reader.err = z.LoadDictionary(opts.Dictionary)
+	}
+
+	// Free the native stream if the reader is dropped without Close
+	runtime.SetFinalizer(reader, (*Reader).finalize)
+	return reader
+}
+
+// NewWriter creates a Writer for compressing data to the provided writer.
+// The compressed data will be written to the provided writer. A level of 0 uses the
+// default level of the instance, set by SetDefaultLevel or given to New, or DefaultCompression.
+// The caller must call Close() when done to ensure all data is flushed.
+func (z *Zstd) NewWriter(w io.Writer, level int, opts ...Option) *Writer {
+	if level != 0 {
+		opts = append([]Option{WithLevel(level)}, opts...)
+	}
+	return z.NewWriterOptions(w, z.options(opts...))
+}
+
+// SetDefaultLevel sets the level used by the instance when none is specified: by
+// Compress and the other one-shot functions given a level of 0, and by the Readers and
+// Writers created from then on. It overrides a level given to New, and a level of 0
+// restores it. The instance may be in use by other goroutines.
+func (z *Zstd) SetDefaultLevel(level int) error {
+	if z.isClosed() {
+		return ErrAlreadyClosed
 	}
+	if level != 0 {
+		if minLevel, maxLevel := int(z.minCLevel()), int(z.maxCLevel()); level < minLevel || level > maxLevel {
+			return fmt.Errorf("%w: %d is outside %d to %d", ErrInvalidLevel, level, minLevel, maxLevel)
+		}
+	}
+	z.defaultLevel.Store(int64(level))
+	return nil
 }
 
-// NewWriter creates an io.WriteCloser for compressing data to the provided writer.
-// The compressed data will be written to the provided writer.
+// resolveLevel returns level, or the default level of the instance if it is 0
+func (z *Zstd) resolveLevel(level int) int {
+	if level != 0 {
+		return level
+	}
+	return z.options().CompressionLevel
+}
+
+// options returns the default options with those of the instance and then opts applied
+func (z *Zstd) options(opts ...Option) Options {
+	options := DefaultOptions()
+	for _, opt := range z.defaults {
+		opt(&options)
+	}
+	if level := z.defaultLevel.Load(); level != 0 {
+		options.CompressionLevel = int(level)
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return options
+}
+
+// NewWriterOptions creates a Writer for compressing data to the provided writer,
+// configured by opts. CompressionLevel selects the level, while WindowSize, Checksum and
+// Workers tune the compression parameters of each frame. WriteBufferSize sizes the
+// internal output buffer and CoalesceSize the buffer for small writes. FlushOnWrite makes
+// every Write immediately decodable by the receiver, and FlushInterval flushes in the
+// background after a period without writes. ContentSize pledges the total size of the
+// data, which must then be written exactly; the frame ends as soon as it is complete.
+// AdaptMinLevel and AdaptMaxLevel let the level follow the speed of the destination.
+// LongDistance enables long distance matching, and Rsyncable rsync-friendly output.
+// Dictionary is used to compress; if it cannot be loaded, the first Write reports why.
+// Zero values fall back to the defaults; a CompressionLevel of 0 uses the default level of
+// the instance, as for NewWriter.
 // The caller must call Close() when done to ensure all data is flushed.
-func (z *Zstd) NewWriter(w io.Writer, level int) io.WriteCloser {
-	return &Writer{
-		zstd:   z,
-		writer: w,
-		ctx:    z.createCCtx(),
-		level:  level,
-		buffer: make([]byte, defaultWriteBufferSize),
+func (z *Zstd) NewWriterOptions(w io.Writer, opts Options) *Writer {
+	// A writer from a closed instance fails every operation
+	if z.isClosed() {
+		return &Writer{zstd: z, closed: true}
+	}
+
+	level := z.resolveLevel(opts.CompressionLevel)
+
+	bufferSize := opts.WriteBufferSize
+	if bufferSize <= 0 {
+		bufferSize = defaultWriteBufferSize
+	}
+
+	coalesceSize := opts.CoalesceSize
+	if coalesceSize == 0 {
+		coalesceSize = defaultCoalesceSize
 	}
+
+	writer := &Writer{
+		zstd:          z,
+		writer:        w,
+		level:         level,
+		windowSize:    opts.WindowSize,
+		checksum:      opts.Checksum,
+		workers:       opts.Workers,
+		buffer:        getBuffer(bufferSize),
+		bufferSize:    bufferSize,
+		flushOnWrite:  opts.FlushOnWrite,
+		flushInterval: opts.FlushInterval,
+		contentSize:   opts.ContentSize,
+		cancelCtx:     opts.Context,
+		progress:      opts.Progress,
+		longDistance:  opts.LongDistance,
+		rsyncable:     opts.Rsyncable,
+		dictLoad:      opts.DictLoadMethod,
+		dictContent:   opts.DictContentType,
+	}
+	if err := opts.validate(int(z.minCLevel()), int(z.maxCLevel())); err != nil {
+		writer.err = err
+	} else if len(opts.Dictionary) > 0 {
+		writer.dict, writer.err = z.LoadDictionary(opts.Dictionary)
+	}
+	if opts.AdaptMinLevel != 0 || opts.AdaptMaxLevel != 0 {
+		writer.adapt = newAdapter(opts.AdaptMinLevel, opts.AdaptMaxLevel)
+		writer.level = min(max(writer.level, writer.adapt.minLevel), writer.adapt.maxLevel)
+
+		// The level can only change between the jobs of the multithreaded compressor
+		writer.workers = max(writer.workers, 1)
+	}
+	if opts.Rsyncable {
+		// Only the multithreaded compressor cuts the output at rsync-friendly points
+		writer.workers = max(writer.workers, 1)
+	}
+	if coalesceSize > 0 && !opts.FlushOnWrite {
+		writer.pending = make([]byte, 0, coalesceSize)
+	}
+
+	// Free the native stream if the writer is dropped without Close
+	runtime.SetFinalizer(writer, (*Writer).finalize)
+	return writer
 }
 
 // Close releases all resources associated with the Zstd instance.
-// After Close is called, the Zstd instance cannot be used anymore.
+// After Close is called, the Zstd instance cannot be used anymore: operations
+// return ErrAlreadyClosed, and calling Close again has no effect.
+// Readers, Writers and Dictionaries created from the instance must not outlive it.
 func (z *Zstd) Close() error {
 	if z.handle == 0 {
 		return nil // Already closed
 	}
+	runtime.SetFinalizer(z, nil)
 
+	z.freeContexts()
+	if z.customMem.opaque != 0 {
+		unregisterAllocator(z.customMem)
+	}
 	err := z.closeLibrary()
 	z.handle = 0
 	return err
 }
+
+// isClosed reports whether the library has been unloaded by Close
+func (z *Zstd) isClosed() bool {
+	return z.handle == 0
+}
@@ -4,9 +4,27 @@ import "io"
 
 // New creates a new Zstandard instance.
 // It handles loading the appropriate library for the current platform.
+// The options become the defaults of the Readers and Writers the instance creates.
 // The returned instance should be closed with Close() when done.
-func New() (*Zstd, error) {
-	return loadLibrary()
+func New(opts ...Option) (*Zstd, error) {
+	z, err := loadLibrary()
+	if err != nil {
+		return nil, err
+	}
+	z.defaults = opts
+
+	options := z.options()
+	z.progress = options.Progress
+	z.registry = options.Dictionaries
+	z.instrumentation = options.Instrumentation
+	if options.Allocator != nil {
+		z.useAllocator(options.Allocator)
+	}
+	if options.Tracer != nil {
+		z.tracer = options.Tracer
+		z.traceCalls()
+	}
+	return z, nil
 }
 
 // CompressLevel compresses the input data using the specified compression level.
@@ -49,78 +67,124 @@ func Decompress(src []byte, maxSize int) ([]byte, error) {
 	return z.Decompress(src, maxSize)
 }
 
-// NewReader creates an io.ReadCloser for decompressing data from the provided reader.
+// NewReader creates a Reader for decompressing data from the provided reader.
+// The Reader uses a Zstandard instance of its own, which is unloaded by Close.
 // The returned reader should be closed with Close() when done.
-func NewReader(r io.Reader) (io.ReadCloser, error) {
+func NewReader(r io.Reader, opts ...Option) (*Reader, error) {
 	z, err := New()
 	if err != nil {
 		return nil, err
 	}
 
-	reader := z.NewReader(r)
+	return ownedReader(z.NewReader(r, opts...)), nil
+}
+
+// NewReaderOptions creates a Reader for decompressing data from the provided reader
+// configured by opts.
+// The returned reader should be closed with Close() when done.
+func NewReaderOptions(r io.Reader, opts Options) (*Reader, error) {
+	z, err := New()
+	if err != nil {
+		return nil, err
+	}
 
-	// We need to wrap the reader to handle closing the zstd instance
-	return &readCloserWrapper{
-		ReadCloser: reader,
-		zstd:       z,
-	}, nil
+	return ownedReader(z.NewReaderOptions(r, opts)), nil
 }
 
-// NewWriter creates an io.WriteCloser for compressing data to the provided writer
-// using the default compression level.
+// NewWriter creates a Writer for compressing data to the provided writer, using the
+// default compression level unless WithLevel selects another.
+// The Writer uses a Zstandard instance of its own, which is unloaded by Close.
 // The returned writer should be closed with Close() when done.
-func NewWriter(w io.Writer) (io.WriteCloser, error) {
-	return NewWriterLevel(w, DefaultCompression)
+func NewWriter(w io.Writer, opts ...Option) (*Writer, error) {
+	z, err := New()
+	if err != nil {
+		return nil, err
+	}
+
+	return ownedWriter(z.NewWriter(w, 0, opts...)), nil
 }
 
-// NewWriterLevel creates an io.WriteCloser for compressing data to the provided writer
+// NewWriterLevel creates a Writer for compressing data to the provided writer
 // using the specified compression level.
 // The returned writer should be closed with Close() when done.
-func NewWriterLevel(w io.Writer, level int) (io.WriteCloser, error) {
+//
+// Deprecated: use NewWriter with WithLevel.
+func NewWriterLevel(w io.Writer, level int, opts ...Option) (*Writer, error) {
+	return NewWriter(w, append([]Option{WithLevel(level)}, opts...)...)
+}
+
+// NewWriterOptions creates a Writer for compressing data to the provided writer
+// configured by opts.
+// The returned writer should be closed with Close() when done.
+func NewWriterOptions(w io.Writer, opts Options) (*Writer, error) {
 	z, err := New()
 	if err != nil {
 		return nil, err
 	}
 
-	writer := z.NewWriter(w, level)
-
-	// We need to wrap the writer to handle closing the zstd instance
-	return &writeCloserWrapper{
-		WriteCloser: writer,
-		zstd:        z,
-	}, nil
+	return ownedWriter(z.NewWriterOptions(w, opts)), nil
 }
 
-// readCloserWrapper wraps a ReadCloser and also closes the zstd instance
-type readCloserWrapper struct {
-	io.ReadCloser
-	zstd *Zstd
+// NewReaderDict creates a Reader for decompressing data from the provided reader
+// that was compressed with the given dictionary.
+// The returned reader should be closed with Close() when done.
+//
+// Deprecated: use NewReader with WithDictionary.
+func NewReaderDict(r io.Reader, dict []byte, opts ...Option) (*Reader, error) {
+	z, err := New()
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := z.LoadDictionary(dict)
+	if err != nil {
+		z.Close()
+		return nil, err
+	}
+
+	return ownedReader(z.NewReaderDict(r, d, opts...)), nil
 }
 
-// Close closes both the reader and the zstd instance
-func (r *readCloserWrapper) Close() error {
-	err1 := r.ReadCloser.Close()
-	err2 := r.zstd.Close()
+// NewWriterDict creates a Writer for compressing data to the provided writer
+// using the given dictionary and compression level.
+// The returned writer should be closed with Close() when done.
+//
+// Deprecated: use NewWriter with WithDictionary and WithLevel.
+func NewWriterDict(w io.Writer, dict []byte, level int, opts ...Option) (*Writer, error) {
+	z, err := New()
+	if err != nil {
+		return nil, err
+	}
 
-	if err1 != nil {
-		return err1
+	d, err := z.LoadDictionary(dict)
+	if err != nil {
+		z.Close()
+		return nil, err
 	}
-	return err2
+
+	return ownedWriter(z.NewWriterDict(w, d, level, opts...)), nil
 }
 
-// writeCloserWrapper wraps a WriteCloser and also closes the zstd instance
-type writeCloserWrapper struct {
-	io.WriteCloser
-	zstd *Zstd
+// ownedReader marks the reader as the only user of its instance, so closing it unloads the library
+func ownedReader(r *Reader) *Reader {
+	r.ownsZstd = true
+	return r
 }
 
-// Close closes both the writer and the zstd instance
-func (w *writeCloserWrapper) Close() error {
-	err1 := w.WriteCloser.Close()
-	err2 := w.zstd.Close()
+// ownedWriter marks the writer as the only user of its instance, so closing it unloads the library
+func ownedWriter(w *Writer) *Writer {
+	w.ownsZstd = true
+	return w
+}
 
-	if err1 != nil {
-		return err1
+// ListFrames describes the frames of the stream read from r, like zstd -l, without
+// decompressing them.
+func ListFrames(r io.Reader) (*FrameList, error) {
+	z, err := New()
+	if err != nil {
+		return nil, err
 	}
-	return err2
+	defer z.Close()
+
+	return z.ListFrames(r)
 }
@@ -1,8 +1,32 @@
 package zstd
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
 	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"testing/iotest"
+	"time"
+	"unsafe"
 )
 
 func TestBasicCompressDecompress(t *testing.T) {
@@ -44,3 +68,4391 @@ func TestLibraryVersion(t *testing.T) {
 	}
 	t.Logf("Loaded zstd library version: %s", version)
 }
+
+func TestPrefixCompression(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	previous := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 200)
+	current := append(append([]byte{}, previous...), []byte("One more sentence at the end.")...)
+
+	withPrefix, err := z.CompressWithPrefix(current, previous, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Prefix compression failed: %v", err)
+	}
+
+	plain, err := z.Compress(current, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	if len(withPrefix) >= len(plain) {
+		t.Errorf("Expected prefix compression to be smaller: %d >= %d", len(withPrefix), len(plain))
+	}
+
+	decompressed, err := z.DecompressWithPrefix(withPrefix, previous, 0)
+	if err != nil {
+		t.Fatalf("Prefix decompression failed: %v", err)
+	}
+
+	if !bytes.Equal(current, decompressed) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+}
+
+// forgedFrame returns a frame holding a single byte whose header claims contentSize bytes
+func forgedFrame(contentSize uint64) []byte {
+	frame := binary.LittleEndian.AppendUint32(nil, 0xFD2FB528)
+	frame = append(frame, 0xC0, 0x00) // 8-byte content size, 1KB window
+	frame = binary.LittleEndian.AppendUint64(frame, contentSize)
+	return append(frame, 0x09, 0x00, 0x00, 'x') // Last raw block of 1 byte
+}
+
+func TestForgedContentSize(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	// The claimed size is not allocated up front; the frame fails once decoded
+	if _, err := z.DecompressWithPrefix(forgedFrame(1<<40), []byte("prefix"), 0); err == nil {
+		t.Error("Expected the forged frame to fail")
+	}
+
+	// A large frame with a recorded size still decompresses beyond the preallocation bound
+	data := make([]byte, maxPreallocSize+1000)
+	compressed, _ := z.Compress(data, 1)
+	decompressed, err := z.DecompressWithPrefix(compressed, nil, 0)
+	if err != nil || !bytes.Equal(decompressed, data) {
+		t.Errorf("Large frame failed: %v", err)
+	}
+	if _, err := z.DecompressWithPrefix(compressed, nil, maxPreallocSize+10); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Errorf("Expected ErrMaxSizeExceeded, got %v", err)
+	}
+}
+
+func TestPatchRoundTrip(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	oldData := make([]byte, 256*1024)
+	rand.New(rand.NewSource(1)).Read(oldData)
+
+	newData := append([]byte{}, oldData...)
+	copy(newData[1000:], []byte("patched region"))
+	newData = append(newData, []byte("appended tail")...)
+
+	patch, err := z.CreatePatch(oldData, newData)
+	if err != nil {
+		t.Fatalf("CreatePatch failed: %v", err)
+	}
+
+	if len(patch) > 1024 {
+		t.Errorf("Patch unexpectedly large: %d bytes", len(patch))
+	}
+
+	applied, err := z.ApplyPatch(oldData, patch)
+	if err != nil {
+		t.Fatalf("ApplyPatch failed: %v", err)
+	}
+
+	if !bytes.Equal(newData, applied) {
+		t.Errorf("Patched data doesn't match new version")
+	}
+
+	// Patches are untrusted: the output is bounded and forged sizes are not allocated
+	if _, err := z.ApplyPatchLimit(oldData, patch, len(newData)-1); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Errorf("Expected ErrMaxSizeExceeded, got %v", err)
+	}
+	if _, err := z.ApplyPatch(oldData, forgedFrame(1<<40)); err == nil {
+		t.Error("Expected a forged patch to fail")
+	}
+}
+
+func TestWriterProgress(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, DefaultCompression)
+
+	data := bytes.Repeat([]byte("progress "), 100000)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	progress := w.Progress()
+	if progress.Ingested != uint64(len(data)) {
+		t.Errorf("Expected %d bytes ingested, got %d", len(data), progress.Ingested)
+	}
+	if progress.Consumed == 0 || progress.Produced == 0 {
+		t.Errorf("Expected compression to have progressed: %+v", progress)
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	if w.BytesIn() != int64(len(data)) {
+		t.Errorf("Expected BytesIn %d, got %d", len(data), w.BytesIn())
+	}
+	if w.BytesOut() != int64(buf.Len()) {
+		t.Errorf("Expected BytesOut %d, got %d", buf.Len(), w.BytesOut())
+	}
+	if w.Ratio() <= 1 {
+		t.Errorf("Expected a compression ratio above 1, got %.2f", w.Ratio())
+	}
+
+	// Progress may be polled while the flush timer flushes the stream
+	w = z.NewWriter(io.Discard, DefaultCompression, WithFlushInterval(time.Millisecond))
+	defer w.Close()
+	deadline := time.Now().Add(50 * time.Millisecond)
+	for time.Now().Before(deadline) {
+		w.Write(data[:1000])
+		w.Progress()
+	}
+}
+
+func TestReaderOptionsMaxDecompressSize(t *testing.T) {
+	data := bytes.Repeat([]byte("limit "), 10000)
+	compressed, err := Compress(data)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.ReadBufferSize = 4096
+	opts.MaxDecompressSize = int64(len(data) / 2)
+
+	reader, err := NewReaderOptions(bytes.NewReader(compressed), opts)
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Errorf("Expected ErrMaxSizeExceeded, got %v", err)
+	}
+	if int64(len(decompressed)) != opts.MaxDecompressSize {
+		t.Errorf("Expected exactly %d bytes before the limit, got %d", opts.MaxDecompressSize, len(decompressed))
+	}
+}
+
+func TestReaderConcatenatedFrames(t *testing.T) {
+	first := bytes.Repeat([]byte("first frame "), 1000)
+	second := bytes.Repeat([]byte("second frame "), 1000)
+
+	a, err := Compress(first)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	b, err := Compress(second)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	reader, err := NewReader(bytes.NewReader(append(a, b...)))
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+
+	if !bytes.Equal(append(first, second...), decompressed) {
+		t.Errorf("Expected both frames to be decoded, got %d bytes", len(decompressed))
+	}
+
+	if reader.BytesIn() != int64(len(a)+len(b)) {
+		t.Errorf("Expected BytesIn %d, got %d", len(a)+len(b), reader.BytesIn())
+	}
+	if reader.BytesOut() != int64(len(decompressed)) {
+		t.Errorf("Expected BytesOut %d, got %d", len(decompressed), reader.BytesOut())
+	}
+
+	truncated, err := NewReader(bytes.NewReader(a[:len(a)-4]))
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer truncated.Close()
+
+	if _, err := io.ReadAll(truncated); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF for truncated input, got %v", err)
+	}
+}
+
+func TestWriterCoalescesSmallWrites(t *testing.T) {
+	data := []byte("written one byte at a time, then flushed and closed")
+
+	var buf bytes.Buffer
+	writer, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	for i := range data {
+		if _, err := writer.Write(data[i : i+1]); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected small writes to be buffered, got %d bytes of output", buf.Len())
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	decompressed, err := Decompress(buf.Bytes(), 0)
+	if err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if !bytes.Equal(data, decompressed) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+}
+
+func TestWriterFlushOnWrite(t *testing.T) {
+	var buf bytes.Buffer
+
+	opts := DefaultOptions()
+	opts.FlushOnWrite = true
+
+	writer, err := NewWriterOptions(&buf, opts)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	defer writer.Close()
+
+	message := []byte("interactive message")
+	if _, err := writer.Write(message); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	// The receiver must be able to decode the message before the frame ends
+	reader, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer reader.Close()
+
+	received := make([]byte, len(message))
+	if _, err := io.ReadFull(reader, received); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(message, received) {
+		t.Errorf("Expected %q, got %q", message, received)
+	}
+}
+
+// signalWriter collects output and signals every write, for tests of background flushing
+type signalWriter struct {
+	written chan []byte
+}
+
+func (s *signalWriter) Write(p []byte) (int, error) {
+	s.written <- append([]byte{}, p...)
+	return len(p), nil
+}
+
+func TestWriterFlushInterval(t *testing.T) {
+	out := &signalWriter{written: make(chan []byte, 16)}
+
+	writer, err := NewWriter(out, WithFlushInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	defer writer.Close()
+
+	if _, err := writer.Write([]byte("last log line")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	select {
+	case chunk := <-out.written:
+		if len(chunk) == 0 {
+			t.Errorf("Expected flushed output")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a background flush after the idle interval")
+	}
+}
+
+func TestWriterAppliesParameters(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := make([]byte, 512*1024)
+	rng := rand.New(rand.NewSource(2))
+	for i := range data {
+		data[i] = byte('a' + rng.Intn(4))
+	}
+
+	compress := func(opts Options) []byte {
+		var buf bytes.Buffer
+		w := z.NewWriterOptions(&buf, opts)
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+
+	fast := compress(Options{CompressionLevel: BestSpeed})
+	best := compress(Options{CompressionLevel: BestCompression})
+	if len(best) >= len(fast) {
+		t.Errorf("Expected level %d to beat level %d: %d >= %d", BestCompression, BestSpeed, len(best), len(fast))
+	}
+
+	// The checksum flag is bit 2 of the frame header descriptor
+	checksummed := compress(Options{CompressionLevel: DefaultCompression, Checksum: true})
+	if checksummed[4]&0x04 == 0 {
+		t.Errorf("Expected the frame header to have the checksum flag set")
+	}
+}
+
+func TestStreamingDictionary(t *testing.T) {
+	dict := []byte(`{"id": 0, "name": "", "email": "@example.com", "active": true, "roles": ["admin", "user"]}`)
+	message := []byte(`{"id": 42, "name": "gopher", "email": "gopher@example.com", "active": true, "roles": ["user"]}`)
+
+	var buf bytes.Buffer
+	writer, err := NewWriterDict(&buf, dict, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if _, err := writer.Write(message); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	plain, err := Compress(message)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	if buf.Len() >= len(plain) {
+		t.Errorf("Expected dictionary compression to be smaller: %d >= %d", buf.Len(), len(plain))
+	}
+
+	reader, err := NewReaderDict(&buf, dict)
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(message, decompressed) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+}
+
+func TestReaderReadByte(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	var encoded []byte
+	values := []uint64{0, 1, 300, 1 << 40}
+	for _, v := range values {
+		encoded = binary.AppendUvarint(encoded, v)
+	}
+
+	compressed, err := z.Compress(encoded, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	reader := z.NewReader(bytes.NewReader(compressed))
+	defer reader.Close()
+
+	for _, want := range values {
+		got, err := binary.ReadUvarint(reader)
+		if err != nil {
+			t.Fatalf("ReadUvarint failed: %v", err)
+		}
+		if got != want {
+			t.Errorf("Expected %d, got %d", want, got)
+		}
+	}
+
+	if _, err := reader.ReadByte(); err != io.EOF {
+		t.Errorf("Expected io.EOF at the end of the stream, got %v", err)
+	}
+}
+
+func TestReaderReadAhead(t *testing.T) {
+	data := make([]byte, 1<<20)
+	rng := rand.New(rand.NewSource(3))
+	for i := range data {
+		data[i] = byte('a' + rng.Intn(8))
+	}
+
+	compressed, err := Compress(data)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	reader, err := NewReader(bytes.NewReader(compressed), WithReadAhead(2))
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(data, decompressed) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+
+	// A source that stalls does not block Close
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go pw.Write(compressed[:len(compressed)/2])
+	stalled, _ := NewReader(pr, WithReadAhead(2))
+	if _, err := io.ReadFull(stalled, make([]byte, 1000)); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	closed := make(chan error, 1)
+	go func() { closed <- stalled.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on a stalled source")
+	}
+
+	// Nor does it keep a Reader dropped without Close from being freed
+	before := Stats().ContextsAlive
+	pr, pw = io.Pipe()
+	defer pw.Close()
+	go pw.Write(compressed[:len(compressed)/2])
+	func() {
+		dropped, _ := NewReader(pr, WithReadAhead(2))
+		io.ReadFull(dropped, make([]byte, 1000))
+	}()
+	for deadline := time.Now().Add(5 * time.Second); Stats().ContextsAlive > before; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the dropped reader to be finalized")
+		}
+		runtime.GC()
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriterCloseWithError(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	pr, pw := io.Pipe()
+	received := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(pr)
+		received <- err
+	}()
+
+	w := z.NewWriter(pw, DefaultCompression)
+	if _, err := w.Write(bytes.Repeat([]byte("partial upload "), 10000)); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	uploadErr := errors.New("upload failed")
+	if err := w.CloseWithError(uploadErr); err != nil {
+		t.Fatalf("CloseWithError failed: %v", err)
+	}
+
+	if err := <-received; !errors.Is(err, uploadErr) {
+		t.Errorf("Expected the pipe reader to observe %v, got %v", uploadErr, err)
+	}
+}
+
+func TestOperationsAfterClose(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, DefaultCompression)
+	if _, err := w.Write([]byte("closed soon")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+	if _, err := w.Write([]byte("too late")); err != ErrAlreadyClosed {
+		t.Errorf("Expected ErrAlreadyClosed from Write, got %v", err)
+	}
+
+	r := z.NewReader(bytes.NewReader(buf.Bytes()))
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+	if _, err := r.Read(make([]byte, 16)); err != ErrAlreadyClosed {
+		t.Errorf("Expected ErrAlreadyClosed from Read, got %v", err)
+	}
+
+	if err := z.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if err := z.Close(); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+	if _, err := z.Compress([]byte("data"), DefaultCompression); err != ErrAlreadyClosed {
+		t.Errorf("Expected ErrAlreadyClosed from Compress, got %v", err)
+	}
+	if _, err := z.NewWriter(&buf, DefaultCompression).Write([]byte("data")); err != ErrAlreadyClosed {
+		t.Errorf("Expected ErrAlreadyClosed from a writer of a closed instance, got %v", err)
+	}
+}
+
+func TestFinalizersReleaseLeakedStreams(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	compressed, err := z.Compress([]byte("leaked"), DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	// Create streams and drop them without calling Close
+	for i := 0; i < 10; i++ {
+		w := z.NewWriter(io.Discard, DefaultCompression)
+		w.Write([]byte("never closed"))
+		r := z.NewReader(bytes.NewReader(compressed))
+		io.ReadAll(r)
+	}
+
+	runtime.GC()
+	runtime.GC()
+
+	if _, err := z.Compress([]byte("still usable"), DefaultCompression); err != nil {
+		t.Errorf("Instance unusable after finalizers ran: %v", err)
+	}
+}
+
+func TestStreamingUnderGCPressure(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	stop := make(chan struct{})
+	gcDone := make(chan struct{})
+	go func() {
+		defer close(gcDone)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				runtime.GC()
+			}
+		}
+	}()
+
+	errs := make(chan error, 4)
+	for g := 0; g < 4; g++ {
+		go func(seed int64) {
+			rng := rand.New(rand.NewSource(seed))
+			for i := 0; i < 20; i++ {
+				data := make([]byte, 1+rng.Intn(256*1024))
+				for j := range data {
+					data[j] = byte('a' + rng.Intn(16))
+				}
+
+				var buf bytes.Buffer
+				w := z.NewWriter(&buf, DefaultCompression)
+				for off := 0; off < len(data); {
+					n := min(len(data)-off, 1+rng.Intn(64*1024))
+					if _, err := w.Write(data[off : off+n]); err != nil {
+						errs <- err
+						return
+					}
+					off += n
+				}
+				if err := w.Close(); err != nil {
+					errs <- err
+					return
+				}
+
+				r := z.NewReader(&buf)
+				decompressed, err := io.ReadAll(r)
+				r.Close()
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(data, decompressed) {
+					errs <- errors.New("roundtrip mismatch under GC pressure")
+					return
+				}
+			}
+			errs <- nil
+		}(int64(g))
+	}
+
+	for g := 0; g < 4; g++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	close(stop)
+	<-gcDone
+}
+
+// shortWriter accepts at most one byte per call without reporting an error
+type shortWriter struct {
+	buf bytes.Buffer
+}
+
+func (s *shortWriter) Write(p []byte) (int, error) {
+	return s.buf.Write(p[:min(len(p), 1)])
+}
+
+func TestWriterDestinationErrors(t *testing.T) {
+	data := bytes.Repeat([]byte("short writes "), 1000)
+
+	short := &shortWriter{}
+	writer, err := NewWriter(short)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if _, err := writer.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	decompressed, err := Decompress(short.buf.Bytes(), len(data))
+	if err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if !bytes.Equal(data, decompressed) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+
+	diskFull := errors.New("disk full")
+	failing, err := NewWriter(NewErrorWriter(diskFull))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	failing.Write(data)
+	if err := failing.Close(); !errors.Is(err, diskFull) {
+		t.Errorf("Expected Close to report %v, got %v", diskFull, err)
+	}
+}
+
+func TestReaderSkipsSkippableFrames(t *testing.T) {
+	skippable := func(payload []byte) []byte {
+		frame := binary.LittleEndian.AppendUint32(nil, 0x184D2A5E)
+		frame = binary.LittleEndian.AppendUint32(frame, uint32(len(payload)))
+		return append(frame, payload...)
+	}
+
+	first, err := Compress([]byte("before "))
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	second, err := Compress([]byte("after"))
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	// Skippable frames at the start, between frames (larger than the read buffer) and at the end
+	var input []byte
+	input = append(input, skippable([]byte("header"))...)
+	input = append(input, first...)
+	input = append(input, skippable(bytes.Repeat([]byte{0xAB}, 100*1024))...)
+	input = append(input, second...)
+	input = append(input, skippable(nil)...)
+
+	reader, err := NewReader(bytes.NewReader(input))
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer reader.Close()
+
+	decompressed, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(decompressed) != "before after" {
+		t.Errorf("Expected %q, got %q", "before after", decompressed)
+	}
+}
+
+func TestWriterSkippableFrame(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, DefaultCompression)
+	w.Write([]byte("first "))
+	if err := w.WriteSkippableFrame(3, []byte("manifest")); err != nil {
+		t.Fatalf("WriteSkippableFrame failed: %v", err)
+	}
+	w.Write([]byte("second"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	var header [8]byte
+	binary.LittleEndian.PutUint32(header[0:4], 0x184D2A53)
+	binary.LittleEndian.PutUint32(header[4:8], uint32(len("manifest")))
+	if !bytes.Contains(buf.Bytes(), append(header[:], "manifest"...)) {
+		t.Errorf("Expected the skippable frame in the output")
+	}
+
+	r := z.NewReader(bytes.NewReader(buf.Bytes()))
+	defer r.Close()
+
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(decompressed) != "first second" {
+		t.Errorf("Expected %q, got %q", "first second", decompressed)
+	}
+}
+
+func TestReaderSkippableFrameHandler(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, DefaultCompression)
+	w.WriteSkippableFrame(1, []byte("header"))
+	w.Write([]byte("before "))
+	large := bytes.Repeat([]byte{0xAB}, 100*1024)
+	w.WriteSkippableFrame(2, large)
+	w.Write([]byte("after"))
+	w.WriteSkippableFrame(3, nil)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	type frame struct {
+		variant uint32
+		payload []byte
+	}
+
+	// One byte at a time splits headers and payloads across source reads
+	for _, src := range []io.Reader{bytes.NewReader(buf.Bytes()), iotest.OneByteReader(bytes.NewReader(buf.Bytes()))} {
+		var frames []frame
+		r := z.NewReader(src, WithSkippableFrameHandler(func(variant uint32, payload []byte) error {
+			frames = append(frames, frame{variant, payload})
+			return nil
+		}))
+
+		decompressed, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("Read failed: %v", err)
+		}
+		if string(decompressed) != "before after" {
+			t.Errorf("Expected %q, got %q", "before after", decompressed)
+		}
+
+		if len(frames) != 3 {
+			t.Fatalf("Expected 3 skippable frames, got %d", len(frames))
+		}
+		if frames[0].variant != 1 || string(frames[0].payload) != "header" {
+			t.Errorf("Unexpected first frame: %d %q", frames[0].variant, frames[0].payload)
+		}
+		if frames[1].variant != 2 || !bytes.Equal(frames[1].payload, large) {
+			t.Errorf("Unexpected second frame: %d with %d bytes", frames[1].variant, len(frames[1].payload))
+		}
+		if frames[2].variant != 3 || len(frames[2].payload) != 0 {
+			t.Errorf("Unexpected third frame: %d %q", frames[2].variant, frames[2].payload)
+		}
+	}
+
+	// Errors from the handler stop the reader
+	stop := errors.New("stop")
+	r := z.NewReader(bytes.NewReader(buf.Bytes()), WithSkippableFrameHandler(func(uint32, []byte) error {
+		return stop
+	}))
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, stop) {
+		t.Errorf("Expected %v, got %v", stop, err)
+	}
+
+	// A truncated payload is reported as such
+	truncated := binary.LittleEndian.AppendUint32(nil, skippableMagicStart)
+	truncated = binary.LittleEndian.AppendUint32(truncated, 100)
+	truncated = append(truncated, "short"...)
+	r = z.NewReader(bytes.NewReader(truncated), WithSkippableFrameHandler(func(uint32, []byte) error {
+		return nil
+	}))
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected %v, got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestWriterContentSize(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("known size content "), 5000)
+	expected, err := z.Compress(data, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	// A single write produces the same frame as one-shot compression, complete before Close
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, DefaultCompression, WithContentSize(int64(len(data))))
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Expected the one-shot frame (%d bytes) before Close, got %d bytes", len(expected), buf.Len())
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), expected) {
+		t.Errorf("Expected Close to write nothing more")
+	}
+
+	// Written in pieces, the frame records the content size
+	buf.Reset()
+	w = z.NewWriter(&buf, DefaultCompression, WithContentSize(int64(len(data))))
+	for chunk := range slices.Chunk(data, 1000) {
+		if _, err := w.Write(chunk); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if size := z.getFrameContentSize(unsafe.Pointer(&buf.Bytes()[0]), uint64(buf.Len())); size != uint64(len(data)) {
+		t.Errorf("Expected content size %d in the frame header, got %d", len(data), size)
+	}
+	decompressed, err := z.Decompress(buf.Bytes(), len(data))
+	if err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+
+	// Writing more than pledged is rejected, and so is closing early
+	w = z.NewWriter(io.Discard, DefaultCompression, WithContentSize(10))
+	if _, err := w.Write(make([]byte, 11)); err == nil {
+		t.Errorf("Expected an error writing past the pledged size")
+	}
+	w.Write(make([]byte, 5))
+	if err := w.Close(); err == nil {
+		t.Errorf("Expected an error closing before the pledged size was written")
+	}
+}
+
+func TestPackageLevelConcreteTypes(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	w.Write([]byte("concrete "))
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	w.Write([]byte("types"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Closing the writer unloads the instance it created
+	if !w.zstd.isClosed() {
+		t.Errorf("Expected the writer's instance to be closed")
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(decompressed) != "concrete types" {
+		t.Errorf("Expected %q, got %q", "concrete types", decompressed)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !r.zstd.isClosed() {
+		t.Errorf("Expected the reader's instance to be closed")
+	}
+}
+
+func TestReaderHeader(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("header metadata "), 1000)
+	compressed, err := z.Compress(data, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	// Before reading, behind a skippable frame
+	input := binary.LittleEndian.AppendUint32(nil, skippableMagicStart)
+	input = binary.LittleEndian.AppendUint32(input, 3)
+	input = append(input, "abc"...)
+	input = append(input, compressed...)
+
+	r := z.NewReader(iotest.OneByteReader(bytes.NewReader(input)))
+	defer r.Close()
+
+	header, err := r.Header()
+	if err != nil {
+		t.Fatalf("Header failed: %v", err)
+	}
+	if !header.HasContentSize || header.ContentSize != uint64(len(data)) {
+		t.Errorf("Expected content size %d, got %d (known: %v)", len(data), header.ContentSize, header.HasContentSize)
+	}
+	if header.WindowSize == 0 || header.HasChecksum || header.DictionaryID != 0 {
+		t.Errorf("Unexpected header: %+v", header)
+	}
+
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+
+	// After reading, for a streamed frame with a checksum
+	var buf bytes.Buffer
+	w := z.NewWriterOptions(&buf, Options{Checksum: true})
+	w.Write(data)
+	w.Close()
+
+	r = z.NewReader(&buf)
+	defer r.Close()
+	io.ReadAll(r)
+	header, err = r.Header()
+	if err != nil {
+		t.Fatalf("Header failed: %v", err)
+	}
+	if header.HasContentSize || !header.HasChecksum {
+		t.Errorf("Unexpected header: %+v", header)
+	}
+
+	// Empty and truncated input
+	r = z.NewReader(bytes.NewReader(nil))
+	defer r.Close()
+	if _, err := r.Header(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	r = z.NewReader(bytes.NewReader(compressed[:3]))
+	defer r.Close()
+	if _, err := r.Header(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestReaderSeek(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := make([]byte, 200*1024)
+	for i := range data {
+		data[i] = byte(i / 7)
+	}
+	compressed, err := z.Compress(data, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	for _, readAhead := range []int{0, 2} {
+		r := z.NewReader(bytes.NewReader(compressed), WithReadAhead(readAhead))
+		defer r.Close()
+
+		check := func(offset int64, whence int, want int64) {
+			t.Helper()
+			pos, err := r.Seek(offset, whence)
+			if err != nil {
+				t.Fatalf("Seek(%d, %d) failed: %v", offset, whence, err)
+			}
+			if pos != want {
+				t.Fatalf("Seek(%d, %d): expected position %d, got %d", offset, whence, want, pos)
+			}
+			buf := make([]byte, 100)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				t.Fatalf("Read after seek failed: %v", err)
+			}
+			if !bytes.Equal(buf, data[want:want+100]) {
+				t.Fatalf("Unexpected data at position %d", want)
+			}
+		}
+
+		check(50000, io.SeekStart, 50000)
+		check(1000, io.SeekCurrent, 51100)
+		check(10, io.SeekStart, 10)
+		check(-50, io.SeekCurrent, 60)
+
+		// Seeking past the end stops there
+		pos, err := r.Seek(int64(len(data))+10, io.SeekStart)
+		if err != nil || pos != int64(len(data)) {
+			t.Errorf("Expected to stop at %d, got %d (%v)", len(data), pos, err)
+		}
+	}
+
+	// Backward seeks need a seekable source
+	r := z.NewReader(io.MultiReader(bytes.NewReader(compressed)))
+	defer r.Close()
+	r.Seek(100, io.SeekStart)
+	if _, err := r.Seek(0, io.SeekStart); err == nil {
+		t.Errorf("Expected an error seeking backward over a non-seekable source")
+	}
+	if _, err := r.Seek(0, io.SeekEnd); err == nil {
+		t.Errorf("Expected an error seeking relative to the end")
+	}
+}
+
+func TestReaderSeekTar(t *testing.T) {
+	var archive bytes.Buffer
+	w, err := NewWriter(&archive)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	tw := tar.NewWriter(w)
+	for i, size := range []int{100000, 300, 50000} {
+		content := bytes.Repeat([]byte{byte('a' + i)}, size)
+		tw.WriteHeader(&tar.Header{Name: fmt.Sprintf("file%d", i), Mode: 0o644, Size: int64(size)})
+		tw.Write(content)
+	}
+	tw.Close()
+	w.Close()
+
+	r, err := NewReader(&archive)
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer r.Close()
+
+	// Skipping entries without reading them seeks over their content
+	tr := tar.NewReader(r)
+	var names []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next failed: %v", err)
+		}
+		names = append(names, hdr.Name)
+	}
+	if strings.Join(names, ",") != "file0,file1,file2" {
+		t.Errorf("Unexpected entries: %v", names)
+	}
+}
+
+func TestReaderDiscard(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	var records []byte
+	for i := range 1000 {
+		records = fmt.Appendf(records, "record %04d\n", i)
+	}
+	compressed, err := z.Compress(records, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+
+	r := z.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+
+	// Skip the first 500 records of 12 bytes each
+	n, err := r.Discard(500 * 12)
+	if err != nil || n != 500*12 {
+		t.Fatalf("Discard returned %d, %v", n, err)
+	}
+	line := make([]byte, 12)
+	if _, err := io.ReadFull(r, line); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if string(line) != "record 0500\n" {
+		t.Errorf("Expected record 0500, got %q", line)
+	}
+	if r.BytesOut() != 501*12 {
+		t.Errorf("Expected BytesOut %d, got %d", 501*12, r.BytesOut())
+	}
+
+	// Discarding past the end reports how much was left
+	n, err = r.Discard(int64(len(records)))
+	if err != io.EOF || n != int64(len(records)-501*12) {
+		t.Errorf("Expected %d bytes and io.EOF, got %d, %v", len(records)-501*12, n, err)
+	}
+}
+
+// slowWriter delays every write, like a congested network connection
+type slowWriter struct {
+	bytes.Buffer
+	delay time.Duration
+}
+
+func (w *slowWriter) Write(p []byte) (int, error) {
+	time.Sleep(w.delay)
+	return w.Buffer.Write(p)
+}
+
+func TestWriterAdaptiveLevel(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := make([]byte, 6<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	// A slow destination lowers the level
+	slow := &slowWriter{delay: time.Millisecond}
+	w := z.NewWriter(slow, 5, WithAdaptiveLevel(1, 9))
+	for chunk := range slices.Chunk(data, 64*1024) {
+		w.Write(chunk)
+	}
+	if level := w.Level(); level >= 5 {
+		t.Errorf("Expected the level to drop below 5 for a slow destination, got %d", level)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	decompressed, err := z.Decompress(slow.Bytes(), len(data))
+	if err != nil {
+		t.Fatalf("Decompression failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+
+	// A fast destination raises it
+	w = z.NewWriter(io.Discard, 1, WithAdaptiveLevel(1, 4))
+	for chunk := range slices.Chunk(data, 64*1024) {
+		w.Write(chunk)
+	}
+	if level := w.Level(); level <= 1 {
+		t.Errorf("Expected the level to rise above 1 for a fast destination, got %d", level)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestWriterPerFrameParameters(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	message := bytes.Repeat([]byte("per frame parameters "), 200)
+	frameHeader := func(frame []byte) FrameHeader {
+		t.Helper()
+		r := z.NewReader(bytes.NewReader(frame))
+		defer r.Close()
+		header, err := r.Header()
+		if err != nil {
+			t.Fatalf("Header failed: %v", err)
+		}
+		return header
+	}
+
+	var first, second bytes.Buffer
+	w := z.NewWriter(&first, BestSpeed)
+	w.Write(message)
+	if err := w.SetChecksum(true); err != ErrMidFrame {
+		t.Errorf("Expected ErrMidFrame, got %v", err)
+	}
+	if err := w.EndFrame(); err != nil {
+		t.Fatalf("EndFrame failed: %v", err)
+	}
+	if frameHeader(first.Bytes()).HasChecksum {
+		t.Errorf("Expected the first frame without a checksum")
+	}
+
+	// Change the parameters for the next message, on a new destination
+	w.Reset(&second)
+	if err := w.SetChecksum(true); err != nil {
+		t.Fatalf("SetChecksum failed: %v", err)
+	}
+	if err := w.SetLevel(BestCompression); err != nil {
+		t.Fatalf("SetLevel failed: %v", err)
+	}
+	if err := w.SetWindowSize(1 << 12); err != nil {
+		t.Fatalf("SetWindowSize failed: %v", err)
+	}
+	w.Write(message)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	header := frameHeader(second.Bytes())
+	if !header.HasChecksum || header.WindowSize > 1<<12 {
+		t.Errorf("Expected a checksum and a 4KB window, got %+v", header)
+	}
+
+	for _, frame := range [][]byte{first.Bytes(), second.Bytes()} {
+		decompressed, err := z.Decompress(frame, len(message))
+		if err != nil {
+			t.Fatalf("Decompression failed: %v", err)
+		}
+		if !bytes.Equal(decompressed, message) {
+			t.Errorf("Decompressed data doesn't match original")
+		}
+	}
+
+	// A closed writer can be reset and reused
+	var third bytes.Buffer
+	w.Reset(&third)
+	w.Write(message)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close after Reset failed: %v", err)
+	}
+	if !frameHeader(third.Bytes()).HasChecksum {
+		t.Errorf("Expected the parameters to survive Reset")
+	}
+}
+
+func TestPools(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	writers := z.NewWriterPool(DefaultCompression)
+	readers := z.NewReaderPool()
+
+	// Writers handed back and out again produce complete streams; whether sync.Pool
+	// returns the same Writer is not guaranteed, so only the output is checked
+	var streams [][]byte
+	for i := range 5 {
+		var buf bytes.Buffer
+		w := writers.Get(&buf)
+		fmt.Fprintf(w, "pooled message %d", i)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		writers.Put(w)
+		if decompressed, err := z.Decompress(buf.Bytes(), 0); err != nil || string(decompressed) != fmt.Sprintf("pooled message %d", i) {
+			t.Errorf("Round trip of stream %d failed: %q, %v", i, decompressed, err)
+		}
+		streams = append(streams, buf.Bytes())
+	}
+
+	var wg sync.WaitGroup
+	for i, stream := range streams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := readers.Get(bytes.NewReader(stream))
+			defer readers.Put(r)
+			defer r.Close()
+
+			decompressed, err := io.ReadAll(r)
+			if err != nil {
+				t.Errorf("Read failed: %v", err)
+				return
+			}
+			if want := fmt.Sprintf("pooled message %d", i); string(decompressed) != want {
+				t.Errorf("Expected %q, got %q", want, decompressed)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Parameters changed by one user are not seen by the next: its output matches
+	// a new Writer at the pool's level
+	content := bytes.Repeat([]byte("fresh pooled content "), 500)
+	var expected bytes.Buffer
+	fresh := z.NewWriter(&expected, DefaultCompression)
+	fresh.Write(content)
+	fresh.Close()
+
+	w := writers.Get(io.Discard)
+	w.SetLevel(BestCompression)
+	w.SetChecksum(true)
+	w.Write(content)
+	w.Close()
+	writers.Put(w)
+	var buf bytes.Buffer
+	w = writers.Get(&buf)
+	w.Write(content)
+	w.Close()
+	writers.Put(w)
+	if !bytes.Equal(buf.Bytes(), expected.Bytes()) {
+		t.Errorf("Expected the level and checksum to be reset after Put")
+	}
+	r := z.NewReader(bytes.NewReader(buf.Bytes()))
+	defer r.Close()
+	if header, err := r.Header(); err != nil || header.HasChecksum {
+		t.Errorf("Expected a frame without checksum, got %+v (%v)", header, err)
+	}
+}
+
+func TestDecompressingWriter(t *testing.T) {
+	data := make([]byte, 300*1024)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	first, err := Compress(data)
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	second, err := Compress([]byte("second frame"))
+	if err != nil {
+		t.Fatalf("Compression failed: %v", err)
+	}
+	input := append(first, second...)
+
+	var out bytes.Buffer
+	dw, err := NewDecompressingWriter(&out)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+
+	// Push the compressed data in uneven pieces, splitting frames anywhere
+	for chunk := range slices.Chunk(input, 777) {
+		if n, err := dw.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatalf("Write returned %d, %v", n, err)
+		}
+	}
+	if err := dw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if !bytes.Equal(out.Bytes(), append(data, "second frame"...)) {
+		t.Errorf("Decompressed output doesn't match original")
+	}
+
+	// Truncated input is reported on Close
+	dw, err = NewDecompressingWriter(io.Discard)
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	dw.Write(first[:len(first)/2])
+	if err := dw.Close(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	// So is output beyond the size limit
+	out.Reset()
+	dw, err = NewDecompressingWriter(&out, WithMaxDecompressSize(1000))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if _, err := dw.Write(first); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Errorf("Expected ErrMaxSizeExceeded, got %v", err)
+	}
+	if out.Len() != 1000 {
+		t.Errorf("Expected 1000 bytes of output, got %d", out.Len())
+	}
+	dw.Close()
+}
+
+func TestFunctionalOptions(t *testing.T) {
+	dict := bytes.Repeat([]byte("shared vocabulary for every message "), 50)
+	message := []byte("a message using the shared vocabulary for every message")
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, WithLevel(BestCompression), WithChecksum(true), WithDictionary(dict),
+		WithWindowLog(16), WithBufferSizes(4096, 4096))
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if w.Level() != BestCompression {
+		t.Errorf("Expected level %d, got %d", BestCompression, w.Level())
+	}
+	w.Write(message)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), WithDictionary(dict))
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	defer r.Close()
+	header, err := r.Header()
+	if err != nil {
+		t.Fatalf("Header failed: %v", err)
+	}
+	if !header.HasChecksum || header.WindowSize > 1<<16 {
+		t.Errorf("Unexpected header: %+v", header)
+	}
+	decompressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, message) {
+		t.Errorf("Expected %q, got %q", message, decompressed)
+	}
+
+	// Options given to New are the defaults of the instance's readers and writers
+	z, err := New(WithLevel(BestSpeed), WithDictionary(dict))
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	buf.Reset()
+	zw := z.NewWriter(&buf, 0)
+	if zw.Level() != BestSpeed {
+		t.Errorf("Expected the instance level %d, got %d", BestSpeed, zw.Level())
+	}
+	zw.Write(message)
+	zw.Close()
+
+	zr := z.NewReader(&buf)
+	defer zr.Close()
+	decompressed, err = io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, message) {
+		t.Errorf("Expected %q, got %q", message, decompressed)
+	}
+}
+
+// cancellingWriter cancels a context once enough data has been written to it
+type cancellingWriter struct {
+	cancel  context.CancelFunc
+	after   int
+	written int
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	if w.written >= w.after {
+		w.cancel()
+	}
+	return len(p), nil
+}
+
+func TestContextCancellation(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	// Uncancelled operations behave like their plain counterparts
+	compressed, err := z.CompressContext(context.Background(), data, DefaultCompression)
+	if err != nil {
+		t.Fatalf("CompressContext failed: %v", err)
+	}
+	decompressed, err := z.DecompressContext(context.Background(), compressed, 0)
+	if err != nil {
+		t.Fatalf("DecompressContext failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Errorf("Decompressed data doesn't match original")
+	}
+
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := z.CompressContext(cancelled, data, DefaultCompression); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if _, err := z.DecompressContext(cancelled, compressed, 0); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	// A writer stops in the middle of a large write, without completing the frame
+	ctx, cancel := context.WithCancel(context.Background())
+	dst := &cancellingWriter{cancel: cancel, after: 64 * 1024}
+	w := z.NewWriter(dst, DefaultCompression, WithContext(ctx))
+	n, err := w.Write(data)
+	if err != context.Canceled || n >= len(data) {
+		t.Errorf("Expected the write to stop early with context.Canceled, got %d, %v", n, err)
+	}
+	written := dst.written
+	if err := w.Close(); err != context.Canceled || dst.written != written {
+		t.Errorf("Expected Close to fail without writing, got %v", err)
+	}
+
+	// A reader stops between chunks
+	ctx, cancel = context.WithCancel(context.Background())
+	r := z.NewReader(bytes.NewReader(compressed), WithContext(ctx))
+	defer r.Close()
+	buf := make([]byte, 1024)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatalf("Read failed: %v", err)
+	}
+	cancel()
+	if _, err := io.ReadAll(r); err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	dictData := bytes.Repeat([]byte("shared dictionary content "), 64)
+
+	// A single instance serves one-shot calls from many goroutines at once
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+
+			dict, err := z.LoadDictionary(dictData)
+			if err != nil {
+				errs <- err
+				return
+			}
+			for i := 0; i < 20; i++ {
+				data := []byte(strings.Repeat(fmt.Sprintf("goroutine %d iteration %d ", g, i), 100))
+
+				compressed, err := z.Compress(data, g%5+1)
+				if err != nil {
+					errs <- err
+					return
+				}
+				decompressed, err := z.Decompress(compressed, len(data))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(decompressed, data) {
+					errs <- fmt.Errorf("goroutine %d: round trip mismatch", g)
+					return
+				}
+
+				compressed, err = z.CompressUsingDict(data, dict, DefaultCompression)
+				if err != nil {
+					errs <- err
+					return
+				}
+				decompressed, err = z.DecompressUsingDict(compressed, dict, len(data))
+				if err != nil {
+					errs <- err
+					return
+				}
+				if !bytes.Equal(decompressed, data) {
+					errs <- fmt.Errorf("goroutine %d: dictionary round trip mismatch", g)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestErrorCodes(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("typed error codes "), 1000)
+	compressed, err := z.Compress(data, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	// A destination that is too small is reported as such
+	_, err = z.Decompress(compressed, len(data)/2)
+	if !errors.Is(err, ErrDstSizeTooSmall) {
+		t.Errorf("Expected ErrDstSizeTooSmall, got %v", err)
+	}
+	var zerr *Error
+	if !errors.As(err, &zerr) || zerr.Code != 70 || zerr.Op != "decompress" {
+		t.Errorf("Expected an *Error from decompress with code 70, got %v", err)
+	}
+
+	// Data that isn't a frame at all is recognized
+	if _, err := z.Decompress([]byte("definitely not a zstd frame"), len(data)); !errors.Is(err, ErrUnknownFrame) {
+		t.Errorf("Expected ErrUnknownFrame, got %v", err)
+	}
+
+	// A damaged checksum is detected by a Reader
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, DefaultCompression, WithChecksum(true))
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	damaged := buf.Bytes()
+	damaged[len(damaged)-1] ^= 0xFF
+	r := z.NewReader(bytes.NewReader(damaged))
+	defer r.Close()
+	_, err = io.ReadAll(r)
+	if !errors.Is(err, ErrChecksumWrong) {
+		t.Errorf("Expected ErrChecksumWrong, got %v", err)
+	}
+	if errors.Is(err, ErrCorruptedData) {
+		t.Error("A checksum error should not match ErrCorruptedData")
+	}
+
+	// Streaming failures carry the same structured error
+	if zerr, ok := err.(*Error); !ok || zerr.Op != "decompress" {
+		t.Errorf("Expected a *Error from the Reader, got %T", err)
+	}
+}
+
+func TestReaderResetDictionary(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	dictData := bytes.Repeat([]byte("reset with a dictionary "), 64)
+	data := bytes.Repeat([]byte("reset with a dictionary and some payload "), 100)
+
+	compress := func(opts ...Option) []byte {
+		var buf bytes.Buffer
+		w := z.NewWriter(&buf, DefaultCompression, opts...)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	withDict := compress(WithDictionary(dictData))
+	plain := compress()
+
+	// The Reader slots into code written against flate.Resetter
+	var r interface {
+		io.ReadCloser
+		flate.Resetter
+	} = z.NewReader(bytes.NewReader(plain))
+	defer r.Close()
+
+	for i, tc := range []struct {
+		src  []byte
+		dict []byte
+	}{
+		{withDict, dictData},
+		{withDict, dictData},
+		{plain, nil},
+		{withDict, dictData},
+	} {
+		if err := r.Reset(bytes.NewReader(tc.src), tc.dict); err != nil {
+			t.Fatalf("Reset %d failed: %v", i, err)
+		}
+		got, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll %d failed: %v", i, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Round trip %d mismatch", i)
+		}
+	}
+}
+
+func TestProgress(t *testing.T) {
+	type report struct{ processed, total int64 }
+	var reports []report
+	record := func(processed, total int64) {
+		reports = append(reports, report{processed, total})
+	}
+	checkReports := func(what string, total int64) {
+		t.Helper()
+		if len(reports) < 2 {
+			t.Fatalf("%s: expected several progress reports, got %d", what, len(reports))
+		}
+		for i, r := range reports {
+			if r.total != total {
+				t.Fatalf("%s: report %d has total %d, expected %d", what, i, r.total, total)
+			}
+			if i > 0 && r.processed < reports[i-1].processed {
+				t.Fatalf("%s: progress went backwards at report %d", what, i)
+			}
+		}
+		if last := reports[len(reports)-1]; last.processed != total {
+			t.Errorf("%s: last report at %d of %d bytes", what, last.processed, total)
+		}
+		reports = nil
+	}
+
+	z, err := New(WithProgress(record))
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	// One-shot operations on large buffers report as they go
+	compressed, err := z.Compress(data, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	checkReports("Compress", int64(len(data)))
+
+	decompressed, err := z.Decompress(compressed, len(data))
+	if err != nil {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Fatal("Round trip mismatch")
+	}
+	checkReports("Decompress", int64(len(compressed)))
+
+	// A Writer without a pledged size reports an unknown total
+	w := z.NewWriter(io.Discard, DefaultCompression)
+	w.Write(data)
+	w.Close()
+	for _, r := range reports {
+		if r.total != -1 {
+			t.Fatalf("Expected an unknown total, got %d", r.total)
+		}
+	}
+	if len(reports) == 0 || reports[len(reports)-1].processed != int64(len(data)) {
+		t.Errorf("Writer progress did not reach the input size")
+	}
+}
+
+func TestTracer(t *testing.T) {
+	var mu sync.Mutex
+	var calls []NativeCall
+	z, err := New(WithTracer(TracerFunc(func(call NativeCall) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	})))
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	find := func(function string) []NativeCall {
+		mu.Lock()
+		defer mu.Unlock()
+		var found []NativeCall
+		for _, call := range calls {
+			if call.Function == function {
+				found = append(found, call)
+			}
+		}
+		calls = nil
+		return found
+	}
+
+	data := bytes.Repeat([]byte("traced native calls "), 1000)
+	compressed, err := z.Compress(data, DefaultCompression)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	oneShot := find("ZSTD_compressCCtx")
+	if len(oneShot) != 1 || oneShot[0].SrcSize != len(data) || oneShot[0].Produced != len(compressed) {
+		t.Errorf("Unexpected trace of Compress: %+v", oneShot)
+	}
+
+	// Streaming calls report how much each one consumed and produced
+	r := z.NewReader(bytes.NewReader(compressed))
+	defer r.Close()
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	var consumed, produced int
+	for _, call := range find("ZSTD_decompressStream") {
+		consumed += call.Consumed
+		produced += call.Produced
+	}
+	if consumed != len(compressed) || produced != len(data) {
+		t.Errorf("Stream trace accounts for %d in and %d out, expected %d and %d",
+			consumed, produced, len(compressed), len(data))
+	}
+
+	// Failed calls carry their error
+	z.Decompress(compressed, 10)
+	failed := find("ZSTD_decompressDCtx")
+	if len(failed) != 1 || !errors.Is(failed[0].Err, ErrDstSizeTooSmall) {
+		t.Errorf("Expected a traced ErrDstSizeTooSmall, got %+v", failed)
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	if err := DefaultOptions().Validate(); err != nil {
+		t.Errorf("Default options are invalid: %v", err)
+	}
+
+	opts := DefaultOptions()
+	opts.CompressionLevel = 23
+	opts.WindowSize = 100
+	opts.Workers = -1
+	opts.ReadAhead = -2
+	opts.FlushOnWrite = true
+	opts.FlushInterval = time.Second
+	opts.AdaptMinLevel, opts.AdaptMaxLevel = 9, 3
+
+	// Every problem is reported at once
+	err := opts.Validate()
+	if !errors.Is(err, ErrInvalidLevel) || !errors.Is(err, ErrInvalidOption) {
+		t.Fatalf("Expected level and option errors, got %v", err)
+	}
+	for _, field := range []string{"CompressionLevel", "WindowSize", "Workers", "ReadAhead", "FlushInterval", "AdaptMinLevel"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Error does not mention %s: %v", field, err)
+		}
+	}
+
+	// Constructors report invalid options on first use
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	w := z.NewWriter(io.Discard, 30)
+	if _, err := w.Write([]byte("data")); !errors.Is(err, ErrInvalidLevel) {
+		t.Errorf("Expected ErrInvalidLevel from Write, got %v", err)
+	}
+	w.Close()
+}
+
+func TestCapabilities(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+
+	// The bundled library is a full build
+	caps, err := z.Capabilities()
+	if err != nil {
+		t.Fatalf("Capabilities failed: %v", err)
+	}
+	want := Capabilities{
+		Version:              z.VersionString(),
+		MinLevel:             minLevel,
+		MaxLevel:             maxLevel,
+		Multithreading:       true,
+		LongDistanceMatching: true,
+		LegacyFormats:        true,
+		AdvancedAPI:          true,
+		DictionaryBuilder:    true,
+	}
+	if caps != want {
+		t.Errorf("Capabilities = %+v, expected %+v", caps, want)
+	}
+
+	z.Close()
+	if _, err := z.Capabilities(); err != ErrAlreadyClosed {
+		t.Errorf("Expected ErrAlreadyClosed, got %v", err)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		want Level
+		str  string
+	}{
+		{"fast", BestSpeed, "fast"},
+		{" Default ", DefaultCompression, "default"},
+		{"best", BestCompression, "best"},
+		{"ULTRA", UltraCompression, "ultra"},
+		{"-3", -3, "-3"},
+		{"7", 7, "7"},
+		{"22", UltraCompression, "ultra"},
+	} {
+		got, err := ParseLevel(tc.in)
+		if err != nil || got != tc.want {
+			t.Errorf("ParseLevel(%q) = %v, %v; expected %v", tc.in, got, err, tc.want)
+		}
+		if got.String() != tc.str {
+			t.Errorf("Level(%d).String() = %q, expected %q", got, got.String(), tc.str)
+		}
+	}
+
+	for _, in := range []string{"", "fastest", "23", "1.5"} {
+		if _, err := ParseLevel(in); !errors.Is(err, ErrInvalidLevel) {
+			t.Errorf("ParseLevel(%q): expected ErrInvalidLevel, got %v", in, err)
+		}
+	}
+
+	// Levels work as flags and in text-based configuration formats
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	level := Level(DefaultCompression)
+	fs.Var(&level, "level", "compression level")
+	if err := fs.Parse([]string{"-level", "best"}); err != nil || level != BestCompression {
+		t.Errorf("Flag parsing gave %v, %v", level, err)
+	}
+
+	var config struct{ Level Level }
+	if err := json.Unmarshal([]byte(`{"Level":"fast"}`), &config); err != nil || config.Level != BestSpeed {
+		t.Errorf("Unmarshal gave %v, %v", config.Level, err)
+	}
+	out, _ := json.Marshal(config)
+	if string(out) != `{"Level":"fast"}` {
+		t.Errorf("Marshal gave %s", out)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	var data []byte
+	for i := 0; i < 20000; i++ {
+		data = fmt.Appendf(data, `{"id":%d,"level":"info","msg":"request served"}`+"\n", i)
+	}
+
+	for name, opts := range map[string]Options{
+		"logs":    LogsProfile(),
+		"json":    JSONProfile(),
+		"archive": ArchiveProfile(),
+	} {
+		if err := opts.Validate(); err != nil {
+			t.Errorf("%s profile is invalid: %v", name, err)
+			continue
+		}
+
+		var buf bytes.Buffer
+		w := z.NewWriterOptions(&buf, opts)
+		if _, err := w.Write(data); err != nil {
+			t.Fatalf("%s: Write failed: %v", name, err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("%s: Close failed: %v", name, err)
+		}
+
+		// Readers with default limits decode every profile
+		r := z.NewReader(&buf)
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatalf("%s: ReadAll failed: %v", name, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: round trip mismatch", name)
+		}
+	}
+}
+
+func TestSetDefaultLevel(t *testing.T) {
+	z, err := New(WithLevel(BestSpeed))
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("central compression policy "), 5000)
+	compressAt := func(level int) []byte {
+		out, err := z.Compress(data, level)
+		if err != nil {
+			t.Fatalf("Compress at %d failed: %v", level, err)
+		}
+		return out
+	}
+
+	// The level given to New applies until SetDefaultLevel overrides it
+	if !bytes.Equal(compressAt(0), compressAt(BestSpeed)) {
+		t.Error("Compress with level 0 did not use the level given to New")
+	}
+	if err := z.SetDefaultLevel(BestCompression); err != nil {
+		t.Fatalf("SetDefaultLevel failed: %v", err)
+	}
+	if !bytes.Equal(compressAt(0), compressAt(BestCompression)) {
+		t.Error("Compress with level 0 did not use the default level")
+	}
+	w := z.NewWriter(io.Discard, 0)
+	defer w.Close()
+	if w.Level() != BestCompression {
+		t.Errorf("NewWriter used level %d, expected %d", w.Level(), BestCompression)
+	}
+	ow := z.NewWriterOptions(io.Discard, Options{})
+	defer ow.Close()
+	if ow.Level() != BestCompression {
+		t.Errorf("NewWriterOptions used level %d, expected %d", ow.Level(), BestCompression)
+	}
+
+	if err := z.SetDefaultLevel(UltraCompression + 1); !errors.Is(err, ErrInvalidLevel) {
+		t.Errorf("Expected ErrInvalidLevel, got %v", err)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	z, err := New(WithDictionary(bytes.Repeat([]byte("record: the quick brown fox "), 100)))
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	if err := z.SelfTest(); err != nil {
+		t.Errorf("SelfTest failed: %v", err)
+	}
+
+	z.Close()
+	if err := z.SelfTest(); err != ErrAlreadyClosed {
+		t.Errorf("Expected ErrAlreadyClosed, got %v", err)
+	}
+}
+
+// trainingSamples returns small JSON-like messages sharing most of their structure
+func trainingSamples(n int) [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	samples := make([][]byte, n)
+	for i := range samples {
+		samples[i] = fmt.Appendf(nil,
+			`{"id":%d,"user":"user%d","action":"%s","status":%d,"region":"eu-west-%d","agent":"Mozilla/5.0"}`,
+			i, rng.Intn(500), []string{"login", "logout", "purchase", "view"}[rng.Intn(4)],
+			[]int{200, 404, 500}[rng.Intn(3)], rng.Intn(3))
+	}
+	return samples
+}
+
+func TestTrainDictionary(t *testing.T) {
+	// The parameter structs must match the C layout of zdict.h
+	if size := unsafe.Sizeof(zdictCoverParams{}); size != 48 {
+		t.Errorf("ZDICT_cover_params_t is 48 bytes, got %d", size)
+	}
+	if size := unsafe.Sizeof(zdictFastCoverParams{}); size != 56 {
+		t.Errorf("ZDICT_fastCover_params_t is 56 bytes, got %d", size)
+	}
+
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	samples := trainingSamples(2000)
+	message := samples[0]
+	plain, _ := z.Compress(message, DefaultCompression)
+
+	for _, opts := range []TrainingOptions{
+		{Steps: 8},
+		{FastCover: true, Accel: 2, Steps: 8, Threads: 2},
+		{K: 64, D: 8, DictID: 1234},
+	} {
+		dictData, tuned, err := z.TrainDictionary(samples, 4096, opts)
+		if err != nil {
+			t.Fatalf("TrainDictionary(%+v) failed: %v", opts, err)
+		}
+		if len(dictData) == 0 || len(dictData) > 4096 {
+			t.Fatalf("Dictionary of %d bytes", len(dictData))
+		}
+		if tuned.K == 0 || tuned.D == 0 {
+			t.Errorf("Search did not report K and D: %+v", tuned)
+		}
+
+		dict, err := z.LoadDictionary(dictData)
+		if err != nil {
+			t.Fatalf("LoadDictionary failed: %v", err)
+		}
+		if opts.DictID != 0 && dict.ID() != opts.DictID {
+			t.Errorf("Dictionary ID %d, expected %d", dict.ID(), opts.DictID)
+		}
+		compressed, err := z.CompressUsingDict(message, dict, DefaultCompression)
+		if err != nil {
+			t.Fatalf("CompressUsingDict failed: %v", err)
+		}
+		if len(compressed) >= len(plain) {
+			t.Errorf("Trained dictionary did not help: %d bytes vs %d without", len(compressed), len(plain))
+		}
+	}
+
+	if _, _, err := z.TrainDictionary(nil, 4096, TrainingOptions{}); !errors.Is(err, ErrEmptyInput) {
+		t.Errorf("Expected ErrEmptyInput, got %v", err)
+	}
+}
+
+func TestFinalizeDictionary(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	// Raw content made of the parts the samples have in common
+	content := []byte(`{"id":,"user":"user","action":"login","status":200,"region":"eu-west-","agent":"Mozilla/5.0"}`)
+	samples := trainingSamples(1000)
+
+	dictData, err := z.FinalizeDictionary(content, samples, 4096, TrainingOptions{Level: 3, DictID: 4321})
+	if err != nil {
+		t.Fatalf("FinalizeDictionary failed: %v", err)
+	}
+	if !bytes.HasSuffix(dictData, content) {
+		t.Errorf("Finalized dictionary does not end with the raw content")
+	}
+
+	dict, err := z.LoadDictionary(dictData)
+	if err != nil {
+		t.Fatalf("LoadDictionary failed: %v", err)
+	}
+	if dict.ID() != 4321 {
+		t.Errorf("Dictionary ID %d, expected 4321", dict.ID())
+	}
+	compressed, err := z.CompressUsingDict(samples[1], dict, DefaultCompression)
+	if err != nil {
+		t.Fatalf("CompressUsingDict failed: %v", err)
+	}
+	decompressed, err := z.DecompressUsingDict(compressed, dict, len(samples[1]))
+	if err != nil {
+		t.Fatalf("DecompressUsingDict failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, samples[1]) {
+		t.Errorf("Round trip mismatch")
+	}
+
+	if _, err := z.FinalizeDictionary(content, nil, 4096, TrainingOptions{}); !errors.Is(err, ErrEmptyInput) {
+		t.Errorf("Expected ErrEmptyInput, got %v", err)
+	}
+}
+
+func TestCompiledDictionary(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	samples := trainingSamples(1000)
+	dictData, _, err := z.TrainDictionary(samples, 4096, TrainingOptions{K: 64, D: 8})
+	if err != nil {
+		t.Fatalf("TrainDictionary failed: %v", err)
+	}
+	dict, err := z.LoadDictionary(dictData)
+	if err != nil {
+		t.Fatalf("LoadDictionary failed: %v", err)
+	}
+	if err := dict.Compile(DefaultCompression); err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if err := dict.CompileDecoder(); err != nil {
+		t.Fatalf("CompileDecoder failed: %v", err)
+	}
+
+	roundTrip := func(level int) {
+		t.Helper()
+		for _, sample := range samples[:20] {
+			compressed, err := z.CompressUsingDict(sample, dict, level)
+			if err != nil {
+				t.Fatalf("CompressUsingDict failed: %v", err)
+			}
+			decompressed, err := z.DecompressUsingDict(compressed, dict, len(sample))
+			if err != nil {
+				t.Fatalf("DecompressUsingDict failed: %v", err)
+			}
+			if !bytes.Equal(decompressed, sample) {
+				t.Fatalf("Round trip mismatch")
+			}
+		}
+	}
+
+	// Levels are compiled on first use, up to a few at once, and again after release
+	for _, level := range []int{DefaultCompression, BestSpeed, 5, 9, 12, 19, BestSpeed} {
+		roundTrip(level)
+		if n := len(dict.cdicts); n > maxCompiledLevels {
+			t.Fatalf("%d levels compiled", n)
+		}
+	}
+	if dict.cdicts[BestSpeed] == nil {
+		t.Errorf("Level in use was not compiled")
+	}
+	dict.Release()
+	roundTrip(DefaultCompression)
+}
+
+func TestDictionaryByReference(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	samples := trainingSamples(1000)
+	dictData, _, err := z.TrainDictionary(samples, 4096, TrainingOptions{K: 64, D: 8})
+	if err != nil {
+		t.Fatalf("TrainDictionary failed: %v", err)
+	}
+	dict, err := z.LoadDictionaryByReference(dictData)
+	if err != nil {
+		t.Fatalf("LoadDictionaryByReference failed: %v", err)
+	}
+	defer dict.Release()
+
+	compressed, err := z.CompressUsingDict(samples[1], dict, DefaultCompression)
+	if err != nil {
+		t.Fatalf("CompressUsingDict failed: %v", err)
+	}
+	if err := dict.CompileDecoder(); err != nil {
+		t.Fatalf("CompileDecoder failed: %v", err)
+	}
+	decompressed, err := z.DecompressUsingDict(compressed, dict, len(samples[1]))
+	if err != nil {
+		t.Fatalf("DecompressUsingDict failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, samples[1]) {
+		t.Errorf("Round trip mismatch")
+	}
+
+	// Streams digest the dictionary by reference as well
+	var buf bytes.Buffer
+	w := z.NewWriterDict(&buf, dict, DefaultCompression)
+	w.Write(samples[2])
+	if err := w.Close(); err != nil {
+		t.Fatalf("Writer.Close failed: %v", err)
+	}
+	r := z.NewReaderDict(&buf, dict)
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	if !bytes.Equal(got, samples[2]) {
+		t.Errorf("Stream round trip mismatch")
+	}
+}
+
+func TestDictionaryRegistry(t *testing.T) {
+	samples := trainingSamples(1000)
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	registry := NewDictionaryRegistry()
+	var dicts []*Dictionary
+	for _, id := range []uint32{101, 102} {
+		dictData, _, err := z.TrainDictionary(samples, 4096, TrainingOptions{K: 64, D: 8, DictID: id})
+		if err != nil {
+			t.Fatalf("TrainDictionary failed: %v", err)
+		}
+		dict, _ := z.LoadDictionary(dictData)
+		dicts = append(dicts, dict)
+	}
+	if err := registry.Register(dicts[0]); err != nil {
+		t.Fatalf("Register failed: %v", err)
+	}
+	raw, _ := z.LoadDictionary([]byte("raw content without a header"))
+	if err := registry.Register(raw); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("Registering a raw dictionary: expected ErrInvalidOption, got %v", err)
+	}
+
+	zr, err := New(WithDictionaryRegistry(registry))
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer zr.Close()
+
+	message := samples[3]
+	compressed, _ := z.CompressUsingDict(message, dicts[0], DefaultCompression)
+	decompressed, err := zr.Decompress(compressed, len(message))
+	if err != nil {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+	if !bytes.Equal(decompressed, message) {
+		t.Errorf("Decompress picked the wrong dictionary")
+	}
+
+	var buf bytes.Buffer
+	w := z.NewWriterDict(&buf, dicts[0], DefaultCompression)
+	w.Write(message)
+	w.Close()
+	r := zr.NewReader(bytes.NewReader(buf.Bytes()))
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, message) {
+		t.Errorf("Reader picked the wrong dictionary: %v", err)
+	}
+
+	// Reading the header first attaches the dictionary all the same
+	r = zr.NewReader(bytes.NewReader(buf.Bytes()))
+	if header, err := r.Header(); err != nil || header.DictionaryID != 101 {
+		t.Errorf("Header = %+v, %v", header, err)
+	}
+	got, err = io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, message) {
+		t.Errorf("Reader after Header picked the wrong dictionary: %v", err)
+	}
+	if got, err := zr.DecompressContext(context.Background(), buf.Bytes(), 0); err != nil || !bytes.Equal(got, message) {
+		t.Errorf("DecompressContext picked the wrong dictionary: %v", err)
+	}
+
+	// Data without a dictionary is unaffected, and a missing dictionary is reported by ID
+	plain, _ := z.Compress(message, DefaultCompression)
+	if _, err := zr.Decompress(plain, len(message)); err != nil {
+		t.Errorf("Decompress without dictionary failed: %v", err)
+	}
+	other, _ := z.CompressUsingDict(message, dicts[1], DefaultCompression)
+	var missing *MissingDictionaryError
+	if _, err := zr.Decompress(other, len(message)); !errors.As(err, &missing) || missing.ID != 102 {
+		t.Errorf("Expected MissingDictionaryError for 102, got %v", err)
+	}
+	r = zr.NewReader(bytes.NewReader(other))
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrDictionaryWrong) {
+		t.Errorf("Reader: expected ErrDictionaryWrong, got %v", err)
+	}
+
+	// Pooled readers look up the dictionary of each stream, and release the previous one
+	registry.Register(dicts[1])
+	pool := z.NewReaderPool(WithDictionaryRegistry(registry))
+	for i, compressed := range [][]byte{buf.Bytes(), other, buf.Bytes()} {
+		pr := pool.Get(bytes.NewReader(compressed))
+		got, err := io.ReadAll(pr)
+		pr.Close()
+		pool.Put(pr)
+		if err != nil || !bytes.Equal(got, message) {
+			t.Errorf("Pooled stream %d failed: %v", i, err)
+		}
+	}
+	pr := pool.Get(bytes.NewReader(plain))
+	io.ReadAll(pr)
+	pr.Close()
+	registry.Unregister(101)
+	if dicts[0].ddict != nil {
+		t.Errorf("Dictionary still held by a pooled reader after Unregister")
+	}
+	pool.Put(pr)
+}
+
+func TestDictionaryRegistryReplace(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	samples := trainingSamples(1000)
+	versions := make([]*Dictionary, 2)
+	for i := range versions {
+		dictData, _, err := z.TrainDictionary(samples[i*500:(i+1)*500], 2048, TrainingOptions{K: 64, D: 8, DictID: 7})
+		if err != nil {
+			t.Fatalf("TrainDictionary failed: %v", err)
+		}
+		versions[i], _ = z.LoadDictionary(dictData)
+	}
+
+	registry := NewDictionaryRegistry()
+	registry.Register(versions[0])
+	dict, done, err := registry.Acquire(7)
+	if err != nil || dict != versions[0] {
+		t.Fatalf("Acquire failed: %v", err)
+	}
+	dict.CompileDecoder()
+	compressed, _ := z.CompressUsingDict(samples[1], dict, DefaultCompression)
+
+	// The replaced version stays usable until released by the operation using it
+	registry.Register(versions[1])
+	if current, _ := registry.Lookup(7); current != versions[1] {
+		t.Errorf("Lookup returned the replaced dictionary")
+	}
+	if versions[0].ddict == nil {
+		t.Errorf("Replaced dictionary released while in use")
+	}
+	decompressed, err := z.DecompressUsingDict(compressed, dict, len(samples[1]))
+	if err != nil || !bytes.Equal(decompressed, samples[1]) {
+		t.Errorf("Round trip with the replaced dictionary failed: %v", err)
+	}
+	done()
+	done()
+	if versions[0].ddict != nil {
+		t.Errorf("Replaced dictionary not released after use")
+	}
+
+	registry.Unregister(7)
+	if _, _, err := registry.Acquire(7); !errors.Is(err, ErrDictionaryWrong) {
+		t.Errorf("Expected ErrDictionaryWrong after Unregister, got %v", err)
+	}
+}
+
+func TestDictionaryFile(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	dictData, _, err := z.TrainDictionary(trainingSamples(1000), 4096, TrainingOptions{K: 64, D: 8, DictID: 55})
+	if err != nil {
+		t.Fatalf("TrainDictionary failed: %v", err)
+	}
+	dict, _ := z.LoadDictionary(dictData)
+
+	path := filepath.Join(t.TempDir(), "samples.dict")
+	if err := z.SaveDictionary(path, dict); err != nil {
+		t.Fatalf("SaveDictionary failed: %v", err)
+	}
+	loaded, err := z.LoadDictionaryFile(path)
+	if err != nil {
+		t.Fatalf("LoadDictionaryFile failed: %v", err)
+	}
+	if loaded.ID() != 55 || loaded.Size() != len(dictData) {
+		t.Errorf("Loaded dictionary %d of %d bytes, expected 55 of %d", loaded.ID(), loaded.Size(), len(dictData))
+	}
+
+	// Raw content can't be saved, and damaged files are rejected
+	raw, _ := z.LoadDictionary([]byte("raw dictionary content"))
+	if err := z.SaveDictionary(path, raw); !errors.Is(err, ErrDictionaryCorrupted) {
+		t.Errorf("Saving raw content: expected ErrDictionaryCorrupted, got %v", err)
+	}
+	for _, damaged := range [][]byte{[]byte("not a dictionary"), dictData[:12]} {
+		os.WriteFile(path, damaged, 0o644)
+		if _, err := z.LoadDictionaryFile(path); !errors.Is(err, ErrDictionaryCorrupted) {
+			t.Errorf("Loading %d bytes: expected ErrDictionaryCorrupted, got %v", len(damaged), err)
+		}
+	}
+}
+
+func TestSampleCollector(t *testing.T) {
+	c := NewSampleCollector(100, 64)
+	for _, msg := range trainingSamples(5000) {
+		c.Add(msg)
+	}
+	c.Add(nil)
+
+	samples := c.Samples()
+	if len(samples) != 100 || c.Seen() != 5000 {
+		t.Fatalf("Kept %d samples of %d seen, expected 100 of 5000", len(samples), c.Seen())
+	}
+	late := 0
+	for _, s := range samples {
+		if len(s) > 64 {
+			t.Fatalf("Sample of %d bytes exceeds the cap", len(s))
+		}
+		// Sampling is uniform, so most samples come from later messages
+		var id int
+		fmt.Sscanf(string(s), `{"id":%d`, &id)
+		if id >= 2500 {
+			late++
+		}
+	}
+	if late < 25 || late > 75 {
+		t.Errorf("%d of 100 samples from the second half of the messages", late)
+	}
+
+	c.Reset()
+	if len(c.Samples()) != 0 || c.Seen() != 0 {
+		t.Errorf("Reset did not discard the samples")
+	}
+}
+
+func TestEvaluateDictionary(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	samples := trainingSamples(1500)
+	dictData, _, err := z.TrainDictionary(samples[:1000], 4096, TrainingOptions{K: 64, D: 8})
+	if err != nil {
+		t.Fatalf("TrainDictionary failed: %v", err)
+	}
+
+	eval, err := z.EvaluateDictionary(dictData, samples[1000:], DefaultCompression)
+	if err != nil {
+		t.Fatalf("EvaluateDictionary failed: %v", err)
+	}
+	if eval.Samples != 500 || eval.OriginalSize == 0 {
+		t.Errorf("Evaluated %d samples of %d bytes", eval.Samples, eval.OriginalSize)
+	}
+	if eval.With.Ratio <= eval.Without.Ratio || eval.Gain() <= 0 {
+		t.Errorf("Dictionary ratio %.2f not better than %.2f", eval.With.Ratio, eval.Without.Ratio)
+	}
+	if eval.With.CompressSpeed <= 0 || eval.Without.DecompressSpeed <= 0 {
+		t.Errorf("Speeds not measured: %+v", eval)
+	}
+
+	if _, err := z.EvaluateDictionary(dictData, nil, 0); !errors.Is(err, ErrEmptyInput) {
+		t.Errorf("Expected ErrEmptyInput, got %v", err)
+	}
+}
+
+func TestCompressBestDict(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	// Two message schemas, each with its own dictionary
+	logs := trainingSamples(1000)
+	metrics := make([][]byte, 1000)
+	for i := range metrics {
+		metrics[i] = fmt.Appendf(nil, "cpu.load host=web%02d dc=fra value=%d.%02d ts=%d", i%40, i%7, i%100, 1700000000+i)
+	}
+	registry := NewDictionaryRegistry()
+	var dicts []*Dictionary
+	for i, samples := range [][][]byte{logs, metrics} {
+		dictData, _, err := z.TrainDictionary(samples[1:], 4096, TrainingOptions{K: 64, D: 8, DictID: uint32(200 + i)})
+		if err != nil {
+			t.Fatalf("TrainDictionary failed: %v", err)
+		}
+		dict, _ := z.LoadDictionary(dictData)
+		registry.Register(dict)
+		dicts = append(dicts, dict)
+	}
+
+	zr, _ := New(WithDictionaryRegistry(registry))
+	defer zr.Close()
+	for i, message := range [][]byte{logs[0], metrics[0]} {
+		compressed, id, err := z.CompressBestDict(message, DefaultCompression, dicts...)
+		if err != nil {
+			t.Fatalf("CompressBestDict failed: %v", err)
+		}
+		if id != uint32(200+i) {
+			t.Errorf("Message %d compressed with dictionary %d, expected %d", i, id, 200+i)
+		}
+		decompressed, err := zr.Decompress(compressed, len(message))
+		if err != nil || !bytes.Equal(decompressed, message) {
+			t.Errorf("Round trip through the registry failed: %v", err)
+		}
+	}
+}
+
+func TestDictionaryLoad(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	samples := trainingSamples(1000)
+	dictData, _, err := z.TrainDictionary(samples[1:], 4096, TrainingOptions{K: 64, D: 8})
+	if err != nil {
+		t.Fatalf("TrainDictionary failed: %v", err)
+	}
+	message := bytes.Join(samples[:50], []byte("\n"))
+
+	for _, tc := range []struct {
+		method      DictLoadMethod
+		contentType DictContentType
+	}{
+		{DictLoadByRef, DictContentAuto},
+		{DictLoadByCopy, DictContentRaw},
+		{DictLoadByRef, DictContentFull},
+	} {
+		opts := []Option{WithDictionary(dictData), WithDictionaryLoad(tc.method, tc.contentType)}
+		var buf bytes.Buffer
+		w := z.NewWriter(&buf, DefaultCompression, opts...)
+		w.Write(message)
+		if err := w.Close(); err != nil {
+			t.Fatalf("%+v: Writer.Close failed: %v", tc, err)
+		}
+		r := z.NewReader(&buf, opts...)
+		got, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(got, message) {
+			t.Errorf("%+v: round trip failed: %v", tc, err)
+		}
+	}
+
+	// Raw content is not a full dictionary; the library fails to create it
+	w := z.NewWriter(io.Discard, DefaultCompression,
+		WithDictionary([]byte("raw content")), WithDictionaryLoad(DictLoadByCopy, DictContentFull))
+	w.Write(message)
+	var zerr *Error
+	if err := w.Close(); !errors.As(err, &zerr) {
+		t.Errorf("Expected a library error, got %v", err)
+	}
+	if err := (Options{DictContentType: 3}).Validate(); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("Expected ErrInvalidOption, got %v", err)
+	}
+}
+
+func TestTrainDictionaryFromFS(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	fsys := fstest.MapFS{
+		"samples/big.json": {Data: bytes.Repeat([]byte(`{"padding":true}`), maxFileSampleSize)},
+		"samples/sub":      {Mode: fs.ModeDir},
+		"README.md":        {Data: []byte("not a sample")},
+	}
+	for i, sample := range trainingSamples(500) {
+		fsys[fmt.Sprintf("samples/%03d.json", i)] = &fstest.MapFile{Data: sample}
+	}
+
+	dictData, err := z.TrainDictionaryFromFS(fsys, "samples/*", 4096)
+	if err != nil {
+		t.Fatalf("TrainDictionaryFromFS failed: %v", err)
+	}
+	if len(dictData) == 0 || len(dictData) > 4096 {
+		t.Errorf("Dictionary of %d bytes", len(dictData))
+	}
+	if _, err := z.TrainDictionaryFromFS(fsys, "*.txt", 4096); !errors.Is(err, ErrEmptyInput) {
+		t.Errorf("Expected ErrEmptyInput, got %v", err)
+	}
+
+	// Paths on disk, walked and cut into blocks
+	dir := t.TempDir()
+	os.MkdirAll(filepath.Join(dir, "sub"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a.txt"), []byte("0123456789"), 0o644)
+	os.WriteFile(filepath.Join(dir, "sub", "b.txt"), []byte("abcde"), 0o644)
+	single := filepath.Join(t.TempDir(), "c.txt")
+	os.WriteFile(single, []byte("xyz"), 0o644)
+	samples, total, err := ReadSamples([]string{dir, single}, 4)
+	if err != nil || total != 18 || len(samples) != 6 || string(samples[2]) != "89" || string(samples[5]) != "xyz" {
+		t.Errorf("ReadSamples returned %q (%d bytes): %v", samples, total, err)
+	}
+	if _, _, err := ReadSamples([]string{filepath.Join(dir, "missing")}, 0); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected fs.ErrNotExist, got %v", err)
+	}
+}
+
+func TestDictionaryTrainer(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	registry := NewDictionaryRegistry()
+	trainer := z.NewDictionaryTrainer(registry, RetrainOptions{
+		Window:   1000,
+		DictSize: 4096,
+		Training: TrainingOptions{K: 64, D: 8},
+		MinGain:  0.02,
+		Keep:     1,
+	})
+	if _, err := trainer.Retrain(); !errors.Is(err, ErrEmptyInput) {
+		t.Errorf("Retraining an empty window: expected ErrEmptyInput, got %v", err)
+	}
+	for _, msg := range trainingSamples(1500) {
+		trainer.Add(msg)
+	}
+
+	result, err := trainer.Retrain()
+	if err != nil {
+		t.Fatalf("Retrain failed: %v", err)
+	}
+	if !result.Replaced || result.ID == 0 || result.Candidate.Gain() <= 0 {
+		t.Fatalf("First dictionary not adopted: %+v", result)
+	}
+	dict, done, err := trainer.Current()
+	if err != nil || dict == nil || dict.ID() != result.ID {
+		t.Fatalf("Current returned %v, %v", dict, err)
+	}
+	compressed, _ := z.CompressUsingDict([]byte(`{"id":1,"user":"user1","action":"view"}`), dict, 0)
+	done()
+
+	// The same traffic gives the same dictionary, which doesn't beat the current one
+	result, err = trainer.Retrain()
+	if err != nil {
+		t.Fatalf("Retrain failed: %v", err)
+	}
+	if result.Replaced {
+		t.Errorf("Equivalent dictionary replaced the current one: %+v", result)
+	}
+
+	zr, _ := New(WithDictionaryRegistry(registry))
+	defer zr.Close()
+	if _, err := zr.Decompress(compressed, 0); err != nil {
+		t.Errorf("Decompress through the registry failed: %v", err)
+	}
+}
+
+func TestXXHash64(t *testing.T) {
+	if h := xxhash64(nil); h != 0xEF46DB3751D8E999 {
+		t.Errorf("XXH64 of nothing is %x", h)
+	}
+	if h := xxhash64([]byte("a")); h != 0xD24EC4F1A98C6E5B {
+		t.Errorf("XXH64 of a is %x", h)
+	}
+
+	// Frames with a checksum end with the low 32 bits of the XXH64 of their content
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+	for _, size := range []int{3, 31, 32, 1000, 100003} {
+		data := bytes.Repeat([]byte("seekable zstd "), size/14+1)[:size]
+		var buf bytes.Buffer
+		w := z.NewWriter(&buf, DefaultCompression, WithChecksum(true))
+		w.Write(data)
+		w.Close()
+		if sum := binary.LittleEndian.Uint32(buf.Bytes()[buf.Len()-4:]); sum != uint32(xxhash64(data)) {
+			t.Errorf("Checksum of %d bytes is %x, frame has %x", size, uint32(xxhash64(data)), sum)
+		}
+
+		// Hashing in pieces gives the same result
+		h := NewXXH64()
+		for piece := data; len(piece) > 0; piece = piece[min(len(piece), 7):] {
+			h.Write(piece[:min(len(piece), 7)])
+		}
+		if h.Sum64() != xxhash64(data) {
+			t.Errorf("XXH64 of %d bytes in pieces is %x, want %x", size, h.Sum64(), xxhash64(data))
+		}
+	}
+}
+
+func TestSeekableWriter(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 10000)
+	var buf bytes.Buffer
+	sw := z.NewSeekableWriter(&buf, 4096, DefaultCompression)
+	sw.Write(data[:1000])
+	sw.Write(data[1000:])
+	if err := sw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The output is a regular stream, with the seek table as a skippable frame
+	got, err := z.Decompress(buf.Bytes(), len(data))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+	out := buf.Bytes()
+	frames := (len(data) + 4095) / 4096
+	if n := binary.LittleEndian.Uint32(out[len(out)-9:]); int(n) != frames {
+		t.Errorf("Seek table lists %d frames, expected %d", n, frames)
+	}
+	if magic := binary.LittleEndian.Uint32(out[len(out)-4:]); magic != seekableMagic {
+		t.Errorf("Seek table ends with %x", magic)
+	}
+
+	if err := z.NewSeekableWriter(io.Discard, seekableMaxFrameSize+1, 0).Close(); !errors.Is(err, ErrInvalidOption) {
+		t.Errorf("Expected ErrInvalidOption, got %v", err)
+	}
+}
+
+func TestSeekableReader(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(data[:50000])
+	var buf bytes.Buffer
+	sw := z.NewSeekableWriter(&buf, 4096, DefaultCompression)
+	sw.Write(data)
+	sw.Close()
+
+	sr, err := z.NewSeekableReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("NewSeekableReader failed: %v", err)
+	}
+	if sr.Size() != int64(len(data)) || sr.NumFrames() != 25 {
+		t.Fatalf("Size %d in %d frames", sr.Size(), sr.NumFrames())
+	}
+
+	// Ranges within a frame, across frames, and up to the end
+	for _, rng := range [][2]int{{0, 10}, {4000, 300}, {8191, 9000}, {99990, 10}} {
+		p := make([]byte, rng[1])
+		if n, err := sr.ReadAt(p, int64(rng[0])); err != nil || !bytes.Equal(p[:n], data[rng[0]:rng[0]+rng[1]]) {
+			t.Errorf("ReadAt(%d, %d) = %d, %v", rng[0], rng[1], n, err)
+		}
+	}
+	if n, err := sr.ReadAt(make([]byte, 20), 99990); n != 10 || err != io.EOF {
+		t.Errorf("ReadAt past the end = %d, %v", n, err)
+	}
+	for _, rng := range [][2]int{{0, 0}, {4000, 300}, {8191, 9000}, {99990, 10}} {
+		if p, err := sr.DecompressAt(int64(rng[0]), int64(rng[1])); err != nil || !bytes.Equal(p, data[rng[0]:rng[0]+rng[1]]) {
+			t.Errorf("DecompressAt(%d, %d) = %d bytes, %v", rng[0], rng[1], len(p), err)
+		}
+	}
+	if p, err := sr.DecompressAt(99990, 20); len(p) != 10 || err != io.EOF {
+		t.Errorf("DecompressAt past the end = %d bytes, %v", len(p), err)
+	}
+
+	sr.Seek(-1000, io.SeekEnd)
+	rest, err := io.ReadAll(sr)
+	if err != nil || !bytes.Equal(rest, data[len(data)-1000:]) {
+		t.Errorf("Read after Seek failed: %v", err)
+	}
+
+	// Corrupted content fails its checksum, and plain streams have no seek table
+	damaged := bytes.Clone(buf.Bytes())
+	binary.LittleEndian.PutUint32(damaged[len(damaged)-13:], 0) // Checksum of the last frame
+	sr, _ = z.NewSeekableReader(bytes.NewReader(damaged), int64(len(damaged)))
+	if _, err := sr.ReadAt(make([]byte, 10), int64(len(data)-10)); !errors.Is(err, ErrChecksumWrong) {
+		t.Errorf("Expected ErrChecksumWrong, got %v", err)
+	}
+	forged := bytes.Clone(buf.Bytes())
+	binary.LittleEndian.PutUint32(forged[len(forged)-17:], 1<<32-1) // Decompressed size of the last frame
+	if _, err := z.NewSeekableReader(bytes.NewReader(forged), int64(len(forged))); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected ErrCorruptedData for an oversized frame, got %v", err)
+	}
+	plain, _ := z.Compress(data, DefaultCompression)
+	if _, err := z.NewSeekableReader(bytes.NewReader(plain), int64(len(plain))); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected ErrCorruptedData, got %v", err)
+	}
+}
+
+func TestParallelWriter(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("parallel chunks compress independently; "), 50000)
+	var buf bytes.Buffer
+	pw := z.NewParallelWriter(&buf, 64<<10, DefaultCompression)
+	for chunk := range slices.Chunk(data, 10000) {
+		if _, err := pw.Write(chunk); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	got, err := z.Decompress(buf.Bytes(), len(data))
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+
+	// Write errors are reported
+	pw = z.NewParallelWriter(&errorWriter{err: io.ErrClosedPipe}, 1024, DefaultCompression)
+	pw.Write(data[:10000])
+	if err := pw.Close(); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Expected io.ErrClosedPipe, got %v", err)
+	}
+}
+
+func TestDecompressParallel(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("frames decompress independently; "), 30000)
+
+	// Frames from a parallel writer, a streamed frame without a content size, and a skippable frame
+	var buf bytes.Buffer
+	pw := z.NewParallelWriter(&buf, 32<<10, DefaultCompression)
+	pw.Write(data)
+	pw.Close()
+	w := z.NewWriter(&buf, DefaultCompression)
+	w.WriteSkippableFrame(3, []byte("metadata"))
+	w.Write(data[:5000])
+	w.Close()
+	expected := append(bytes.Clone(data), data[:5000]...)
+
+	var out bytes.Buffer
+	n, err := z.DecompressParallel(&out, buf.Bytes())
+	if err != nil || n != int64(len(expected)) || !bytes.Equal(out.Bytes(), expected) {
+		t.Fatalf("DecompressParallel wrote %d bytes: %v", n, err)
+	}
+
+	if _, err := z.DecompressParallel(io.Discard, buf.Bytes()[:buf.Len()-3]); err == nil {
+		t.Errorf("Truncated input decompressed without error")
+	}
+
+	// A header claiming a huge content size is streamed rather than allocated
+	forged := append(bytes.Clone(buf.Bytes()), forgedFrame(1<<40)...)
+	if _, err := z.DecompressParallel(io.Discard, forged); err == nil {
+		t.Errorf("Frame with a forged content size decompressed without error")
+	}
+
+	// Seekable streams decompress in parallel from the current position
+	buf.Reset()
+	sw := z.NewSeekableWriter(&buf, 16<<10, DefaultCompression)
+	sw.Write(data)
+	sw.Close()
+	sr, _ := z.NewSeekableReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	sr.Seek(20000, io.SeekStart)
+	out.Reset()
+	if _, err := io.Copy(&out, sr); err != nil || !bytes.Equal(out.Bytes(), data[20000:]) {
+		t.Errorf("SeekableReader.WriteTo failed: %v", err)
+	}
+}
+
+func TestTarZst(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	fsys := fstest.MapFS{
+		"README.md":         {Data: []byte("archive"), Mode: 0o644},
+		"docs/guide.txt":    {Data: bytes.Repeat([]byte("guide "), 1000), Mode: 0o600},
+		"docs/empty":        {Mode: fs.ModeDir | 0o755},
+		"src/main/main.go":  {Data: []byte("package main"), Mode: 0o644},
+		"src/main/main.bin": {Data: []byte{0, 1, 2}, Mode: 0o755},
+	}
+	var buf bytes.Buffer
+	var entries int
+	if err := z.CreateTarZst(&buf, fsys, TarOptions{Progress: func(string, int64) { entries++ }}); err != nil {
+		t.Fatalf("CreateTarZst failed: %v", err)
+	}
+
+	dir := t.TempDir()
+	var extracted int64
+	opts := TarOptions{Progress: func(_ string, processed int64) { extracted = processed }}
+	if err := z.ExtractTarZst(bytes.NewReader(buf.Bytes()), dir, opts); err != nil {
+		t.Fatalf("ExtractTarZst failed: %v", err)
+	}
+	if entries != 8 || extracted != 6022 {
+		t.Errorf("Progress reported %d entries and %d bytes", entries, extracted)
+	}
+	for name, file := range fsys {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%s not extracted: %v", name, err)
+			continue
+		}
+		if !info.IsDir() {
+			data, _ := os.ReadFile(filepath.Join(dir, name))
+			if !bytes.Equal(data, file.Data) || info.Mode().Perm() != file.Mode.Perm() {
+				t.Errorf("%s extracted with mode %v", name, info.Mode())
+			}
+		}
+	}
+
+	// Entries escaping the destination are refused
+	for _, name := range []string{"../evil", "/etc/evil", "a/../../evil"} {
+		buf.Reset()
+		zw := z.NewWriter(&buf, DefaultCompression)
+		tw := tar.NewWriter(zw)
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: 4, Typeflag: tar.TypeReg})
+		tw.Write([]byte("evil"))
+		tw.Close()
+		zw.Close()
+		if err := z.ExtractTarZst(&buf, t.TempDir(), TarOptions{}); !errors.Is(err, ErrInsecurePath) {
+			t.Errorf("%s: expected ErrInsecurePath, got %v", name, err)
+		}
+	}
+
+	// An archive failing between entries, after output was flushed, is not left looking complete
+	noise := make([]byte, 1<<20)
+	rand.New(rand.NewSource(7)).Read(noise)
+	fsys["a.bin"] = &fstest.MapFile{Data: noise, Mode: 0o644}
+	buf.Reset()
+	failing := failingFS{FS: fsys, name: "src/main"}
+	if err := z.CreateTarZst(&buf, failing, TarOptions{}); err == nil {
+		t.Fatal("Expected CreateTarZst to report the failing entry")
+	}
+	if err := z.ExtractTarZst(bytes.NewReader(buf.Bytes()), t.TempDir(), TarOptions{}); err == nil {
+		t.Errorf("Failed archive extracted without error")
+	}
+}
+
+// failingFS is an fs.FS failing to open one file or directory
+type failingFS struct {
+	fs.FS
+	name string
+}
+
+func (f failingFS) Open(name string) (fs.File, error) {
+	if name == f.name {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.New("device failure")}
+	}
+	return f.FS.Open(name)
+}
+
+func TestZip(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	content := bytes.Repeat([]byte("zip entry compressed with zstd "), 1000)
+	writeZip := func(register func(*zip.Writer)) []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		register(zw)
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: "entry.txt", Method: ZipMethod})
+		if err != nil {
+			t.Fatalf("CreateHeader failed: %v", err)
+		}
+		w.Write(content)
+		if err := zw.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		return buf.Bytes()
+	}
+	readZip := func(archive []byte, register func(*zip.Reader)) {
+		t.Helper()
+		zr, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			t.Fatalf("NewReader failed: %v", err)
+		}
+		register(zr)
+		if zr.File[0].Method != ZipMethod || zr.File[0].CompressedSize64 >= uint64(len(content)) {
+			t.Errorf("Entry stored with method %d in %d bytes", zr.File[0].Method, zr.File[0].CompressedSize64)
+		}
+		f, err := zr.File[0].Open()
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		got, err := io.ReadAll(f)
+		f.Close()
+		if err != nil || !bytes.Equal(got, content) {
+			t.Errorf("Entry round trip failed: %v", err)
+		}
+	}
+
+	// Registered with a single archive, using the instance
+	archive := writeZip(func(zw *zip.Writer) { zw.RegisterCompressor(ZipMethod, z.ZipCompressor(BestSpeed)) })
+	readZip(archive, func(zr *zip.Reader) { zr.RegisterDecompressor(ZipMethod, z.ZipDecompressor()) })
+
+	// Registered with archive/zip for all archives
+	RegisterZipCompressor(DefaultCompression)
+	RegisterZipDecompressor()
+	RegisterZipDecompressor()
+	readZip(writeZip(func(*zip.Writer) {}), func(*zip.Reader) {})
+}
+
+func TestAppendWriter(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	path := filepath.Join(t.TempDir(), "log.zst")
+	var want bytes.Buffer
+	for i := 0; i < 3; i++ {
+		f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := z.NewAppendWriter(f, 0, WithChecksum(i == 1))
+		if err != nil {
+			t.Fatalf("NewAppendWriter failed on append %d: %v", i, err)
+		}
+		line := bytes.Repeat([]byte(fmt.Sprintf("log line %d\n", i)), 5000)
+		want.Write(line)
+		w.Write(line)
+		if i == 2 {
+			w.WriteSkippableFrame(0, []byte("index"))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close failed: %v", err)
+		}
+		f.Close()
+	}
+
+	compressed, _ := os.ReadFile(path)
+	r := z.NewReader(bytes.NewReader(compressed))
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, want.Bytes()) {
+		t.Fatalf("Reading appended frames failed: %v", err)
+	}
+
+	// A file cut within a frame is refused and left as is
+	os.WriteFile(path, compressed[:len(compressed)-20], 0o644)
+	f, _ := os.OpenFile(path, os.O_RDWR, 0)
+	defer f.Close()
+	if _, err := z.NewAppendWriter(f, 0); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF for a truncated file, got %v", err)
+	}
+	if info, _ := f.Stat(); info.Size() != int64(len(compressed)-20) {
+		t.Errorf("Truncated file was modified")
+	}
+}
+
+func TestListFrames(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	var buf bytes.Buffer
+	first, _ := z.Compress(bytes.Repeat([]byte("first frame "), 20000), 3)
+	buf.Write(first)
+	w := z.NewWriter(&buf, 1, WithChecksum(true))
+	w.Write(bytes.Repeat([]byte("second frame "), 1000))
+	w.WriteSkippableFrame(3, []byte("metadata"))
+	w.Close()
+	stream := buf.Bytes()
+
+	for name, r := range map[string]io.Reader{
+		"ReaderAt": bytes.NewReader(stream),
+		"stream":   bufio.NewReader(bytes.NewReader(stream)),
+	} {
+		list, err := z.ListFrames(r)
+		if err != nil {
+			t.Fatalf("%s: ListFrames failed: %v", name, err)
+		}
+		if len(list.Frames) != 3 || list.SkippableFrames != 1 || list.CompressedSize != int64(len(stream)) {
+			t.Fatalf("%s: Unexpected listing %+v", name, list)
+		}
+		f0, f1, f2 := list.Frames[0], list.Frames[1], list.Frames[2]
+		if f0.CompressedSize != int64(len(first)) || f0.ContentSize != 240000 || f0.HasChecksum {
+			t.Errorf("%s: Unexpected first frame %+v", name, f0)
+		}
+		if f1.Offset != int64(len(first)) || f1.HasContentSize || !f1.HasChecksum || f1.WindowSize == 0 {
+			t.Errorf("%s: Unexpected second frame %+v", name, f1)
+		}
+		if !f2.Skippable || f2.CompressedSize != 16 {
+			t.Errorf("%s: Unexpected skippable frame %+v", name, f2)
+		}
+		if list.HasContentSize || list.Ratio() != 0 {
+			t.Errorf("%s: Content size reported without the second frame's", name)
+		}
+	}
+
+	list, err := ListFrames(bytes.NewReader(first))
+	if err != nil || !list.HasContentSize || list.ContentSize != 240000 || list.Ratio() <= 1 {
+		t.Errorf("Unexpected listing of a single frame %+v: %v", list, err)
+	}
+
+	// Truncated streams give the frames before the error
+	for _, r := range []io.Reader{bytes.NewReader(stream[:len(first)+10]), bufio.NewReader(bytes.NewReader(stream[:len(first)+10]))} {
+		list, err = z.ListFrames(r)
+		if !errors.Is(err, io.ErrUnexpectedEOF) || len(list.Frames) != 1 {
+			t.Errorf("Expected io.ErrUnexpectedEOF after one frame, got %d frames: %v", len(list.Frames), err)
+		}
+	}
+}
+
+func TestSplitMergeFrames(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	var stream bytes.Buffer
+	for i := 0; i < 3; i++ {
+		frame, _ := z.Compress(bytes.Repeat([]byte{byte('a' + i)}, 1000*(i+1)), 0)
+		stream.Write(frame)
+	}
+	w := z.NewWriter(&stream, 0)
+	w.WriteSkippableFrame(0, []byte("trailer"))
+	w.Close()
+
+	frames, err := z.SplitFrames(stream.Bytes())
+	if err != nil || len(frames) != 4 {
+		t.Fatalf("SplitFrames returned %d frames: %v", len(frames), err)
+	}
+	merged, err := z.MergeFrames(frames...)
+	if err != nil || !bytes.Equal(merged, stream.Bytes()) {
+		t.Errorf("MergeFrames did not restore the stream: %v", err)
+	}
+	if _, err := z.MergeFrames(frames[0], frames[1][:len(frames[1])-1]); err == nil {
+		t.Error("Expected an error merging a truncated frame")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.zst")
+	os.WriteFile(path, stream.Bytes(), 0o644)
+	paths, err := z.SplitFile(path, dir)
+	if err != nil || len(paths) != 4 || filepath.Base(paths[2]) != "data.2.zst" {
+		t.Fatalf("SplitFile returned %v: %v", paths, err)
+	}
+	part, _ := os.ReadFile(paths[1])
+	if !bytes.Equal(part, frames[1]) {
+		t.Error("Split file doesn't hold the frame")
+	}
+
+	dst := filepath.Join(dir, "merged.zst")
+	if err := z.MergeFiles(dst, paths...); err != nil {
+		t.Fatalf("MergeFiles failed: %v", err)
+	}
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, stream.Bytes()) {
+		t.Error("MergeFiles did not restore the file")
+	}
+
+	// A truncated part leaves the destination as it was
+	os.WriteFile(paths[1], part[:len(part)-2], 0o644)
+	if err := z.MergeFiles(dst, paths...); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected io.ErrUnexpectedEOF for a truncated part, got %v", err)
+	}
+	if got, _ := os.ReadFile(dst); !bytes.Equal(got, stream.Bytes()) {
+		t.Error("Failed merge modified the destination")
+	}
+}
+
+func TestFS(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	page := bytes.Repeat([]byte("<p>compressed asset</p>\n"), 500)
+	compressed, _ := z.Compress(page, 0)
+	var streamed bytes.Buffer
+	w := z.NewWriter(&streamed, 0)
+	w.Write([]byte(`{"recorded": false}`))
+	w.Close()
+
+	inner := fstest.MapFS{
+		"index.html.zst":     {Data: compressed},
+		"plain.txt":          {Data: []byte("as is")},
+		"data/app.json.zst":  {Data: streamed.Bytes()},
+		"both.txt":           {Data: []byte("uncompressed wins")},
+		"both.txt.zst":       {Data: compressed},
+		"data/empty.txt.zst": {Data: []byte{}},
+	}
+	for name, fsys := range map[string]fs.FS{"instance": z.FS(inner), "package": FS(inner)} {
+		if err := fstest.TestFS(fsys, "index.html", "plain.txt", "data/app.json", "both.txt", "both.txt.zst", "data/empty.txt"); err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if got, err := fs.ReadFile(fsys, "index.html"); err != nil || !bytes.Equal(got, page) {
+			t.Errorf("%s: Reading a compressed file failed: %v", name, err)
+		}
+		if info, err := fs.Stat(fsys, "data/app.json"); err != nil || info.Size() != 19 || info.Name() != "app.json" {
+			t.Errorf("%s: Unexpected file info %v: %v", name, info, err)
+		}
+		if got, _ := fs.ReadFile(fsys, "both.txt"); string(got) != "uncompressed wins" {
+			t.Errorf("%s: Compressed file hid the uncompressed one", name)
+		}
+	}
+
+	// The package-level FS loads the library once for all its files
+	shared := FS(inner)
+	first, _ := shared.Open("index.html")
+	first.Close()
+	second, err := shared.Open("data/app.json")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer second.Close()
+	if instance, _ := fsZstd(); second.(*zstFile).reader.zstd != instance || instance.isClosed() {
+		t.Error("Expected the files of the package-level FS to share one open instance")
+	}
+}
+
+func TestRotatingWriter(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log.zst")
+	os.WriteFile(path, []byte("left by a crash"), 0o644)
+
+	rw, err := z.NewRotatingWriter(path, RotateOptions{MaxSize: 1000, MaxBackups: 3}, WithChecksum(true))
+	if err != nil {
+		t.Fatalf("NewRotatingWriter failed: %v", err)
+	}
+	var lines []string
+	for i := 0; i < 10; i++ {
+		line := fmt.Sprintf("%03d %s\n", i, strings.Repeat("x", 295))
+		lines = append(lines, line)
+		if _, err := rw.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Three lines fit in each file; the oldest files, and the one found at start, are gone
+	for i, want := range []string{
+		strings.Join(lines[9:], ""),
+		strings.Join(lines[6:9], ""),
+		strings.Join(lines[3:6], ""),
+		strings.Join(lines[0:3], ""),
+	} {
+		name := path
+		if i > 0 {
+			name = filepath.Join(dir, fmt.Sprintf("app.log.%d.zst", i))
+		}
+		compressed, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatalf("Reading %s failed: %v", name, err)
+		}
+		list, _ := z.ListFrames(bytes.NewReader(compressed))
+		got, err := z.Decompress(compressed, 0)
+		if err != nil || string(got) != want || len(list.Frames) != 1 || !list.Frames[0].HasChecksum {
+			t.Errorf("Unexpected content of %s: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.log.4.zst")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("More backups kept than MaxBackups")
+	}
+
+	// Rotation by age
+	rw, _ = z.NewRotatingWriter(path, RotateOptions{MaxAge: time.Millisecond})
+	rw.Write([]byte("first"))
+	time.Sleep(5 * time.Millisecond)
+	rw.Write([]byte("second"))
+	rw.Close()
+	first, _ := os.ReadFile(filepath.Join(dir, "app.log.1.zst"))
+	second, _ := os.ReadFile(path)
+	if got, _ := z.Decompress(first, 0); string(got) != "first" {
+		t.Errorf("Expected the first write in the rotated file, got %q", got)
+	}
+	if got, _ := z.Decompress(second, 0); string(got) != "second" {
+		t.Errorf("Expected the second write in the current file, got %q", got)
+	}
+
+	// A file without output is not rotated by age
+	dir = t.TempDir()
+	path = filepath.Join(dir, "idle.log.zst")
+	rw, _ = z.NewRotatingWriter(path, RotateOptions{MaxAge: time.Millisecond})
+	time.Sleep(5 * time.Millisecond)
+	rw.Write([]byte("late"))
+	rw.Close()
+	if _, err := os.Stat(filepath.Join(dir, "idle.log.1.zst")); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected the idle file not to be rotated, got %v", err)
+	}
+
+	// A failed rotation is retried by the next write
+	path = filepath.Join(dir, "retry.log.zst")
+	rw, _ = z.NewRotatingWriter(path, RotateOptions{MaxBackups: 1})
+	rw.Write([]byte("before"))
+	blocker := filepath.Join(dir, "retry.log.1.zst")
+	os.MkdirAll(filepath.Join(blocker, "busy"), 0o755)
+	if err := rw.Rotate(); err == nil {
+		t.Fatal("Expected the rotation to fail")
+	}
+	os.RemoveAll(blocker)
+	if _, err := rw.Write([]byte("after")); err != nil {
+		t.Fatalf("Write after a failed rotation failed: %v", err)
+	}
+	if err := rw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	before, _ := os.ReadFile(blocker)
+	after, _ := os.ReadFile(path)
+	if got, _ := z.Decompress(before, 0); string(got) != "before" {
+		t.Errorf("Expected the output before the failure in the rotated file, got %q", got)
+	}
+	if got, _ := z.Decompress(after, 0); string(got) != "after" {
+		t.Errorf("Expected the output after the failure in the current file, got %q", got)
+	}
+}
+
+func TestCompressFileResumable(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "input"), filepath.Join(dir, "input.zst")
+	data := bytes.Repeat([]byte("a long running compression job "), 100000)
+	os.WriteFile(src, data, 0o644)
+
+	// Interrupted after the first checkpoint, with a partial frame written after it
+	errStop := errors.New("stopped")
+	options := ResumeOptions{Interval: 1 << 20, OnCheckpoint: func(Checkpoint) error { return errStop }}
+	if err := z.CompressFileResumable(src, dst, options, WithChecksum(true)); err != errStop {
+		t.Fatalf("Expected the job to stop, got %v", err)
+	}
+	f, _ := os.OpenFile(dst, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0x28, 0xB5, 0x2F, 0xFD, 0x00})
+	f.Close()
+
+	var checkpoints []Checkpoint
+	options.OnCheckpoint = func(c Checkpoint) error {
+		checkpoints = append(checkpoints, c)
+		return nil
+	}
+	if err := z.CompressFileResumable(src, dst, options, WithChecksum(true)); err != nil {
+		t.Fatalf("Resuming failed: %v", err)
+	}
+	if len(checkpoints) != 1 || checkpoints[0].Input != 2<<20 {
+		t.Errorf("Expected the job to resume at the second interval, got checkpoints %v", checkpoints)
+	}
+	if _, err := os.Stat(dst + ".checkpoint"); !errors.Is(err, fs.ErrNotExist) {
+		t.Error("Checkpoint left after completion")
+	}
+
+	compressed, _ := os.ReadFile(dst)
+	list, err := z.ListFrames(bytes.NewReader(compressed))
+	if err != nil || len(list.Frames) != 3 {
+		t.Errorf("Expected 3 frames, got %d: %v", len(list.Frames), err)
+	}
+	r := z.NewReader(bytes.NewReader(compressed))
+	got, err := io.ReadAll(r)
+	r.Close()
+	if err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Resumed output doesn't decompress to the input: %v", err)
+	}
+}
+
+func TestDecompressFileMmap(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := make([]byte, 100000)
+	rand.New(rand.NewSource(1)).Read(data[:50000])
+	dir := t.TempDir()
+
+	// Frames with content sizes, decompressed whole, and a stream without, which is not
+	var seekable bytes.Buffer
+	sw := z.NewSeekableWriter(&seekable, 4096, DefaultCompression)
+	sw.Write(data)
+	sw.Close()
+	var stream bytes.Buffer
+	w := z.NewWriter(&stream, DefaultCompression)
+	w.Write(data)
+	w.Close()
+
+	for name, content := range map[string][]byte{"seekable.zst": seekable.Bytes(), "stream.zst": stream.Bytes(), "empty.zst": nil} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		expected := data
+		if content == nil {
+			expected = nil
+		}
+		for _, mmap := range []bool{false, true} {
+			var out bytes.Buffer
+			n, err := z.DecompressFile(&out, path, WithMmap(mmap))
+			if err != nil || n != int64(len(expected)) || !bytes.Equal(out.Bytes(), expected) {
+				t.Errorf("%s, mmap %v: %d bytes, %v", name, mmap, n, err)
+			}
+		}
+	}
+
+	sr, err := z.OpenSeekableFile(filepath.Join(dir, "seekable.zst"), WithMmap(true))
+	if err != nil {
+		t.Fatalf("OpenSeekableFile failed: %v", err)
+	}
+	p := make([]byte, 9000)
+	if _, err := sr.ReadAt(p, 8191); err != nil || !bytes.Equal(p, data[8191:17191]) {
+		t.Errorf("ReadAt failed: %v", err)
+	}
+	if err := sr.Close(); err != nil {
+		t.Errorf("Close failed: %v", err)
+	}
+	if _, err := sr.ReadAt(p, 20000); err == nil {
+		t.Error("ReadAt after Close succeeded")
+	}
+
+	if _, err := z.OpenSeekableFile(filepath.Join(dir, "stream.zst"), WithMmap(true)); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected ErrCorruptedData without a seek table, got %v", err)
+	}
+}
+
+func TestBuildSeekTable(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	// Frames recording their size, a skippable frame, and a frame written as a stream
+	data := make([]byte, 50000)
+	rand.New(rand.NewSource(1)).Read(data[:20000])
+	var stream bytes.Buffer
+	for _, part := range [][]byte{data[:10000], data[10000:30000]} {
+		frame, _ := z.Compress(part, DefaultCompression)
+		stream.Write(frame)
+	}
+	w := z.NewWriter(&stream, DefaultCompression)
+	w.WriteSkippableFrame(0, []byte("metadata"))
+	w.Write(data[30000:])
+	w.Close()
+
+	src := bytes.NewReader(stream.Bytes())
+	table, err := z.BuildSeekTable(src, src.Size())
+	if err != nil {
+		t.Fatalf("BuildSeekTable failed: %v", err)
+	}
+	sr, err := z.NewIndexedReader(src, src.Size(), table)
+	if err != nil {
+		t.Fatalf("NewIndexedReader failed: %v", err)
+	}
+	if sr.Size() != int64(len(data)) || sr.NumFrames() != 4 {
+		t.Fatalf("Size %d in %d frames", sr.Size(), sr.NumFrames())
+	}
+	if p, err := sr.DecompressAt(9990, 30000); err != nil || !bytes.Equal(p, data[9990:39990]) {
+		t.Errorf("DecompressAt failed: %v", err)
+	}
+
+	// Appended, the table makes the stream seekable, and it doesn't match other streams
+	seekable := append(bytes.Clone(stream.Bytes()), table...)
+	if sr, err := z.NewSeekableReader(bytes.NewReader(seekable), int64(len(seekable))); err != nil || sr.Size() != int64(len(data)) {
+		t.Errorf("NewSeekableReader failed: %v", err)
+	}
+	if _, err := z.NewIndexedReader(src, src.Size()-1, table); !errors.Is(err, ErrCorruptedData) {
+		t.Errorf("Expected ErrCorruptedData, got %v", err)
+	}
+}
+
+func TestMessages(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	messages := [][]byte{
+		[]byte(`{"event":"login","user":"alice"}`),
+		{},
+		bytes.Repeat([]byte(`{"event":"click","user":"bob"}`), 10000),
+		[]byte(`{"event":"logout","user":"alice"}`),
+	}
+	for _, stream := range []bool{false, true} {
+		var buf bytes.Buffer
+		mw := z.NewMessageWriter(&buf, nil, WithMessageStream(stream))
+		for _, m := range messages {
+			if err := mw.WriteMessage(m); err != nil {
+				t.Fatalf("WriteMessage failed: %v", err)
+			}
+		}
+		mw.Close()
+
+		mr := z.NewMessageReader(&buf, nil, WithMessageStream(stream), WithMaxDecompressSize(1<<20))
+		for i, want := range messages {
+			if got, err := mr.ReadMessage(); err != nil || !bytes.Equal(got, want) {
+				t.Errorf("Stream %v: message %d is %d bytes, %v", stream, i, len(got), err)
+			}
+		}
+		if _, err := mr.ReadMessage(); err != io.EOF {
+			t.Errorf("Stream %v: expected io.EOF, got %v", stream, err)
+		}
+		mr.Close()
+	}
+
+	// Messages above the limit are refused, and truncated ones reported
+	var buf bytes.Buffer
+	mw := z.NewMessageWriter(&buf, nil)
+	mw.WriteMessage(messages[2])
+	var sizeErr *MaxSizeError
+	if _, err := z.NewMessageReader(bytes.NewReader(buf.Bytes()), nil, WithMaxDecompressSize(1000)).ReadMessage(); !errors.As(err, &sizeErr) {
+		t.Errorf("Expected a MaxSizeError, got %v", err)
+	}
+	if _, err := z.NewMessageReader(bytes.NewReader(buf.Bytes()[:buf.Len()-1]), nil).ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Errorf("Expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestCompressedBytes(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+	defer SetSQLOptions(SQLOptions{})
+
+	doc := CompressedBytes(bytes.Repeat([]byte(`{"name":"widget","tags":["a","b"]},`), 500))
+	roundTrip := func() CompressedBytes {
+		t.Helper()
+		value, err := doc.Value()
+		if err != nil {
+			t.Fatalf("Value failed: %v", err)
+		}
+		stored := value.([]byte)
+		if len(stored) >= len(doc) {
+			t.Errorf("Stored %d bytes for %d", len(stored), len(doc))
+		}
+		var scanned CompressedBytes
+		if err := scanned.Scan(stored); err != nil || !bytes.Equal(scanned, doc) {
+			t.Fatalf("Scan failed: %v", err)
+		}
+		return CompressedBytes(stored)
+	}
+	roundTrip()
+
+	// Values compressed with a dictionary need it, or a registry holding it
+	dict, err := z.LoadDictionary(bytes.Repeat([]byte(`{"name":"widget"}`), 100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetSQLOptions(SQLOptions{Level: BestCompression, Dictionary: dict})
+	stored := roundTrip()
+	SetSQLOptions(SQLOptions{})
+	var scanned CompressedBytes
+	if err := scanned.Scan([]byte(stored)); !errors.Is(err, ErrDictionaryWrong) {
+		t.Errorf("Expected ErrDictionaryWrong, got %v", err)
+	}
+
+	// NULL, and values stored before the column was compressed
+	if err := scanned.Scan(nil); err != nil || scanned != nil {
+		t.Errorf("Scan(nil) = %v, %v", scanned, err)
+	}
+	if err := scanned.Scan(`{"plain":true}`); err != nil || string(scanned) != `{"plain":true}` {
+		t.Errorf("Scan of a plain value = %q, %v", scanned, err)
+	}
+	if value, err := CompressedBytes(nil).Value(); value != nil || err != nil {
+		t.Errorf("Value of nil = %v, %v", value, err)
+	}
+	legacy := []byte{0xB5, 0x01, 0x00, 0x06, 0x00, 0x03, 'b', 'l', 'o', 'b'}
+	if err := scanned.Scan(legacy); err != nil || !bytes.Equal(scanned, legacy) {
+		t.Errorf("Scan of a binary value looking like a header = %q, %v", scanned, err)
+	}
+	value, _ := CompressedBytes{}.Value()
+	if err := scanned.Scan(value); err != nil || scanned == nil || len(scanned) != 0 {
+		t.Errorf("Scan of an empty value = %q, %v", scanned, err)
+	}
+
+	// The size recorded is not trusted beyond the limit
+	forged := []byte{0xB5, 0x01, 0x00, 0x06, 0x00}
+	forged = binary.AppendUvarint(forged, 1<<42)
+	forged = append(forged, forgedFrame(1<<42)...)
+	if err := scanned.Scan(forged); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Errorf("Expected ErrMaxSizeExceeded, got %v", err)
+	}
+	SetSQLOptions(SQLOptions{MaxSize: int64(len(doc))})
+	stored = roundTrip()
+	SetSQLOptions(SQLOptions{MaxSize: int64(len(doc)) - 1})
+	if err := scanned.Scan([]byte(stored)); !errors.Is(err, ErrMaxSizeExceeded) {
+		t.Errorf("Expected ErrMaxSizeExceeded below the content size, got %v", err)
+	}
+}
+
+func TestStats(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	before := Stats()
+	data := bytes.Repeat([]byte("counted operations "), 1000)
+
+	// A streamed frame counts as one operation, like a one-shot call
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, DefaultCompression)
+	if _, err := w.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if _, err := z.Decompress(buf.Bytes(), len(data)); err != nil {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+	if _, err := z.Decompress([]byte("not a zstd frame"), 0); err == nil {
+		t.Fatal("Decompress of garbage succeeded")
+	}
+
+	after := Stats()
+	if n := after.Compressions - before.Compressions; n != 1 {
+		t.Errorf("%d compressions counted, want 1", n)
+	}
+	if n := after.Decompressions - before.Decompressions; n != 1 {
+		t.Errorf("%d decompressions counted, want 1", n)
+	}
+	if n := after.CompressBytesIn - before.CompressBytesIn; n != int64(len(data)) {
+		t.Errorf("%d bytes compressed counted, want %d", n, len(data))
+	}
+	if n := after.CompressBytesOut - before.CompressBytesOut; n != int64(buf.Len()) {
+		t.Errorf("%d compressed bytes counted, want %d", n, buf.Len())
+	}
+	if n := after.DecompressBytesOut - before.DecompressBytesOut; n != int64(len(data)) {
+		t.Errorf("%d bytes decompressed counted, want %d", n, len(data))
+	}
+	if after.Errors == before.Errors {
+		t.Error("Failed decompression not counted")
+	}
+	if after.ContextsAlive < 1 || after.LibraryVersion != z.VersionString() {
+		t.Errorf("Unexpected statistics: %+v", after)
+	}
+}
+
+// recordingInstrumentation records the operations it is notified of
+type recordingInstrumentation struct {
+	mu      sync.Mutex
+	started []Operation
+	ended   []OperationResult
+	spans   []any // Value carried from each start to its end
+}
+
+type spanKey struct{}
+
+type requestKey struct{}
+
+func (ri *recordingInstrumentation) start(op Operation) context.Context {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.started = append(ri.started, op)
+	return context.WithValue(op.Context, spanKey{}, len(ri.started))
+}
+
+func (ri *recordingInstrumentation) end(ctx context.Context, result OperationResult) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.ended = append(ri.ended, result)
+	ri.spans = append(ri.spans, ctx.Value(spanKey{}))
+}
+
+func (ri *recordingInstrumentation) OnCompressStart(op Operation) context.Context {
+	return ri.start(op)
+}
+
+func (ri *recordingInstrumentation) OnCompressEnd(ctx context.Context, result OperationResult) {
+	ri.end(ctx, result)
+}
+
+func (ri *recordingInstrumentation) OnDecompressStart(op Operation) context.Context {
+	return ri.start(op)
+}
+
+func (ri *recordingInstrumentation) OnDecompressEnd(ctx context.Context, result OperationResult) {
+	ri.end(ctx, result)
+}
+
+func TestInstrumentation(t *testing.T) {
+	ri := &recordingInstrumentation{}
+	z, err := New(WithInstrumentation(ri))
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("instrumented operations "), 1000)
+	compressed, err := z.Compress(data, BestSpeed)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	if len(ri.started) != 1 || ri.started[0].Level != BestSpeed || ri.started[0].SrcSize != int64(len(data)) || ri.started[0].Streaming {
+		t.Errorf("Unexpected start of Compress: %+v", ri.started)
+	}
+	if len(ri.ended) != 1 || ri.ended[0].Consumed != int64(len(data)) || ri.ended[0].Produced != int64(len(compressed)) || ri.spans[0] != 1 {
+		t.Errorf("Unexpected end of Compress: %+v", ri.ended)
+	}
+
+	// Streams are reported from the first Write to Close, in the context given to them
+	ctx := context.WithValue(context.Background(), requestKey{}, "stream")
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, 0, WithContext(ctx))
+	w.Write(data)
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(ri.ended) != 2 || ri.ended[1].Consumed != int64(len(data)) || ri.ended[1].Produced != int64(buf.Len()) {
+		t.Errorf("Unexpected end of the Writer: %+v", ri.ended)
+	}
+	if op := ri.started[1]; !op.Streaming || op.Context.Value(requestKey{}) != "stream" || op.SrcSize != -1 {
+		t.Errorf("Unexpected start of the Writer: %+v", op)
+	}
+
+	// Failures are reported with their error
+	r := z.NewReader(bytes.NewReader(compressed[:len(compressed)/2]))
+	if _, err := io.ReadAll(r); err == nil {
+		t.Error("Reading a truncated stream succeeded")
+	}
+	r.Close()
+	if len(ri.ended) != 3 || ri.ended[2].Err == nil || ri.started[2].SrcSize != int64(len(compressed)/2) {
+		t.Errorf("Unexpected report of the failed Reader: %+v", ri.ended)
+	}
+	if _, err := z.Decompress([]byte("not a zstd frame"), 0); err == nil {
+		t.Error("Decompress of garbage succeeded")
+	}
+	if len(ri.ended) != 4 || ri.ended[3].Err == nil {
+		t.Errorf("Unexpected report of the failed Decompress: %+v", ri.ended)
+	}
+}
+
+func TestCompressPipe(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("compressed as it is read "), 10000)
+	r := z.CompressPipe(bytes.NewReader(data), BestSpeed)
+	compressed, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %v", err)
+	}
+	r.Close()
+	decompressed, err := z.Decompress(compressed, len(data))
+	if err != nil || !bytes.Equal(decompressed, data) {
+		t.Fatalf("Round trip failed: %v", err)
+	}
+
+	// Errors from the source reach the reader
+	broken := io.MultiReader(bytes.NewReader(data), iotest.ErrReader(io.ErrUnexpectedEOF))
+	if _, err := io.ReadAll(CompressPipe(broken, 0)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("ReadAll returned %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+
+	// Closing early stops the compression
+	r = z.CompressPipe(bytes.NewReader(data), 0)
+	r.Close()
+	if _, err := r.Read(make([]byte, 1)); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Read after Close returned %v", err)
+	}
+}
+
+func TestScanner(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	// Lines longer than the default limit of bufio.Scanner are accepted
+	lines := []string{"first line", strings.Repeat("long line ", 20000), "", "last line"}
+	compressed, err := z.Compress([]byte(strings.Join(lines, "\n")+"\n"), 0)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+
+	s := z.NewScanner(bytes.NewReader(compressed))
+	defer s.Close()
+	var scanned []string
+	for s.Scan() {
+		scanned = append(scanned, s.Text())
+	}
+	if err := s.Err(); err != nil {
+		t.Fatalf("Scan failed: %v", err)
+	}
+	if !slices.Equal(scanned, lines) {
+		t.Errorf("Scanned %d lines, want %d", len(scanned), len(lines))
+	}
+
+	// Corrupted data stops the scan with an error
+	s, err = NewScanner(bytes.NewReader(compressed[:len(compressed)/2]))
+	if err != nil {
+		t.Fatalf("NewScanner failed: %v", err)
+	}
+	defer s.Close()
+	for s.Scan() {
+	}
+	if s.Err() == nil {
+		t.Error("Scanning a truncated stream succeeded")
+	}
+}
+
+func TestPartWriter(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := make([]byte, 4<<20)
+	rand.New(rand.NewSource(1)).Read(data)
+
+	const partSize = 1 << 20
+	var parts [][]byte
+	pw := z.NewPartWriter(partSize, func(part int, data []byte) error {
+		if part != len(parts)+1 {
+			t.Errorf("Part %d emitted after %d parts", part, len(parts))
+		}
+		parts = append(parts, bytes.Clone(data))
+		return nil
+	}, WithLevel(BestSpeed))
+	if _, err := pw.Write(data); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if err := pw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if len(parts) != 4 || pw.Parts() != len(parts) {
+		t.Fatalf("%d parts emitted, want 4", len(parts))
+	}
+
+	// Each part is a stream of its own, of at least the part size except the last one
+	var decompressed []byte
+	for i, part := range parts {
+		if i < len(parts)-1 && (len(part) < partSize || len(part) > partSize+2*partChunkSize) {
+			t.Errorf("Part %d has %d bytes, want about %d", i+1, len(part), partSize)
+		}
+		content, err := z.Decompress(part, len(data))
+		if err != nil {
+			t.Fatalf("Decompress of part %d failed: %v", i+1, err)
+		}
+		decompressed = append(decompressed, content...)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Error("Parts do not decompress to the input")
+	}
+
+	// An error from emit stops the writer
+	emitErr := errors.New("upload failed")
+	pw = z.NewPartWriter(partSize, func(int, []byte) error { return emitErr })
+	if _, err := pw.Write(data); !errors.Is(err, emitErr) {
+		t.Errorf("Write returned %v, want %v", err, emitErr)
+	}
+	if err := pw.Close(); !errors.Is(err, emitErr) {
+		t.Errorf("Close returned %v, want %v", err, emitErr)
+	}
+}
+
+func TestHashWriterReader(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("hashed while compressed "), 5000)
+	want := sha256.Sum256(data)
+
+	var buf bytes.Buffer
+	hw := z.NewHashWriter(&buf, sha256.New(), DefaultCompression)
+	if _, err := io.Copy(hw, bytes.NewReader(data)); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if err := hw.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if sum := hw.Sum(nil); !bytes.Equal(sum, want[:]) {
+		t.Errorf("HashWriter sum is %x, want %x", sum, want)
+	}
+
+	// The XXH64 of the content matches the checksum recorded by the frame
+	hr := z.NewHashReader(bytes.NewReader(buf.Bytes()), NewXXH64())
+	defer hr.Close()
+	if _, err := io.Copy(io.Discard, hr); err != nil {
+		t.Fatalf("Copy failed: %v", err)
+	}
+	if sum := binary.BigEndian.Uint64(hr.Sum(nil)); sum != xxhash64(data) {
+		t.Errorf("HashReader sum is %x, want %x", sum, xxhash64(data))
+	}
+}
+
+func TestRecompress(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	// Three frames of text, a skippable frame before the last one
+	words := strings.Fields("cold storage archive upgraded to a higher level once written rarely read")
+	rng := rand.New(rand.NewSource(1))
+	chunks := make([][]byte, 3)
+	for i := range chunks {
+		for len(chunks[i]) < 50000 {
+			chunks[i] = append(chunks[i], words[rng.Intn(len(words))]...)
+			chunks[i] = append(chunks[i], ' ')
+		}
+	}
+	var src bytes.Buffer
+	w := z.NewWriter(&src, BestSpeed)
+	for i, chunk := range chunks {
+		if i == 2 {
+			w.WriteSkippableFrame(3, []byte("metadata"))
+		}
+		w.Write(chunk)
+		w.EndFrame()
+	}
+	w.Close()
+	content := bytes.Join(chunks, nil)
+
+	var single, framed bytes.Buffer
+	n, err := z.Recompress(&single, bytes.NewReader(src.Bytes()), BestCompression)
+	if err != nil || n != int64(len(content)) {
+		t.Fatalf("Recompress returned %d, %v", n, err)
+	}
+	if _, err := z.RecompressFrames(&framed, bytes.NewReader(src.Bytes()), BestCompression, WithChecksum(true)); err != nil {
+		t.Fatalf("RecompressFrames failed: %v", err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		frames int
+	}{
+		{"Recompress", single.Bytes(), 3},
+		{"RecompressFrames", framed.Bytes(), 4},
+	} {
+		list, err := z.ListFrames(bytes.NewReader(tc.data))
+		if err != nil {
+			t.Fatalf("%s: ListFrames failed: %v", tc.name, err)
+		}
+		if len(list.Frames) != tc.frames || list.SkippableFrames != 1 {
+			t.Errorf("%s: %d frames, %d skippable, want %d and 1", tc.name, len(list.Frames), list.SkippableFrames, tc.frames)
+		}
+
+		var skipped []string
+		r := z.NewReader(bytes.NewReader(tc.data), WithSkippableFrameHandler(func(_ uint32, payload []byte) error {
+			skipped = append(skipped, string(payload))
+			return nil
+		}))
+		decompressed, err := io.ReadAll(r)
+		r.Close()
+		if err != nil || !bytes.Equal(decompressed, content) {
+			t.Errorf("%s: content does not round trip: %v", tc.name, err)
+		}
+		if !slices.Equal(skipped, []string{"metadata"}) {
+			t.Errorf("%s: skippable frames %q", tc.name, skipped)
+		}
+	}
+	if single.Len() >= src.Len() {
+		t.Errorf("Recompressed at a higher level to %d bytes, from %d", single.Len(), src.Len())
+	}
+
+	// Corrupted input fails
+	if _, err := z.Recompress(io.Discard, bytes.NewReader(src.Bytes()[:src.Len()/2]), 0); err == nil {
+		t.Error("Recompress of a truncated stream succeeded")
+	}
+}
+
+func TestBufferPool(t *testing.T) {
+	for _, size := range []int{1, 1000, 1024, 1025, 32 << 10, 64 << 20} {
+		b := getBuffer(size)
+		if len(b) != size || cap(b)&(cap(b)-1) != 0 || cap(b) < 1024 {
+			t.Errorf("getBuffer(%d) has length %d and capacity %d", size, len(b), cap(b))
+		}
+		putBuffer(b)
+	}
+	if b := getBuffer(64<<20 + 1); len(b) != 64<<20+1 {
+		t.Errorf("getBuffer beyond the largest class has length %d", len(b))
+	}
+
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	// Results do not share storage with pooled buffers
+	data := bytes.Repeat([]byte("pooled scratch buffers "), 1000)
+	first, _ := z.Compress(data, 0)
+	want := bytes.Clone(first)
+	z.Compress(bytes.Repeat([]byte("other content "), 1000), 0)
+	if !bytes.Equal(first, want) {
+		t.Error("Compress result changed by a later call")
+	}
+
+	// Closing hands the buffers back; Reset takes new ones
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, 0)
+	w.Close()
+	r := z.NewReader(bytes.NewReader(nil))
+	r.Close()
+	for i := 0; i < 2; i++ {
+		buf.Reset()
+		w.Reset(&buf)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close after Reset failed: %v", err)
+		}
+		if err := r.Reset(bytes.NewReader(buf.Bytes()), nil); err != nil {
+			t.Fatalf("Reset failed: %v", err)
+		}
+		decompressed, err := io.ReadAll(r)
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Fatalf("Round trip after Reset failed: %v", err)
+		}
+		r.Close()
+	}
+}
+
+func TestContextReuse(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("small payload "), 10)
+	dict, err := z.LoadDictionary(bytes.Repeat([]byte("small payload dictionary content "), 100))
+	if err != nil {
+		t.Fatalf("LoadDictionary failed: %v", err)
+	}
+
+	// Sequential one-shot calls of every kind share one context per direction
+	for range 100 {
+		compressed, err := z.Compress(data, 0)
+		if err != nil {
+			t.Fatalf("Compress failed: %v", err)
+		}
+		if _, err := z.Decompress(compressed, 0); err != nil {
+			t.Fatalf("Decompress failed: %v", err)
+		}
+		compressed, err = z.CompressUsingDict(data, dict, 0)
+		if err != nil {
+			t.Fatalf("CompressUsingDict failed: %v", err)
+		}
+		if _, err := z.DecompressUsingDict(compressed, dict, 0); err != nil {
+			t.Fatalf("DecompressUsingDict failed: %v", err)
+		}
+	}
+	if cctxs, dctxs := len(z.cctxPool.idle), len(z.dctxPool.idle); cctxs != 1 || dctxs != 1 {
+		t.Errorf("Expected one idle context per direction, got %d and %d", cctxs, dctxs)
+	}
+
+	// A context reused after a dictionary call compresses without it
+	compressed, _ := z.Compress(data, 0)
+	if decompressed, err := z.Decompress(compressed, 0); err != nil || !bytes.Equal(decompressed, data) {
+		t.Errorf("Reused context produced a bad frame: %v", err)
+	}
+}
+
+func TestStableBuffers(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	// The source is filled progressively, as when receiving data
+	content := bytes.Repeat([]byte("stable buffers let libzstd skip copies. "), 20000)
+	src := make([]byte, len(content))
+	dst := make([]byte, z.CompressBound(len(src)))
+	c, err := z.NewStableCompressor(dst, src, 3, WithChecksum(true))
+	if err != nil {
+		t.Fatalf("NewStableCompressor failed: %v", err)
+	}
+	defer c.Close()
+	for n := 0; n < len(content); n += 100000 {
+		end := min(n+100000, len(content))
+		copy(src[n:end], content[n:end])
+		if err := c.Compress(end); err != nil {
+			t.Fatalf("Compress failed: %v", err)
+		}
+	}
+	compressed, err := c.Finish(len(content))
+	if err != nil {
+		t.Fatalf("Finish failed: %v", err)
+	}
+	if err := c.Compress(len(content)); err == nil {
+		t.Error("Expected Compress after Finish to fail")
+	}
+
+	// Compressed data arrives in small pieces; the content lands in place
+	out := make([]byte, len(content))
+	d, err := z.NewStableDecompressor(out)
+	if err != nil {
+		t.Fatalf("NewStableDecompressor failed: %v", err)
+	}
+	defer d.Close()
+	for rest := compressed; len(rest) > 0 && !d.Done(); {
+		n, err := d.Decompress(rest[:min(len(rest), 1000)])
+		if err != nil {
+			t.Fatalf("Decompress failed: %v", err)
+		}
+		rest = rest[n:]
+	}
+	if !d.Done() || !bytes.Equal(d.Bytes(), content) {
+		t.Errorf("Expected the content back, got %d bytes", len(d.Bytes()))
+	}
+
+	// A destination too small for the content fails instead of growing
+	small, _ := z.NewStableDecompressor(make([]byte, 1000))
+	if _, err := small.Decompress(compressed); err == nil {
+		t.Error("Expected a destination too small to fail")
+	}
+	if err := small.Close(); err == nil {
+		t.Error("Expected Close to report the failure")
+	}
+
+	// Level 0 is the instance default, and dropping them without Close frees them
+	stableFrame := func(level int) []byte {
+		c, err := z.NewStableCompressor(make([]byte, len(dst)), src, level)
+		if err != nil {
+			t.Fatalf("NewStableCompressor failed: %v", err)
+		}
+		defer c.Close()
+		frame, err := c.Finish(len(content))
+		if err != nil {
+			t.Fatalf("Finish failed: %v", err)
+		}
+		return frame
+	}
+	z.SetDefaultLevel(1)
+	if !bytes.Equal(stableFrame(0), stableFrame(1)) {
+		t.Error("Expected level 0 to compress at the default level of the instance")
+	}
+	z.SetDefaultLevel(0)
+
+	before := Stats().ContextsAlive
+	z.NewStableCompressor(dst, src, 1)
+	z.NewStableDecompressor(out)
+	runtime.GC()
+	runtime.GC()
+	if alive := Stats().ContextsAlive; alive > before {
+		t.Errorf("Expected the dropped streams to be freed, %d more alive", alive-before)
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	data := bytes.Repeat([]byte("representative application data, "), 3000)
+	results, err := z.Benchmark(data, []int{1, 0, 9})
+	if err != nil {
+		t.Fatalf("Benchmark failed: %v", err)
+	}
+	if len(results) != 3 || results[1].Level != DefaultCompression {
+		t.Fatalf("Expected results for levels 1, 3 and 9, got %+v", results)
+	}
+	for _, r := range results {
+		if r.OriginalSize != int64(len(data)) || r.Ratio <= 1 || r.CompressSpeed <= 0 || r.DecompressSpeed <= 0 {
+			t.Errorf("Unexpected result %+v", r)
+		}
+	}
+
+	if _, err := z.Benchmark(nil, []int{1}); !errors.Is(err, ErrEmptyInput) {
+		t.Errorf("Expected ErrEmptyInput, got %v", err)
+	}
+}
+
+func TestStaticContexts(t *testing.T) {
+	z, err := New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	c, err := z.NewStaticCompressor(make([]byte, z.StaticCompressorSize(5)), 5)
+	if err != nil {
+		t.Fatalf("NewStaticCompressor failed: %v", err)
+	}
+	defer c.Close()
+	d, err := z.NewStaticDecompressor(make([]byte, z.StaticDecompressorSize()))
+	if err != nil {
+		t.Fatalf("NewStaticDecompressor failed: %v", err)
+	}
+	defer d.Close()
+
+	// The buffers are allocated once too, and reused by every round trip
+	data := bytes.Repeat([]byte("static contexts never allocate. "), 5000)
+	compressedBuf := make([]byte, z.CompressBound(len(data)))
+	decompressedBuf := make([]byte, len(data))
+	for range 10 {
+		compressed, err := c.Compress(compressedBuf, data)
+		if err != nil {
+			t.Fatalf("Compress failed: %v", err)
+		}
+		decompressed, err := d.Decompress(decompressedBuf, compressed)
+		if err != nil || !bytes.Equal(decompressed, data) {
+			t.Fatalf("Decompress failed: %v", err)
+		}
+	}
+
+	// Dropping them without Close unpins the workspaces
+	z.NewStaticCompressor(make([]byte, z.StaticCompressorSize(1)), 1)
+	z.NewStaticDecompressor(make([]byte, z.StaticDecompressorSize()))
+	runtime.GC()
+	runtime.GC()
+
+	if _, err := z.NewStaticCompressor(make([]byte, 1024), 5); !errors.Is(err, ErrWorkspaceTooSmall) {
+		t.Errorf("Expected ErrWorkspaceTooSmall, got %v", err)
+	}
+	if _, err := c.Compress(make([]byte, 10), data); !errors.Is(err, ErrDstSizeTooSmall) {
+		t.Errorf("Expected ErrDstSizeTooSmall, got %v", err)
+	}
+}
+
+func TestAllocator(t *testing.T) {
+	var alloc GoAllocator
+	z, err := New(WithAllocator(&alloc))
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+
+	data := bytes.Repeat([]byte("native memory accounted by the Go allocator. "), 20000)
+	compressed, err := z.Compress(data, 5)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	if decompressed, err := z.Decompress(compressed, len(data)); err != nil || !bytes.Equal(decompressed, data) {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+	pooled := alloc.InUse()
+	if pooled <= 0 {
+		t.Fatal("Expected the pooled contexts to be allocated by the allocator")
+	}
+
+	// Streams allocate from it too while open
+	var buf bytes.Buffer
+	w := z.NewWriter(&buf, 5)
+	w.Write(data)
+	if alloc.InUse() <= pooled {
+		t.Error("Expected the stream to be allocated by the allocator")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	r := z.NewReader(&buf)
+	if decompressed, err := io.ReadAll(r); err != nil || !bytes.Equal(decompressed, data) {
+		t.Fatalf("Read failed: %v", err)
+	}
+	r.Close()
+
+	if err := z.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if inUse := alloc.InUse(); inUse != 0 {
+		t.Errorf("Expected everything freed, %d bytes still in use", inUse)
+	}
+}
@@ -0,0 +1,24 @@
+// Package zstdexpvar publishes the statistics of the zstd package under expvar, as the
+// "zstd" variable, so the /debug/vars endpoint reports compression health. It is
+// imported for its side effect:
+//
+//	import _ "github.com/develerltd/zstd-purego/zstdexpvar"
+//
+// The statistics live in a separate package because importing expvar registers its
+// handler on http.DefaultServeMux, which programs not asking for it should not get.
+package zstdexpvar
+
+import (
+	"expvar"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// Name is the expvar variable holding the statistics
+const Name = "zstd"
+
+func init() {
+	expvar.Publish(Name, expvar.Func(func() any {
+		return zstd.Stats()
+	}))
+}
@@ -0,0 +1,40 @@
+package zstdexpvar
+
+import (
+	"encoding/json"
+	"expvar"
+	"testing"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+func TestPublished(t *testing.T) {
+	z, err := zstd.New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	compressed, err := z.Compress([]byte("statistics statistics statistics"), 3)
+	if err != nil {
+		t.Fatalf("Compress failed: %v", err)
+	}
+	if _, err := z.Decompress(compressed, 0); err != nil {
+		t.Fatalf("Decompress failed: %v", err)
+	}
+
+	v := expvar.Get(Name)
+	if v == nil {
+		t.Fatalf("%q is not published", Name)
+	}
+	var stats zstd.Statistics
+	if err := json.Unmarshal([]byte(v.String()), &stats); err != nil {
+		t.Fatalf("Invalid statistics %s: %v", v, err)
+	}
+	if stats.Compressions == 0 || stats.Decompressions == 0 || stats.CompressBytesIn == 0 {
+		t.Errorf("Operations are not counted: %+v", stats)
+	}
+	if stats.LibraryVersion != z.VersionString() {
+		t.Errorf("Library version %q, want %q", stats.LibraryVersion, z.VersionString())
+	}
+}
@@ -0,0 +1,158 @@
+package zstdhttp
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// Transport is an http.RoundTripper asking servers for responses compressed with
+// Zstandard, or gzip, and decompressing them transparently, as http.Transport does
+// with gzip alone. Requests setting their own Accept-Encoding are left alone, and get
+// the responses as sent. Request bodies can be compressed too, for servers known to
+// accept them. Readers and Writers are pooled by the Transport, which is safe for
+// concurrent use.
+type Transport struct {
+	Base             http.RoundTripper // Transport sending the requests (nil = http.DefaultTransport)
+	CompressRequests bool              // Compress request bodies, sent with Content-Encoding: zstd
+	Level            int               // Compression level of request bodies (0 = zstd.DefaultCompression)
+	MaxResponseSize  int64             // Decompressed size limit of responses (0 = no limit)
+
+	once    sync.Once
+	readers *zstd.ReaderPool
+	writers *zstd.WriterPool
+}
+
+// RoundTrip implements the http.RoundTripper interface
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	z, err := instance()
+	if err != nil {
+		return base.RoundTrip(req)
+	}
+	t.once.Do(func() {
+		t.readers = z.NewReaderPool(zstd.WithMaxDecompressSize(t.MaxResponseSize))
+		t.writers = z.NewWriterPool(t.Level, zstd.WithWindowLog(maxWindowLog))
+	})
+
+	negotiate := req.Header.Get("Accept-Encoding") == "" && req.Header.Get("Range") == ""
+	compress := t.CompressRequests && req.Body != nil && req.Body != http.NoBody &&
+		req.Header.Get("Content-Encoding") == ""
+	if !negotiate && !compress {
+		return base.RoundTrip(req)
+	}
+
+	// The request given must not be modified
+	req = req.Clone(req.Context())
+	if negotiate {
+		req.Header.Set("Accept-Encoding", Encoding+", gzip")
+	}
+	if compress {
+		req.Body = t.compressBody(req.Body)
+		if getBody := req.GetBody; getBody != nil {
+			req.GetBody = func() (io.ReadCloser, error) {
+				body, err := getBody()
+				if err != nil {
+					return nil, err
+				}
+				return t.compressBody(body), nil
+			}
+		}
+		req.ContentLength = -1
+		req.Header.Del("Content-Length")
+		req.Header.Set("Content-Encoding", Encoding)
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil || !negotiate || resp.Body == nil || resp.Body == http.NoBody {
+		return resp, err
+	}
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case Encoding:
+		resp.Body = &zstdBody{body: resp.Body, reader: t.readers.Get(resp.Body), pool: t.readers}
+	case "gzip":
+		resp.Body = &gzipBody{body: resp.Body}
+	default:
+		return resp, nil
+	}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// compressBody returns a body streaming the compressed content of body, compressed in
+// a goroutine as it is read. The transport closing the returned body stops it.
+func (t *Transport) compressBody(body io.ReadCloser) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		w := t.writers.Get(pw)
+		_, err := io.Copy(w, body)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if err == nil {
+			t.writers.Put(w)
+		}
+		body.Close()
+		pw.CloseWithError(err)
+	}()
+	return pr
+}
+
+// zstdBody is the body of a response decompressed by a pooled Reader
+type zstdBody struct {
+	body   io.ReadCloser
+	reader *zstd.Reader // nil once closed
+	pool   *zstd.ReaderPool
+}
+
+// Read implements the io.Reader interface
+func (b *zstdBody) Read(p []byte) (int, error) {
+	if b.reader == nil {
+		return 0, zstd.ErrAlreadyClosed
+	}
+	return b.reader.Read(p)
+}
+
+// Close implements the io.Closer interface, returning the Reader to the pool
+func (b *zstdBody) Close() error {
+	if b.reader != nil {
+		b.reader.Close()
+		b.pool.Put(b.reader)
+		b.reader = nil
+	}
+	return b.body.Close()
+}
+
+// gzipBody is the body of a response decompressed with gzip, on the first read as
+// gzip.NewReader reads the header
+type gzipBody struct {
+	body   io.ReadCloser
+	reader *gzip.Reader
+	err    error // Failure to read the header, reported by every Read
+}
+
+// Read implements the io.Reader interface
+func (b *gzipBody) Read(p []byte) (int, error) {
+	if b.reader == nil && b.err == nil {
+		b.reader, b.err = gzip.NewReader(b.body)
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.reader.Read(p)
+}
+
+// Close implements the io.Closer interface
+func (b *gzipBody) Close() error {
+	return b.body.Close()
+}
@@ -0,0 +1,268 @@
+// Package zstdhttp adds the Zstandard content coding to net/http: Handler compresses
+// responses for clients announcing support for it in Accept-Encoding, and Transport
+// asks for compressed responses and decompresses them on the client side.
+package zstdhttp
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// Encoding is the content coding of Zstandard, as registered for HTTP
+const Encoding = "zstd"
+
+// defaultMinSize is the smallest response compressed by default
+const defaultMinSize = 1024
+
+// maxWindowLog caps the window of compressed responses to 8 MiB, the most that HTTP
+// clients are required to support for the zstd content coding (RFC 9659)
+const maxWindowLog = 23
+
+// The library is loaded once and shared by all handlers and transports of the package
+var (
+	sharedOnce sync.Once
+	shared     *zstd.Zstd
+	sharedErr  error
+)
+
+// instance returns the shared library instance, loading it on first use
+func instance() (*zstd.Zstd, error) {
+	sharedOnce.Do(func() {
+		shared, sharedErr = zstd.New()
+	})
+	return shared, sharedErr
+}
+
+// Options configures Handler
+type Options struct {
+	Level   int // Compression level (0 = zstd.DefaultCompression)
+	MinSize int // Responses with a shorter body are sent as they are (0 = 1024)
+
+	// SkipContentType reports whether responses of the content type, such as
+	// "image/png", are sent as they are because they are compressed already
+	// (nil = skip images other than SVG, audio, video, fonts and compressed archives)
+	SkipContentType func(contentType string) bool
+}
+
+// Handler returns a handler compressing the responses of next with Zstandard for
+// requests that accept it. The start of each response is held until MinSize bytes are
+// written, the handler returns or it flushes, then the response is compressed unless it
+// is short, already has a Content-Encoding, is a partial response, or its content type is
+// skipped. The content type is sniffed first if next didn't set it, as net/http would.
+// Compressed responses lose their Content-Length, and their ETag is made weak. Writers
+// are pooled across responses. If the library can't be loaded, responses are sent as
+// they are.
+func Handler(next http.Handler, opts Options) http.Handler {
+	z, err := instance()
+	if err != nil {
+		return next
+	}
+	if opts.MinSize <= 0 {
+		opts.MinSize = defaultMinSize
+	}
+	if opts.SkipContentType == nil {
+		opts.SkipContentType = compressedContentType
+	}
+	return &handler{
+		next:    next,
+		options: opts,
+		pool:    z.NewWriterPool(opts.Level, zstd.WithWindowLog(maxWindowLog)),
+	}
+}
+
+// handler is the handler returned by Handler
+type handler struct {
+	next    http.Handler
+	options Options
+	pool    *zstd.WriterPool
+}
+
+// ServeHTTP implements the http.Handler interface
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Vary", "Accept-Encoding")
+	if r.Method == http.MethodHead || !AcceptsZstd(r.Header) {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	rw := &responseWriter{ResponseWriter: w, handler: h}
+	h.next.ServeHTTP(rw, r)
+	rw.close()
+}
+
+// AcceptsZstd reports whether the Accept-Encoding of the request headers accepts the
+// zstd content coding, by name or through *, with a non-zero quality
+func AcceptsZstd(header http.Header) bool {
+	wildcard := false
+	for _, value := range header.Values("Accept-Encoding") {
+		for _, item := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(item, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			switch coding {
+			case Encoding:
+				return quality(params) > 0
+			case "*":
+				wildcard = quality(params) > 0
+			}
+		}
+	}
+	return wildcard
+}
+
+// quality returns the q parameter among the parameters of a coding, 1 if absent
+func quality(params string) float64 {
+	for _, param := range strings.Split(params, ";") {
+		name, value, _ := strings.Cut(param, "=")
+		if strings.EqualFold(strings.TrimSpace(name), "q") {
+			q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return 0
+			}
+			return q
+		}
+	}
+	return 1
+}
+
+// compressedContentType reports whether the content type is compressed already
+func compressedContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+	mediaType = strings.TrimSpace(mediaType)
+	switch {
+	case mediaType == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mediaType, "image/"), strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"), strings.HasPrefix(mediaType, "font/woff"):
+		return true
+	}
+	switch mediaType {
+	case "application/zip", "application/gzip", "application/x-gzip", "application/zstd",
+		"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+		"application/vnd.rar", "application/x-rar-compressed", "application/pdf":
+		return true
+	}
+	return false
+}
+
+// responseWriter compresses the response once it is known to be worth it
+type responseWriter struct {
+	http.ResponseWriter
+	handler *handler
+	status  int          // Status given to WriteHeader, sent once decided (0 = none yet)
+	buf     []byte       // Start of the body, held until decided
+	decided bool         // The headers are sent, with or without compression
+	zw      *zstd.Writer // Compressing the body, if decided so
+}
+
+// WriteHeader implements the http.ResponseWriter interface
+func (rw *responseWriter) WriteHeader(status int) {
+	if rw.decided || rw.status != 0 {
+		return
+	}
+	if status >= 100 && status < 200 {
+		// Informational responses, such as 103 Early Hints, precede the actual one
+		rw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	rw.status = status
+	if status == http.StatusNoContent || status == http.StatusNotModified {
+		rw.decide()
+	}
+}
+
+// Write implements the io.Writer interface
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if !rw.decided {
+		rw.buf = append(rw.buf, p...)
+		if len(rw.buf) < rw.handler.options.MinSize {
+			return len(p), nil
+		}
+		if err := rw.decide(); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if rw.zw != nil {
+		return rw.zw.Write(p)
+	}
+	return rw.ResponseWriter.Write(p)
+}
+
+// Flush implements the http.Flusher interface, deciding on compression with the body
+// written so far
+func (rw *responseWriter) Flush() {
+	if !rw.decided {
+		if err := rw.decide(); err != nil {
+			return
+		}
+	}
+	if rw.zw != nil {
+		if err := rw.zw.Flush(); err != nil {
+			return
+		}
+	}
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped ResponseWriter, for http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// decide sends the headers, compressing the response if it is worth it, then the body
+// held so far
+func (rw *responseWriter) decide() error {
+	rw.decided = true
+	header := rw.Header()
+	if _, ok := header["Content-Type"]; !ok && len(rw.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(rw.buf))
+	}
+	status := rw.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	compress := len(rw.buf) >= rw.handler.options.MinSize &&
+		status != http.StatusPartialContent && header.Get("Content-Range") == "" &&
+		header.Get("Content-Encoding") == "" &&
+		!rw.handler.options.SkipContentType(header.Get("Content-Type"))
+	if compress {
+		header.Set("Content-Encoding", Encoding)
+		header.Del("Content-Length")
+		header.Del("Accept-Ranges")
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+	}
+	rw.ResponseWriter.WriteHeader(status)
+
+	buf := rw.buf
+	rw.buf = nil
+	if compress {
+		rw.zw = rw.handler.pool.Get(rw.ResponseWriter)
+		_, err := rw.zw.Write(buf)
+		return err
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := rw.ResponseWriter.Write(buf)
+	return err
+}
+
+// close sends what is held of the response, and completes the compressed stream
+func (rw *responseWriter) close() {
+	if !rw.decided && (rw.status != 0 || len(rw.buf) > 0) {
+		rw.decide()
+	}
+	if rw.zw != nil {
+		if err := rw.zw.Close(); err == nil {
+			rw.handler.pool.Put(rw.zw)
+		}
+		rw.zw = nil
+	}
+}
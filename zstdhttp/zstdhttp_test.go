@@ -0,0 +1,155 @@
+package zstdhttp
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+func TestHandler(t *testing.T) {
+	page := bytes.Repeat([]byte("<p>Hello, zstd!</p>\n"), 200)
+	handler := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(page[:100])
+			w.Write(page[100:])
+		case "/small":
+			w.Write([]byte("short"))
+		case "/image":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(page)
+		case "/empty":
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}), Options{})
+
+	get := func(path, acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if acceptEncoding != "" {
+			req.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/page", "gzip, zstd;q=0.9")
+	if rec.Header().Get("Content-Encoding") != "zstd" || rec.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatalf("Unexpected headers %v", rec.Header())
+	}
+	if rec.Header().Get("Content-Type") != "text/html; charset=utf-8" || rec.Header().Get("ETag") != `W/"v1"` {
+		t.Errorf("Unexpected headers %v", rec.Header())
+	}
+	zr, err := zstd.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	body, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(body, page) {
+		t.Errorf("Decompress failed: %v", err)
+	}
+
+	// Responses go through as they are for clients not accepting zstd, when short,
+	// already compressed, or without a body
+	for _, c := range []struct{ path, acceptEncoding string }{
+		{"/page", ""}, {"/page", "gzip, zstd;q=0"}, {"/small", "zstd"}, {"/image", "*"}, {"/empty", "zstd"},
+	} {
+		rec := get(c.path, c.acceptEncoding)
+		if rec.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s with %q was compressed", c.path, c.acceptEncoding)
+		}
+		if want := map[string]int{"/page": len(page), "/small": 5, "/image": len(page)}[c.path]; rec.Body.Len() != want {
+			t.Errorf("%s with %q: %d bytes, expected %d", c.path, c.acceptEncoding, rec.Body.Len(), want)
+		}
+	}
+	if rec := get("/empty", "zstd"); rec.Code != http.StatusNoContent {
+		t.Errorf("Status %d, expected 204", rec.Code)
+	}
+}
+
+func TestAcceptsZstd(t *testing.T) {
+	for value, want := range map[string]bool{
+		"":                      false,
+		"gzip, deflate":         false,
+		"gzip, zstd":            true,
+		"ZSTD;q=0.5":            true,
+		"zstd;q=0":              false,
+		"*":                     true,
+		"*;q=0.1, zstd;q=0":     false,
+		"br;q=1.0, *;q=0, gzip": false,
+	} {
+		header := http.Header{"Accept-Encoding": {value}}
+		if got := AcceptsZstd(header); got != want {
+			t.Errorf("AcceptsZstd(%q) = %v", value, got)
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	page := bytes.Repeat([]byte("<p>Hello, zstd!</p>\n"), 200)
+	mux := http.NewServeMux()
+	mux.Handle("/echo", Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body io.Reader = r.Body
+		if r.Header.Get("Content-Encoding") == Encoding {
+			zr, err := zstd.NewReader(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			defer zr.Close()
+			body = zr
+		}
+		io.Copy(w, body)
+	}), Options{}))
+	mux.HandleFunc("/gzip", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write(page)
+		zw.Close()
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := &http.Client{Transport: &Transport{CompressRequests: true}}
+	for i := 0; i < 2; i++ {
+		resp, err := client.Post(server.URL+"/echo", "text/html", bytes.NewReader(page))
+		if err != nil {
+			t.Fatalf("Post failed: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil || !bytes.Equal(body, page) || !resp.Uncompressed {
+			t.Errorf("Echo failed: %d bytes, %v", len(body), err)
+		}
+	}
+
+	resp, err := client.Get(server.URL + "/gzip")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil || !bytes.Equal(body, page) || resp.Header.Get("Content-Encoding") != "" {
+		t.Errorf("Gzip response failed: %d bytes, %v", len(body), err)
+	}
+
+	// An explicit Accept-Encoding gets the response as sent
+	req, _ := http.NewRequest(http.MethodPost, server.URL+"/echo", bytes.NewReader(page))
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Do failed: %v", err)
+	}
+	body, _ = io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if !bytes.Equal(body, page) || resp.Uncompressed {
+		t.Errorf("Identity response failed: %d bytes", len(body))
+	}
+}
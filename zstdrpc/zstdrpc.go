@@ -0,0 +1,202 @@
+// Package zstdrpc provides net/rpc codecs compressing every request and response with
+// Zstandard, optionally with a dictionary, and the gob Encoder and Decoder they are
+// built on, compressing each value encoded. Services exchanging large payloads switch
+// to them without changing their methods:
+//
+//	rpc.ServeCodec(zstdrpc.NewServerCodec(z, conn, zstdrpc.Options{}))
+//	client := rpc.NewClientWithCodec(zstdrpc.NewClientCodec(z, conn, zstdrpc.Options{}))
+//
+// Each value, or each header and body, is gob encoded then compressed as a message of
+// its own, as by zstd.MessageWriter. Both sides must use the same dictionary.
+package zstdrpc
+
+import (
+	"bytes"
+	"encoding/gob"
+	"io"
+	"net/rpc"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// defaultMaxMessageSize is the largest message accepted by default
+const defaultMaxMessageSize = 64 << 20
+
+// Options configures Encoders, Decoders and codecs
+type Options struct {
+	Level          int              // Compression level (0 = the instance default)
+	Dictionary     *zstd.Dictionary // Dictionary the messages are compressed with, if any
+	MaxMessageSize int              // Largest decompressed message accepted (0 = 64 MiB)
+}
+
+// Encoder gob encodes values to a stream, compressing each one
+type Encoder struct {
+	mw  *zstd.MessageWriter
+	buf bytes.Buffer // Encoded values of the message being built
+	enc *gob.Encoder // Encoding to buf, keeping the types sent across messages
+}
+
+// NewEncoder returns an Encoder writing to w, with the library instance z
+func NewEncoder(z *zstd.Zstd, w io.Writer, opts Options) *Encoder {
+	e := &Encoder{mw: z.NewMessageWriter(w, opts.Dictionary, opts.zstdOptions()...)}
+	e.enc = gob.NewEncoder(&e.buf)
+	return e
+}
+
+// Encode writes the gob encoding of v as a compressed message. After an error, the
+// stream is unusable, as the types it defines may not have been written.
+func (e *Encoder) Encode(v any) error {
+	return e.encode(v)
+}
+
+// encode writes the gob encodings of values as one compressed message
+func (e *Encoder) encode(values ...any) error {
+	e.buf.Reset()
+	for _, v := range values {
+		if err := e.enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return e.mw.WriteMessage(e.buf.Bytes())
+}
+
+// Decoder decodes values written by an Encoder
+type Decoder struct {
+	dec     *gob.Decoder
+	message messageReader
+}
+
+// NewDecoder returns a Decoder reading from r, with the library instance z
+func NewDecoder(z *zstd.Zstd, r io.Reader, opts Options) *Decoder {
+	d := &Decoder{message: messageReader{mr: z.NewMessageReader(r, opts.Dictionary, opts.zstdOptions()...)}}
+	d.dec = gob.NewDecoder(&d.message)
+	return d
+}
+
+// Decode reads the next value from the stream and stores it in v, which may be nil to
+// discard it
+func (d *Decoder) Decode(v any) error {
+	return d.dec.Decode(v)
+}
+
+// zstdOptions returns the options of the MessageWriter or MessageReader
+func (o Options) zstdOptions() []zstd.Option {
+	maxSize := o.MaxMessageSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxMessageSize
+	}
+	opts := []zstd.Option{zstd.WithMaxDecompressSize(int64(maxSize))}
+	if o.Level != 0 {
+		opts = append(opts, zstd.WithLevel(o.Level))
+	}
+	return opts
+}
+
+// messageReader reads the content of the messages of a stream one after the other.
+// It implements io.ByteReader, so gob reads no further than the values it decodes, and
+// never waits for a message before it is needed.
+type messageReader struct {
+	mr      *zstd.MessageReader
+	content []byte // Unread content of the current message
+}
+
+// Read implements the io.Reader interface
+func (m *messageReader) Read(p []byte) (int, error) {
+	if err := m.next(); err != nil {
+		return 0, err
+	}
+	n := copy(p, m.content)
+	m.content = m.content[n:]
+	return n, nil
+}
+
+// ReadByte implements the io.ByteReader interface
+func (m *messageReader) ReadByte() (byte, error) {
+	if err := m.next(); err != nil {
+		return 0, err
+	}
+	b := m.content[0]
+	m.content = m.content[1:]
+	return b, nil
+}
+
+// next reads the next non-empty message once the current one is consumed
+func (m *messageReader) next() error {
+	for len(m.content) == 0 {
+		content, err := m.mr.ReadMessage()
+		if err != nil {
+			return err
+		}
+		m.content = content
+	}
+	return nil
+}
+
+// serverCodec is the rpc.ServerCodec returned by NewServerCodec
+type serverCodec struct {
+	conn   io.Closer
+	dec    *Decoder
+	enc    *Encoder
+	closed bool
+}
+
+// NewServerCodec returns an rpc.ServerCodec exchanging compressed messages over conn
+func NewServerCodec(z *zstd.Zstd, conn io.ReadWriteCloser, opts Options) rpc.ServerCodec {
+	return &serverCodec{conn: conn, dec: NewDecoder(z, conn, opts), enc: NewEncoder(z, conn, opts)}
+}
+
+func (c *serverCodec) ReadRequestHeader(r *rpc.Request) error {
+	return c.dec.Decode(r)
+}
+
+func (c *serverCodec) ReadRequestBody(body any) error {
+	return c.dec.Decode(body)
+}
+
+// WriteResponse sends the header and body as one message. As with the gob codec of
+// net/rpc, the connection is closed if they can't be encoded, the stream being broken.
+func (c *serverCodec) WriteResponse(r *rpc.Response, body any) error {
+	if err := c.enc.encode(r, body); err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *serverCodec) Close() error {
+	if c.closed {
+		// Only call Close once
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
+
+// clientCodec is the rpc.ClientCodec returned by NewClientCodec
+type clientCodec struct {
+	conn io.Closer
+	dec  *Decoder
+	enc  *Encoder
+}
+
+// NewClientCodec returns an rpc.ClientCodec exchanging compressed messages over conn
+func NewClientCodec(z *zstd.Zstd, conn io.ReadWriteCloser, opts Options) rpc.ClientCodec {
+	return &clientCodec{conn: conn, dec: NewDecoder(z, conn, opts), enc: NewEncoder(z, conn, opts)}
+}
+
+// WriteRequest sends the header and body as one message
+func (c *clientCodec) WriteRequest(r *rpc.Request, body any) error {
+	return c.enc.encode(r, body)
+}
+
+func (c *clientCodec) ReadResponseHeader(r *rpc.Response) error {
+	return c.dec.Decode(r)
+}
+
+func (c *clientCodec) ReadResponseBody(body any) error {
+	return c.dec.Decode(body)
+}
+
+func (c *clientCodec) Close() error {
+	return c.conn.Close()
+}
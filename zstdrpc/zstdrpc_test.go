@@ -0,0 +1,93 @@
+package zstdrpc
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"net/rpc"
+	"strings"
+	"testing"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+type Echo struct{}
+
+type EchoArgs struct {
+	Text  string
+	Times int
+}
+
+func (Echo) Repeat(args EchoArgs, reply *string) error {
+	*reply = strings.Repeat(args.Text, args.Times)
+	return nil
+}
+
+func TestCodecs(t *testing.T) {
+	z, err := zstd.New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	server := rpc.NewServer()
+	if err := server.Register(Echo{}); err != nil {
+		t.Fatal(err)
+	}
+	serverConn, clientConn := net.Pipe()
+	go server.ServeCodec(NewServerCodec(z, serverConn, Options{}))
+	client := rpc.NewClientWithCodec(NewClientCodec(z, clientConn, Options{}))
+	defer client.Close()
+
+	for _, times := range []int{1, 100000, 3} {
+		var reply string
+		if err := client.Call("Echo.Repeat", EchoArgs{Text: "abc", Times: times}, &reply); err != nil {
+			t.Fatalf("Call failed: %v", err)
+		}
+		if reply != strings.Repeat("abc", times) {
+			t.Errorf("Reply of %d bytes, expected %d", len(reply), 3*times)
+		}
+	}
+	if err := client.Call("Echo.Missing", EchoArgs{}, new(string)); err == nil {
+		t.Error("Call of a missing method succeeded")
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	z, err := zstd.New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	dict, err := z.LoadDictionary([]byte(strings.Repeat("common message content ", 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Dictionary: dict, MaxMessageSize: 1 << 16}
+
+	var buf bytes.Buffer
+	enc := NewEncoder(z, &buf, opts)
+	values := []EchoArgs{{"common message content", 1}, {"", 0}, {strings.Repeat("x", 1000), 2}}
+	for _, v := range values {
+		if err := enc.Encode(v); err != nil {
+			t.Fatalf("Encode failed: %v", err)
+		}
+	}
+	if err := enc.Encode(EchoArgs{Text: strings.Repeat("y", 1<<17)}); err != nil {
+		t.Fatalf("Encode failed: %v", err)
+	}
+
+	dec := NewDecoder(z, &buf, opts)
+	for _, want := range values {
+		var got EchoArgs
+		if err := dec.Decode(&got); err != nil || got != want {
+			t.Errorf("Decode = %v, %v", got, err)
+		}
+	}
+	// Messages above the limit are refused
+	var sizeErr *zstd.MaxSizeError
+	if err := dec.Decode(new(EchoArgs)); !errors.As(err, &sizeErr) {
+		t.Errorf("Expected a MaxSizeError, got %v", err)
+	}
+}
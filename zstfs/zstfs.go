@@ -0,0 +1,159 @@
+// Package zstfs serves static assets stored compressed with Zstandard, typically embedded
+// in the binary, decompressing them lazily as they are opened. The zstfs command prepares
+// a directory of assets for it, compressing each file that benefits to name.zst:
+//
+//	//go:generate go run github.com/develerltd/zstd-purego/cmd/zstfs -o assets static
+//	//go:embed assets
+//	var assets embed.FS
+//
+// Files are then served under their original names by an FS, which can keep the content
+// of recently opened files in memory so popular assets are decompressed only once.
+package zstfs
+
+import (
+	"bytes"
+	"container/list"
+	"io"
+	"io/fs"
+	"sync"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+// Options configures an FS
+type Options struct {
+	// CacheSize is the total decompressed size of the files kept in memory once read,
+	// the least recently opened being evicted first (0 = no caching). Files larger than
+	// it are always decompressed as they are read.
+	CacheSize int64
+}
+
+// FS is a file system serving the files of another, where files stored compressed as
+// name.zst appear as name, as by zstd.FS. It is safe for concurrent use.
+type FS struct {
+	fsys    fs.FS
+	options Options
+
+	mu     sync.Mutex
+	cached map[string]*list.Element // Cached files by name, in lru
+	lru    *list.List               // Cached files, most recently opened first
+	size   int64                    // Total size of the cached files
+}
+
+// cachedFile is the content of a file kept in memory
+type cachedFile struct {
+	name    string
+	info    fs.FileInfo
+	content []byte
+}
+
+// New returns a file system serving the assets of fsys, decompressed by the instance, or
+// by an instance loaded once and shared if nil, as by zstd.FS
+func New(z *zstd.Zstd, fsys fs.FS, opts Options) *FS {
+	served := zstd.FS(fsys)
+	if z != nil {
+		served = z.FS(fsys)
+	}
+	return &FS{
+		fsys:    served,
+		options: opts,
+		cached:  make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// Open implements the fs.FS interface. Files served from the cache, or cached by this
+// call, implement io.ReaderAt and io.Seeker.
+func (f *FS) Open(name string) (fs.File, error) {
+	if file := f.lookup(name); file != nil {
+		return file, nil
+	}
+
+	file, err := f.fsys.Open(name)
+	if err != nil || f.options.CacheSize <= 0 {
+		return file, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || info.Size() > f.options.CacheSize {
+		return file, nil
+	}
+
+	content, err := io.ReadAll(file)
+	file.Close()
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	cached := &cachedFile{name: name, info: info, content: content}
+	f.store(cached)
+	return cached.open(), nil
+}
+
+// ReadFile implements the fs.ReadFileFS interface
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	file, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if m, ok := file.(*memFile); ok {
+		return bytes.Clone(m.content), nil
+	}
+	return io.ReadAll(file)
+}
+
+// lookup returns the file at name opened from the cache, or nil if not cached
+func (f *FS) lookup(name string) fs.File {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	elem, ok := f.cached[name]
+	if !ok {
+		return nil
+	}
+	f.lru.MoveToFront(elem)
+	return elem.Value.(*cachedFile).open()
+}
+
+// store adds the file to the cache, evicting the least recently opened files to keep
+// the cache within its size
+func (f *FS) store(file *cachedFile) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.cached[file.name]; ok {
+		// Read concurrently by another Open
+		return
+	}
+
+	f.cached[file.name] = f.lru.PushFront(file)
+	f.size += int64(len(file.content))
+	for f.size > f.options.CacheSize {
+		oldest := f.lru.Remove(f.lru.Back()).(*cachedFile)
+		delete(f.cached, oldest.name)
+		f.size -= int64(len(oldest.content))
+	}
+}
+
+// open returns a file reading the cached content
+func (c *cachedFile) open() *memFile {
+	return &memFile{Reader: bytes.NewReader(c.content), info: c.info, content: c.content}
+}
+
+// memFile is an open file of the cache
+type memFile struct {
+	*bytes.Reader
+	info    fs.FileInfo
+	content []byte
+}
+
+// Stat implements the fs.File interface
+func (m *memFile) Stat() (fs.FileInfo, error) {
+	return m.info, nil
+}
+
+// Close implements the io.Closer interface
+func (m *memFile) Close() error {
+	return nil
+}
@@ -0,0 +1,71 @@
+package zstfs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	zstd "github.com/develerltd/zstd-purego"
+)
+
+func TestFS(t *testing.T) {
+	z, err := zstd.New()
+	if err != nil {
+		t.Fatalf("Failed to load library: %v", err)
+	}
+	defer z.Close()
+
+	page := bytes.Repeat([]byte("<p>Hello, zstfs!</p>\n"), 500)
+	script := bytes.Repeat([]byte("console.log(1);\n"), 1000)
+	compress := func(data []byte) []byte {
+		compressed, err := z.Compress(data, zstd.DefaultCompression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return compressed
+	}
+	assets := fstest.MapFS{
+		"index.html.zst": {Data: compress(page)},
+		"js/app.js.zst":  {Data: compress(script)},
+		"logo.png":       {Data: []byte("\x89PNG")},
+	}
+
+	files := New(z, assets, Options{CacheSize: int64(len(script))})
+	if err := fstest.TestFS(files, "index.html", "js/app.js", "logo.png"); err != nil {
+		t.Fatal(err)
+	}
+
+	open := func(name string) fs.File {
+		f, err := files.Open(name)
+		if err != nil {
+			t.Fatalf("Open %s failed: %v", name, err)
+		}
+		return f
+	}
+
+	// Opening a file again serves it from the cache, which evicts the least recently opened
+	f := open("js/app.js")
+	f.Close()
+	f = open("js/app.js")
+	if _, ok := f.(*memFile); !ok {
+		t.Error("Expected a cached file")
+	}
+	if got, err := io.ReadAll(f); err != nil || !bytes.Equal(got, script) {
+		t.Errorf("Cached content differs: %v", err)
+	}
+	open("index.html").Close()
+	if _, ok := files.cached["js/app.js"]; ok {
+		t.Error("Expected js/app.js to be evicted")
+	}
+	if files.size > files.options.CacheSize {
+		t.Errorf("Cache holds %d bytes, above %d", files.size, files.options.CacheSize)
+	}
+
+	// Without a cache, files are decompressed as they are read
+	uncached := New(nil, assets, Options{})
+	if data, err := fs.ReadFile(uncached, "index.html"); err != nil || !bytes.Equal(data, page) {
+		t.Errorf("ReadFile failed: %v", err)
+	}
+}